@@ -3,13 +3,59 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	DebugMode          bool
 	AllowRegistrations bool
 	TimezoneName       string
+	// MaxUploadSize is the maximum size of an upload request body in bytes, 0 disables the limit.
+	MaxUploadSize int64
+	// TrashRetentionDays is the number of days deleted items stay in the trash, 0 keeps them until they are purged by hand.
+	TrashRetentionDays int64
+	// VersionKeep is the number of older versions kept per file, 0 keeps all of them.
+	VersionKeep int64
+	// VersionKeepDays is the number of days an older version is kept, 0 keeps it until it is pruned by count.
+	VersionKeepDays int64
+	// DefaultQuota is the number of bytes the files of a user may take up unless they have a quota of their own, 0 means unlimited.
+	// Only current files count: older versions and the trash are not counted and are bounded by their retention instead.
+	DefaultQuota int64
+	// ExtractMaxEntries is the number of files and folders an uploaded archive may contain to be extracted, 0 means unlimited.
+	ExtractMaxEntries int64
+	// ExtractMaxSizeMB is the size the files of an uploaded archive may add up to when extracted, 0 means unlimited.
+	ExtractMaxSizeMB int64
+	// StorageBackend selects where file contents are kept, "local" for DATA_ROOT or "s3" for an S3 compatible bucket.
+	StorageBackend string
+	// S3Endpoint is the URL of the S3 compatible service, e.g. https://s3.eu-central-1.amazonaws.com.
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3Prefix          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	// S3PathStyle puts the bucket into the path instead of the host name, as most self-hosted stores expect.
+	S3PathStyle bool
+	// S3PartSizeMB is the size of the parts large uploads are sent to S3 in, at least 5.
+	S3PartSizeMB int64
+	// EncryptionKey holds base64 encoded master keys separated by commas, the first one encrypts new contents. Empty disables encryption.
+	EncryptionKey string
+	// EncryptionKeyFile is a file with one master key per line, used instead of EncryptionKey.
+	EncryptionKeyFile string
+	// LinkSecret signs the cookies of visitors that entered the password of a link. Empty uses a random secret until the next restart.
+	LinkSecret string
+}
+
+// TrashRetention returns how long deleted items stay in the trash, 0 means forever.
+func (c *Config) TrashRetention() time.Duration {
+	return time.Duration(c.TrashRetentionDays) * 24 * time.Hour
+}
+
+// VersionRetention returns how long older file versions are kept, 0 means forever.
+func (c *Config) VersionRetention() time.Duration {
+	return time.Duration(c.VersionKeepDays) * 24 * time.Hour
 }
 
 func envOrDefaultBool(key string, defaultValue bool) bool {
@@ -28,14 +74,54 @@ func envOrDefaultString(key string, defaultValue string) string {
 	return strings.TrimSpace(val)
 }
 
+func envOrDefaultInt64(key string, defaultValue int64) int64 {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
+
 func Init() *Config {
 	cfg := &Config{}
 	cfg.DebugMode = envOrDefaultBool("DEBUG", false)
 	cfg.AllowRegistrations = envOrDefaultBool("ALLOW_REGISTRATION", false)
 	cfg.TimezoneName = envOrDefaultString("TZ", "UTC")
+	cfg.MaxUploadSize = envOrDefaultInt64("MAX_UPLOAD_SIZE", 0)
+	cfg.TrashRetentionDays = envOrDefaultInt64("TRASH_RETENTION_DAYS", 30)
+	cfg.VersionKeep = envOrDefaultInt64("VERSION_KEEP", 10)
+	cfg.VersionKeepDays = envOrDefaultInt64("VERSION_KEEP_DAYS", 0)
+	cfg.DefaultQuota = envOrDefaultInt64("DEFAULT_QUOTA", 0)
+	cfg.ExtractMaxEntries = envOrDefaultInt64("EXTRACT_MAX_ENTRIES", 10000)
+	cfg.ExtractMaxSizeMB = envOrDefaultInt64("EXTRACT_MAX_SIZE_MB", 10240)
+	cfg.StorageBackend = envOrDefaultString("STORAGE_BACKEND", "local")
+	cfg.S3Endpoint = envOrDefaultString("S3_ENDPOINT", "")
+	cfg.S3Region = envOrDefaultString("S3_REGION", "us-east-1")
+	cfg.S3Bucket = envOrDefaultString("S3_BUCKET", "")
+	cfg.S3Prefix = envOrDefaultString("S3_PREFIX", "")
+	cfg.S3AccessKeyID = envOrDefaultString("S3_ACCESS_KEY_ID", "")
+	cfg.S3SecretAccessKey = envOrDefaultString("S3_SECRET_ACCESS_KEY", "")
+	cfg.S3PathStyle = envOrDefaultBool("S3_PATH_STYLE", false)
+	cfg.S3PartSizeMB = envOrDefaultInt64("S3_PART_SIZE_MB", 16)
+	cfg.EncryptionKey = envOrDefaultString("ENCRYPTION_KEY", "")
+	cfg.EncryptionKeyFile = envOrDefaultString("ENCRYPTION_KEY_FILE", "")
+	cfg.LinkSecret = envOrDefaultString("LINK_SECRET", "")
 
 	flag.BoolVar(&cfg.DebugMode, "debug", cfg.DebugMode, "enable debug mode")
 	flag.BoolVar(&cfg.AllowRegistrations, "allowRegistrations", cfg.AllowRegistrations, "allow registrations")
+	flag.Int64Var(&cfg.MaxUploadSize, "maxUploadSize", cfg.MaxUploadSize, "maximum upload size in bytes (0 = unlimited)")
+	flag.Int64Var(&cfg.TrashRetentionDays, "trashRetentionDays", cfg.TrashRetentionDays, "days deleted items stay in the trash (0 = forever)")
+	flag.Int64Var(&cfg.VersionKeep, "versionKeep", cfg.VersionKeep, "older versions kept per file (0 = all)")
+	flag.Int64Var(&cfg.VersionKeepDays, "versionKeepDays", cfg.VersionKeepDays, "days older versions are kept (0 = forever)")
+	flag.Int64Var(&cfg.DefaultQuota, "defaultQuota", cfg.DefaultQuota, "bytes the files of a user may take up (0 = unlimited)")
+	flag.Int64Var(&cfg.ExtractMaxEntries, "extractMaxEntries", cfg.ExtractMaxEntries, "files and folders an extracted archive may contain (0 = unlimited)")
+	flag.Int64Var(&cfg.ExtractMaxSizeMB, "extractMaxSizeMB", cfg.ExtractMaxSizeMB, "megabytes an extracted archive may unpack to (0 = unlimited)")
+	flag.StringVar(&cfg.StorageBackend, "storageBackend", cfg.StorageBackend, "where file contents are kept (local or s3)")
+	flag.StringVar(&cfg.EncryptionKeyFile, "encryptionKeyFile", cfg.EncryptionKeyFile, "file with the master keys contents are encrypted with")
 	flag.Parse()
 
 	return cfg
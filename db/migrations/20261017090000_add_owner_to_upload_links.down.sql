@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_upload_links_user_id;
+
+ALTER TABLE upload_links DROP COLUMN folder_id;
+ALTER TABLE upload_links DROP COLUMN user_id;
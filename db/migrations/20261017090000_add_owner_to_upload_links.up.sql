@@ -0,0 +1,5 @@
+-- Tie upload links to their creator and the folder uploads land in
+ALTER TABLE upload_links ADD COLUMN user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE upload_links ADD COLUMN folder_id INTEGER REFERENCES folders(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_upload_links_user_id ON upload_links(user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_tus_uploads_user_id;
+
+DROP TABLE IF EXISTS tus_uploads;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_trash_entries_trash_id;
+DROP INDEX IF EXISTS idx_trash_items_deleted_at;
+DROP INDEX IF EXISTS idx_trash_items_user_id;
+
+DROP TABLE IF EXISTS trash_entries;
+DROP TABLE IF EXISTS trash_items;
@@ -0,0 +1,28 @@
+CREATE TABLE trash_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    is_folder BOOLEAN NOT NULL,
+    original_path TEXT NOT NULL,
+    original_folder_id INTEGER REFERENCES folders(id) ON DELETE SET NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    file_count INTEGER NOT NULL DEFAULT 0,
+    deleted_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Everything needed to recreate the folders and files rows of a trashed item.
+-- path is relative to the trashed item, the item itself has the empty path.
+CREATE TABLE trash_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    trash_id INTEGER NOT NULL REFERENCES trash_items(id) ON DELETE CASCADE,
+    is_folder BOOLEAN NOT NULL,
+    path TEXT NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    mime_type TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_trash_items_user_id ON trash_items(user_id);
+CREATE INDEX idx_trash_items_deleted_at ON trash_items(deleted_at);
+CREATE INDEX idx_trash_entries_trash_id ON trash_entries(trash_id);
@@ -0,0 +1,10 @@
+ALTER TABLE trash_entries DROP COLUMN version;
+ALTER TABLE trash_entries DROP COLUMN file_id;
+
+DROP INDEX IF EXISTS idx_file_versions_archived_at;
+DROP INDEX IF EXISTS idx_file_versions_file_id;
+
+DROP TABLE IF EXISTS file_versions;
+
+ALTER TABLE files DROP COLUMN updated_at;
+ALTER TABLE files DROP COLUMN version;
@@ -0,0 +1,24 @@
+-- The current content of a file is version files.version, older ones live in file_versions
+ALTER TABLE files ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE files ADD COLUMN updated_at DATETIME;
+
+CREATE TABLE file_versions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- No foreign key: the versions of a trashed file are kept until the trash item is purged
+    file_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    size BIGINT NOT NULL,
+    mime_type TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    archived_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(file_id, version)
+);
+
+CREATE INDEX idx_file_versions_file_id ON file_versions(file_id);
+CREATE INDEX idx_file_versions_archived_at ON file_versions(archived_at);
+
+-- Trashed files keep their ID and version number, so the history can be reattached on restore
+ALTER TABLE trash_entries ADD COLUMN file_id INTEGER;
+ALTER TABLE trash_entries ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
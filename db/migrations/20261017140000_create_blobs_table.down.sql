@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS blobs_trash_entries_update;
+DROP TRIGGER IF EXISTS blobs_trash_entries_delete;
+DROP TRIGGER IF EXISTS blobs_trash_entries_insert;
+DROP TRIGGER IF EXISTS blobs_file_versions_update;
+DROP TRIGGER IF EXISTS blobs_file_versions_delete;
+DROP TRIGGER IF EXISTS blobs_file_versions_insert;
+DROP TRIGGER IF EXISTS blobs_files_update;
+DROP TRIGGER IF EXISTS blobs_files_delete;
+DROP TRIGGER IF EXISTS blobs_files_insert;
+
+DROP INDEX IF EXISTS idx_blobs_released_at;
+
+DROP TABLE IF EXISTS blobs;
@@ -0,0 +1,87 @@
+-- File contents are stored once per SHA-256. ref_count counts the files,
+-- file versions and trashed files using a blob and is kept up to date by the
+-- triggers below. Blobs nobody uses anymore get released_at and are deleted
+-- from storage by the blob collector.
+CREATE TABLE blobs (
+    hash TEXT PRIMARY KEY,
+    size BIGINT NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    released_at DATETIME
+);
+
+CREATE INDEX idx_blobs_released_at ON blobs(released_at);
+
+INSERT INTO blobs (hash, size, ref_count)
+SELECT hash, MAX(size), COUNT(*) FROM (
+    SELECT hash, size FROM files
+    UNION ALL SELECT hash, size FROM file_versions
+    UNION ALL SELECT hash, size FROM trash_entries WHERE is_folder = 0
+) GROUP BY hash;
+
+CREATE TRIGGER blobs_files_insert AFTER INSERT ON files
+BEGIN
+    INSERT OR IGNORE INTO blobs (hash, size) VALUES (NEW.hash, NEW.size);
+    UPDATE blobs SET ref_count = ref_count + 1, released_at = NULL WHERE hash = NEW.hash;
+END;
+
+CREATE TRIGGER blobs_files_delete AFTER DELETE ON files
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1,
+                     released_at = CASE WHEN ref_count = 1 THEN CURRENT_TIMESTAMP END
+    WHERE hash = OLD.hash;
+END;
+
+CREATE TRIGGER blobs_files_update AFTER UPDATE OF hash ON files WHEN NEW.hash != OLD.hash
+BEGIN
+    INSERT OR IGNORE INTO blobs (hash, size) VALUES (NEW.hash, NEW.size);
+    UPDATE blobs SET ref_count = ref_count + 1, released_at = NULL WHERE hash = NEW.hash;
+    UPDATE blobs SET ref_count = ref_count - 1,
+                     released_at = CASE WHEN ref_count = 1 THEN CURRENT_TIMESTAMP END
+    WHERE hash = OLD.hash;
+END;
+
+CREATE TRIGGER blobs_file_versions_insert AFTER INSERT ON file_versions
+BEGIN
+    INSERT OR IGNORE INTO blobs (hash, size) VALUES (NEW.hash, NEW.size);
+    UPDATE blobs SET ref_count = ref_count + 1, released_at = NULL WHERE hash = NEW.hash;
+END;
+
+CREATE TRIGGER blobs_file_versions_delete AFTER DELETE ON file_versions
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1,
+                     released_at = CASE WHEN ref_count = 1 THEN CURRENT_TIMESTAMP END
+    WHERE hash = OLD.hash;
+END;
+
+CREATE TRIGGER blobs_file_versions_update AFTER UPDATE OF hash ON file_versions WHEN NEW.hash != OLD.hash
+BEGIN
+    INSERT OR IGNORE INTO blobs (hash, size) VALUES (NEW.hash, NEW.size);
+    UPDATE blobs SET ref_count = ref_count + 1, released_at = NULL WHERE hash = NEW.hash;
+    UPDATE blobs SET ref_count = ref_count - 1,
+                     released_at = CASE WHEN ref_count = 1 THEN CURRENT_TIMESTAMP END
+    WHERE hash = OLD.hash;
+END;
+
+CREATE TRIGGER blobs_trash_entries_insert AFTER INSERT ON trash_entries WHEN NEW.is_folder = 0
+BEGIN
+    INSERT OR IGNORE INTO blobs (hash, size) VALUES (NEW.hash, NEW.size);
+    UPDATE blobs SET ref_count = ref_count + 1, released_at = NULL WHERE hash = NEW.hash;
+END;
+
+CREATE TRIGGER blobs_trash_entries_delete AFTER DELETE ON trash_entries WHEN OLD.is_folder = 0
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1,
+                     released_at = CASE WHEN ref_count = 1 THEN CURRENT_TIMESTAMP END
+    WHERE hash = OLD.hash;
+END;
+
+CREATE TRIGGER blobs_trash_entries_update AFTER UPDATE OF hash ON trash_entries
+    WHEN NEW.is_folder = 0 AND NEW.hash != OLD.hash
+BEGIN
+    INSERT OR IGNORE INTO blobs (hash, size) VALUES (NEW.hash, NEW.size);
+    UPDATE blobs SET ref_count = ref_count + 1, released_at = NULL WHERE hash = NEW.hash;
+    UPDATE blobs SET ref_count = ref_count - 1,
+                     released_at = CASE WHEN ref_count = 1 THEN CURRENT_TIMESTAMP END
+    WHERE hash = OLD.hash;
+END;
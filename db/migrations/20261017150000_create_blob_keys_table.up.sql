@@ -0,0 +1,12 @@
+-- Data keys of encrypted contents. The content of hash is stored encrypted
+-- as object in the underlying store; wrapped_key is its data key encrypted
+-- with the master key key_id. Rows are removed when the content is deleted.
+CREATE TABLE blob_keys (
+    hash TEXT PRIMARY KEY,
+    object TEXT NOT NULL,
+    key_id TEXT NOT NULL,
+    wrapped_key BLOB NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_blob_keys_key_id ON blob_keys(key_id);
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS users_files_update;
+DROP TRIGGER IF EXISTS users_files_delete;
+DROP TRIGGER IF EXISTS users_files_insert;
+
+ALTER TABLE users DROP COLUMN quota_bytes;
+ALTER TABLE users DROP COLUMN used_bytes;
@@ -0,0 +1,24 @@
+-- used_bytes is the total size of a user's files, kept up to date by the
+-- triggers below. Versions and trashed files are not counted. quota_bytes
+-- overrides the instance default, 0 means unlimited.
+ALTER TABLE users ADD COLUMN used_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN quota_bytes BIGINT;
+
+UPDATE users SET used_bytes = (SELECT COALESCE(SUM(size), 0) FROM files WHERE files.user_id = users.id);
+
+CREATE TRIGGER users_files_insert AFTER INSERT ON files
+BEGIN
+    UPDATE users SET used_bytes = used_bytes + NEW.size WHERE id = NEW.user_id;
+END;
+
+CREATE TRIGGER users_files_delete AFTER DELETE ON files
+BEGIN
+    UPDATE users SET used_bytes = used_bytes - OLD.size WHERE id = OLD.user_id;
+END;
+
+CREATE TRIGGER users_files_update AFTER UPDATE OF size, user_id ON files
+    WHEN NEW.size != OLD.size OR NEW.user_id != OLD.user_id
+BEGIN
+    UPDATE users SET used_bytes = used_bytes - OLD.size WHERE id = OLD.user_id;
+    UPDATE users SET used_bytes = used_bytes + NEW.size WHERE id = NEW.user_id;
+END;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS thumbnails_blobs_delete;
+DROP TRIGGER IF EXISTS blobs_thumbnails_delete;
+DROP TRIGGER IF EXISTS blobs_thumbnails_insert;
+
+DROP TABLE IF EXISTS thumbnails;
@@ -0,0 +1,31 @@
+-- Thumbnails are made per content, so files sharing a content share their
+-- thumbnails and a changed file gets new ones under its new hash. The JPEG
+-- of a thumbnail is stored as a blob itself, thumb_hash is NULL if the
+-- content could not be decoded. Thumbnails go when their content is deleted
+-- from storage, which in turn releases their own blobs.
+CREATE TABLE thumbnails (
+    hash TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    thumb_hash TEXT,
+    thumb_size BIGINT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (hash, size)
+);
+
+CREATE TRIGGER blobs_thumbnails_insert AFTER INSERT ON thumbnails WHEN NEW.thumb_hash IS NOT NULL
+BEGIN
+    INSERT OR IGNORE INTO blobs (hash, size) VALUES (NEW.thumb_hash, NEW.thumb_size);
+    UPDATE blobs SET ref_count = ref_count + 1, released_at = NULL WHERE hash = NEW.thumb_hash;
+END;
+
+CREATE TRIGGER blobs_thumbnails_delete AFTER DELETE ON thumbnails WHEN OLD.thumb_hash IS NOT NULL
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1,
+                     released_at = CASE WHEN ref_count = 1 THEN CURRENT_TIMESTAMP END
+    WHERE hash = OLD.thumb_hash;
+END;
+
+CREATE TRIGGER thumbnails_blobs_delete AFTER DELETE ON blobs
+BEGIN
+    DELETE FROM thumbnails WHERE hash = OLD.hash;
+END;
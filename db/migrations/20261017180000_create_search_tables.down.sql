@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS search_texts_blobs_delete;
+DROP TRIGGER IF EXISTS search_texts_insert;
+DROP TRIGGER IF EXISTS search_files_delete;
+DROP TRIGGER IF EXISTS search_files_content;
+DROP TRIGGER IF EXISTS search_files_move;
+DROP TRIGGER IF EXISTS search_files_insert;
+
+DROP TABLE IF EXISTS files_search;
+DROP TABLE IF EXISTS search_texts;
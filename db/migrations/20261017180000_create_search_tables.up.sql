@@ -0,0 +1,62 @@
+-- The text of a content is extracted once and shared by all files with that
+-- content. text is empty for contents without any, e.g. a PDF of scans. The
+-- text goes when its content is deleted from storage.
+CREATE TABLE search_texts (
+    hash TEXT PRIMARY KEY,
+    text TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- files_search has a row per file under the same rowid, with the folder path
+-- of the file and the text of its content. Triggers keep it in step with the
+-- files table, whatever changes it.
+CREATE VIRTUAL TABLE files_search USING fts5(
+    name,
+    path,
+    content,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO files_search (rowid, name, path, content)
+SELECT id, name,
+       CASE WHEN location = name THEN '' ELSE substr(location, 1, length(location) - length(name) - 1) END,
+       ''
+FROM files;
+
+CREATE TRIGGER search_files_insert AFTER INSERT ON files
+BEGIN
+    INSERT INTO files_search (rowid, name, path, content)
+    VALUES (NEW.id, NEW.name,
+            CASE WHEN NEW.location = NEW.name THEN '' ELSE substr(NEW.location, 1, length(NEW.location) - length(NEW.name) - 1) END,
+            COALESCE((SELECT text FROM search_texts WHERE hash = NEW.hash), ''));
+END;
+
+CREATE TRIGGER search_files_move AFTER UPDATE OF name, location ON files
+BEGIN
+    UPDATE files_search
+    SET name = NEW.name,
+        path = CASE WHEN NEW.location = NEW.name THEN '' ELSE substr(NEW.location, 1, length(NEW.location) - length(NEW.name) - 1) END
+    WHERE rowid = NEW.id;
+END;
+
+CREATE TRIGGER search_files_content AFTER UPDATE OF hash ON files
+BEGIN
+    UPDATE files_search
+    SET content = COALESCE((SELECT text FROM search_texts WHERE hash = NEW.hash), '')
+    WHERE rowid = NEW.id;
+END;
+
+CREATE TRIGGER search_files_delete AFTER DELETE ON files
+BEGIN
+    DELETE FROM files_search WHERE rowid = OLD.id;
+END;
+
+CREATE TRIGGER search_texts_insert AFTER INSERT ON search_texts
+BEGIN
+    UPDATE files_search SET content = NEW.text WHERE rowid IN (SELECT id FROM files WHERE hash = NEW.hash);
+END;
+
+CREATE TRIGGER search_texts_blobs_delete AFTER DELETE ON blobs
+BEGIN
+    DELETE FROM search_texts WHERE hash = OLD.hash;
+END;
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS shares_groups_delete;
+DROP TRIGGER IF EXISTS shares_files_delete;
+DROP TRIGGER IF EXISTS shares_folders_delete;
+DROP TABLE IF EXISTS shares;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
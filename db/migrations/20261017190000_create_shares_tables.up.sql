@@ -0,0 +1,57 @@
+-- Groups are named sets of users, owned by the user who made them, that
+-- folders and files can be shared with as a whole.
+CREATE TABLE groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(owner_id, name)
+);
+
+CREATE TABLE group_members (
+    group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id)
+);
+
+-- A share grants a user or a group a permission on a folder, including
+-- everything below it, or on a single file. permission is 1 for read, 2 for
+-- write and 3 for manage, see model.Permission.
+CREATE TABLE shares (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    folder_id INTEGER REFERENCES folders(id) ON DELETE CASCADE,
+    file_id INTEGER REFERENCES files(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    group_id INTEGER REFERENCES groups(id) ON DELETE CASCADE,
+    permission INTEGER NOT NULL CHECK (permission BETWEEN 1 AND 3),
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((folder_id IS NULL) <> (file_id IS NULL)),
+    CHECK ((user_id IS NULL) <> (group_id IS NULL))
+);
+
+CREATE UNIQUE INDEX idx_shares_item_target ON shares(
+    IFNULL(folder_id, 0), IFNULL(file_id, 0), IFNULL(user_id, 0), IFNULL(group_id, 0)
+);
+CREATE INDEX idx_shares_user_id ON shares(user_id);
+CREATE INDEX idx_shares_group_id ON shares(group_id);
+CREATE INDEX idx_group_members_user_id ON group_members(user_id);
+
+-- foreign_keys is only switched on for one connection of the pool, so the
+-- cascades above are backed by triggers. Trashed items lose their shares.
+CREATE TRIGGER shares_folders_delete AFTER DELETE ON folders
+BEGIN
+    DELETE FROM shares WHERE folder_id = OLD.id;
+END;
+
+CREATE TRIGGER shares_files_delete AFTER DELETE ON files
+BEGIN
+    DELETE FROM shares WHERE file_id = OLD.id;
+END;
+
+CREATE TRIGGER shares_groups_delete AFTER DELETE ON groups
+BEGIN
+    DELETE FROM shares WHERE group_id = OLD.id;
+    DELETE FROM group_members WHERE group_id = OLD.id;
+END;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS download_links_files_delete;
+DROP TRIGGER IF EXISTS download_links_folders_delete;
+DROP TABLE IF EXISTS download_links;
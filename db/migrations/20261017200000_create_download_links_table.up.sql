@@ -0,0 +1,34 @@
+-- A download link gives everyone who knows its token, and its password if
+-- one is set, read access to a folder with everything below it or to a
+-- single file. password is empty for links without one, expires_at NULL for
+-- links that do not expire and max_downloads 0 for unlimited downloads.
+CREATE TABLE download_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    folder_id INTEGER REFERENCES folders(id) ON DELETE CASCADE,
+    file_id INTEGER REFERENCES files(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    password TEXT NOT NULL DEFAULT '',
+    link_token TEXT NOT NULL UNIQUE,
+    expires_at DATETIME,
+    max_downloads INTEGER NOT NULL DEFAULT 0,
+    downloads INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((folder_id IS NULL) <> (file_id IS NULL))
+);
+
+CREATE INDEX idx_download_links_user_id ON download_links(user_id);
+CREATE INDEX idx_download_links_folder_id ON download_links(folder_id);
+CREATE INDEX idx_download_links_file_id ON download_links(file_id);
+
+-- Like for shares, the cascades are backed by triggers. Trashed items lose
+-- their links.
+CREATE TRIGGER download_links_folders_delete AFTER DELETE ON folders
+BEGIN
+    DELETE FROM download_links WHERE folder_id = OLD.id;
+END;
+
+CREATE TRIGGER download_links_files_delete AFTER DELETE ON files
+BEGIN
+    DELETE FROM download_links WHERE file_id = OLD.id;
+END;
@@ -0,0 +1,3 @@
+-- Unlocked upload links are remembered in a signed cookie now, which works
+-- for visitors without an account as well.
+DROP TABLE IF EXISTS link_unlocks;
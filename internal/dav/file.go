@@ -0,0 +1,185 @@
+package dav
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"golang.org/x/net/webdav"
+)
+
+// fileInfo describes a folder or file row as an os.FileInfo. It also
+// implements webdav.ContentTyper and webdav.ETager so the stored MIME type and
+// hash are used instead of reading the file.
+type fileInfo struct {
+	name     string
+	size     int64
+	modTime  time.Time
+	isDir    bool
+	mimeType string
+	hash     string
+}
+
+func newFolderInfo(f *model.Folder) *fileInfo {
+	return &fileInfo{name: f.Name, modTime: f.UpdatedAt, isDir: true}
+}
+
+func newFileInfo(f *model.File) *fileInfo {
+	return &fileInfo{name: f.Name, size: f.Size, modTime: f.CreatedAt, mimeType: f.MimeType, hash: f.Hash}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (fi *fileInfo) ContentType(context.Context) (string, error) {
+	if fi.isDir || fi.mimeType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.mimeType, nil
+}
+
+func (fi *fileInfo) ETag(context.Context) (string, error) {
+	if fi.hash == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + fi.hash + `"`, nil
+}
+
+// dirFile lists the contents of a folder.
+type dirFile struct {
+	ctx     context.Context
+	fs      *FileSystem
+	folder  *model.Folder
+	entries []os.FileInfo
+	loaded  bool
+}
+
+func (d *dirFile) Close() error                             { return nil }
+func (d *dirFile) Read([]byte) (int, error)                 { return 0, os.ErrInvalid }
+func (d *dirFile) Write([]byte) (int, error)                { return 0, os.ErrPermission }
+func (d *dirFile) Seek(int64, int) (int64, error)           { return 0, nil }
+func (d *dirFile) Stat() (os.FileInfo, error)               { return newFolderInfo(d.folder), nil }
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) { return d.readdir(count) }
+
+func (d *dirFile) readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		folders, files, err := d.fs.folders.GetFolderContents(d.ctx, d.fs.user.ID, d.folder.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range folders {
+			d.entries = append(d.entries, newFolderInfo(f))
+		}
+		for _, f := range files {
+			d.entries = append(d.entries, newFileInfo(f))
+		}
+		d.loaded = true
+	}
+
+	// Same semantics as os.File.Readdir.
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+// readFile serves the stored bytes of a file.
+type readFile struct {
+	io.ReadSeekCloser
+	info *fileInfo
+}
+
+func (f *readFile) Write([]byte) (int, error)          { return 0, os.ErrPermission }
+func (f *readFile) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+func (f *readFile) Stat() (os.FileInfo, error)         { return f.info, nil }
+
+// writeFile pipes everything written to it into PersonalFileService.StoreFile,
+// so uploads are streamed to disk and registered like web uploads. The file is
+// only stored once Close returns without an error.
+type writeFile struct {
+	pw      *io.PipeWriter
+	done    chan error
+	name    string
+	modTime time.Time
+	written int64
+	// stored is set by the StoreFile goroutine before it sends to done and
+	// only read after Close received from done.
+	stored *model.File
+	info   *fileInfo
+}
+
+func newWriteFile(ctx context.Context, user *model.User, files *service.PersonalFileService, folder *model.Folder, name string) *writeFile {
+	pr, pw := io.Pipe()
+	f := &writeFile{pw: pw, done: make(chan error, 1), name: name, modTime: time.Now()}
+	go func() {
+		stored, err := files.StoreFile(ctx, user, folder.ID, folder.Path, name, pr)
+		_ = pr.CloseWithError(err)
+		f.stored = stored
+		f.done <- err
+	}()
+	return f
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	n, err := f.pw.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// ReadFrom is what io.Copy uses to write a PUT body into the file. Unlike
+// Write it sees when reading the body fails, like when the client goes away
+// or the upload is too large, and then fails StoreFile, so Close does not
+// store the part of the body that arrived.
+func (f *writeFile) ReadFrom(src io.Reader) (int64, error) {
+	n, err := io.Copy(writerOnly{f}, src)
+	if err != nil {
+		_ = f.pw.CloseWithError(err)
+	}
+	return n, err
+}
+
+// writerOnly hides ReadFrom, so io.Copy in ReadFrom does not call it again.
+type writerOnly struct{ io.Writer }
+
+func (f *writeFile) Close() error {
+	_ = f.pw.Close()
+	err := <-f.done
+	if err == nil {
+		f.info = newFileInfo(f.stored)
+	}
+	return err
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	if f.info != nil {
+		return f.info, nil
+	}
+	return &fileInfo{name: f.name, size: f.written, modTime: f.modTime}, nil
+}
+
+func (f *writeFile) Read([]byte) (int, error)           { return 0, os.ErrInvalid }
+func (f *writeFile) Seek(int64, int) (int64, error)     { return 0, os.ErrInvalid }
+func (f *writeFile) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
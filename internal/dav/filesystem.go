@@ -0,0 +1,179 @@
+// Package dav exposes the folder tree of a user over WebDAV.
+package dav
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"golang.org/x/net/webdav"
+)
+
+// FileSystem implements webdav.FileSystem for a single user. Every change goes
+// through the services, so the folders table and the files on disk stay in
+// sync with what the web UI shows.
+type FileSystem struct {
+	user    *model.User
+	folders *service.FolderService
+	files   *service.PersonalFileService
+	trash   *service.TrashService
+}
+
+func NewFileSystem(user *model.User, folders *service.FolderService, files *service.PersonalFileService, trash *service.TrashService) *FileSystem {
+	return &FileSystem{user: user, folders: folders, files: files, trash: trash}
+}
+
+// toDBPath converts a WebDAV name like "/docs/a.txt" to "docs/a.txt".
+func toDBPath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// splitDBPath returns the parent folder path and the base name of a DB path.
+func splitDBPath(dbPath string) (string, string) {
+	parent, base := path.Split(dbPath)
+	return strings.TrimSuffix(parent, "/"), base
+}
+
+func (fs *FileSystem) folder(ctx context.Context, dbPath string) (*model.Folder, error) {
+	folder, err := fs.folders.GetByDBPath(ctx, fs.user.ID, dbPath)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return folder, nil
+}
+
+// lookup resolves a DB path to either a folder or a file together with the
+// folder containing it.
+func (fs *FileSystem) lookup(ctx context.Context, dbPath string) (*model.Folder, *model.File, *model.Folder, error) {
+	if folder, err := fs.folder(ctx, dbPath); err == nil {
+		return folder, nil, nil, nil
+	}
+	if dbPath == "" {
+		return nil, nil, nil, os.ErrNotExist
+	}
+	parentPath, name := splitDBPath(dbPath)
+	parent, err := fs.folder(ctx, parentPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	file, err := fs.files.GetFileByName(ctx, fs.user.ID, parent.ID, name)
+	if err != nil {
+		return nil, nil, nil, os.ErrNotExist
+	}
+	return nil, file, parent, nil
+}
+
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, _ os.FileMode) error {
+	dbPath := toDBPath(name)
+	if _, _, _, err := fs.lookup(ctx, dbPath); err == nil {
+		return os.ErrExist
+	}
+	parentPath, base := splitDBPath(dbPath)
+	parent, err := fs.folder(ctx, parentPath)
+	if err != nil {
+		return err
+	}
+	_, err = fs.folders.CreateFolder(ctx, fs.user.ID, fs.user.Username, parent.ID, base, "/"+dbPath)
+	return err
+}
+
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	dbPath := toDBPath(name)
+	folder, file, _, err := fs.lookup(ctx, dbPath)
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		if folder != nil {
+			return nil, os.ErrPermission
+		}
+		if file == nil && flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		parentPath, base := splitDBPath(dbPath)
+		parent, err := fs.folder(ctx, parentPath)
+		if err != nil {
+			return nil, err
+		}
+		return newWriteFile(ctx, fs.user, fs.files, parent, base), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if folder != nil {
+		return &dirFile{ctx: ctx, fs: fs, folder: folder}, nil
+	}
+
+	rc, err := fs.files.OpenFile(ctx, fs.user, file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return &readFile{ReadSeekCloser: rc, info: newFileInfo(file)}, nil
+}
+
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	dbPath := toDBPath(name)
+	if dbPath == "" {
+		return os.ErrPermission
+	}
+	folder, file, _, err := fs.lookup(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+	// Deletions go to the trash like in the web UI.
+	if file != nil {
+		_, err = fs.trash.TrashFile(ctx, fs.user, file.ID)
+		return err
+	}
+	_, err = fs.trash.TrashFolder(ctx, fs.user, folder.ID)
+	return err
+}
+
+func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, newPath := toDBPath(oldName), toDBPath(newName)
+	if oldPath == "" || newPath == "" {
+		return os.ErrPermission
+	}
+	folder, file, _, err := fs.lookup(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+
+	parentPath, base := splitDBPath(newPath)
+	parent, err := fs.folder(ctx, parentPath)
+	if err != nil {
+		return err
+	}
+	if folder != nil {
+		err := fs.folders.MoveFolder(ctx, fs.user, folder.ID, parent.ID, base)
+		switch {
+		case errors.Is(err, service.ErrFolderAlreadyExists):
+			return os.ErrExist
+		case errors.Is(err, service.ErrCannotMoveToChild), errors.Is(err, service.ErrCannotMoveRoot):
+			return os.ErrPermission
+		}
+		return err
+	}
+	err = fs.files.MoveFile(ctx, fs.user, file.ID, parent, base)
+	if errors.Is(err, service.ErrFileAlreadyExists) || errors.Is(err, service.ErrFolderAlreadyExists) {
+		return os.ErrExist
+	}
+	return err
+}
+
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	folder, file, _, err := fs.lookup(ctx, toDBPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if folder != nil {
+		return newFolderInfo(folder), nil
+	}
+	return newFileInfo(file), nil
+}
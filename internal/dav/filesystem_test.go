@@ -0,0 +1,239 @@
+package dav_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/dav"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+	"golang.org/x/net/webdav"
+)
+
+func setupDavTest(t *testing.T) (*dav.FileSystem, *service.FolderService, *model.User, int64) {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	trashRepo := repository.NewTrashRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, path.New(tmpDir))
+	fileSvc := service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, path.New(tmpDir))
+	trashSvc := service.NewTrashService(trashRepo, folderRepo, fileRepo, service.NewQuotaService(userRepo, 0), access, path.New(tmpDir))
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	user := &model.User{ID: userID, Username: "testuser"}
+
+	root, err := folderSvc.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/")
+	if err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+
+	return dav.NewFileSystem(user, folderSvc, fileSvc, trashSvc), folderSvc, user, root.ID
+}
+
+func writeDavFile(t *testing.T, fs webdav.FileSystem, name, content string) {
+	t.Helper()
+	ctx := testutil.TestContext(t)
+
+	f, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile(%q) for writing failed: %v", name, err)
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		t.Fatalf("write %q failed: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close %q failed: %v", name, err)
+	}
+}
+
+func readDavFile(t *testing.T, fs webdav.FileSystem, name string) string {
+	t.Helper()
+	ctx := testutil.TestContext(t)
+
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(%q) for reading failed: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %q failed: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestFileSystem_WriteAndRead(t *testing.T) {
+	fs, folderSvc, user, _ := setupDavTest(t)
+	ctx := testutil.TestContext(t)
+
+	if err := fs.Mkdir(ctx, "/docs", 0o755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	writeDavFile(t, fs, "/docs/a.txt", "first")
+	writeDavFile(t, fs, "/docs/a.txt", "second version")
+
+	if got := readDavFile(t, fs, "/docs/a.txt"); got != "second version" {
+		t.Errorf("content = %q, want %q", got, "second version")
+	}
+
+	info, err := fs.Stat(ctx, "/docs/a.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.IsDir() || info.Size() != int64(len("second version")) {
+		t.Errorf("unexpected file info: dir=%v size=%d", info.IsDir(), info.Size())
+	}
+
+	// Overwriting must not leave a second row behind.
+	docs, err := folderSvc.GetByDBPath(ctx, user.ID, "docs")
+	if err != nil {
+		t.Fatalf("GetByDBPath failed: %v", err)
+	}
+	_, files, err := folderSvc.GetFolderContents(ctx, user.ID, docs.ID)
+	if err != nil {
+		t.Fatalf("GetFolderContents failed: %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected 1 file after overwrite, got %d", len(files))
+	}
+
+	dir, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(/) failed: %v", err)
+	}
+	entries, err := dir.Readdir(0)
+	if err != nil {
+		t.Fatalf("Readdir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "docs" || !entries[0].IsDir() {
+		t.Errorf("unexpected root listing: %v", entries)
+	}
+}
+
+// failingBody sends some bytes of a body and then fails like a connection
+// that was cut.
+type failingBody struct{ sent bool }
+
+func (b *failingBody) Read(p []byte) (int, error) {
+	if !b.sent {
+		b.sent = true
+		return copy(p, "partial"), nil
+	}
+	return 0, errors.New("connection reset")
+}
+
+func TestFileSystem_InterruptedPut(t *testing.T) {
+	fs, folderSvc, user, _ := setupDavTest(t)
+	ctx := testutil.TestContext(t)
+	h := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+
+	writeDavFile(t, fs, "/a.txt", "complete content")
+	for _, name := range []string{"/a.txt", "/new.txt"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, name, &failingBody{}))
+		if w.Code < 400 {
+			t.Errorf("PUT %s: expected an error, got %d", name, w.Code)
+		}
+	}
+
+	if got := readDavFile(t, fs, "/a.txt"); got != "complete content" {
+		t.Errorf("expected the content to be kept, got %q", got)
+	}
+	if _, err := fs.Stat(ctx, "/new.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no file from an interrupted PUT, got %v", err)
+	}
+	root, err := folderSvc.GetByDBPath(ctx, user.ID, "")
+	if err != nil {
+		t.Fatalf("GetByDBPath failed: %v", err)
+	}
+	_, files, err := folderSvc.GetFolderContents(ctx, user.ID, root.ID)
+	if err != nil || len(files) != 1 || files[0].Version != 1 {
+		t.Errorf("expected a.txt alone at version 1, got %d files, %v", len(files), err)
+	}
+}
+
+func TestFileSystem_Errors(t *testing.T) {
+	fs, _, _, _ := setupDavTest(t)
+	ctx := testutil.TestContext(t)
+
+	if err := fs.Mkdir(ctx, "/docs", 0o755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		fn   func() error
+		want error
+	}{
+		{"stat missing", func() error { _, err := fs.Stat(ctx, "/missing.txt"); return err }, os.ErrNotExist},
+		{"mkdir existing", func() error { return fs.Mkdir(ctx, "/docs", 0o755) }, os.ErrExist},
+		{"mkdir without parent", func() error { return fs.Mkdir(ctx, "/a/b", 0o755) }, os.ErrNotExist},
+		{"remove root", func() error { return fs.RemoveAll(ctx, "/") }, os.ErrPermission},
+		{"write to folder", func() error {
+			_, err := fs.OpenFile(ctx, "/docs", os.O_RDWR|os.O_CREATE, 0o644)
+			return err
+		}, os.ErrPermission},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSystem_RenameAndRemove(t *testing.T) {
+	fs, _, _, _ := setupDavTest(t)
+	ctx := testutil.TestContext(t)
+
+	if err := fs.Mkdir(ctx, "/docs", 0o755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := fs.Mkdir(ctx, "/docs/sub", 0o755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	writeDavFile(t, fs, "/a.txt", "hello")
+	writeDavFile(t, fs, "/docs/sub/b.txt", "nested")
+
+	if err := fs.Rename(ctx, "/a.txt", "/docs/renamed.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := fs.Stat(ctx, "/a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old name still exists: %v", err)
+	}
+	if got := readDavFile(t, fs, "/docs/renamed.txt"); got != "hello" {
+		t.Errorf("content after rename = %q, want %q", got, "hello")
+	}
+
+	if err := fs.RemoveAll(ctx, "/docs"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	for _, name := range []string{"/docs", "/docs/renamed.txt", "/docs/sub/b.txt"} {
+		if _, err := fs.Stat(ctx, name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s still exists after RemoveAll: %v", name, err)
+		}
+	}
+}
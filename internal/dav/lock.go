@@ -0,0 +1,78 @@
+package dav
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// userLockSystem gives every user their own part of one shared lock system.
+// Paths are moved below /<user id> and tokens carry the user id, so users
+// neither see each other's locks nor can confirm, refresh or release them
+// with a guessed token. The shared lock system forgets locks once they are
+// released or expired, so nothing is kept per user.
+type userLockSystem struct {
+	ls     webdav.LockSystem
+	root   string
+	prefix string
+}
+
+// NewUserLockSystem returns the part of ls for the user with userID.
+func NewUserLockSystem(ls webdav.LockSystem, userID int64) webdav.LockSystem {
+	id := strconv.FormatInt(userID, 10)
+	return &userLockSystem{ls: ls, root: "/" + id, prefix: id + ":"}
+}
+
+func (u *userLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	if name0 != "" {
+		name0 = u.root + name0
+	}
+	if name1 != "" {
+		name1 = u.root + name1
+	}
+	own := make([]webdav.Condition, len(conditions))
+	for i, c := range conditions {
+		if c.Token != "" {
+			c.Token = u.token(c.Token)
+		}
+		own[i] = c
+	}
+	return u.ls.Confirm(now, name0, name1, own...)
+}
+
+func (u *userLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Root = u.root + details.Root
+	token, err := u.ls.Create(now, details)
+	if err != nil {
+		return "", err
+	}
+	return u.prefix + token, nil
+}
+
+func (u *userLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	details, err := u.ls.Refresh(now, u.token(token), duration)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	details.Root = strings.TrimPrefix(details.Root, u.root)
+	if details.Root == "" {
+		details.Root = "/"
+	}
+	return details, nil
+}
+
+func (u *userLockSystem) Unlock(now time.Time, token string) error {
+	return u.ls.Unlock(now, u.token(token))
+}
+
+// token returns the token of the shared lock system for a token of the user.
+// Tokens of other users become one that matches no lock.
+func (u *userLockSystem) token(token string) string {
+	t, ok := strings.CutPrefix(token, u.prefix)
+	if !ok {
+		return "-"
+	}
+	return t
+}
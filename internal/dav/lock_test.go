@@ -0,0 +1,53 @@
+package dav_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/dav"
+	"golang.org/x/net/webdav"
+)
+
+func TestUserLockSystem(t *testing.T) {
+	shared := webdav.NewMemLS()
+	alice, bob := dav.NewUserLockSystem(shared, 1), dav.NewUserLockSystem(shared, 2)
+	now := time.Now()
+	details := webdav.LockDetails{Root: "/docs/a.txt", Duration: time.Hour, ZeroDepth: true}
+
+	aliceToken, err := alice.Create(now, details)
+	if err != nil {
+		t.Fatalf("Create for alice failed: %v", err)
+	}
+	bobToken, err := bob.Create(now, details)
+	if err != nil {
+		t.Fatalf("expected bob to lock the same path of his own files, got %v", err)
+	}
+
+	if _, err := bob.Confirm(now, "/docs/a.txt", "", webdav.Condition{Token: aliceToken}); !errors.Is(err, webdav.ErrConfirmationFailed) {
+		t.Errorf("expected the token of alice not to confirm for bob, got %v", err)
+	}
+	_, raw, _ := strings.Cut(aliceToken, ":")
+	for _, token := range []string{aliceToken, raw} {
+		if err := bob.Unlock(now, token); !errors.Is(err, webdav.ErrNoSuchLock) {
+			t.Errorf("expected bob not to release the lock of alice with %q, got %v", token, err)
+		}
+	}
+
+	release, err := alice.Confirm(now, "/docs/a.txt", "", webdav.Condition{Token: aliceToken})
+	if err != nil {
+		t.Fatalf("Confirm for alice failed: %v", err)
+	}
+	release()
+	got, err := alice.Refresh(now, aliceToken, time.Hour)
+	if err != nil || got.Root != "/docs/a.txt" {
+		t.Errorf("expected the lock of alice on /docs/a.txt, got %q, %v", got.Root, err)
+	}
+	if err := alice.Unlock(now, aliceToken); err != nil {
+		t.Errorf("Unlock for alice failed: %v", err)
+	}
+	if err := bob.Unlock(now, bobToken); err != nil {
+		t.Errorf("Unlock for bob failed: %v", err)
+	}
+}
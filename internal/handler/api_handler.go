@@ -0,0 +1,1342 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAPIBodySize caps JSON request bodies, uploads are limited separately.
+const maxAPIBodySize = 1 << 20
+
+// APIHandler serves the versioned JSON API under /api/v1/. It is described in
+// static/openapi.yaml, keep both in sync.
+type APIHandler struct {
+	*baseHandler
+	folderService       *service.FolderService
+	fileService         *service.PersonalFileService
+	linkService         *service.UploadLinkService
+	trashService        *service.TrashService
+	versionService      *service.FileVersionService
+	archiveService      *service.ArchiveService
+	extractService      *service.ExtractService
+	searchService       *service.SearchService
+	shareService        *service.ShareService
+	groupService        *service.GroupService
+	downloadLinkService *service.DownloadLinkService
+}
+
+func NewAPIHandler(cfg *config.Config, r *Renderer, folderService *service.FolderService, fileService *service.PersonalFileService, linkService *service.UploadLinkService, trashService *service.TrashService, versionService *service.FileVersionService, archiveService *service.ArchiveService, extractService *service.ExtractService, searchService *service.SearchService, shareService *service.ShareService, groupService *service.GroupService, downloadLinkService *service.DownloadLinkService) *APIHandler {
+	return &APIHandler{
+		baseHandler:         newBaseHandler(cfg, r),
+		folderService:       folderService,
+		fileService:         fileService,
+		linkService:         linkService,
+		trashService:        trashService,
+		versionService:      versionService,
+		archiveService:      archiveService,
+		extractService:      extractService,
+		searchService:       searchService,
+		shareService:        shareService,
+		groupService:        groupService,
+		downloadLinkService: downloadLinkService,
+	}
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiFolder struct {
+	ID        int64     `json:"id"`
+	ParentID  *int64    `json:"parent_id"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type apiFile struct {
+	ID        int64      `json:"id"`
+	FolderID  *int64     `json:"folder_id"`
+	Name      string     `json:"name"`
+	Path      string     `json:"path"`
+	Size      int64      `json:"size"`
+	MimeType  string     `json:"mime_type"`
+	Hash      string     `json:"hash"`
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+type apiFileVersion struct {
+	Version    int       `json:"version"`
+	Size       int64     `json:"size"`
+	MimeType   string    `json:"mime_type"`
+	Hash       string    `json:"hash"`
+	CreatedAt  time.Time `json:"created_at"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+type apiFolderContents struct {
+	Folder  apiFolder   `json:"folder"`
+	Folders []apiFolder `json:"folders"`
+	Files   []apiFile   `json:"files"`
+}
+
+type apiExtraction struct {
+	Folder  apiFolder `json:"folder"`
+	Folders int       `json:"folders"`
+	Files   int       `json:"files"`
+	Bytes   int64     `json:"bytes"`
+	Skipped []string  `json:"skipped"`
+}
+
+type apiSearchResult struct {
+	File    apiFile `json:"file"`
+	Snippet string  `json:"snippet"`
+}
+
+type apiTrashItem struct {
+	ID               int64      `json:"id"`
+	Name             string     `json:"name"`
+	IsFolder         bool       `json:"is_folder"`
+	OriginalPath     string     `json:"original_path"`
+	OriginalFolderID *int64     `json:"original_folder_id"`
+	Size             int64      `json:"size"`
+	FileCount        int        `json:"file_count"`
+	DeletedAt        time.Time  `json:"deleted_at"`
+	PurgeAt          *time.Time `json:"purge_at"`
+}
+
+type apiLink struct {
+	Token       string    `json:"token"`
+	Name        string    `json:"name"`
+	FolderID    *int64    `json:"folder_id"`
+	URL         string    `json:"url"`
+	HasPassword bool      `json:"has_password"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type apiDownloadLink struct {
+	Token        string     `json:"token"`
+	Name         string     `json:"name"`
+	FolderID     *int64     `json:"folder_id"`
+	FileID       *int64     `json:"file_id"`
+	URL          string     `json:"url"`
+	HasPassword  bool       `json:"has_password"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxDownloads int64      `json:"max_downloads"`
+	Downloads    int64      `json:"downloads"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type apiShare struct {
+	ID         int64     `json:"id"`
+	FolderID   *int64    `json:"folder_id"`
+	FileID     *int64    `json:"file_id"`
+	UserID     *int64    `json:"user_id"`
+	GroupID    *int64    `json:"group_id"`
+	Target     string    `json:"target"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type apiSharedItem struct {
+	FolderID   *int64    `json:"folder_id"`
+	FileID     *int64    `json:"file_id"`
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	Permission string    `json:"permission"`
+	ShareID    *int64    `json:"share_id"`
+	SharedAt   time.Time `json:"shared_at"`
+}
+
+type apiGroup struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Members   []string  `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func nullableID(id sql.NullInt64) *int64 {
+	if !id.Valid {
+		return nil
+	}
+	return &id.Int64
+}
+
+func toAPIFolder(f *model.Folder) apiFolder {
+	return apiFolder{
+		ID:        f.ID,
+		ParentID:  nullableID(f.ParentID),
+		Name:      f.Name,
+		Path:      f.Path,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.UpdatedAt,
+	}
+}
+
+func toAPIFile(f *model.File) apiFile {
+	res := apiFile{
+		ID:        f.ID,
+		FolderID:  nullableID(f.FolderID),
+		Name:      f.Name,
+		Path:      f.Location,
+		Size:      f.Size,
+		MimeType:  f.MimeType,
+		Hash:      f.Hash,
+		Version:   f.Version,
+		CreatedAt: f.CreatedAt,
+	}
+	if f.UpdatedAt.Valid {
+		res.UpdatedAt = &f.UpdatedAt.Time
+	}
+	return res
+}
+
+func toAPIFileVersion(v *model.FileVersion) apiFileVersion {
+	return apiFileVersion{
+		Version:    v.Version,
+		Size:       v.Size,
+		MimeType:   v.MimeType,
+		Hash:       v.Hash,
+		CreatedAt:  v.CreatedAt,
+		ArchivedAt: v.ArchivedAt,
+	}
+}
+
+func (h *APIHandler) toAPITrashItem(t *model.TrashItem) apiTrashItem {
+	return apiTrashItem{
+		ID:               t.ID,
+		Name:             t.Name,
+		IsFolder:         t.IsFolder,
+		OriginalPath:     t.OriginalPath,
+		OriginalFolderID: nullableID(t.OriginalFolderID),
+		Size:             t.Size,
+		FileCount:        t.FileCount,
+		DeletedAt:        t.DeletedAt,
+		PurgeAt:          trashPurgeAt(h.cfg, t),
+	}
+}
+
+func toAPILink(l *model.UploadLink) apiLink {
+	return apiLink{
+		Token:       l.LinkToken,
+		Name:        l.Name,
+		FolderID:    nullableID(l.FolderID),
+		URL:         "/links/" + l.LinkToken,
+		HasPassword: l.HasPassword(),
+		CreatedAt:   l.CreatedAt,
+		ExpiresAt:   l.ExpiresAt,
+	}
+}
+
+func toAPIDownloadLink(l *model.DownloadLink) apiDownloadLink {
+	res := apiDownloadLink{
+		Token:        l.LinkToken,
+		Name:         l.Name,
+		FolderID:     nullableID(l.FolderID),
+		FileID:       nullableID(l.FileID),
+		URL:          "/d/" + l.LinkToken,
+		HasPassword:  l.HasPassword(),
+		MaxDownloads: l.MaxDownloads,
+		Downloads:    l.Downloads,
+		CreatedAt:    l.CreatedAt,
+	}
+	if l.ExpiresAt.Valid {
+		res.ExpiresAt = &l.ExpiresAt.Time
+	}
+	return res
+}
+
+func toAPIShare(s *model.Share) apiShare {
+	return apiShare{
+		ID:         s.ID,
+		FolderID:   nullableID(s.FolderID),
+		FileID:     nullableID(s.FileID),
+		UserID:     nullableID(s.UserID),
+		GroupID:    nullableID(s.GroupID),
+		Target:     s.TargetName,
+		Permission: s.Permission.String(),
+		CreatedAt:  s.CreatedAt,
+	}
+}
+
+func toAPISharedItem(it *model.SharedItem) apiSharedItem {
+	return apiSharedItem{
+		FolderID:   nullableID(it.FolderID),
+		FileID:     nullableID(it.FileID),
+		Name:       it.Name,
+		Owner:      it.Owner,
+		Permission: it.Permission.String(),
+		ShareID:    nullableID(it.ShareID),
+		SharedAt:   it.SharedAt,
+	}
+}
+
+func toAPIGroup(g *model.Group) apiGroup {
+	res := apiGroup{
+		ID:        g.ID,
+		Name:      g.Name,
+		Members:   make([]string, len(g.Members)),
+		CreatedAt: g.CreatedAt,
+	}
+	for i, m := range g.Members {
+		res.Members[i] = m.Username
+	}
+	return res
+}
+
+// apiErrors maps service errors to the status and code returned to clients.
+// Errors not listed here are reported as internal errors without details.
+var apiErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{service.ErrFolderNotFound, http.StatusNotFound, "folder_not_found"},
+	{service.ErrFileNotFound, http.StatusNotFound, "file_not_found"},
+	{service.ErrLinkNotFound, http.StatusNotFound, "link_not_found"},
+	{service.ErrTrashItemNotFound, http.StatusNotFound, "trash_item_not_found"},
+	{service.ErrVersionNotFound, http.StatusNotFound, "version_not_found"},
+	{service.ErrShareNotFound, http.StatusNotFound, "share_not_found"},
+	{service.ErrGroupNotFound, http.StatusNotFound, "group_not_found"},
+	{service.ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+	{service.ErrDownloadLinkNotFound, http.StatusNotFound, "download_link_not_found"},
+	{sql.ErrNoRows, http.StatusNotFound, "not_found"},
+	{service.ErrInvalidFolderName, http.StatusBadRequest, "invalid_folder_name"},
+	{service.ErrInvalidFolderPath, http.StatusBadRequest, "invalid_folder_path"},
+	{service.ErrInvalidFileName, http.StatusBadRequest, "invalid_file_name"},
+	{service.ErrEmptyLinkFields, http.StatusBadRequest, "invalid_link_fields"},
+	{service.ErrInvalidPermission, http.StatusBadRequest, "invalid_permission"},
+	{service.ErrInvalidGroupName, http.StatusBadRequest, "invalid_group_name"},
+	{service.ErrCannotShareRoot, http.StatusBadRequest, "cannot_share_root"},
+	{service.ErrShareWithOwner, http.StatusBadRequest, "share_with_owner"},
+	{service.ErrInvalidMaxDownloads, http.StatusBadRequest, "invalid_max_downloads"},
+	{service.ErrPermissionDenied, http.StatusForbidden, "permission_denied"},
+	{service.ErrFolderAlreadyExists, http.StatusConflict, "folder_already_exists"},
+	{service.ErrFileAlreadyExists, http.StatusConflict, "file_already_exists"},
+	{service.ErrCannotMoveToChild, http.StatusConflict, "cannot_move_to_child"},
+	{service.ErrDifferentOwner, http.StatusConflict, "different_owner"},
+	{service.ErrGroupAlreadyExists, http.StatusConflict, "group_already_exists"},
+	{service.ErrCannotDeleteRoot, http.StatusBadRequest, "cannot_delete_root"},
+	{service.ErrCannotMoveRoot, http.StatusBadRequest, "cannot_move_root"},
+	{service.ErrRestoreConflict, http.StatusConflict, "restore_conflict"},
+	{service.ErrLinkExpired, http.StatusGone, "link_expired"},
+	{service.ErrQuotaExceeded, http.StatusRequestEntityTooLarge, "quota_exceeded"},
+	{service.ErrInvalidArchiveFormat, http.StatusBadRequest, "invalid_archive_format"},
+	{service.ErrEmptySelection, http.StatusBadRequest, "empty_selection"},
+	{service.ErrUnsupportedArchive, http.StatusBadRequest, "unsupported_archive"},
+	{service.ErrUnsafeArchivePath, http.StatusBadRequest, "unsafe_archive_path"},
+	{service.ErrArchiveTooLarge, http.StatusRequestEntityTooLarge, "archive_too_large"},
+	{service.ErrEmptySearch, http.StatusBadRequest, "empty_search"},
+	{service.ErrBlobCollected, http.StatusServiceUnavailable, "try_again"},
+}
+
+func (h *APIHandler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("could not encode json response: %v", err)
+	}
+}
+
+func (h *APIHandler) writeError(w http.ResponseWriter, status int, code, message string) {
+	h.writeJSON(w, status, map[string]apiError{"error": {Code: code, Message: message}})
+}
+
+// writeServiceError answers with the status and code mapped from err.
+func (h *APIHandler) writeServiceError(w http.ResponseWriter, err error) {
+	if isUploadTooLarge(err) {
+		h.writeError(w, http.StatusRequestEntityTooLarge, "upload_too_large", "upload too large")
+		return
+	}
+	for _, e := range apiErrors {
+		if errors.Is(err, e.err) {
+			h.writeError(w, e.status, e.code, e.err.Error())
+			return
+		}
+	}
+	logger.Error("api request failed: %v", err)
+	h.writeError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+}
+
+func (h *APIHandler) decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		logger.Error("invalid json body: %v", err)
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "invalid json body")
+		return false
+	}
+	return true
+}
+
+func (h *APIHandler) pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+// NotFound answers every request under /api/v1/ that no route matched.
+func (h *APIHandler) NotFound(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	h.writeError(w, http.StatusNotFound, "not_found", "no such endpoint")
+}
+
+func (h *APIHandler) folderContents(w http.ResponseWriter, r *http.Request, user *model.User, folder *model.Folder) {
+	folders, files, err := h.folderService.GetFolderContents(r.Context(), user.ID, folder.ID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := apiFolderContents{
+		Folder:  toAPIFolder(folder),
+		Folders: make([]apiFolder, len(folders)),
+		Files:   make([]apiFile, len(files)),
+	}
+	for i, f := range folders {
+		res.Folders[i] = toAPIFolder(f)
+	}
+	for i, f := range files {
+		res.Files[i] = toAPIFile(f)
+	}
+	h.writeJSON(w, http.StatusOK, res)
+}
+
+// GetFolderByPath lists the folder given by the path query parameter, the
+// root folder if it is empty.
+func (h *APIHandler) GetFolderByPath(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	folder, err := h.folderService.GetByDBPath(r.Context(), user.ID, strings.Trim(r.URL.Query().Get("path"), "/"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.folderContents(w, r, user, folder)
+}
+
+func (h *APIHandler) GetFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	folder, err := h.folderService.GetById(r.Context(), user.ID, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.folderContents(w, r, user, folder)
+}
+
+// DownloadFolderArchive streams the folder with all of its subfolders as a
+// ZIP or tar.gz archive.
+func (h *APIHandler) DownloadFolderArchive(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	format, err := service.ParseArchiveFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	archive, err := h.archiveService.Folder(r.Context(), user, id, format)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	sendArchive(w, archive)
+}
+
+// DownloadArchive streams a selection of files and folders as one archive.
+func (h *APIHandler) DownloadArchive(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	var req struct {
+		FolderIDs []int64 `json:"folder_ids"`
+		FileIDs   []int64 `json:"file_ids"`
+		Format    string  `json:"format"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	format, err := service.ParseArchiveFormat(req.Format)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	archive, err := h.archiveService.Select(r.Context(), user, req.FolderIDs, req.FileIDs, format)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	sendArchive(w, archive)
+}
+
+// parentOrRoot returns the folder with the given ID or the root folder of the
+// user if id is nil.
+func (h *APIHandler) parentOrRoot(r *http.Request, user *model.User, id *int64) (*model.Folder, error) {
+	if id == nil {
+		return h.folderService.GetByDBPath(r.Context(), user.ID, "")
+	}
+	return h.folderService.GetById(r.Context(), user.ID, *id)
+}
+
+func (h *APIHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	var req struct {
+		ParentID *int64 `json:"parent_id"`
+		Name     string `json:"name"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	parent, err := h.parentOrRoot(r, user, req.ParentID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	folder, err := h.folderService.CreateFolder(r.Context(), user.ID, user.Username, parent.ID, name, path.Join("/", parent.Path, name))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, toAPIFolder(folder))
+}
+
+// UpdateFolder moves and/or renames a folder.
+func (h *APIHandler) UpdateFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		ParentID *int64  `json:"parent_id"`
+		Name     *string `json:"name"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ParentID == nil && req.Name == nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "parent_id or name is required")
+		return
+	}
+	folder, err := h.folderService.GetById(r.Context(), user.ID, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	if !folder.ParentID.Valid {
+		h.writeServiceError(w, service.ErrCannotMoveRoot)
+		return
+	}
+	parentID, name := folder.ParentID.Int64, folder.Name
+	if req.ParentID != nil {
+		parentID = *req.ParentID
+	}
+	if req.Name != nil {
+		name = *req.Name
+	}
+	if err := h.folderService.MoveFolder(r.Context(), user, id, parentID, name); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	folder, err = h.folderService.GetById(r.Context(), user.ID, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, toAPIFolder(folder))
+}
+
+func (h *APIHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	item, err := h.trashService.TrashFolder(r.Context(), user, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, h.toAPITrashItem(item))
+}
+
+// UploadFiles stores every file part of a multipart body in the folder and
+// returns the stored files.
+func (h *APIHandler) UploadFiles(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	folder, err := h.folderService.GetById(r.Context(), user.ID, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	h.limitUploadSize(w, r)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "multipart body required")
+		return
+	}
+
+	stored := []apiFile{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.writeServiceError(w, err)
+			return
+		}
+		if part.FileName() == "" {
+			_ = part.Close()
+			continue
+		}
+		file, err := h.fileService.StoreFile(r.Context(), user, folder.ID, folder.Path, part.FileName(), part)
+		_ = part.Close()
+		if err != nil {
+			h.writeServiceError(w, err)
+			return
+		}
+		stored = append(stored, toAPIFile(file))
+	}
+	h.writeJSON(w, http.StatusCreated, map[string][]apiFile{"files": stored})
+}
+
+// ExtractArchive unpacks the uploaded archive into a new folder in the folder.
+func (h *APIHandler) ExtractArchive(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	folder, err := h.folderService.GetById(r.Context(), user.ID, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	h.limitUploadSize(w, r)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "multipart body required")
+		return
+	}
+	part, err := nextFilePart(reader)
+	if errors.Is(err, errNoFilePart) {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "archive file part required")
+		return
+	}
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	defer part.Close()
+
+	res, err := h.extractService.Extract(r.Context(), user, folder, part.FileName(), part)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	skipped := res.Skipped
+	if skipped == nil {
+		skipped = []string{}
+	}
+	h.writeJSON(w, http.StatusCreated, apiExtraction{
+		Folder:  toAPIFolder(res.Folder),
+		Folders: res.Folders,
+		Files:   res.Files,
+		Bytes:   res.Bytes,
+		Skipped: skipped,
+	})
+}
+
+func (h *APIHandler) readableFile(w http.ResponseWriter, r *http.Request, user *model.User) (*model.File, bool) {
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return nil, false
+	}
+	file, err := h.fileService.GetFile(r.Context(), user.ID, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return nil, false
+	}
+	return file, true
+}
+
+func (h *APIHandler) GetFile(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	file, ok := h.readableFile(w, r, user)
+	if !ok {
+		return
+	}
+	h.writeJSON(w, http.StatusOK, toAPIFile(file))
+}
+
+func (h *APIHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	file, ok := h.readableFile(w, r, user)
+	if !ok {
+		return
+	}
+	d, err := h.fileService.Download(r.Context(), user, file)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	serveDownload(w, r, d)
+}
+
+// ListFileVersions returns the older versions of a file, newest first.
+func (h *APIHandler) ListFileVersions(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	_, versions, err := h.versionService.GetHistory(r.Context(), user, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := make([]apiFileVersion, len(versions))
+	for i, v := range versions {
+		res[i] = toAPIFileVersion(v)
+	}
+	h.writeJSON(w, http.StatusOK, map[string][]apiFileVersion{"versions": res})
+}
+
+// pathVersion looks up the older version of a file addressed by the id and
+// version path values.
+func (h *APIHandler) pathVersion(w http.ResponseWriter, r *http.Request, user *model.User) (*model.FileVersion, bool) {
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return nil, false
+	}
+	n, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "invalid version")
+		return nil, false
+	}
+	v, err := h.versionService.GetVersion(r.Context(), user, id, n)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return nil, false
+	}
+	return v, true
+}
+
+func (h *APIHandler) DownloadFileVersion(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	v, ok := h.pathVersion(w, r, user)
+	if !ok {
+		return
+	}
+	d, err := h.versionService.DownloadVersion(r.Context(), user, v)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	serveDownload(w, r, d)
+}
+
+// RestoreFileVersion makes an older version the current content of a file
+// and returns the file.
+func (h *APIHandler) RestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	v, ok := h.pathVersion(w, r, user)
+	if !ok {
+		return
+	}
+	file, err := h.fileService.RestoreVersion(r.Context(), user, v.FileID, v.Version)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, toAPIFile(file))
+}
+
+// UpdateFile moves and/or renames a file.
+func (h *APIHandler) UpdateFile(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	file, ok := h.readableFile(w, r, user)
+	if !ok {
+		return
+	}
+	var req struct {
+		FolderID *int64  `json:"folder_id"`
+		Name     *string `json:"name"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+
+	folderID := file.FolderID.Int64
+	if req.FolderID != nil {
+		folderID = *req.FolderID
+	}
+	dst, err := h.folderService.GetById(r.Context(), user.ID, folderID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	name := file.Name
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+	}
+
+	if err := h.fileService.MoveFile(r.Context(), user, file.ID, dst, name); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	file, err = h.fileService.GetFileById(r.Context(), file.ID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, toAPIFile(file))
+}
+
+// CopyFile copies a file into another folder or under another name. The copy
+// shares its stored content with the original.
+func (h *APIHandler) CopyFile(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	file, ok := h.readableFile(w, r, user)
+	if !ok {
+		return
+	}
+	var req struct {
+		FolderID *int64  `json:"folder_id"`
+		Name     *string `json:"name"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+
+	folderID := file.FolderID.Int64
+	if req.FolderID != nil {
+		folderID = *req.FolderID
+	}
+	dst, err := h.folderService.GetById(r.Context(), user.ID, folderID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	name := file.Name
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+	}
+
+	cp, err := h.fileService.CopyFile(r.Context(), user, file.ID, dst, name)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, toAPIFile(cp))
+}
+
+func (h *APIHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	file, ok := h.readableFile(w, r, user)
+	if !ok {
+		return
+	}
+	item, err := h.trashService.TrashFile(r.Context(), user, file.ID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, h.toAPITrashItem(item))
+}
+
+// Search returns the files matching the words of q and the filters of
+// parseSearchQuery, best matches first.
+func (h *APIHandler) Search(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	q, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	results, err := h.searchService.Search(r.Context(), user, q)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	plain := strings.NewReplacer(model.MatchStart, "", model.MatchEnd, "")
+	res := make([]apiSearchResult, len(results))
+	for i, sr := range results {
+		res[i] = apiSearchResult{File: toAPIFile(&sr.File), Snippet: plain.Replace(sr.Snippet)}
+	}
+	h.writeJSON(w, http.StatusOK, map[string][]apiSearchResult{"results": res})
+}
+
+func (h *APIHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	items, err := h.trashService.GetUserTrash(r.Context(), user)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := make([]apiTrashItem, len(items))
+	for i, t := range items {
+		res[i] = h.toAPITrashItem(t)
+	}
+	h.writeJSON(w, http.StatusOK, map[string][]apiTrashItem{"items": res})
+}
+
+// RestoreTrashItem moves an item back to where it was deleted from and
+// returns it with its restored path.
+func (h *APIHandler) RestoreTrashItem(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	item, err := h.trashService.Restore(r.Context(), user, id)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := h.toAPITrashItem(item)
+	res.PurgeAt = nil
+	h.writeJSON(w, http.StatusOK, res)
+}
+
+func (h *APIHandler) PurgeTrashItem(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.trashService.Purge(r.Context(), user, id); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APIHandler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	n, err := h.trashService.EmptyTrash(r.Context(), user)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+}
+
+func (h *APIHandler) ListLinks(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	links, err := h.linkService.GetUserLinks(r.Context(), user.ID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := make([]apiLink, len(links))
+	for i, l := range links {
+		res[i] = toAPILink(l)
+	}
+	h.writeJSON(w, http.StatusOK, map[string][]apiLink{"links": res})
+}
+
+func (h *APIHandler) CreateLink(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	var req struct {
+		Name      string    `json:"name"`
+		Password  string    `json:"password"`
+		FolderID  *int64    `json:"folder_id"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ExpiresAt.IsZero() {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "expires_at is required")
+		return
+	}
+	folder, err := h.parentOrRoot(r, user, req.FolderID)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	link, err := h.linkService.CreateUploadLink(r.Context(), user.ID, folder.ID, req.Name, req.Password, req.ExpiresAt.UTC())
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, toAPILink(link))
+}
+
+func (h *APIHandler) GetLink(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	link, err := h.linkService.GetOwnedLink(r.Context(), user.ID, r.PathValue("token"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, toAPILink(link))
+}
+
+func (h *APIHandler) UpdateLink(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	link, err := h.linkService.GetOwnedLink(r.Context(), user.ID, r.PathValue("token"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	var req struct {
+		Name      *string    `json:"name"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	name, expiresAt := link.Name, link.ExpiresAt
+	if req.Name != nil {
+		name = *req.Name
+	}
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.UTC()
+	}
+	link, err = h.linkService.UpdateUploadLink(r.Context(), user.ID, link.LinkToken, name, expiresAt)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, toAPILink(link))
+}
+
+func (h *APIHandler) DeleteLink(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	if err := h.linkService.RevokeUploadLink(r.Context(), user.ID, r.PathValue("token")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDownloadLinks returns the download links of the folder in the folder_id
+// or the file in the file_id query parameter, or without either the links the
+// user created.
+func (h *APIHandler) ListDownloadLinks(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	q := r.URL.Query()
+	var links []*model.DownloadLink
+	var err error
+	switch {
+	case q.Get("folder_id") != "":
+		id, perr := strconv.ParseInt(q.Get("folder_id"), 10, 64)
+		if perr != nil {
+			h.writeError(w, http.StatusBadRequest, "invalid_request", "invalid folder_id")
+			return
+		}
+		links, err = h.downloadLinkService.GetFolderLinks(r.Context(), user, id)
+	case q.Get("file_id") != "":
+		id, perr := strconv.ParseInt(q.Get("file_id"), 10, 64)
+		if perr != nil {
+			h.writeError(w, http.StatusBadRequest, "invalid_request", "invalid file_id")
+			return
+		}
+		links, err = h.downloadLinkService.GetFileLinks(r.Context(), user, id)
+	default:
+		links, err = h.downloadLinkService.GetUserLinks(r.Context(), user)
+	}
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := make([]apiDownloadLink, len(links))
+	for i, l := range links {
+		res[i] = toAPIDownloadLink(l)
+	}
+	h.writeJSON(w, http.StatusOK, map[string][]apiDownloadLink{"links": res})
+}
+
+// CreateDownloadLink creates a public download link to a folder or file.
+func (h *APIHandler) CreateDownloadLink(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	var req struct {
+		FolderID     *int64     `json:"folder_id"`
+		FileID       *int64     `json:"file_id"`
+		Name         string     `json:"name"`
+		Password     string     `json:"password"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+		MaxDownloads int64      `json:"max_downloads"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if (req.FolderID == nil) == (req.FileID == nil) {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "exactly one of folder_id and file_id is required")
+		return
+	}
+	opts := service.DownloadLinkOptions{Name: req.Name, Password: req.Password, MaxDownloads: req.MaxDownloads}
+	if req.ExpiresAt != nil {
+		opts.ExpiresAt = *req.ExpiresAt
+	}
+
+	var link *model.DownloadLink
+	var err error
+	if req.FolderID != nil {
+		link, err = h.downloadLinkService.CreateFolderLink(r.Context(), user, *req.FolderID, opts)
+	} else {
+		link, err = h.downloadLinkService.CreateFileLink(r.Context(), user, *req.FileID, opts)
+	}
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, toAPIDownloadLink(link))
+}
+
+func (h *APIHandler) DeleteDownloadLink(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	if err := h.downloadLinkService.RevokeDownloadLink(r.Context(), user, r.PathValue("token")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSharedWithMe returns the folders and files other users shared with the
+// user.
+func (h *APIHandler) ListSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	items, err := h.shareService.GetSharedWithUser(r.Context(), user)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := make([]apiSharedItem, len(items))
+	for i, it := range items {
+		res[i] = toAPISharedItem(it)
+	}
+	h.writeJSON(w, http.StatusOK, map[string][]apiSharedItem{"items": res})
+}
+
+// ListShares returns the shares of the folder in the folder_id or the file in
+// the file_id query parameter.
+func (h *APIHandler) ListShares(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	q := r.URL.Query()
+	var shares []*model.Share
+	var err error
+	switch {
+	case q.Get("folder_id") != "":
+		id, perr := strconv.ParseInt(q.Get("folder_id"), 10, 64)
+		if perr != nil {
+			h.writeError(w, http.StatusBadRequest, "invalid_request", "invalid folder_id")
+			return
+		}
+		shares, err = h.shareService.GetFolderShares(r.Context(), user, id)
+	case q.Get("file_id") != "":
+		id, perr := strconv.ParseInt(q.Get("file_id"), 10, 64)
+		if perr != nil {
+			h.writeError(w, http.StatusBadRequest, "invalid_request", "invalid file_id")
+			return
+		}
+		shares, err = h.shareService.GetFileShares(r.Context(), user, id)
+	default:
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "folder_id or file_id is required")
+		return
+	}
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := make([]apiShare, len(shares))
+	for i, s := range shares {
+		res[i] = toAPIShare(s)
+	}
+	h.writeJSON(w, http.StatusOK, map[string][]apiShare{"shares": res})
+}
+
+// CreateShare shares a folder or file with a user or group. Sharing with the
+// same target again changes the permission of the existing share.
+func (h *APIHandler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	var req struct {
+		FolderID   *int64 `json:"folder_id"`
+		FileID     *int64 `json:"file_id"`
+		Username   string `json:"username"`
+		GroupID    *int64 `json:"group_id"`
+		Permission string `json:"permission"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if (req.FolderID == nil) == (req.FileID == nil) {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "exactly one of folder_id and file_id is required")
+		return
+	}
+	if (req.Username == "") == (req.GroupID == nil) {
+		h.writeError(w, http.StatusBadRequest, "invalid_request", "exactly one of username and group_id is required")
+		return
+	}
+	to := service.ShareTarget{Username: req.Username}
+	if req.GroupID != nil {
+		to.GroupID = *req.GroupID
+	}
+	p := model.ParsePermission(req.Permission)
+
+	var share *model.Share
+	var err error
+	if req.FolderID != nil {
+		share, err = h.shareService.ShareFolder(r.Context(), user, *req.FolderID, to, p)
+	} else {
+		share, err = h.shareService.ShareFile(r.Context(), user, *req.FileID, to, p)
+	}
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, toAPIShare(share))
+}
+
+// DeleteShare revokes a share, or leaves it if it is a share with the user.
+func (h *APIHandler) DeleteShare(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.shareService.RevokeShare(r.Context(), user, id); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APIHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	groups, err := h.groupService.GetUserGroups(r.Context(), user)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	res := make([]apiGroup, len(groups))
+	for i, g := range groups {
+		res[i] = toAPIGroup(g)
+	}
+	h.writeJSON(w, http.StatusOK, map[string][]apiGroup{"groups": res})
+}
+
+func (h *APIHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	group, err := h.groupService.CreateGroup(r.Context(), user, req.Name)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, toAPIGroup(group))
+}
+
+func (h *APIHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.groupService.DeleteGroup(r.Context(), user, id); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APIHandler) AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.groupService.AddMember(r.Context(), user, id, r.PathValue("username")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APIHandler) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.groupService.RemoveMember(r.Context(), user, id, r.PathValue("username")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
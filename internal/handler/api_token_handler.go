@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"errors"
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/timezone"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type APITokenHandler struct {
+	*baseHandler
+	tokenService *service.APITokenService
+}
+
+func NewAPITokenHandler(cfg *config.Config, r *Renderer, tokenService *service.APITokenService) *APITokenHandler {
+	return &APITokenHandler{
+		baseHandler:  newBaseHandler(cfg, r),
+		tokenService: tokenService,
+	}
+}
+
+// sessionUser returns the user of a browser session. Tokens can not be used
+// to manage tokens, otherwise a leaked token could mint new ones.
+func (h *APITokenHandler) sessionUser(w http.ResponseWriter, r *http.Request) *model.User {
+	if ExtractToken(r) != nil {
+		http.Error(w, "api tokens cannot manage api tokens", http.StatusForbidden)
+		logger.Error("token management attempted with an api token")
+		return nil
+	}
+	return ExtractUserOrRedirect(w, r)
+}
+
+func (h *APITokenHandler) renderTokens(w http.ResponseWriter, r *http.Request, user *model.User, data map[string]any) {
+	tokens, err := h.tokenService.GetUserTokens(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get api tokens: %v", err)
+		return
+	}
+	data["Tokens"] = tokens
+	h.r.Render(w, true, APITokenPage, "API Tokens", data)
+}
+
+// Tokens lists the API tokens of the user and creates new ones.
+func (h *APITokenHandler) Tokens(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := h.sessionUser(w, r)
+	if user == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.renderTokens(w, r, user, map[string]any{})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.Error("could not parse form: %v", err)
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		var expiresAt *time.Time
+		if v := r.Form.Get("expiry"); v != "" {
+			exp, err := timezone.TZ.ParseDatetimeLocal(v)
+			if err != nil {
+				logger.Error("invalid date format: %v", err)
+				http.Error(w, "Invalid date format", http.StatusBadRequest)
+				return
+			}
+			expiresAt = &exp
+		}
+
+		plain, t, err := h.tokenService.CreateToken(r.Context(), user.ID, r.Form.Get("name"), r.Form["scope"], expiresAt)
+		if err != nil {
+			logger.Error("could not create api token: %v", err)
+			msg := "Failed to create token"
+			if errors.Is(err, service.ErrEmptyTokenName) || errors.Is(err, service.ErrInvalidTokenScope) {
+				msg = "Please enter a name and select at least one scope"
+			}
+			h.renderTokens(w, r, user, map[string]any{"Error": msg})
+			return
+		}
+		h.renderTokens(w, r, user, map[string]any{
+			"NewToken":     plain,
+			"NewTokenName": t.Name,
+		})
+	default:
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RevokeToken handles POST /tokens/<id>/revoke.
+func (h *APITokenHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := h.sessionUser(w, r)
+	if user == nil {
+		return
+	}
+
+	idStr, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/tokens/"), "/revoke")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+	if err := h.tokenService.RevokeToken(r.Context(), user.ID, id); err != nil {
+		logger.Error("could not revoke api token: %v", err)
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"errors"
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type ArchiveHandler struct {
+	*baseHandler
+	archiveSvc *service.ArchiveService
+}
+
+func NewArchiveHandler(cfg *config.Config, r *Renderer, archiveSvc *service.ArchiveService) *ArchiveHandler {
+	return &ArchiveHandler{newBaseHandler(cfg, r), archiveSvc}
+}
+
+// DownloadFolder handles GET /download/folder/{id}, which streams the folder
+// with all of its subfolders as a ZIP, or a tar.gz with format=tar.gz.
+func (h *ArchiveHandler) DownloadFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	folderID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/download/folder/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+	format, err := service.ParseArchiveFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	archive, err := h.archiveSvc.Folder(r.Context(), user, folderID, format)
+	if err != nil {
+		h.archiveError(w, r, err)
+		return
+	}
+	sendArchive(w, archive)
+}
+
+// DownloadSelection handles /download/archive, which streams the files and
+// folders in the repeated file and folder fields as one archive.
+func (h *ArchiveHandler) DownloadSelection(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	folderIDs, err := parseIDs(r.Form["folder"])
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+	fileIDs, err := parseIDs(r.Form["file"])
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+	format, err := service.ParseArchiveFormat(r.Form.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	archive, err := h.archiveSvc.Select(r.Context(), user, folderIDs, fileIDs, format)
+	if err != nil {
+		h.archiveError(w, r, err)
+		return
+	}
+	sendArchive(w, archive)
+}
+
+func (h *ArchiveHandler) archiveError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrFolderNotFound), errors.Is(err, service.ErrFileNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, service.ErrEmptySelection):
+		http.Error(w, "Select at least one file or folder", http.StatusBadRequest)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not collect archive: %v", err)
+	}
+}
+
+// sendArchive streams archive to w. The size is not known up front, so the
+// response is chunked and an error halfway can only abort it.
+func sendArchive(w http.ResponseWriter, archive *service.Archive) {
+	w.Header().Set("Content-Type", archive.Format.ContentType())
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", archive.Name))
+	if err := archive.Write(w); err != nil {
+		logger.Error("could not send archive %q: %v", archive.Name, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func parseIDs(values []string) ([]int64, error) {
+	ids := make([]int64, 0, len(values))
+	for _, v := range values {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
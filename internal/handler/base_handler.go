@@ -1,6 +1,16 @@
 package handler
 
-import "github.com/NiClassic/go-cloud/config"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/service"
+)
 
 type baseHandler struct {
 	cfg *config.Config
@@ -10,3 +20,102 @@ type baseHandler struct {
 func newBaseHandler(cfg *config.Config, r *Renderer) *baseHandler {
 	return &baseHandler{cfg, r}
 }
+
+// limitUploadSize caps the request body at the configured maximum upload size.
+func (b *baseHandler) limitUploadSize(w http.ResponseWriter, r *http.Request) {
+	if b.cfg.MaxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, b.cfg.MaxUploadSize)
+	}
+}
+
+// isUploadTooLarge reports whether err was caused by exceeding the limit set
+// by limitUploadSize.
+func isUploadTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// errNoFilePart is returned by nextFilePart for a body without a file.
+var errNoFilePart = errors.New("no file in request")
+
+// nextFilePart skips to the next part of reader that holds a file.
+func nextFilePart(reader *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errNoFilePart
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+		_ = part.Close()
+	}
+}
+
+// serveDownload sends d as an attachment and closes it. The content hash is a
+// strong ETag, so If-None-Match, If-Range and Range requests are answered by
+// http.ServeContent without the client fetching the content again.
+func serveDownload(w http.ResponseWriter, r *http.Request, d *service.Download) {
+	serveContent(w, r, d, "attachment")
+}
+
+// serveInline sends d like serveDownload, but for the browser to show it
+// rather than save it. It must only be used for types that cannot run
+// scripts, see service.PreviewKind.Inline.
+func serveInline(w http.ResponseWriter, r *http.Request, d *service.Download) {
+	serveContent(w, r, d, "inline")
+}
+
+func serveContent(w http.ResponseWriter, r *http.Request, d *service.Download, disposition string) {
+	defer d.Content.Close()
+
+	mimeType := d.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	h := w.Header()
+	h.Set("Content-Type", mimeType)
+	h.Set("Content-Disposition", contentDisposition(disposition, d.Name))
+	h.Set("X-Content-Type-Options", "nosniff")
+	// Downloads need a session or token, so only the client may keep them
+	// and it has to revalidate each time.
+	h.Set("Cache-Control", "private, no-cache")
+	if d.Hash != "" {
+		h.Set("ETag", `"`+d.Hash+`"`)
+	}
+	http.ServeContent(w, r, d.Name, d.ModTime, d.Content)
+}
+
+// contentDisposition returns the disposition, attachment or inline, of a
+// file called name, with an ASCII fallback for old clients and the exact name
+// encoded as in RFC 5987.
+func contentDisposition(disposition, name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return disposition + `; filename="` + fallback + `"; filename*=UTF-8''` + b.String()
+}
+
+// isAttrChar reports whether c may appear unencoded in an RFC 5987 value.
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -16,3 +16,22 @@ func ExtractUserOrRedirect(w http.ResponseWriter, r *http.Request) *model.User {
 	}
 	return user
 }
+
+// ExtractUser returns the session user or nil for anonymous visitors.
+func ExtractUser(r *http.Request) *model.User {
+	user, ok := r.Context().Value(middleware.UserKey).(*model.User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// ExtractToken returns the API token the request was authenticated with, or
+// nil for session and password logins.
+func ExtractToken(r *http.Request) *model.APIToken {
+	t, ok := r.Context().Value(middleware.TokenKey).(*model.APIToken)
+	if !ok {
+		return nil
+	}
+	return t
+}
@@ -2,18 +2,42 @@ package handler
 
 import (
 	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/service"
 	"net/http"
 )
 
-type DashboardHandler struct{ *baseHandler }
+type DashboardHandler struct {
+	*baseHandler
+	quotaService *service.QuotaService
+}
 
-func NewDashboardHandler(cfg *config.Config, r *Renderer) *DashboardHandler {
-	return &DashboardHandler{baseHandler: newBaseHandler(cfg, r)}
+func NewDashboardHandler(cfg *config.Config, r *Renderer, quotaService *service.QuotaService) *DashboardHandler {
+	return &DashboardHandler{baseHandler: newBaseHandler(cfg, r), quotaService: quotaService}
 }
 
+// Dashboard shows the profile of the user with how much of their quota
+// their files use.
 func (h *DashboardHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
 	user := ExtractUserOrRedirect(w, r)
-	h.r.Render(w, true, DashboardPage, "Dashboard", map[string]any{
+	if user == nil {
+		return
+	}
+	usage, err := h.quotaService.GetUsage(r.Context(), user.ID)
+	if err != nil {
+		logger.Error("could not get usage of %s: %v", user.Username, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
 		"Username": user.Username,
-	})
+		"Used":     humanReadableSize(usage.Used),
+		"Percent":  usage.Percent(),
+	}
+	if usage.Quota > 0 {
+		data["Quota"] = humanReadableSize(usage.Quota)
+		data["Available"] = humanReadableSize(usage.Available())
+	}
+	h.r.Render(w, true, DashboardPage, "Profile", data)
 }
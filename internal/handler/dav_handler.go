@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/dav"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"golang.org/x/net/webdav"
+	"net/http"
+)
+
+const davPrefix = "/dav"
+
+// DavHandler serves the personal files of the authenticated user over WebDAV.
+type DavHandler struct {
+	*baseHandler
+	folderService *service.FolderService
+	fileService   *service.PersonalFileService
+	trashService  *service.TrashService
+	// locks outlive a single request, every user gets their own part of it,
+	// see dav.NewUserLockSystem.
+	locks webdav.LockSystem
+}
+
+func NewDavHandler(cfg *config.Config, r *Renderer, folderService *service.FolderService, fileService *service.PersonalFileService, trashService *service.TrashService) *DavHandler {
+	return &DavHandler{
+		baseHandler:   newBaseHandler(cfg, r),
+		folderService: folderService,
+		fileService:   fileService,
+		trashService:  trashService,
+		locks:         webdav.NewMemLS(),
+	}
+}
+
+func (h *DavHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user := ExtractUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodPut {
+		h.limitUploadSize(w, r)
+	}
+
+	dh := &webdav.Handler{
+		Prefix:     davPrefix,
+		FileSystem: dav.NewFileSystem(user, h.folderService, h.fileService, h.trashService),
+		LockSystem: dav.NewUserLockSystem(h.locks, user.ID),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logger.Error("webdav %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	dh.ServeHTTP(w, r)
+}
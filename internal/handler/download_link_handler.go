@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+)
+
+// downloadLinkCookie holds the proof that the visitor entered the password of
+// a download link. Its path is the link, so every link has its own.
+const downloadLinkCookie = "download_link"
+
+type DownloadLinkHandler struct {
+	*baseHandler
+	linkSvc *service.DownloadLinkService
+}
+
+func NewDownloadLinkHandler(cfg *config.Config, r *Renderer, linkSvc *service.DownloadLinkService) *DownloadLinkHandler {
+	return &DownloadLinkHandler{newBaseHandler(cfg, r), linkSvc}
+}
+
+// Visit handles /d/{token} and everything below it, which needs no account.
+// GET /d/{token} shows the linked folder or file, or asks for the password
+// that POST /d/{token} checks. /d/{token}/folder/{id} shows a folder below a
+// linked folder, /d/{token}/file/{id} downloads a file and
+// /d/{token}/archive/{id} a folder as ZIP, or tar.gz with format=tar.gz.
+func (h *DownloadLinkHandler) Visit(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	linkToken, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/d/"), "/")
+	link, err := h.linkSvc.Open(r.Context(), linkToken)
+	if err != nil {
+		h.linkError(w, r, err)
+		return
+	}
+	linkURL := "/d/" + link.LinkToken
+
+	if rest == "" && r.Method == http.MethodPost {
+		h.unlock(w, r, link)
+		return
+	}
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.unlocked(r, link) {
+		if rest != "" {
+			http.Redirect(w, r, linkURL, http.StatusSeeOther)
+			return
+		}
+		h.renderLink(w, r, link, map[string]any{"Locked": true})
+		return
+	}
+
+	var id int64
+	kind, idStr, _ := strings.Cut(rest, "/")
+	if kind != "" {
+		if id, err = strconv.ParseInt(idStr, 10, 64); err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+	}
+	switch kind {
+	case "":
+		if link.FileID.Valid {
+			h.renderFile(w, r, link)
+			return
+		}
+		h.renderFolder(w, r, link, 0)
+	case "folder":
+		h.renderFolder(w, r, link, id)
+	case "file":
+		d, err := h.linkSvc.Download(r.Context(), link, id)
+		if err != nil {
+			h.linkError(w, r, err)
+			return
+		}
+		serveDownload(w, r, d)
+	case "archive":
+		format, err := service.ParseArchiveFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		archive, err := h.linkSvc.Archive(r.Context(), link, id, format)
+		if err != nil {
+			h.linkError(w, r, err)
+			return
+		}
+		sendArchive(w, archive)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// unlock checks the password form of a link and keeps the proof in a cookie
+// for the pages and downloads of the link.
+func (h *DownloadLinkHandler) unlock(w http.ResponseWriter, r *http.Request, link *model.DownloadLink) {
+	_, err := h.linkSvc.ValidatePassword(r.Context(), link.LinkToken, r.FormValue("password"))
+	if errors.Is(err, service.ErrInvalidPassword) {
+		w.WriteHeader(http.StatusUnauthorized)
+		h.renderLink(w, r, link, map[string]any{"Locked": true, "Error": "Wrong password"})
+		return
+	}
+	if err != nil {
+		h.linkError(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     downloadLinkCookie,
+		Value:    h.linkSvc.UnlockProof(link),
+		Path:     "/d/" + link.LinkToken,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/d/"+link.LinkToken, http.StatusSeeOther)
+}
+
+func (h *DownloadLinkHandler) unlocked(r *http.Request, link *model.DownloadLink) bool {
+	proof := ""
+	if c, err := r.Cookie(downloadLinkCookie); err == nil {
+		proof = c.Value
+	}
+	return h.linkSvc.Unlocked(link, proof)
+}
+
+func (h *DownloadLinkHandler) renderFile(w http.ResponseWriter, r *http.Request, link *model.DownloadLink) {
+	file, err := h.linkSvc.File(r.Context(), link, 0)
+	if err != nil {
+		h.linkError(w, r, err)
+		return
+	}
+	h.renderLink(w, r, link, map[string]any{"File": filesToRows([]*model.File{file})[0]})
+}
+
+func (h *DownloadLinkHandler) renderFolder(w http.ResponseWriter, r *http.Request, link *model.DownloadLink, folderID int64) {
+	folder, folders, files, err := h.linkSvc.Folder(r.Context(), link, folderID)
+	if err != nil {
+		h.linkError(w, r, err)
+		return
+	}
+	data := map[string]any{
+		"Folder":  folder,
+		"Folders": foldersToRows(folders, ""),
+		"Files":   filesToRows(files),
+	}
+	if folder.ID != link.FolderID.Int64 {
+		data["BackURL"] = "/d/" + link.LinkToken
+		if folder.ParentID.Int64 != link.FolderID.Int64 {
+			data["BackURL"] = "/d/" + link.LinkToken + "/folder/" + strconv.FormatInt(folder.ParentID.Int64, 10)
+		}
+	}
+	h.renderLink(w, r, link, data)
+}
+
+func (h *DownloadLinkHandler) renderLink(w http.ResponseWriter, r *http.Request, link *model.DownloadLink, data map[string]any) {
+	data["Link"] = link
+	if link.MaxDownloads > 0 {
+		data["DownloadsLeft"] = link.MaxDownloads - link.Downloads
+	}
+	h.r.Render(w, ExtractUser(r) != nil, DownloadLinkPage, link.Name, data)
+}
+
+func (h *DownloadLinkHandler) linkError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrDownloadLinkNotFound),
+		errors.Is(err, service.ErrFolderNotFound), errors.Is(err, service.ErrFileNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, service.ErrDownloadLinkExpired):
+		http.Error(w, "This link has expired", http.StatusGone)
+	case errors.Is(err, service.ErrDownloadLimitReached):
+		http.Error(w, "This link has reached its download limit", http.StatusGone)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not serve download link: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FileVersionHandler struct {
+	*baseHandler
+	fileSvc    *service.PersonalFileService
+	versionSvc *service.FileVersionService
+	converter  *path.Converter
+}
+
+func NewFileVersionHandler(cfg *config.Config, r *Renderer, fileSvc *service.PersonalFileService, versionSvc *service.FileVersionService, c *path.Converter) *FileVersionHandler {
+	return &FileVersionHandler{
+		baseHandler: newBaseHandler(cfg, r),
+		fileSvc:     fileSvc,
+		versionSvc:  versionSvc,
+		converter:   c,
+	}
+}
+
+type versionRow struct {
+	Version   int
+	Size      string
+	Hash      string
+	ShortHash string
+	CreatedAt time.Time
+	Current   bool
+}
+
+func (h *FileVersionHandler) renderHistory(w http.ResponseWriter, r *http.Request, user *model.User, fileID int64, data map[string]any) {
+	file, versions, err := h.versionSvc.GetHistory(r.Context(), user, fileID)
+	if err != nil {
+		if errors.Is(err, service.ErrFileNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get versions of file %d: %v", fileID, err)
+		return
+	}
+	rows := make([]versionRow, 0, len(versions)+1)
+	rows = append(rows, versionRow{
+		Version:   file.Version,
+		Size:      humanReadableSize(file.Size),
+		Hash:      file.Hash,
+		ShortHash: shortHash(file.Hash),
+		CreatedAt: file.ContentCreatedAt(),
+		Current:   true,
+	})
+	for _, v := range versions {
+		rows = append(rows, versionRow{
+			Version:   v.Version,
+			Size:      humanReadableSize(v.Size),
+			Hash:      v.Hash,
+			ShortHash: shortHash(v.Hash),
+			CreatedAt: v.CreatedAt,
+		})
+	}
+	data["File"] = file
+	data["FolderPath"] = fileFolderURL(h.converter, user, file)
+	data["Versions"] = rows
+	data["Keep"] = h.cfg.VersionKeep
+	data["KeepDays"] = h.cfg.VersionKeepDays
+	h.r.Render(w, true, FileVersionPage, "Versions of "+file.Name, data)
+}
+
+// History lists the current and older versions of the file in the URL, e.g.
+// /versions/42.
+func (h *FileVersionHandler) History(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	fileID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/versions/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+	h.renderHistory(w, r, user, fileID, map[string]any{})
+}
+
+// Download handles GET /versions/download?id=42&version=3 and sends an older
+// version of a file.
+func (h *FileVersionHandler) Download(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	fileID, version, ok := formVersion(w, r)
+	if !ok {
+		return
+	}
+
+	v, err := h.versionSvc.GetVersion(r.Context(), user, fileID, version)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	d, err := h.versionSvc.DownloadVersion(r.Context(), user, v)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not open version %d of file %d: %v", version, fileID, err)
+		return
+	}
+	serveDownload(w, r, d)
+}
+
+// Restore handles POST /versions/restore, which makes the version in the
+// version form field the current content of the file in the id field.
+func (h *FileVersionHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	fileID, version, ok := formVersion(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := h.fileSvc.RestoreVersion(r.Context(), user, fileID, version)
+	if err != nil {
+		logger.Error("could not restore version %d of file %d: %v", version, fileID, err)
+		if errors.Is(err, service.ErrFileNotFound) || errors.Is(err, service.ErrVersionNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			h.renderHistory(w, r, user, fileID, map[string]any{"Error": "Restoring the version would exceed your storage quota"})
+			return
+		}
+		h.renderHistory(w, r, user, fileID, map[string]any{"Error": "Failed to restore the version"})
+		return
+	}
+	h.renderHistory(w, r, user, fileID, map[string]any{
+		"Notice": fmt.Sprintf("Restored version %d of %q as version %d", version, file.Name, file.Version),
+	})
+}
+
+func formVersion(w http.ResponseWriter, r *http.Request) (int64, int, bool) {
+	fileID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return fileID, version, true
+}
+
+// shortHash abbreviates a SHA-256 for display, the full hash is in the title.
+func shortHash(hash string) string {
+	if len(hash) <= 12 {
+		return hash
+	}
+	return hash[:12] + "…"
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/NiClassic/go-cloud/config"
 	"github.com/NiClassic/go-cloud/internal/logger"
@@ -8,6 +9,7 @@ import (
 	"github.com/NiClassic/go-cloud/internal/service"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -15,13 +17,15 @@ type FolderHandler struct {
 	*baseHandler
 	folderSvc *service.FolderService
 	fileSvc   *service.PersonalFileService
+	trashSvc  *service.TrashService
 }
 
-func NewFolderHandler(cfg *config.Config, r *Renderer, folderSvc *service.FolderService, fileSvc *service.PersonalFileService) *FolderHandler {
+func NewFolderHandler(cfg *config.Config, r *Renderer, folderSvc *service.FolderService, fileSvc *service.PersonalFileService, trashSvc *service.TrashService) *FolderHandler {
 	return &FolderHandler{
 		baseHandler: newBaseHandler(cfg, r),
 		folderSvc:   folderSvc,
 		fileSvc:     fileSvc,
+		trashSvc:    trashSvc,
 	}
 }
 
@@ -131,6 +135,168 @@ func (h *FolderHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// DeleteFolder moves a folder with everything in it to the trash and answers
+// with the rows of the parent folder, headed by a summary of what was removed.
+func (h *FolderHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+
+	user, folder := h.formFolder(w, r)
+	if folder == nil {
+		return
+	}
+
+	item, err := h.trashSvc.TrashFolder(r.Context(), user, folder.ID)
+	if err != nil {
+		logger.Error("could not delete folder %d: %v", folder.ID, err)
+		if errors.Is(err, service.ErrCannotDeleteRoot) {
+			http.Error(w, "The root folder cannot be deleted", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to delete folder", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("moved folder %q of user %d to the trash: %d files, %d bytes", folder.Path, user.ID, item.FileCount, item.Size)
+
+	h.renderParentRows(w, r, user, folder, fmt.Sprintf("Moved %q to the trash: %d files (%s)",
+		folder.Name, item.FileCount, humanReadableSize(item.Size)))
+}
+
+// MoveFolder moves a folder with everything in it below the folder given by
+// the destination path, relative to the root folder. HTMX sends the path typed
+// into the prompt in the HX-Prompt header.
+func (h *FolderHandler) MoveFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+
+	user, folder := h.formFolder(w, r)
+	if folder == nil {
+		return
+	}
+
+	destination := r.FormValue("destination")
+	if destination == "" {
+		destination = r.Header.Get("HX-Prompt")
+	}
+	dst, err := h.folderSvc.GetByDBPath(r.Context(), user.ID, strings.Trim(path.Clean("/"+destination), "/"))
+	if err != nil {
+		http.Error(w, "Destination folder not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.folderSvc.MoveFolder(r.Context(), user, folder.ID, dst.ID, ""); err != nil {
+		logger.Error("could not move folder %d: %v", folder.ID, err)
+		h.folderMoveError(w, err)
+		return
+	}
+	logger.Info("moved folder %q of user %d to %q", folder.Path, user.ID, dst.Path)
+
+	target := dst.Path
+	if target == "" {
+		target = "/"
+	}
+	h.renderParentRows(w, r, user, folder, fmt.Sprintf("Moved %q to %s", folder.Name, target))
+}
+
+// RenameFolder renames a folder in place. The new name comes from the name
+// form field or, for HTMX prompts, from the HX-Prompt header.
+func (h *FolderHandler) RenameFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+
+	user, folder := h.formFolder(w, r)
+	if folder == nil {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		name = strings.TrimSpace(r.Header.Get("HX-Prompt"))
+	}
+	if err := h.folderSvc.RenameFolder(r.Context(), user, folder.ID, name); err != nil {
+		logger.Error("could not rename folder %d: %v", folder.ID, err)
+		h.folderMoveError(w, err)
+		return
+	}
+	logger.Info("renamed folder %q of user %d to %q", folder.Path, user.ID, name)
+
+	h.renderParentRows(w, r, user, folder, fmt.Sprintf("Renamed %q to %q", folder.Name, name))
+}
+
+// formFolder resolves the folder given by the id form field of a POST request.
+// It writes the error response itself and returns a nil folder on failure.
+func (h *FolderHandler) formFolder(w http.ResponseWriter, r *http.Request) (*model.User, *model.Folder) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logger.InvalidMethod(r)
+		return nil, nil
+	}
+
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return nil, nil
+	}
+
+	folderID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return nil, nil
+	}
+	folder, err := h.folderSvc.GetById(r.Context(), user.ID, folderID)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil
+	}
+	return user, folder
+}
+
+func (h *FolderHandler) folderMoveError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrCannotMoveRoot):
+		http.Error(w, "The root folder cannot be moved or renamed", http.StatusBadRequest)
+	case errors.Is(err, service.ErrCannotMoveToChild):
+		http.Error(w, "A folder cannot be moved into itself", http.StatusConflict)
+	case errors.Is(err, service.ErrFolderAlreadyExists):
+		http.Error(w, "The destination already contains an item with that name", http.StatusConflict)
+	case errors.Is(err, service.ErrInvalidFolderName):
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+	case errors.Is(err, service.ErrDifferentOwner):
+		http.Error(w, "Items cannot be moved between the files of different users", http.StatusConflict)
+	case errors.Is(err, service.ErrPermissionDenied):
+		http.Error(w, "You may not change this folder", http.StatusForbidden)
+	case errors.Is(err, service.ErrFolderNotFound):
+		http.Error(w, "Folder not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Failed to move folder", http.StatusInternalServerError)
+	}
+}
+
+// renderParentRows answers a change to folder with the rows of the folder it
+// was in before, headed by notice. Plain form posts are redirected there.
+func (h *FolderHandler) renderParentRows(w http.ResponseWriter, r *http.Request, user *model.User, folder *model.Folder, notice string) {
+	if r.Header.Get("HX-Request") != "true" {
+		http.Redirect(w, r, "/files/"+h.parentURLPath(folder.Path), http.StatusSeeOther)
+		return
+	}
+
+	folders, files, err := h.folderSvc.GetFolderContents(r.Context(), user.ID, folder.ParentID.Int64)
+	if err != nil {
+		logger.Error("could not get folder contents: %v", err)
+		folders = []*model.Folder{}
+		files = []*model.File{}
+	}
+	h.r.Render(w, true, FileRows, "", map[string]any{
+		"Folders": foldersToRows(folders, user.Username),
+		"Files":   filesToRows(files),
+		"Notice":  notice,
+	})
+}
+
+func (h *FolderHandler) parentURLPath(dbPath string) string {
+	parent := path.Dir(dbPath)
+	if parent == "." {
+		return ""
+	}
+	return parent
+}
+
 func foldersToRows(folders []*model.Folder, username string) []fileRow {
 	rows := make([]fileRow, len(folders))
 	for i, f := range folders {
@@ -155,6 +321,7 @@ func filesToRows(files []*model.File) []fileRow {
 			Size:      humanReadableSize(f.Size),
 			Id:        f.ID,
 			IsDir:     false,
+			Thumbnail: thumbnailURL(f),
 		}
 	}
 	return rows
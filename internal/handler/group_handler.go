@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+)
+
+type GroupHandler struct {
+	*baseHandler
+	groupSvc *service.GroupService
+}
+
+func NewGroupHandler(cfg *config.Config, r *Renderer, groupSvc *service.GroupService) *GroupHandler {
+	return &GroupHandler{
+		baseHandler: newBaseHandler(cfg, r),
+		groupSvc:    groupSvc,
+	}
+}
+
+func (h *GroupHandler) renderGroups(w http.ResponseWriter, r *http.Request, user *model.User, data map[string]any) {
+	groups, err := h.groupSvc.GetUserGroups(r.Context(), user)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get groups of %s: %v", user.Username, err)
+		return
+	}
+	data["Groups"] = groups
+	h.r.Render(w, true, GroupPage, "Groups", data)
+}
+
+// Groups lists the groups of the user with their members.
+func (h *GroupHandler) Groups(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	h.renderGroups(w, r, user, map[string]any{})
+}
+
+// CreateGroup handles POST /groups/create with the name form field.
+func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	name := r.FormValue("name")
+	if _, err := h.groupSvc.CreateGroup(r.Context(), user, name); err != nil {
+		h.groupError(w, r, user, err)
+		return
+	}
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+// DeleteGroup handles POST /groups/delete with the group in the id form field.
+func (h *GroupHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formGroup(w, r)
+	if user == nil {
+		return
+	}
+	if err := h.groupSvc.DeleteGroup(r.Context(), user, id); err != nil {
+		h.groupError(w, r, user, err)
+		return
+	}
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+// AddMember handles POST /groups/members/add, which adds the user called
+// username to the group id.
+func (h *GroupHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formGroup(w, r)
+	if user == nil {
+		return
+	}
+	if err := h.groupSvc.AddMember(r.Context(), user, id, r.FormValue("username")); err != nil {
+		h.groupError(w, r, user, err)
+		return
+	}
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+// RemoveMember handles POST /groups/members/remove, which removes the user
+// called username from the group id.
+func (h *GroupHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formGroup(w, r)
+	if user == nil {
+		return
+	}
+	if err := h.groupSvc.RemoveMember(r.Context(), user, id, r.FormValue("username")); err != nil {
+		h.groupError(w, r, user, err)
+		return
+	}
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+// groupError shows the groups page with a message for err.
+func (h *GroupHandler) groupError(w http.ResponseWriter, r *http.Request, user *model.User, err error) {
+	var msg string
+	switch {
+	case errors.Is(err, service.ErrGroupNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, service.ErrInvalidGroupName):
+		msg = "Enter a name for the group"
+	case errors.Is(err, service.ErrGroupAlreadyExists):
+		msg = "You already have a group with that name"
+	case errors.Is(err, service.ErrUserNotFound):
+		msg = fmt.Sprintf("There is no user called %q", r.FormValue("username"))
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not change the groups of %s: %v", user.Username, err)
+		return
+	}
+	h.renderGroups(w, r, user, map[string]any{"Error": msg})
+}
+
+func (h *GroupHandler) formGroup(w http.ResponseWriter, r *http.Request) (*model.User, int64) {
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, 0
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return nil, 0
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return nil, 0
+	}
+	return user, id
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/NiClassic/go-cloud/config"
 	"github.com/NiClassic/go-cloud/internal/logger"
@@ -21,17 +22,20 @@ type fileRow struct {
 	Id        int64
 	IsDir     bool
 	Path      string
+	// Thumbnail is the URL of the thumbnails of an image without the size.
+	Thumbnail string
 }
 type PersonalFileUploadHandler struct {
 	*baseHandler
-	sto           storage.FileManager
-	fileService   *service.PersonalFileService
-	folderService *service.FolderService
-	converter     *path.Converter
+	sto            storage.FileManager
+	fileService    *service.PersonalFileService
+	folderService  *service.FolderService
+	extractService *service.ExtractService
+	converter      *path.Converter
 }
 
-func NewPersonalFileUploadHandler(cfg *config.Config, r *Renderer, sto storage.FileManager, fileService *service.PersonalFileService, folderService *service.FolderService, c *path.Converter) *PersonalFileUploadHandler {
-	return &PersonalFileUploadHandler{newBaseHandler(cfg, r), sto, fileService, folderService, c}
+func NewPersonalFileUploadHandler(cfg *config.Config, r *Renderer, sto storage.FileManager, fileService *service.PersonalFileService, folderService *service.FolderService, extractService *service.ExtractService, c *path.Converter) *PersonalFileUploadHandler {
+	return &PersonalFileUploadHandler{newBaseHandler(cfg, r), sto, fileService, folderService, extractService, c}
 }
 
 func (p *PersonalFileUploadHandler) filesToRows(files []*model.File) []fileRow {
@@ -43,6 +47,7 @@ func (p *PersonalFileUploadHandler) filesToRows(files []*model.File) []fileRow {
 			Size:      p.humanReadableSize(f.Size),
 			Id:        f.ID,
 			IsDir:     false,
+			Thumbnail: thumbnailURL(f),
 		}
 	}
 	return rows
@@ -122,6 +127,7 @@ func (p *PersonalFileUploadHandler) UploadFiles(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	p.limitUploadSize(w, r)
 	reader, err := r.MultipartReader()
 	if err != nil {
 		http.Error(w, "invalid multipart data: "+err.Error(), http.StatusBadRequest)
@@ -148,6 +154,21 @@ func (p *PersonalFileUploadHandler) UploadFiles(w http.ResponseWriter, r *http.R
 
 	// Store files (pass DB path format)
 	if err := p.fileService.StoreFiles(r.Context(), user, reader, folder.ID, dbPath); err != nil {
+		if isUploadTooLarge(err) {
+			http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+			logger.Error("upload too large: %v", err)
+			return
+		}
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			http.Error(w, "storage quota exceeded", http.StatusRequestEntityTooLarge)
+			logger.Error("upload of %s exceeds their quota: %v", user.Username, err)
+			return
+		}
+		if errors.Is(err, service.ErrInvalidFolderPath) || errors.Is(err, service.ErrInvalidFolderName) {
+			http.Error(w, "invalid file or folder name in upload", http.StatusBadRequest)
+			logger.Error("invalid path in upload of %s: %v", user.Username, err)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		logger.Error("could not store files: %v", err)
 		return
@@ -167,9 +188,86 @@ func (p *PersonalFileUploadHandler) UploadFiles(w http.ResponseWriter, r *http.R
 	})
 }
 
+// ExtractArchive handles POST /files/extract/{path}, which unpacks the
+// uploaded ZIP, tar or tar.gz archive into a new folder in the folder at path.
+func (p *PersonalFileUploadHandler) ExtractArchive(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logger.InvalidMethod(r)
+		return
+	}
+
+	p.limitUploadSize(w, r)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "invalid multipart data: "+err.Error(), http.StatusBadRequest)
+		logger.Error("invalid multipart data: %v", err)
+		return
+	}
+
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+
+	dbPath := p.converter.FromURLPath(strings.TrimPrefix(r.URL.Path, "/files/extract"))
+	folder, err := p.folderService.GetByPath(r.Context(), user.ID, user.Username, dbPath)
+	if err != nil {
+		logger.Error("could not get folder: %v", err)
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+
+	part, err := nextFilePart(reader)
+	if err != nil {
+		if isUploadTooLarge(err) {
+			http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "no archive uploaded", http.StatusBadRequest)
+		return
+	}
+	defer part.Close()
+
+	res, err := p.extractService.Extract(r.Context(), user, folder, part.FileName(), part)
+	if err != nil {
+		switch {
+		case isUploadTooLarge(err):
+			http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, service.ErrQuotaExceeded):
+			http.Error(w, "storage quota exceeded", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, service.ErrArchiveTooLarge):
+			http.Error(w, "archive has too many entries or is too large when extracted", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, service.ErrUnsupportedArchive), errors.Is(err, service.ErrUnsafeArchivePath):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		logger.Error("could not extract %q for %s: %v", part.FileName(), user.Username, err)
+		return
+	}
+
+	folders, files, err := p.folderService.GetFolderContents(r.Context(), user.ID, folder.ID)
+	if err != nil {
+		logger.Error("could not get folder content: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	notice := fmt.Sprintf("Extracted %d files into %q", res.Files, res.Folder.Name)
+	if len(res.Skipped) > 0 {
+		notice += fmt.Sprintf(", skipped %d entries", len(res.Skipped))
+	}
+	p.r.Render(w, true, FileRows, "", map[string]any{
+		"Files":   p.filesToRows(files),
+		"Folders": p.foldersToRows(folders),
+		"Notice":  notice,
+	})
+}
+
 func (p *PersonalFileUploadHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	logger.Request(r)
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		logger.InvalidMethod(r)
 		return
@@ -189,17 +287,19 @@ func (p *PersonalFileUploadHandler) DownloadFile(w http.ResponseWriter, r *http.
 	}
 
 	// Get file from database
-	file, err := p.fileService.GetFileById(r.Context(), fileID)
-	if err != nil || file.UserID != user.ID {
+	file, err := p.fileService.GetFile(r.Context(), user.ID, fileID)
+	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	// File location is already the full filesystem path
-	// But we can use pathUtil to verify or rebuild if needed
-	fullPath := p.converter.GetFullFilePath(user.Username, file.Location)
-
-	http.ServeFile(w, r, fullPath)
+	d, err := p.fileService.Download(r.Context(), user, file)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not open file %d: %v", file.ID, err)
+		return
+	}
+	serveDownload(w, r, d)
 }
 
 func (p *PersonalFileUploadHandler) humanReadableSize(b int64) string {
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/service"
+)
+
+type PreviewHandler struct {
+	*baseHandler
+	fileSvc    *service.PersonalFileService
+	previewSvc *service.PreviewService
+	converter  *path.Converter
+}
+
+func NewPreviewHandler(cfg *config.Config, r *Renderer, fileSvc *service.PersonalFileService, previewSvc *service.PreviewService, c *path.Converter) *PreviewHandler {
+	return &PreviewHandler{newBaseHandler(cfg, r), fileSvc, previewSvc, c}
+}
+
+// readableFile returns the file whose ID follows prefix in the URL path, or
+// writes an error if the user may not read it.
+func (h *PreviewHandler) readableFile(w http.ResponseWriter, r *http.Request, user *model.User, prefix string) *model.File {
+	fileID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, prefix), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return nil
+	}
+	file, err := h.fileSvc.GetFile(r.Context(), user.ID, fileID)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	return file
+}
+
+// Preview handles GET /preview/{id}, a page showing the file with the viewer
+// its type needs, or a download button for types without one.
+func (h *PreviewHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	file := h.readableFile(w, r, user, "/preview/")
+	if file == nil {
+		return
+	}
+
+	p, err := h.previewSvc.Preview(r.Context(), user, file)
+	if err != nil {
+		if errors.Is(err, service.ErrFileNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not preview file %d: %v", file.ID, err)
+		return
+	}
+	h.r.Render(w, true, FilePreviewPage, file.Name, map[string]any{
+		"File":      file,
+		"Size":      humanReadableSize(file.Size),
+		"FolderURL": fileFolderURL(h.converter, user, file),
+		"Kind":      string(p.Kind),
+		// The service sanitizes the HTML it renders.
+		"HTML":       template.HTML(p.HTML),
+		"Truncated":  p.Truncated,
+		"ContentURL": "/preview/content/" + strconv.FormatInt(file.ID, 10),
+	})
+}
+
+// Content handles GET /preview/content/{id}, which sends images, PDFs, audio
+// and video for the browser to show. Seeking in media is served by range
+// requests.
+func (h *PreviewHandler) Content(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	file := h.readableFile(w, r, user, "/preview/content/")
+	if file == nil {
+		return
+	}
+	if !service.PreviewKindOf(file).Inline() {
+		http.NotFound(w, r)
+		return
+	}
+
+	d, err := h.fileSvc.Download(r.Context(), user, file)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not open file %d: %v", file.ID, err)
+		return
+	}
+	serveInline(w, r, d)
+}
@@ -33,6 +33,18 @@ const (
 	LinkSharePasswordPage
 	LinkShareDetailPage
 	LinkShareCreationPage
+	LinkUploadResult
+	LinkShareEditPage
+	APITokenPage
+	TrashPage
+	FileVersionPage
+	FilePreviewPage
+	SearchPage
+	SharedPage
+	SharedFolderPage
+	SharePage
+	GroupPage
+	DownloadLinkPage
 )
 
 func (r *Renderer) parseTemplates() error {
@@ -79,6 +91,30 @@ func pageToTemplateName(template Template) string {
 		return "view_upload_link.html"
 	case LinkShareCreationPage:
 		return "create_upload_link.html"
+	case LinkUploadResult:
+		return "link_upload_result"
+	case LinkShareEditPage:
+		return "edit_upload_link.html"
+	case APITokenPage:
+		return "view_api_tokens.html"
+	case TrashPage:
+		return "view_trash.html"
+	case FileVersionPage:
+		return "view_file_versions.html"
+	case FilePreviewPage:
+		return "view_file_preview.html"
+	case SearchPage:
+		return "view_search.html"
+	case SharedPage:
+		return "view_shared.html"
+	case SharedFolderPage:
+		return "view_shared_folder.html"
+	case SharePage:
+		return "view_shares.html"
+	case GroupPage:
+		return "view_groups.html"
+	case DownloadLinkPage:
+		return "view_download_link.html"
 	default:
 		return "not_found.html"
 	}
@@ -12,15 +12,31 @@ import (
 func New(cfg *config.Config, r *Renderer, services *service.Services, st storage.FileManager, c *path.Converter) *http.ServeMux {
 	authH := NewAuthHandler(cfg, r, services.Auth, services.Folder, st)
 	rootH := NewRootHandler(services.Auth)
-	uploadH := NewUploadLinkHandler(cfg, r, services.UploadLink, services.LinkUnlock)
-	pFileH := NewPersonalFileUploadHandler(cfg, r, st, services.PFile, services.Folder, c)
-	folderH := NewFolderHandler(cfg, r, services.Folder, services.PFile)
+	uploadH := NewUploadLinkHandler(cfg, r, services.UploadLink, services.DownloadLink, services.Folder)
+	pFileH := NewPersonalFileUploadHandler(cfg, r, st, services.PFile, services.Folder, services.Extract, c)
+	folderH := NewFolderHandler(cfg, r, services.Folder, services.PFile, services.Trash)
+	tusH := NewTusHandler(cfg, r, services.Tus, services.Folder)
+	davH := NewDavHandler(cfg, r, services.Folder, services.PFile, services.Trash)
+	tokenH := NewAPITokenHandler(cfg, r, services.APIToken)
+	trashH := NewTrashHandler(cfg, r, services.Trash)
+	versionH := NewFileVersionHandler(cfg, r, services.PFile, services.Version, c)
+	dashboardH := NewDashboardHandler(cfg, r, services.Quota)
+	archiveH := NewArchiveHandler(cfg, r, services.Archive)
+	thumbnailH := NewThumbnailHandler(cfg, r, services.PFile, services.Thumbnail)
+	previewH := NewPreviewHandler(cfg, r, services.PFile, services.Preview, c)
+	searchH := NewSearchHandler(cfg, r, services.Search, services.Folder, c)
+	shareH := NewShareHandler(cfg, r, services.Share, services.Group, services.Folder, services.PFile, services.DownloadLink, c)
+	groupH := NewGroupHandler(cfg, r, services.Group)
+	downloadH := NewDownloadLinkHandler(cfg, r, services.DownloadLink)
+	apiH := NewAPIHandler(cfg, r, services.Folder, services.PFile, services.UploadLink, services.Trash, services.Version, services.Archive, services.Extract, services.Search, services.Share, services.Group, services.DownloadLink)
 
 	mux := http.NewServeMux()
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
 	guest := middleware.NewGuestOnly(services.Auth)
-	auth := middleware.NewSessionValidator(services.Auth)
+	auth := middleware.NewSessionValidator(services.Auth, services.APIToken)
+	basic := middleware.NewBasicAuth(services.Auth, services.APIToken)
+	apiAuth := middleware.NewAPIAuth(services.Auth, services.APIToken)
 
 	// Authentication routes
 	mux.Handle("/register", middleware.Recover(guest.WithoutAuth(http.HandlerFunc(authH.Register))))
@@ -30,16 +46,115 @@ func New(cfg *config.Config, r *Renderer, services *service.Services, st storage
 	// Upload link routes
 	mux.Handle("/links/create", middleware.Recover(auth.WithAuth(http.HandlerFunc(uploadH.CreateUploadLink))))
 	mux.Handle("/links", middleware.Recover(auth.WithAuth(http.HandlerFunc(uploadH.ShowLinks))))
-	mux.Handle("/links/", middleware.Recover(auth.WithAuth(http.HandlerFunc(uploadH.VisitUploadLink))))
+	mux.Handle("/links/", middleware.Recover(auth.WithOptionalAuth(http.HandlerFunc(uploadH.VisitUploadLink))))
+
+	// Download link routes, public like upload links
+	mux.Handle("/d/", middleware.Recover(auth.WithOptionalAuth(http.HandlerFunc(downloadH.Visit))))
 
 	// File management routes
 	mux.Handle("/files", middleware.Recover(auth.WithAuth(http.HandlerFunc(pFileH.RedirectNoTrailingSlash))))
 	mux.Handle("/files/", middleware.Recover(auth.WithAuth(http.HandlerFunc(pFileH.ListFiles))))
 	mux.Handle("/download/", middleware.Recover(auth.WithAuth(http.HandlerFunc(pFileH.DownloadFile))))
+	mux.Handle("/download/folder/", middleware.Recover(auth.WithAuth(http.HandlerFunc(archiveH.DownloadFolder))))
+	mux.Handle("/download/archive", middleware.Recover(auth.WithAuth(http.HandlerFunc(archiveH.DownloadSelection))))
+	mux.Handle("/preview/", middleware.Recover(auth.WithAuth(http.HandlerFunc(previewH.Preview))))
+	mux.Handle("/preview/content/", middleware.Recover(auth.WithAuth(http.HandlerFunc(previewH.Content))))
+	mux.Handle("/search", middleware.Recover(auth.WithAuth(http.HandlerFunc(searchH.Search))))
+	mux.Handle("/thumbnails/", middleware.Recover(auth.WithAuth(http.HandlerFunc(thumbnailH.Thumbnail))))
 	mux.Handle("/files/upload/", middleware.Recover(auth.WithAuth(http.HandlerFunc(pFileH.UploadFiles))))
+	mux.Handle("/files/extract/", middleware.Recover(auth.WithAuth(http.HandlerFunc(pFileH.ExtractArchive))))
+	mux.Handle("/files/tus/", middleware.Recover(auth.WithAuth(http.HandlerFunc(tusH.Handle))))
 
 	// Folder management routes
 	mux.Handle("/folders/create", middleware.Recover(auth.WithAuth(http.HandlerFunc(folderH.CreateFolder))))
+	mux.Handle("/folders/delete", middleware.Recover(auth.WithAuth(http.HandlerFunc(folderH.DeleteFolder))))
+	mux.Handle("/folders/move", middleware.Recover(auth.WithAuth(http.HandlerFunc(folderH.MoveFolder))))
+	mux.Handle("/folders/rename", middleware.Recover(auth.WithAuth(http.HandlerFunc(folderH.RenameFolder))))
+
+	// File version routes
+	mux.Handle("/versions/", middleware.Recover(auth.WithAuth(http.HandlerFunc(versionH.History))))
+	mux.Handle("/versions/download", middleware.Recover(auth.WithAuth(http.HandlerFunc(versionH.Download))))
+	mux.Handle("/versions/restore", middleware.Recover(auth.WithAuth(http.HandlerFunc(versionH.Restore))))
+
+	// Sharing routes
+	mux.Handle("/shared", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.SharedWithMe))))
+	mux.Handle("/shared/folder/", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.SharedFolder))))
+	mux.Handle("/shared/upload/", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.Upload))))
+	mux.Handle("/shared/mkdir", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.CreateFolder))))
+	mux.Handle("/shared/leave", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.Leave))))
+	mux.Handle("/shares/folder/", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.Shares))))
+	mux.Handle("/shares/file/", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.Shares))))
+	mux.Handle("/shares/create", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.Share))))
+	mux.Handle("/shares/revoke", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.Revoke))))
+	mux.Handle("/shares/links/create", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.CreateLink))))
+	mux.Handle("/shares/links/revoke", middleware.Recover(auth.WithAuth(http.HandlerFunc(shareH.RevokeLink))))
+	mux.Handle("/groups", middleware.Recover(auth.WithAuth(http.HandlerFunc(groupH.Groups))))
+	mux.Handle("/groups/create", middleware.Recover(auth.WithAuth(http.HandlerFunc(groupH.CreateGroup))))
+	mux.Handle("/groups/delete", middleware.Recover(auth.WithAuth(http.HandlerFunc(groupH.DeleteGroup))))
+	mux.Handle("/groups/members/add", middleware.Recover(auth.WithAuth(http.HandlerFunc(groupH.AddMember))))
+	mux.Handle("/groups/members/remove", middleware.Recover(auth.WithAuth(http.HandlerFunc(groupH.RemoveMember))))
+
+	// Trash routes
+	mux.Handle("/trash", middleware.Recover(auth.WithAuth(http.HandlerFunc(trashH.Trash))))
+	mux.Handle("/trash/restore", middleware.Recover(auth.WithAuth(http.HandlerFunc(trashH.Restore))))
+	mux.Handle("/trash/delete", middleware.Recover(auth.WithAuth(http.HandlerFunc(trashH.Purge))))
+	mux.Handle("/trash/empty", middleware.Recover(auth.WithAuth(http.HandlerFunc(trashH.Empty))))
+
+	// Profile routes
+	mux.Handle("/profile", middleware.Recover(auth.WithAuth(http.HandlerFunc(dashboardH.Dashboard))))
+
+	// API token routes
+	mux.Handle("/tokens", middleware.Recover(auth.WithAuth(http.HandlerFunc(tokenH.Tokens))))
+	mux.Handle("/tokens/", middleware.Recover(auth.WithAuth(http.HandlerFunc(tokenH.RevokeToken))))
+
+	// WebDAV routes
+	mux.Handle("/dav", middleware.Recover(basic.WithBasicAuth(http.HandlerFunc(davH.Handle))))
+	mux.Handle("/dav/", middleware.Recover(basic.WithBasicAuth(http.HandlerFunc(davH.Handle))))
+
+	// JSON API routes, see static/openapi.yaml
+	api := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, middleware.Recover(apiAuth.WithAPIAuth(h)))
+	}
+	api("GET /api/v1/folders", apiH.GetFolderByPath)
+	api("POST /api/v1/folders", apiH.CreateFolder)
+	api("GET /api/v1/folders/{id}", apiH.GetFolder)
+	api("PATCH /api/v1/folders/{id}", apiH.UpdateFolder)
+	api("DELETE /api/v1/folders/{id}", apiH.DeleteFolder)
+	api("POST /api/v1/folders/{id}/files", apiH.UploadFiles)
+	api("POST /api/v1/folders/{id}/extract", apiH.ExtractArchive)
+	api("GET /api/v1/folders/{id}/archive", apiH.DownloadFolderArchive)
+	api("POST /api/v1/archive", apiH.DownloadArchive)
+	api("GET /api/v1/files/{id}", apiH.GetFile)
+	api("GET /api/v1/files/{id}/content", apiH.DownloadFile)
+	api("GET /api/v1/files/{id}/versions", apiH.ListFileVersions)
+	api("GET /api/v1/files/{id}/versions/{version}/content", apiH.DownloadFileVersion)
+	api("POST /api/v1/files/{id}/versions/{version}/restore", apiH.RestoreFileVersion)
+	api("PATCH /api/v1/files/{id}", apiH.UpdateFile)
+	api("POST /api/v1/files/{id}/copy", apiH.CopyFile)
+	api("DELETE /api/v1/files/{id}", apiH.DeleteFile)
+	api("GET /api/v1/search", apiH.Search)
+	api("GET /api/v1/links", apiH.ListLinks)
+	api("POST /api/v1/links", apiH.CreateLink)
+	api("GET /api/v1/links/{token}", apiH.GetLink)
+	api("PATCH /api/v1/links/{token}", apiH.UpdateLink)
+	api("DELETE /api/v1/links/{token}", apiH.DeleteLink)
+	api("GET /api/v1/download-links", apiH.ListDownloadLinks)
+	api("POST /api/v1/download-links", apiH.CreateDownloadLink)
+	api("DELETE /api/v1/download-links/{token}", apiH.DeleteDownloadLink)
+	api("GET /api/v1/shared", apiH.ListSharedWithMe)
+	api("GET /api/v1/shares", apiH.ListShares)
+	api("POST /api/v1/shares", apiH.CreateShare)
+	api("DELETE /api/v1/shares/{id}", apiH.DeleteShare)
+	api("GET /api/v1/groups", apiH.ListGroups)
+	api("POST /api/v1/groups", apiH.CreateGroup)
+	api("DELETE /api/v1/groups/{id}", apiH.DeleteGroup)
+	api("PUT /api/v1/groups/{id}/members/{username}", apiH.AddGroupMember)
+	api("DELETE /api/v1/groups/{id}/members/{username}", apiH.RemoveGroupMember)
+	api("GET /api/v1/trash", apiH.ListTrash)
+	api("DELETE /api/v1/trash", apiH.EmptyTrash)
+	api("POST /api/v1/trash/{id}/restore", apiH.RestoreTrashItem)
+	api("DELETE /api/v1/trash/{id}", apiH.PurgeTrashItem)
+	mux.Handle("/api/v1/", middleware.Recover(http.HandlerFunc(apiH.NotFound)))
 
 	// Root route
 	mux.Handle("/", middleware.Recover(http.HandlerFunc(rootH.Root)))
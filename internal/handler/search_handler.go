@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/service"
+)
+
+type SearchHandler struct {
+	*baseHandler
+	searchSvc *service.SearchService
+	folderSvc *service.FolderService
+	converter *path.Converter
+}
+
+func NewSearchHandler(cfg *config.Config, r *Renderer, searchSvc *service.SearchService, folderSvc *service.FolderService, c *path.Converter) *SearchHandler {
+	return &SearchHandler{newBaseHandler(cfg, r), searchSvc, folderSvc, c}
+}
+
+// searchRow is a file on the search page, with where it is and why it matched.
+type searchRow struct {
+	fileRow
+	Folder    string
+	FolderURL string
+	Snippet   template.HTML
+}
+
+// parseSearchQuery reads a search from the query parameters q, folder, type,
+// min_size, max_size, after, before, limit and offset. Dates are given as
+// 2006-01-02 or in RFC 3339, sizes in bytes.
+func parseSearchQuery(v url.Values) (*model.SearchQuery, error) {
+	q := &model.SearchQuery{
+		Text:     v.Get("q"),
+		MimeType: v.Get("type"),
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"folder", &q.FolderID}, {"min_size", &q.MinSize}, {"max_size", &q.MaxSize}} {
+		if s := v.Get(p.name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", p.name, s)
+			}
+			*p.dst = n
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &q.Limit}, {"offset", &q.Offset}} {
+		if s := v.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", p.name, s)
+			}
+			*p.dst = n
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"after", &q.After}, {"before", &q.Before}} {
+		if s := v.Get(p.name); s != "" {
+			t, err := time.Parse(time.DateOnly, s)
+			if err != nil {
+				if t, err = time.Parse(time.RFC3339, s); err != nil {
+					return nil, fmt.Errorf("invalid %s %q", p.name, s)
+				}
+			}
+			*p.dst = t
+		}
+	}
+	return q, nil
+}
+
+// snippetHTML escapes a search snippet and marks the words that matched.
+func snippetHTML(s string) template.HTML {
+	s = template.HTMLEscapeString(s)
+	return template.HTML(strings.NewReplacer(model.MatchStart, "<mark>", model.MatchEnd, "</mark>").Replace(s))
+}
+
+// Search handles GET /search, a page with the files matching the words of q
+// and the filters of parseSearchQuery.
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+
+	v := r.URL.Query()
+	data := map[string]any{
+		"Query":  v.Get("q"),
+		"Type":   v.Get("type"),
+		"After":  v.Get("after"),
+		"Before": v.Get("before"),
+	}
+	q, err := parseSearchQuery(v)
+	if err != nil {
+		data["Error"] = err.Error()
+		h.r.Render(w, true, SearchPage, "Search", data)
+		return
+	}
+	if q.FolderID != 0 {
+		folder, err := h.folderSvc.GetById(r.Context(), user.ID, q.FolderID)
+		if err != nil {
+			http.Redirect(w, r, "/search?q="+url.QueryEscape(q.Text), http.StatusSeeOther)
+			return
+		}
+		data["Folder"] = folder
+		data["FolderURL"] = h.converter.ToURLPath(folder.Path)
+	}
+	if strings.TrimSpace(q.Text) == "" {
+		h.r.Render(w, true, SearchPage, "Search", data)
+		return
+	}
+
+	results, err := h.searchSvc.Search(r.Context(), user, q)
+	if errors.Is(err, service.ErrEmptySearch) {
+		data["Error"] = "Enter a word to search for."
+		h.r.Render(w, true, SearchPage, "Search", data)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not search the files of %s: %v", user.Username, err)
+		return
+	}
+	files := make([]*model.File, len(results))
+	for i, res := range results {
+		files[i] = &res.File
+	}
+	fileRows := filesToRows(files)
+	rows := make([]searchRow, len(results))
+	for i, res := range results {
+		folder := h.converter.GetParentDBPath(res.File.Location)
+		rows[i] = searchRow{
+			fileRow:   fileRows[i],
+			Folder:    "/" + folder,
+			FolderURL: h.converter.ToURLPath(folder),
+			Snippet:   snippetHTML(res.Snippet),
+		}
+	}
+	data["Results"] = rows
+	data["Searched"] = true
+	h.r.Render(w, true, SearchPage, "Search", data)
+}
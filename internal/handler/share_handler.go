@@ -0,0 +1,566 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/timezone"
+)
+
+type ShareHandler struct {
+	*baseHandler
+	shareSvc  *service.ShareService
+	groupSvc  *service.GroupService
+	folderSvc *service.FolderService
+	fileSvc   *service.PersonalFileService
+	linkSvc   *service.DownloadLinkService
+	converter *path.Converter
+}
+
+func NewShareHandler(cfg *config.Config, r *Renderer, shareSvc *service.ShareService, groupSvc *service.GroupService, folderSvc *service.FolderService, fileSvc *service.PersonalFileService, linkSvc *service.DownloadLinkService, c *path.Converter) *ShareHandler {
+	return &ShareHandler{
+		baseHandler: newBaseHandler(cfg, r),
+		shareSvc:    shareSvc,
+		groupSvc:    groupSvc,
+		folderSvc:   folderSvc,
+		fileSvc:     fileSvc,
+		linkSvc:     linkSvc,
+		converter:   c,
+	}
+}
+
+// fileFolderURL returns the page of the folder a file is in, the shared
+// folder page for the files of others.
+func fileFolderURL(c *path.Converter, user *model.User, file *model.File) string {
+	if file.UserID != user.ID {
+		return "/shared/folder/" + strconv.FormatInt(file.FolderID.Int64, 10)
+	}
+	return c.ToURLPath(c.GetParentDBPath(file.Location))
+}
+
+type sharedRow struct {
+	ID         int64
+	IsFolder   bool
+	Name       string
+	Owner      string
+	Permission string
+	SharedAt   time.Time
+	ShareID    int64
+}
+
+// SharedWithMe handles GET /shared, the folders and files others shared with
+// the user.
+func (h *ShareHandler) SharedWithMe(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	h.renderSharedWithMe(w, r, user, map[string]any{})
+}
+
+func (h *ShareHandler) renderSharedWithMe(w http.ResponseWriter, r *http.Request, user *model.User, data map[string]any) {
+	items, err := h.shareSvc.GetSharedWithUser(r.Context(), user)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get items shared with %s: %v", user.Username, err)
+		return
+	}
+	rows := make([]sharedRow, len(items))
+	for i, it := range items {
+		rows[i] = sharedRow{
+			ID:         it.FileID.Int64,
+			IsFolder:   it.FolderID.Valid,
+			Name:       it.Name,
+			Owner:      it.Owner,
+			Permission: it.Permission.String(),
+			SharedAt:   it.SharedAt,
+			ShareID:    it.ShareID.Int64,
+		}
+		if it.FolderID.Valid {
+			rows[i].ID = it.FolderID.Int64
+		}
+	}
+	data["Items"] = rows
+	h.r.Render(w, true, SharedPage, "Shared with me", data)
+}
+
+// Leave handles POST /shared/leave, which removes the share in the id form
+// field from the items shared with the user.
+func (h *ShareHandler) Leave(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formID(w, r)
+	if user == nil {
+		return
+	}
+	if err := h.shareSvc.RevokeShare(r.Context(), user, id); err != nil {
+		if errors.Is(err, service.ErrShareNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not leave share %d: %v", id, err)
+		return
+	}
+	http.Redirect(w, r, "/shared", http.StatusSeeOther)
+}
+
+// SharedFolder handles GET /shared/folder/{id}, the contents of a folder
+// shared with the user or of a folder below one. Users who may no longer see
+// the folder are sent back to /shared.
+func (h *ShareHandler) SharedFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/shared/folder/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+	h.renderSharedFolder(w, r, user, id, map[string]any{})
+}
+
+func (h *ShareHandler) renderSharedFolder(w http.ResponseWriter, r *http.Request, user *model.User, folderID int64, data map[string]any) {
+	folder, err := h.folderSvc.GetById(r.Context(), user.ID, folderID)
+	if err != nil {
+		http.Redirect(w, r, "/shared", http.StatusSeeOther)
+		return
+	}
+	if folder.UserID == user.ID {
+		http.Redirect(w, r, h.converter.ToURLPath(folder.Path), http.StatusSeeOther)
+		return
+	}
+	p, err := h.folderSvc.Permission(r.Context(), user.ID, folder)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get permission on folder %d: %v", folder.ID, err)
+		return
+	}
+	folders, files, err := h.folderSvc.GetFolderContents(r.Context(), user.ID, folder.ID)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get folder content: %v", err)
+		return
+	}
+
+	// Go up as long as the parent is shared as well.
+	backURL := "/shared"
+	if folder.ParentID.Valid {
+		if _, err := h.folderSvc.GetById(r.Context(), user.ID, folder.ParentID.Int64); err == nil {
+			backURL = "/shared/folder/" + strconv.FormatInt(folder.ParentID.Int64, 10)
+		}
+	}
+	data["Folder"] = folder
+	data["BackURL"] = backURL
+	data["Folders"] = foldersToRows(folders, "")
+	data["Files"] = filesToRows(files)
+	data["Permission"] = p.String()
+	data["CanWrite"] = p >= model.PermissionWrite
+	data["CanManage"] = p >= model.PermissionManage
+	h.r.Render(w, true, SharedFolderPage, folder.Name, data)
+}
+
+// Upload handles POST /shared/upload/{id}, files uploaded to a shared folder
+// the user may write to. They count towards the quota of the owner.
+func (h *ShareHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/shared/upload/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+	folder, err := h.folderSvc.GetById(r.Context(), user.ID, id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.limitUploadSize(w, r)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "invalid multipart data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.fileSvc.StoreFiles(r.Context(), user, reader, folder.ID, folder.Path); err != nil {
+		switch {
+		case isUploadTooLarge(err):
+			http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, service.ErrPermissionDenied):
+			http.Error(w, "You may not upload to this folder", http.StatusForbidden)
+		case errors.Is(err, service.ErrQuotaExceeded):
+			http.Error(w, "The storage quota of the owner is exceeded", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, service.ErrInvalidFolderPath), errors.Is(err, service.ErrInvalidFolderName):
+			http.Error(w, "invalid file or folder name in upload", http.StatusBadRequest)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			logger.Error("could not store files in shared folder %d: %v", folder.ID, err)
+		}
+		return
+	}
+	http.Redirect(w, r, "/shared/folder/"+strconv.FormatInt(folder.ID, 10), http.StatusSeeOther)
+}
+
+// CreateFolder handles POST /shared/mkdir, which creates the folder name in
+// the shared folder id.
+func (h *ShareHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formID(w, r)
+	if user == nil {
+		return
+	}
+	_, err := h.folderSvc.CreateFolder(r.Context(), user.ID, user.Username, id, strings.TrimSpace(r.FormValue("name")), "")
+	if err != nil {
+		msg := "Failed to create the folder"
+		switch {
+		case errors.Is(err, service.ErrFolderNotFound):
+			http.Redirect(w, r, "/shared", http.StatusSeeOther)
+			return
+		case errors.Is(err, service.ErrPermissionDenied):
+			msg = "You may not create folders here"
+		case errors.Is(err, service.ErrInvalidFolderName), errors.Is(err, service.ErrInvalidFolderPath):
+			msg = "Invalid folder name"
+		case errors.Is(err, service.ErrFolderAlreadyExists):
+			msg = "A folder with that name already exists"
+		default:
+			logger.Error("could not create folder in shared folder %d: %v", id, err)
+		}
+		h.renderSharedFolder(w, r, user, id, map[string]any{"Error": msg})
+		return
+	}
+	http.Redirect(w, r, "/shared/folder/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
+}
+
+type shareRow struct {
+	ID         int64
+	IsGroup    bool
+	Target     string
+	Permission string
+	CreatedAt  time.Time
+}
+
+// sharedItem is the folder or file a share page is about.
+type sharedItem struct {
+	Kind    string
+	ID      int64
+	Name    string
+	BackURL string
+}
+
+// item returns the folder or file the share page at /shares/folder/{id} or
+// /shares/file/{id} is about, or writes an error.
+func (h *ShareHandler) item(w http.ResponseWriter, r *http.Request, user *model.User, kind string, id int64) (*sharedItem, bool) {
+	switch kind {
+	case "folder":
+		folder, err := h.folderSvc.GetById(r.Context(), user.ID, id)
+		if err != nil {
+			http.NotFound(w, r)
+			return nil, false
+		}
+		back := "/shared/folder/" + strconv.FormatInt(folder.ID, 10)
+		if folder.UserID == user.ID {
+			back = h.converter.ToURLPath(h.converter.GetParentDBPath(folder.Path))
+		}
+		return &sharedItem{kind, folder.ID, folder.Name, back}, true
+	case "file":
+		file, err := h.fileSvc.GetFile(r.Context(), user.ID, id)
+		if err != nil {
+			http.NotFound(w, r)
+			return nil, false
+		}
+		return &sharedItem{kind, file.ID, file.Name, fileFolderURL(h.converter, user, file)}, true
+	}
+	http.NotFound(w, r)
+	return nil, false
+}
+
+// Shares handles GET /shares/folder/{id} and /shares/file/{id}, the page
+// listing who an item is shared with, where it can be shared further.
+func (h *ShareHandler) Shares(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	kind, idStr, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/shares/"), "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	h.renderShares(w, r, user, kind, id, map[string]any{})
+}
+
+func (h *ShareHandler) renderShares(w http.ResponseWriter, r *http.Request, user *model.User, kind string, id int64, data map[string]any) {
+	item, ok := h.item(w, r, user, kind, id)
+	if !ok {
+		return
+	}
+	var shares []*model.Share
+	var links []*model.DownloadLink
+	var err error
+	if kind == "folder" {
+		shares, err = h.shareSvc.GetFolderShares(r.Context(), user, id)
+		if err == nil {
+			links, err = h.linkSvc.GetFolderLinks(r.Context(), user, id)
+		}
+	} else {
+		shares, err = h.shareSvc.GetFileShares(r.Context(), user, id)
+		if err == nil {
+			links, err = h.linkSvc.GetFileLinks(r.Context(), user, id)
+		}
+	}
+	if errors.Is(err, service.ErrPermissionDenied) {
+		http.Error(w, "Only users who may manage this item can share it", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get shares of %s %d: %v", kind, id, err)
+		return
+	}
+	groups, err := h.groupSvc.GetUserGroups(r.Context(), user)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get groups of %s: %v", user.Username, err)
+		return
+	}
+
+	rows := make([]shareRow, len(shares))
+	for i, s := range shares {
+		rows[i] = shareRow{
+			ID:         s.ID,
+			IsGroup:    s.GroupID.Valid,
+			Target:     s.TargetName,
+			Permission: s.Permission.String(),
+			CreatedAt:  s.CreatedAt,
+		}
+	}
+	data["Item"] = item
+	data["Shares"] = rows
+	data["Links"] = links
+	data["Groups"] = groups
+	data["Now"] = timezone.TZ.GetUTCNow()
+	h.r.Render(w, true, SharePage, "Share "+item.Name, data)
+}
+
+// Share handles POST /shares/create, which shares the folder or file in the
+// form with the user called username or the group in the group field.
+func (h *ShareHandler) Share(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formID(w, r)
+	if user == nil {
+		return
+	}
+	kind := r.FormValue("kind")
+	p := model.ParsePermission(r.FormValue("permission"))
+	to := service.ShareTarget{Username: r.FormValue("username")}
+	var err error
+	if g := r.FormValue("group"); g != "" {
+		if to.GroupID, err = strconv.ParseInt(g, 10, 64); err != nil {
+			http.Error(w, "Invalid group ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch kind {
+	case "folder":
+		_, err = h.shareSvc.ShareFolder(r.Context(), user, id, to, p)
+	case "file":
+		_, err = h.shareSvc.ShareFile(r.Context(), user, id, to, p)
+	default:
+		http.Error(w, "Invalid item", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		msg := "Failed to share the item"
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			msg = fmt.Sprintf("There is no user called %q", to.Username)
+		case errors.Is(err, service.ErrGroupNotFound):
+			msg = "Group not found"
+		case errors.Is(err, service.ErrShareWithOwner):
+			msg = "The item already belongs to that user"
+		case errors.Is(err, service.ErrCannotShareRoot):
+			msg = "Your root folder cannot be shared, share the folders in it instead"
+		case errors.Is(err, service.ErrInvalidPermission):
+			msg = "Choose read, write or manage"
+		default:
+			// renderShares reports missing items and permissions.
+			logger.Error("could not share %s %d: %v", kind, id, err)
+		}
+		h.renderShares(w, r, user, kind, id, map[string]any{"Error": msg})
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/shares/%s/%d", kind, id), http.StatusSeeOther)
+}
+
+// Revoke handles POST /shares/revoke, which deletes the share in the id form
+// field and goes back to the share page of the item in kind and item.
+func (h *ShareHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formID(w, r)
+	if user == nil {
+		return
+	}
+	itemID, err := strconv.ParseInt(r.FormValue("item"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+	kind := r.FormValue("kind")
+	if err := h.shareSvc.RevokeShare(r.Context(), user, id); err != nil {
+		if errors.Is(err, service.ErrShareNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if errors.Is(err, service.ErrPermissionDenied) {
+			http.Error(w, "Only users who may manage this item can revoke its shares", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not revoke share %d: %v", id, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/shares/%s/%d", kind, itemID), http.StatusSeeOther)
+}
+
+// CreateLink handles POST /shares/links/create, which creates a download link
+// to the folder or file in the form. password, expiry and max_downloads are
+// optional.
+func (h *ShareHandler) CreateLink(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formID(w, r)
+	if user == nil {
+		return
+	}
+	kind := r.FormValue("kind")
+	opts := service.DownloadLinkOptions{Name: r.FormValue("name"), Password: r.FormValue("password")}
+	if v := r.FormValue("expiry"); v != "" {
+		exp, err := timezone.TZ.ParseDatetimeLocal(v)
+		if err != nil {
+			http.Error(w, "Invalid date format", http.StatusBadRequest)
+			return
+		}
+		opts.ExpiresAt = exp
+	}
+	if v := r.FormValue("max_downloads"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid number of downloads", http.StatusBadRequest)
+			return
+		}
+		opts.MaxDownloads = n
+	}
+
+	var err error
+	switch kind {
+	case "folder":
+		_, err = h.linkSvc.CreateFolderLink(r.Context(), user, id, opts)
+	case "file":
+		_, err = h.linkSvc.CreateFileLink(r.Context(), user, id, opts)
+	default:
+		http.Error(w, "Invalid item", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		msg := "Failed to create the link"
+		switch {
+		case errors.Is(err, service.ErrCannotShareRoot):
+			msg = "Your root folder cannot be shared, share the folders in it instead"
+		case errors.Is(err, service.ErrInvalidMaxDownloads):
+			msg = "The number of downloads must not be negative"
+		default:
+			logger.Error("could not create download link for %s %d: %v", kind, id, err)
+		}
+		h.renderShares(w, r, user, kind, id, map[string]any{"Error": msg})
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/shares/%s/%d", kind, id), http.StatusSeeOther)
+}
+
+// RevokeLink handles POST /shares/links/revoke, which deletes the download
+// link in the token form field. It goes back to the share page of the item in
+// kind and item, or to the links page if back is "links".
+func (h *ShareHandler) RevokeLink(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	if err := h.linkSvc.RevokeDownloadLink(r.Context(), user, r.FormValue("token")); err != nil {
+		if errors.Is(err, service.ErrDownloadLinkNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not revoke download link: %v", err)
+		return
+	}
+	kind := r.FormValue("kind")
+	itemID, err := strconv.ParseInt(r.FormValue("item"), 10, 64)
+	if r.FormValue("back") == "links" || err != nil || (kind != "folder" && kind != "file") {
+		http.Redirect(w, r, "/links", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/shares/%s/%d", kind, itemID), http.StatusSeeOther)
+}
+
+// formID returns the user and the id form field of a POST request, or writes
+// an error and returns nil.
+func (h *ShareHandler) formID(w http.ResponseWriter, r *http.Request) (*model.User, int64) {
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, 0
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return nil, 0
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, 0
+	}
+	return user, id
+}
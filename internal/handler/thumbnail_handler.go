@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+)
+
+type ThumbnailHandler struct {
+	*baseHandler
+	fileSvc      *service.PersonalFileService
+	thumbnailSvc *service.ThumbnailService
+}
+
+func NewThumbnailHandler(cfg *config.Config, r *Renderer, fileSvc *service.PersonalFileService, thumbnailSvc *service.ThumbnailService) *ThumbnailHandler {
+	return &ThumbnailHandler{newBaseHandler(cfg, r), fileSvc, thumbnailSvc}
+}
+
+// thumbnailURL returns the URL of the thumbnails of file without the size, or
+// an empty string for files without thumbnails. The content hash in v makes
+// the URL change with the content, so thumbnails can be cached for long.
+func thumbnailURL(file *model.File) string {
+	if !service.HasThumbnail(file.MimeType) {
+		return ""
+	}
+	return fmt.Sprintf("/thumbnails/%d?v=%.16s", file.ID, file.Hash)
+}
+
+// Thumbnail handles GET /thumbnails/{id}?size=small|large and sends a JPEG
+// thumbnail of an image.
+func (h *ThumbnailHandler) Thumbnail(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	fileID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/thumbnails/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+	size, err := service.ParseThumbnailSize(r.URL.Query().Get("size"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, err := h.fileSvc.GetFile(r.Context(), user.ID, fileID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	d, err := h.thumbnailSvc.Thumbnail(r.Context(), user, file, size)
+	switch {
+	case errors.Is(err, service.ErrNoThumbnail), errors.Is(err, service.ErrFileNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not make thumbnail of file %d: %v", file.ID, err)
+		return
+	}
+	defer d.Content.Close()
+
+	w.Header().Set("Content-Type", d.MimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+d.Hash+`"`)
+	if r.URL.Query().Get("v") == file.Hash[:min(16, len(file.Hash))] {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	http.ServeContent(w, r, d.Name, d.ModTime, d.Content)
+}
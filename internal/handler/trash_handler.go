@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type TrashHandler struct {
+	*baseHandler
+	trashSvc *service.TrashService
+}
+
+func NewTrashHandler(cfg *config.Config, r *Renderer, trashSvc *service.TrashService) *TrashHandler {
+	return &TrashHandler{
+		baseHandler: newBaseHandler(cfg, r),
+		trashSvc:    trashSvc,
+	}
+}
+
+type trashRow struct {
+	ID           int64
+	Name         string
+	IsFolder     bool
+	OriginalPath string
+	Size         string
+	FileCount    int
+	DeletedAt    time.Time
+	PurgeAt      *time.Time
+}
+
+// trashPurgeAt returns when the purge job removes an item, nil if items are
+// kept until they are purged by hand.
+func trashPurgeAt(cfg *config.Config, item *model.TrashItem) *time.Time {
+	if cfg.TrashRetention() <= 0 {
+		return nil
+	}
+	t := item.DeletedAt.Add(cfg.TrashRetention())
+	return &t
+}
+
+func (h *TrashHandler) renderTrash(w http.ResponseWriter, r *http.Request, user *model.User, data map[string]any) {
+	items, err := h.trashSvc.GetUserTrash(r.Context(), user)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		logger.Error("could not get trash: %v", err)
+		return
+	}
+	rows := make([]trashRow, len(items))
+	for i, t := range items {
+		rows[i] = trashRow{
+			ID:           t.ID,
+			Name:         t.Name,
+			IsFolder:     t.IsFolder,
+			OriginalPath: "/" + t.OriginalPath,
+			Size:         humanReadableSize(t.Size),
+			FileCount:    t.FileCount,
+			DeletedAt:    t.DeletedAt,
+			PurgeAt:      trashPurgeAt(h.cfg, t),
+		}
+	}
+	data["Items"] = rows
+	data["RetentionDays"] = h.cfg.TrashRetentionDays
+	h.r.Render(w, true, TrashPage, "Trash", data)
+}
+
+// Trash lists the deleted files and folders of the user.
+func (h *TrashHandler) Trash(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodGet {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	h.renderTrash(w, r, user, map[string]any{})
+}
+
+// Restore handles POST /trash/restore with the item in the id form field.
+func (h *TrashHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formItem(w, r)
+	if user == nil {
+		return
+	}
+
+	item, err := h.trashSvc.Restore(r.Context(), user, id)
+	if err != nil {
+		logger.Error("could not restore trash item %d: %v", id, err)
+		msg := "Failed to restore the item"
+		switch {
+		case errors.Is(err, service.ErrTrashItemNotFound):
+			http.NotFound(w, r)
+			return
+		case errors.Is(err, service.ErrRestoreConflict):
+			msg = "An item with the same name already exists at the original location. Rename it and try again."
+		case errors.Is(err, service.ErrQuotaExceeded):
+			msg = "Restoring the item would exceed your storage quota. Free up some space and try again."
+		}
+		h.renderTrash(w, r, user, map[string]any{"Error": msg})
+		return
+	}
+	h.renderTrash(w, r, user, map[string]any{"Notice": fmt.Sprintf("Restored %q to /%s", item.Name, item.OriginalPath)})
+}
+
+// Purge handles POST /trash/delete, which deletes an item for good.
+func (h *TrashHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	user, id := h.formItem(w, r)
+	if user == nil {
+		return
+	}
+
+	if err := h.trashSvc.Purge(r.Context(), user, id); err != nil {
+		logger.Error("could not purge trash item %d: %v", id, err)
+		if errors.Is(err, service.ErrTrashItemNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		h.renderTrash(w, r, user, map[string]any{"Error": "Failed to delete the item"})
+		return
+	}
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// Empty handles POST /trash/empty.
+func (h *TrashHandler) Empty(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+
+	n, err := h.trashSvc.EmptyTrash(r.Context(), user)
+	if err != nil {
+		logger.Error("could not empty trash of user %d after %d items: %v", user.ID, n, err)
+		h.renderTrash(w, r, user, map[string]any{"Error": "Failed to empty the trash"})
+		return
+	}
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+func (h *TrashHandler) formItem(w http.ResponseWriter, r *http.Request) (*model.User, int64) {
+	if r.Method != http.MethodPost {
+		logger.InvalidMethod(r)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, 0
+	}
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return nil, 0
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return nil, 0
+	}
+	return user, id
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/NiClassic/go-cloud/config"
+	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	tusPrefix     = "/files/tus/"
+)
+
+// TusHandler implements the core, creation and termination parts of the tus
+// 1.0 resumable upload protocol (https://tus.io/protocols/resumable-upload).
+type TusHandler struct {
+	*baseHandler
+	tusService    *service.TusService
+	folderService *service.FolderService
+}
+
+func NewTusHandler(cfg *config.Config, r *Renderer, tusService *service.TusService, folderService *service.FolderService) *TusHandler {
+	return &TusHandler{
+		baseHandler:   newBaseHandler(cfg, r),
+		tusService:    tusService,
+		folderService: folderService,
+	}
+}
+
+func (h *TusHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	logger.Request(r)
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		if h.cfg.MaxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.cfg.MaxUploadSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		logger.Error("unsupported tus version: %q", r.Header.Get("Tus-Resumable"))
+		return
+	}
+
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+
+	uploadToken := strings.Trim(strings.TrimPrefix(r.URL.Path, tusPrefix), "/")
+	switch {
+	case r.Method == http.MethodPost && uploadToken == "":
+		h.create(w, r, user)
+	case r.Method == http.MethodHead && uploadToken != "":
+		h.head(w, r, user, uploadToken)
+	case r.Method == http.MethodPatch && uploadToken != "":
+		h.patch(w, r, user, uploadToken)
+	case r.Method == http.MethodDelete && uploadToken != "":
+		h.terminate(w, r, user, uploadToken)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logger.InvalidMethod(r)
+	}
+}
+
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request, user *model.User) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		logger.Error("invalid Upload-Length: %q", r.Header.Get("Upload-Length"))
+		return
+	}
+	if h.cfg.MaxUploadSize > 0 && length > h.cfg.MaxUploadSize {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		logger.Error("upload of %d bytes exceeds the limit of %d bytes", length, h.cfg.MaxUploadSize)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		logger.Error("invalid Upload-Metadata: %v", err)
+		return
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+
+	// The target folder is given as a DB path, the user's root folder is the default.
+	folder, err := h.folderService.GetByPath(r.Context(), user.ID, user.Username, metadata["folder"])
+	if err != nil {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		logger.Error("could not get folder %q: %v", metadata["folder"], err)
+		return
+	}
+
+	upload, err := h.tusService.CreateUpload(r.Context(), user, folder.ID, filename, length)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", tusPrefix+upload.UploadToken)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *TusHandler) head(w http.ResponseWriter, r *http.Request, user *model.User, uploadToken string) {
+	upload, err := h.tusService.GetUpload(r.Context(), user.ID, uploadToken)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TusHandler) patch(w http.ResponseWriter, r *http.Request, user *model.User, uploadToken string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "invalid Content-Type", http.StatusUnsupportedMediaType)
+		logger.Error("invalid tus Content-Type: %q", r.Header.Get("Content-Type"))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		logger.Error("invalid Upload-Offset: %q", r.Header.Get("Upload-Offset"))
+		return
+	}
+
+	newOffset, err := h.tusService.WriteChunk(r.Context(), user, uploadToken, offset, r.Body)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) terminate(w http.ResponseWriter, r *http.Request, user *model.User, uploadToken string) {
+	if err := h.tusService.Terminate(r.Context(), user, uploadToken); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) writeError(w http.ResponseWriter, err error) {
+	logger.Error("tus request failed: %v", err)
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound), errors.Is(err, service.ErrFolderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrUploadOffsetMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrUploadTooLarge), errors.Is(err, service.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, service.ErrInvalidFileName), errors.Is(err, service.ErrInvalidUploadLength):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// parseTusMetadata decodes an Upload-Metadata header, a comma separated list
+// of keys with an optional base64 encoded value each.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, errors.New("malformed metadata pair: " + pair)
+		}
+	}
+	return metadata, nil
+}
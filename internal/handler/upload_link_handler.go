@@ -1,42 +1,63 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/NiClassic/go-cloud/config"
 	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
 	"github.com/NiClassic/go-cloud/internal/timezone"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/NiClassic/go-cloud/internal/service"
 )
 
+// uploadLinkCookie holds the proof that the visitor entered the password of
+// an upload link. Its path is the link, so every link has its own.
+const uploadLinkCookie = "upload_link"
+
 type UploadLinkHandler struct {
 	*baseHandler
-	linkService       *service.UploadLinkService
-	linkUnlockService *service.LinkUnlockService
+	linkService     *service.UploadLinkService
+	downloadService *service.DownloadLinkService
+	folderService   *service.FolderService
 }
 
-func NewUploadLinkHandler(cfg *config.Config, r *Renderer, ls *service.UploadLinkService, lu *service.LinkUnlockService) *UploadLinkHandler {
+func NewUploadLinkHandler(cfg *config.Config, r *Renderer, ls *service.UploadLinkService, ds *service.DownloadLinkService, fs *service.FolderService) *UploadLinkHandler {
 	return &UploadLinkHandler{
-		baseHandler:       newBaseHandler(cfg, r),
-		linkService:       ls,
-		linkUnlockService: lu,
+		baseHandler:     newBaseHandler(cfg, r),
+		linkService:     ls,
+		downloadService: ds,
+		folderService:   fs,
 	}
 }
 
 func (h *UploadLinkHandler) ShowLinks(w http.ResponseWriter, r *http.Request) {
 	logger.Request(r)
-	links, err := h.linkService.GetAllLinks(r.Context())
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	links, err := h.linkService.GetUserLinks(r.Context(), user.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		logger.Error("could not get all links: %v", err)
 		return
 	}
-	h.r.Render(w, true, LinkSharePage, "Upload Links", map[string]any{
-		"Links": links,
-		"Now":   timezone.TZ.GetUTCNow(),
+	downloadLinks, err := h.downloadService.GetUserLinks(r.Context(), user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.Error("could not get download links: %v", err)
+		return
+	}
+	h.r.Render(w, true, LinkSharePage, "Links", map[string]any{
+		"Links":         links,
+		"DownloadLinks": downloadLinks,
+		"Now":           timezone.TZ.GetUTCNow(),
 	})
 }
 
@@ -54,64 +75,222 @@ func (h *UploadLinkHandler) VisitUploadLink(w http.ResponseWriter, r *http.Reque
 	link, err := h.linkService.GetByToken(r.Context(), linkToken)
 	if err != nil {
 		logger.Error("could not get upload link: %v", err)
-		http.Redirect(w, r, "/links/create", http.StatusSeeOther)
+		http.NotFound(w, r)
+		return
+	}
+	// Visitors of an upload link do not need an account, so user may be nil.
+	user := ExtractUser(r)
+
+	if len(parts) == 2 && (parts[1] == "edit" || parts[1] == "revoke") {
+		h.manageUploadLink(w, r, parts[1], linkToken)
+		return
+	}
+	if link.Expired(time.Now()) {
+		http.Error(w, "This upload link has expired", http.StatusGone)
 		return
 	}
-	user := ExtractUserOrRedirect(w, r)
 
 	switch r.Method {
 	case http.MethodGet:
+		unlocked := h.unlocked(r, user, link)
 		if len(parts) == 2 && parts[1] == "auth" {
-			h.r.Render(w, true, LinkSharePasswordPage, "Unlock Link", map[string]any{
-				"LinkName":  link.Name,
-				"LinkToken": link.LinkToken,
-			})
+			if unlocked {
+				http.Redirect(w, r, "/links/"+link.LinkToken, http.StatusSeeOther)
+				return
+			}
+			h.renderPasswordPage(w, user, link, "")
 			return
 		}
-		unlocked, err := h.linkUnlockService.HasUnlocked(r.Context(), user.ID, link.ID)
-		if err != nil || !unlocked {
+		if !unlocked {
 			http.Redirect(w, r, fmt.Sprintf("/links/%s/auth", link.LinkToken), http.StatusSeeOther)
-			logger.Error("could not visit upload link: %v", err)
 			return
 		}
-		h.r.Render(w, true, LinkShareDetailPage, "View Link", map[string]any{
-			"LinkName": link.Name,
+		h.r.Render(w, user != nil, LinkShareDetailPage, link.Name, map[string]any{
+			"LinkName":  link.Name,
+			"LinkToken": link.LinkToken,
+			"ExpiresAt": link.ExpiresAt,
 		})
 
 	case http.MethodPost:
+		if len(parts) == 2 && parts[1] == "upload" {
+			h.uploadFiles(w, r, user, link)
+			return
+		}
 		if len(parts) != 2 || parts[1] != "auth" {
 			http.Error(w, "invalid request", http.StatusMethodNotAllowed)
 			logger.Error("invalid request: %v", r.URL.Path)
 			return
 		}
-		password := r.FormValue("password")
-		link, err = h.linkService.ValidatePassword(r.Context(), link.LinkToken, password)
-		if err != nil {
-			logger.Error("could not validate link password: %v", err)
-			http.Redirect(w, r, "/links/create", http.StatusSeeOther)
+		if _, err := h.linkService.ValidatePassword(r.Context(), link.LinkToken, r.FormValue("password")); err != nil {
+			switch {
+			case errors.Is(err, service.ErrInvalidPassword):
+				w.WriteHeader(http.StatusUnauthorized)
+				h.renderPasswordPage(w, user, link, "Wrong password")
+			case errors.Is(err, service.ErrLinkExpired):
+				http.Error(w, "This upload link has expired", http.StatusGone)
+			default:
+				logger.Error("could not validate link password: %v", err)
+				http.NotFound(w, r)
+			}
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     uploadLinkCookie,
+			Value:    h.linkService.UnlockProof(link),
+			Path:     "/links/" + link.LinkToken,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/links/"+link.LinkToken, http.StatusSeeOther)
+	default:
+		logger.InvalidMethod(r)
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+// manageUploadLink handles the owner-only edit and revoke actions of a link.
+func (h *UploadLinkHandler) manageUploadLink(w http.ResponseWriter, r *http.Request, action, linkToken string) {
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
+	link, err := h.linkService.GetOwnedLink(r.Context(), user.ID, linkToken)
+	if err != nil {
+		logger.Error("user %d cannot manage link: %v", user.ID, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case action == "edit" && r.Method == http.MethodGet:
+		h.r.Render(w, true, LinkShareEditPage, "Edit Link", map[string]any{
+			"LinkName":  link.Name,
+			"LinkToken": link.LinkToken,
+			"ExpiresAt": link.ExpiresAt,
+		})
+	case action == "edit" && r.Method == http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			logger.Error("could not parse form: %v", err)
+			http.Error(w, "invalid form", http.StatusBadRequest)
 			return
 		}
-		err = h.linkUnlockService.UnlockLink(r.Context(), user.ID, link.ID, link.ExpiresAt)
+		exp, err := timezone.TZ.ParseDatetimeLocal(r.Form.Get("expiry"))
 		if err != nil {
-			logger.Error("could not unlock link: %v", err)
-			http.Redirect(w, r, "/links/create", http.StatusSeeOther)
+			logger.Error("invalid date format: %v", err)
+			http.Error(w, "Invalid date format", http.StatusBadRequest)
 			return
 		}
-
-		http.Redirect(w, r, "/links/"+link.LinkToken, http.StatusSeeOther)
+		if _, err := h.linkService.UpdateUploadLink(r.Context(), user.ID, link.LinkToken, r.Form.Get("name"), exp); err != nil {
+			logger.Error("could not update upload link: %v", err)
+			http.Error(w, "failed to update upload link", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/links", http.StatusSeeOther)
+	case action == "revoke" && r.Method == http.MethodPost:
+		if err := h.linkService.RevokeUploadLink(r.Context(), user.ID, link.LinkToken); err != nil {
+			logger.Error("could not revoke upload link: %v", err)
+			http.Error(w, "failed to revoke upload link", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/links", http.StatusSeeOther)
 	default:
 		logger.InvalidMethod(r)
-		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *UploadLinkHandler) renderPasswordPage(w http.ResponseWriter, user *model.User, link *model.UploadLink, msg string) {
+	h.r.Render(w, user != nil, LinkSharePasswordPage, "Unlock Link", map[string]any{
+		"LinkName":  link.Name,
+		"LinkToken": link.LinkToken,
+		"Error":     msg,
+	})
+}
+
+// unlocked reports whether the visitor, user is nil without an account, may
+// upload without entering the password, see UploadLinkService.Unlocked.
+func (h *UploadLinkHandler) unlocked(r *http.Request, user *model.User, link *model.UploadLink) bool {
+	proof := ""
+	if c, err := r.Cookie(uploadLinkCookie); err == nil {
+		proof = c.Value
 	}
+	return h.linkService.Unlocked(link, user, proof)
+}
+
+// uploadFiles stores the files posted to an upload link in the folder of the
+// link owner. Visitors that have not unlocked the link, like scripts, have to
+// send the password as the first form field.
+func (h *UploadLinkHandler) uploadFiles(w http.ResponseWriter, r *http.Request, user *model.User, link *model.UploadLink) {
+	h.limitUploadSize(w, r)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		logger.Error("invalid multipart data: %v", err)
+		h.r.Error(w, "Invalid upload")
+		return
+	}
+
+	if !h.unlocked(r, user, link) {
+		part, err := reader.NextPart()
+		if err != nil || part.FormName() != "password" {
+			logger.Error("upload to link %d without password", link.ID)
+			h.r.Error(w, "Password required")
+			return
+		}
+		plain, err := io.ReadAll(io.LimitReader(part, 1024))
+		if err != nil {
+			logger.Error("could not read link password: %v", err)
+			h.r.Error(w, "Invalid upload")
+			return
+		}
+		if _, err := h.linkService.ValidatePassword(r.Context(), link.LinkToken, string(plain)); err != nil {
+			logger.Error("could not validate link password: %v", err)
+			h.r.Error(w, "Invalid password")
+			return
+		}
+	}
+
+	if err := h.linkService.StoreFiles(r.Context(), link, reader); err != nil {
+		logger.Error("could not store files for link %d: %v", link.ID, err)
+		switch {
+		case isUploadTooLarge(err):
+			h.r.Error(w, "The upload is too large")
+		case errors.Is(err, service.ErrQuotaExceeded):
+			h.r.Error(w, "The owner of this link has no storage space left")
+		case errors.Is(err, service.ErrLinkExpired):
+			h.r.Error(w, "This upload link has expired")
+		case errors.Is(err, service.ErrLinkNoDestination):
+			h.r.Error(w, "This upload link does not accept files")
+		case errors.Is(err, service.ErrInvalidFolderPath), errors.Is(err, service.ErrInvalidFolderName):
+			h.r.Error(w, "A file or folder in the upload has an invalid name")
+		default:
+			h.r.Error(w, "Something went wrong. Please try again")
+		}
+		return
+	}
+	h.r.Render(w, user != nil, LinkUploadResult, "", map[string]any{
+		"LinkName": link.Name,
+	})
 }
 
 func (h *UploadLinkHandler) CreateUploadLink(w http.ResponseWriter, r *http.Request) {
 	logger.Request(r)
+	user := ExtractUserOrRedirect(w, r)
+	if user == nil {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
+		folders, err := h.folderService.GetAllFolders(r.Context(), user.ID)
+		if err != nil {
+			logger.Error("could not get folders: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
 		exp := timezone.TZ.GetUTCNow().Add(time.Hour)
 		h.r.Render(w, true, LinkShareCreationPage, "Create Link", map[string]any{
 			"DefaultExpiresAt": exp,
+			"Folders":          folders,
 		})
 	case http.MethodPost:
 		if err := r.ParseForm(); err != nil {
@@ -131,20 +310,30 @@ func (h *UploadLinkHandler) CreateUploadLink(w http.ResponseWriter, r *http.Requ
 			http.Error(w, "Expiry time must be in the future", http.StatusBadRequest)
 			return
 		}
+		folderID, err := strconv.ParseInt(r.Form.Get("folder"), 10, 64)
+		if err != nil {
+			logger.Error("invalid folder id: %v", err)
+			http.Error(w, "Invalid folder", http.StatusBadRequest)
+			return
+		}
 		link, err := h.linkService.CreateUploadLink(r.Context(),
+			user.ID,
+			folderID,
 			r.Form.Get("name"),
 			r.Form.Get("password"),
 			exp,
 		)
-		if err != nil {
+		if errors.Is(err, service.ErrFolderNotFound) {
 			logger.Error("could not create upload link: %v", err)
-			http.Error(w, "failed to create upload link", http.StatusInternalServerError)
+			http.Error(w, "Invalid folder", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, service.ErrEmptyLinkFields) {
+			http.Error(w, "Enter a name for the link", http.StatusBadRequest)
 			return
 		}
-		user := ExtractUserOrRedirect(w, r)
-		err = h.linkUnlockService.UnlockLink(r.Context(), user.ID, link.ID, exp)
 		if err != nil {
-			logger.Error("could not unlock link for creator: %v", err)
+			logger.Error("could not create upload link: %v", err)
 			http.Error(w, "failed to create upload link", http.StatusInternalServerError)
 			return
 		}
@@ -3,7 +3,9 @@ package middleware
 import (
 	"context"
 	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/model"
 	"net/http"
+	"strings"
 
 	"github.com/NiClassic/go-cloud/internal/service"
 )
@@ -11,22 +13,41 @@ import (
 const (
 	cookieName   = "session_token"
 	redirectPath = "/login"
+	basicRealm   = `Basic realm="go-cloud"`
 )
 
 type ctxKey int
 
 const (
 	UserKey ctxKey = iota
+	// TokenKey holds the *model.APIToken of requests authenticated with one.
+	TokenKey
 )
 
-type SessionValidator struct{ svc *service.AuthService }
+type SessionValidator struct {
+	svc    *service.AuthService
+	tokens *service.APITokenService
+}
 
-func NewSessionValidator(svc *service.AuthService) *SessionValidator {
-	return &SessionValidator{svc: svc}
+func NewSessionValidator(svc *service.AuthService, tokens *service.APITokenService) *SessionValidator {
+	return &SessionValidator{svc: svc, tokens: tokens}
 }
 
+// WithAuth requires the session cookie or an API token sent as
+// "Authorization: Bearer". Visitors without either are sent to the login page.
 func (s *SessionValidator) WithAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if plain, ok := bearerToken(r); ok {
+			user, t, err := authenticateToken(r, s.tokens, plain)
+			if err != nil {
+				logger.Error("api token rejected: %v", err)
+				http.Error(w, err.Error(), tokenErrorStatus(err))
+				return
+			}
+			next.ServeHTTP(w, withUser(r, user, t))
+			return
+		}
+
 		cookie, err := r.Cookie(cookieName)
 		if err != nil {
 			logger.Error("could not find cookie: %v", err)
@@ -46,6 +67,27 @@ func (s *SessionValidator) WithAuth(next http.Handler) http.Handler {
 	})
 }
 
+// WithOptionalAuth stores the session user in the context if there is one,
+// but lets anonymous visitors through as well.
+func (s *SessionValidator) WithOptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(cookieName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := s.svc.GetUserBySessionToken(r.Context(), cookie.Value)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), UserKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 type GuestOnly struct{ svc *service.AuthService }
 
 func NewGuestOnly(svc *service.AuthService) *GuestOnly {
@@ -63,3 +105,98 @@ func (g *GuestOnly) WithoutAuth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// BasicAuth authenticates requests with HTTP Basic credentials. It is meant
+// for clients like WebDAV mounts that cannot log in through the web form. An
+// API token can be used as the password or sent as a bearer token instead.
+type BasicAuth struct {
+	svc    *service.AuthService
+	tokens *service.APITokenService
+}
+
+func NewBasicAuth(svc *service.AuthService, tokens *service.APITokenService) *BasicAuth {
+	return &BasicAuth{svc: svc, tokens: tokens}
+}
+
+func (b *BasicAuth) WithBasicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, t, err := b.authenticate(r)
+		if err != nil {
+			logger.Error("basic auth failed: %v", err)
+			status := tokenErrorStatus(err)
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", basicRealm)
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, withUser(r, user, t))
+	})
+}
+
+func (b *BasicAuth) authenticate(r *http.Request) (*model.User, *model.APIToken, error) {
+	if plain, ok := bearerToken(r); ok {
+		return authenticateToken(r, b.tokens, plain)
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil, service.ErrInvalidCredentials
+	}
+	if strings.HasPrefix(password, service.APITokenPrefix) {
+		user, t, err := authenticateToken(r, b.tokens, password)
+		if err != nil {
+			return nil, nil, err
+		}
+		if user.Username != username {
+			return nil, nil, service.ErrInvalidCredentials
+		}
+		return user, t, nil
+	}
+	user, err := b.svc.Authenticate(r.Context(), username, password)
+	return user, nil, err
+}
+
+// APIAuth authenticates requests to the JSON API. It accepts API tokens, HTTP
+// Basic credentials and the session cookie of the web UI and answers with a
+// JSON error instead of redirecting to the login page.
+type APIAuth struct {
+	basic *BasicAuth
+}
+
+func NewAPIAuth(svc *service.AuthService, tokens *service.APITokenService) *APIAuth {
+	return &APIAuth{basic: NewBasicAuth(svc, tokens)}
+}
+
+func (a *APIAuth) WithAPIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, t, err := a.authenticate(r)
+		if err != nil {
+			logger.Error("api authentication failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			if tokenErrorStatus(err) == http.StatusForbidden {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"error":{"code":"forbidden","message":"token scope does not allow this request"}}` + "\n"))
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":"unauthorized","message":"authentication required"}}` + "\n"))
+			return
+		}
+		next.ServeHTTP(w, withUser(r, user, t))
+	})
+}
+
+func (a *APIAuth) authenticate(r *http.Request) (*model.User, *model.APIToken, error) {
+	if _, ok := bearerToken(r); ok {
+		return a.basic.authenticate(r)
+	}
+	if _, _, ok := r.BasicAuth(); ok {
+		return a.basic.authenticate(r)
+	}
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := a.basic.svc.GetUserBySessionToken(r.Context(), cookie.Value)
+	return user, nil, err
+}
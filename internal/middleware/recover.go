@@ -11,6 +11,10 @@ func Recover(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rec := recover(); rec != nil {
+				// Handlers abort responses they cannot finish, net/http closes the connection.
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
 				logger.Error("panic: %v\n%s", rec, debug.Stack())
 				http.Error(w, "internal server error", http.StatusInternalServerError)
 			}
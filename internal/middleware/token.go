@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+)
+
+var errTokenScope = errors.New("api token scope does not allow this request")
+
+// readOnlyMethods only need the read scope, every other method needs the
+// write scope.
+var readOnlyMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, tok, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(tok), true
+}
+
+// authenticateToken resolves a plain API token to its user and checks that
+// the scopes of the token allow the request method.
+func authenticateToken(r *http.Request, tokens *service.APITokenService, plain string) (*model.User, *model.APIToken, error) {
+	user, t, err := tokens.Authenticate(r.Context(), plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	scope := service.ScopeWrite
+	if readOnlyMethods[r.Method] {
+		scope = service.ScopeRead
+	}
+	if !t.HasScope(scope) {
+		return nil, nil, errTokenScope
+	}
+	return user, t, nil
+}
+
+// tokenErrorStatus is the status code for an error of authenticateToken.
+func tokenErrorStatus(err error) int {
+	if errors.Is(err, errTokenScope) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// withUser stores the user, and the API token used to authenticate if any, in
+// the request context.
+func withUser(r *http.Request, user *model.User, t *model.APIToken) *http.Request {
+	ctx := context.WithValue(r.Context(), UserKey, user)
+	if t != nil {
+		ctx = context.WithValue(ctx, TokenKey, t)
+	}
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// APIToken is a personal access token for clients that cannot log in through
+// the web form. Only the SHA-256 hash of the token is stored.
+type APIToken struct {
+	ID         int64        `db:"id"`
+	UserID     int64        `db:"user_id"`
+	Name       string       `db:"name"`
+	TokenHash  string       `db:"token_hash"`
+	Scopes     string       `db:"scopes"`
+	CreatedAt  time.Time    `db:"created_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	ExpiresAt  sql.NullTime `db:"expires_at"`
+}
+
+// HasScope reports whether scope is in the comma separated scopes of the token.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
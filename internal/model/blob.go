@@ -0,0 +1,49 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Blob is a stored content, shared by every file, version and trashed file
+// with the same hash.
+type Blob struct {
+	Hash       string       `db:"hash"`
+	Size       int64        `db:"size"`
+	RefCount   int          `db:"ref_count"`
+	CreatedAt  time.Time    `db:"created_at"`
+	ReleasedAt sql.NullTime `db:"released_at"`
+}
+
+// BlobRefKind names the table a BlobRef comes from.
+type BlobRefKind string
+
+const (
+	BlobRefFile    BlobRefKind = "file"
+	BlobRefVersion BlobRefKind = "version"
+	BlobRefTrash   BlobRefKind = "trash"
+)
+
+// BlobRef is a row that uses a blob together with what is needed to find its
+// content in the legacy per-user layout.
+type BlobRef struct {
+	Kind     BlobRefKind
+	ID       int64
+	Username string
+	Hash     string
+	// Location is the file location, or the path of a trash entry inside its
+	// trash item TrashID.
+	Location string
+	TrashID  int64
+}
+
+// BlobKey is the data key an encrypted blob was encrypted with.
+type BlobKey struct {
+	Hash string `db:"hash"`
+	// Object is the name of the encrypted content in the underlying store.
+	Object string `db:"object"`
+	// KeyID names the master key WrappedKey is encrypted with.
+	KeyID      string    `db:"key_id"`
+	WrappedKey []byte    `db:"wrapped_key"`
+	CreatedAt  time.Time `db:"created_at"`
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DownloadLink gives everyone who knows its token read access to a folder,
+// with everything below it, or to a single file, without an account.
+type DownloadLink struct {
+	ID       int64         `db:"id"`
+	UserID   int64         `db:"user_id"`
+	FolderID sql.NullInt64 `db:"folder_id"`
+	FileID   sql.NullInt64 `db:"file_id"`
+	Name     string        `db:"name"`
+	// HashedPassword is the bcrypt hash of the password, empty for links
+	// without one.
+	HashedPassword string       `db:"password"`
+	LinkToken      string       `db:"link_token"`
+	ExpiresAt      sql.NullTime `db:"expires_at"`
+	// MaxDownloads is how often files or archives may be downloaded through
+	// the link, 0 for unlimited.
+	MaxDownloads int64     `db:"max_downloads"`
+	Downloads    int64     `db:"downloads"`
+	CreatedAt    time.Time `db:"created_at"`
+	// ItemName is the name of the folder or file.
+	ItemName string
+}
+
+func (l *DownloadLink) HasPassword() bool {
+	return l.HashedPassword != ""
+}
+
+func (l *DownloadLink) Expired(now time.Time) bool {
+	return l.ExpiresAt.Valid && now.After(l.ExpiresAt.Time)
+}
+
+// Exhausted reports whether no downloads are left.
+func (l *DownloadLink) Exhausted() bool {
+	return l.MaxDownloads > 0 && l.Downloads >= l.MaxDownloads
+}
@@ -15,4 +15,29 @@ type File struct {
 	Location  string        `db:"location"`
 	Hash      string        `db:"hash"`
 	FolderID  sql.NullInt64 `db:"folder_id"`
+	// Version counts the uploads to this name, older versions are FileVersions.
+	Version   int          `db:"version"`
+	UpdatedAt sql.NullTime `db:"updated_at"`
+}
+
+// ContentCreatedAt returns when the current content was uploaded.
+func (f *File) ContentCreatedAt() time.Time {
+	if f.UpdatedAt.Valid {
+		return f.UpdatedAt.Time
+	}
+	return f.CreatedAt
+}
+
+// FileVersion is an earlier content of a file, kept when it was replaced by
+// a newer upload.
+type FileVersion struct {
+	ID         int64     `db:"id"`
+	FileID     int64     `db:"file_id"`
+	UserID     int64     `db:"user_id"`
+	Version    int       `db:"version"`
+	Size       int64     `db:"size"`
+	MimeType   string    `db:"mime_type"`
+	Hash       string    `db:"hash"`
+	CreatedAt  time.Time `db:"created_at"`
+	ArchivedAt time.Time `db:"archived_at"`
 }
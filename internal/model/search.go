@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+// SearchQuery is a full-text search over the files of a user. Fields left at
+// their zero value do not filter.
+type SearchQuery struct {
+	// Text holds the words to look for in names, folder paths and contents.
+	Text string
+	// FolderID restricts the search to a folder and its subfolders.
+	FolderID int64
+	// MimeType matches the start of the detected type, e.g. "image/" or
+	// "application/pdf".
+	MimeType string
+	MinSize  int64
+	MaxSize  int64
+	// After and Before bound when the current content was uploaded.
+	After  time.Time
+	Before time.Time
+	Limit  int
+	Offset int
+}
+
+// Snippets of a SearchResult enclose the words that matched in these markers.
+const (
+	MatchStart = "\x02"
+	MatchEnd   = "\x03"
+)
+
+// SearchResult is a file found by a search, best matches first.
+type SearchResult struct {
+	File File
+	// Snippet is an excerpt of the content around the matches, empty for
+	// files without text.
+	Snippet string
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Permission is what a user may do with a folder or file. Every permission
+// includes the ones below it, owners have PermissionManage on their items.
+type Permission int
+
+const (
+	PermissionNone Permission = iota
+	// PermissionRead allows listing, previewing and downloading.
+	PermissionRead
+	// PermissionWrite additionally allows uploading, renaming, moving and
+	// deleting.
+	PermissionWrite
+	// PermissionManage additionally allows sharing with others.
+	PermissionManage
+)
+
+var permissionNames = []string{"none", "read", "write", "manage"}
+
+func (p Permission) String() string {
+	if p < PermissionNone || int(p) >= len(permissionNames) {
+		return "none"
+	}
+	return permissionNames[p]
+}
+
+// ParsePermission returns the permission called s, PermissionNone for
+// unknown names.
+func ParsePermission(s string) Permission {
+	for i, name := range permissionNames {
+		if name == s {
+			return Permission(i)
+		}
+	}
+	return PermissionNone
+}
+
+// Share grants a user or the members of a group a permission on a folder,
+// with everything below it, or on a single file.
+type Share struct {
+	ID         int64         `db:"id"`
+	FolderID   sql.NullInt64 `db:"folder_id"`
+	FileID     sql.NullInt64 `db:"file_id"`
+	UserID     sql.NullInt64 `db:"user_id"`
+	GroupID    sql.NullInt64 `db:"group_id"`
+	Permission Permission    `db:"permission"`
+	CreatedBy  int64         `db:"created_by"`
+	CreatedAt  time.Time     `db:"created_at"`
+	// TargetName is the username or the group name the item is shared with.
+	TargetName string
+}
+
+// SharedItem is a folder or file of another user that was shared with a
+// user, directly or through a group. Permission is the highest one granted.
+type SharedItem struct {
+	FolderID   sql.NullInt64
+	FileID     sql.NullInt64
+	Name       string
+	Owner      string
+	Permission Permission
+	SharedAt   time.Time
+	// ShareID is the share with the user directly, which they may leave.
+	// It is not set for items shared only through groups.
+	ShareID sql.NullInt64
+}
+
+// Group is a named set of users that items can be shared with at once.
+type Group struct {
+	ID        int64     `db:"id"`
+	OwnerID   int64     `db:"owner_id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+	Members   []*User
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Thumbnail is a downscaled JPEG of an image content, Size pixels along its
+// longer edge.
+type Thumbnail struct {
+	Hash string `db:"hash"`
+	Size int    `db:"size"`
+	// ThumbHash is the blob holding the JPEG, NULL if the content could not
+	// be decoded.
+	ThumbHash sql.NullString `db:"thumb_hash"`
+	ThumbSize int64          `db:"thumb_size"`
+	CreatedAt time.Time      `db:"created_at"`
+}
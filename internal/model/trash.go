@@ -0,0 +1,36 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TrashItem is a deleted file or folder waiting in the trash of its owner
+// until it is restored or purged.
+type TrashItem struct {
+	ID               int64         `db:"id"`
+	UserID           int64         `db:"user_id"`
+	Name             string        `db:"name"`
+	IsFolder         bool          `db:"is_folder"`
+	OriginalPath     string        `db:"original_path"`
+	OriginalFolderID sql.NullInt64 `db:"original_folder_id"`
+	Size             int64         `db:"size"`
+	FileCount        int           `db:"file_count"`
+	DeletedAt        time.Time     `db:"deleted_at"`
+}
+
+// TrashEntry is a folder or file inside a trash item. Path is relative to the
+// item, the item itself has the empty path.
+type TrashEntry struct {
+	ID        int64     `db:"id"`
+	TrashID   int64     `db:"trash_id"`
+	IsFolder  bool      `db:"is_folder"`
+	Path      string    `db:"path"`
+	Size      int64     `db:"size"`
+	MimeType  string    `db:"mime_type"`
+	Hash      string    `db:"hash"`
+	CreatedAt time.Time `db:"created_at"`
+	// FileID and Version identify the history of a trashed file.
+	FileID  sql.NullInt64 `db:"file_id"`
+	Version int           `db:"version"`
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// TusUpload is a resumable upload that has been created through the tus
+// protocol but has not received all of its bytes yet.
+type TusUpload struct {
+	ID          int64     `db:"id"`
+	UserID      int64     `db:"user_id"`
+	FolderID    int64     `db:"folder_id"`
+	UploadToken string    `db:"upload_token"`
+	Filename    string    `db:"filename"`
+	Length      int64     `db:"upload_length"`
+	Offset      int64     `db:"upload_offset"`
+	CreatedAt   time.Time `db:"created_at"`
+}
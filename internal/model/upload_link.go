@@ -1,12 +1,28 @@
 package model
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
 
 type UploadLink struct {
-	ID             int64     `db:"id"`
-	Name           string    `db:"name"`
-	HashedPassword string    `db:"password"`
-	CreatedAt      time.Time `db:"created_at"`
-	ExpiresAt      time.Time `db:"expires_at"`
-	LinkToken      string    `db:"link_token"`
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+	// HashedPassword is the bcrypt hash of the password, empty for links
+	// without one.
+	HashedPassword string        `db:"password"`
+	CreatedAt      time.Time     `db:"created_at"`
+	ExpiresAt      time.Time     `db:"expires_at"`
+	LinkToken      string        `db:"link_token"`
+	UserID         sql.NullInt64 `db:"user_id"`
+	FolderID       sql.NullInt64 `db:"folder_id"`
+}
+
+func (l *UploadLink) HasPassword() bool {
+	return l.HashedPassword != ""
+}
+
+// Expired reports whether the link no longer accepts uploads at now.
+func (l *UploadLink) Expired(now time.Time) bool {
+	return now.After(l.ExpiresAt)
 }
@@ -5,3 +5,26 @@ type User struct {
 	Username       string `db:"username"`
 	HashedPassword string `db:"password"`
 }
+
+// Usage is how much space a user's files take up, see QuotaService.
+type Usage struct {
+	Used int64
+	// Quota is the limit in bytes, 0 means unlimited.
+	Quota int64
+}
+
+// Available returns how many bytes may still be stored, or -1 if unlimited.
+func (u *Usage) Available() int64 {
+	if u.Quota == 0 {
+		return -1
+	}
+	return max(u.Quota-u.Used, 0)
+}
+
+// Percent returns the share of the quota in use, 0 if unlimited.
+func (u *Usage) Percent() int {
+	if u.Quota == 0 {
+		return 0
+	}
+	return int(min(u.Used*100/u.Quota, 100))
+}
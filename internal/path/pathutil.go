@@ -2,7 +2,6 @@ package path
 
 import (
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -24,7 +23,10 @@ func New(dataRoot string) *Converter {
 // Example: "bob/documents/file.pdf" -> "documents/file.pdf"
 func (c *Converter) ToDBPath(username, inputPath string) string {
 	cleaned := strings.TrimPrefix(inputPath, "/")
-	cleaned = strings.TrimPrefix(cleaned, username)
+	// Only strip the username as a whole segment, "bobby/x" must stay intact for bob.
+	if cleaned == username || strings.HasPrefix(cleaned, username+"/") {
+		cleaned = strings.TrimPrefix(cleaned, username)
+	}
 	cleaned = strings.TrimPrefix(cleaned, "/")
 
 	if cleaned == "." || cleaned == "/" {
@@ -209,19 +211,6 @@ func (c *Converter) GetBreadcrumbs(dbPath string) []Breadcrumb {
 	return breadcrumbs
 }
 
-// EnsureDir ensures a directory exists on the filesystem.
-func (c *Converter) EnsureDir(username, dbPath string) error {
-	fullPath := c.FromDBPath(username, dbPath)
-	return os.MkdirAll(fullPath, 0755)
-}
-
-// GetFullFilePath returns the complete filesystem path for a file.
-//
-// Example: ("bob", "documents/invoice.pdf") -> "/data/bob/documents/invoice.pdf"
-func (c *Converter) GetFullFilePath(username, dbPath string) string {
-	return c.FromDBPath(username, dbPath)
-}
-
 // MigrateOldPath converts old path format to new DB format.
 // This is useful during migration from the old system.
 //
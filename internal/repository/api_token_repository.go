@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+type APITokenRepository struct{ baseRepo }
+
+func NewAPITokenRepository(db *sql.DB) *APITokenRepository {
+	return &APITokenRepository{newBaseRepo(db)}
+}
+
+func (r *APITokenRepository) Insert(ctx context.Context, userID int64, name, tokenHash, scopes string, expiresAt sql.NullTime) (int64, error) {
+	const q = `INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, q, userID, name, tokenHash, scopes, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *APITokenRepository) GetByID(ctx context.Context, id int64) (*model.APIToken, error) {
+	const q = `SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at
+		     FROM api_tokens WHERE id = ?`
+	var t model.APIToken
+	if err := r.db.QueryRowContext(ctx, q, id).Scan(
+		&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.Scopes, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *APITokenRepository) GetByHash(ctx context.Context, tokenHash string) (*model.APIToken, error) {
+	const q = `SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at
+		     FROM api_tokens WHERE token_hash = ?`
+	var t model.APIToken
+	if err := r.db.QueryRowContext(ctx, q, tokenHash).Scan(
+		&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.Scopes, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *APITokenRepository) GetByUser(ctx context.Context, userID int64) ([]*model.APIToken, error) {
+	const q = `SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at
+		     FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC`
+
+	rows, err := r.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var tokens []*model.APIToken
+	for rows.Next() {
+		var t model.APIToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.Scopes, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &t)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *APITokenRepository) UpdateLastUsed(ctx context.Context, id int64, usedAt time.Time) error {
+	const q = `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, usedAt, id)
+	return err
+}
+
+func (r *APITokenRepository) Delete(ctx context.Context, id int64) error {
+	const q = `DELETE FROM api_tokens WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, id)
+	return err
+}
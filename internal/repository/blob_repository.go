@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+// BlobRepository reads the reference counts of stored contents and keeps the
+// data keys of encrypted ones. The counts are maintained by triggers on files,
+// file_versions and trash_entries.
+type BlobRepository struct{ baseRepo }
+
+func NewBlobRepository(db *sql.DB) *BlobRepository {
+	return &BlobRepository{newBaseRepo(db)}
+}
+
+func (r *BlobRepository) GetByHash(ctx context.Context, hash string) (*model.Blob, error) {
+	const q = `SELECT hash, size, ref_count, created_at, released_at FROM blobs WHERE hash = ?`
+	var b model.Blob
+	if err := r.db.QueryRowContext(ctx, q, hash).Scan(&b.Hash, &b.Size, &b.RefCount, &b.CreatedAt, &b.ReleasedAt); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetReleasedBefore returns the hashes of blobs nothing has used since t.
+func (r *BlobRepository) GetReleasedBefore(ctx context.Context, t time.Time) ([]string, error) {
+	// released_at is filled by CURRENT_TIMESTAMP, compare in the same format.
+	const q = `SELECT hash FROM blobs WHERE ref_count <= 0 AND released_at < ?`
+	rows, err := r.db.QueryContext(ctx, q, t.UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+// Claim records the blob of a content that was just saved and restarts the
+// grace period of an unused one, so it is not deleted before the row that is
+// going to use it is inserted. Contents nothing ends up using are released
+// from the time they were claimed.
+func (r *BlobRepository) Claim(ctx context.Context, hash string, size int64) error {
+	const q = `INSERT INTO blobs (hash, size, released_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		     ON CONFLICT (hash) DO UPDATE SET released_at = CURRENT_TIMESTAMP WHERE ref_count <= 0`
+	_, err := r.db.ExecContext(ctx, q, hash, size)
+	return err
+}
+
+// DeleteReleased deletes the blob row of hash if it is still unused since t
+// and reports whether it did, so its content may be deleted from storage.
+func (r *BlobRepository) DeleteReleased(ctx context.Context, hash string, t time.Time) (bool, error) {
+	const q = `DELETE FROM blobs WHERE hash = ? AND ref_count <= 0 AND released_at < ?`
+	res, err := r.db.ExecContext(ctx, q, hash, t.UTC().Format(time.DateTime))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetRefs returns every row of all users that uses a blob.
+func (r *BlobRepository) GetRefs(ctx context.Context) ([]*model.BlobRef, error) {
+	const q = `SELECT 'file', f.id, u.username, f.hash, f.location, 0
+		     FROM files f JOIN users u ON u.id = f.user_id
+		   UNION ALL
+		   SELECT 'version', v.id, u.username, v.hash, '', 0
+		     FROM file_versions v JOIN users u ON u.id = v.user_id
+		   UNION ALL
+		   SELECT 'trash', e.id, u.username, e.hash, e.path, e.trash_id
+		     FROM trash_entries e JOIN trash_items t ON t.id = e.trash_id JOIN users u ON u.id = t.user_id
+		    WHERE e.is_folder = 0`
+	rows, err := r.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var refs []*model.BlobRef
+	for rows.Next() {
+		var ref model.BlobRef
+		if err := rows.Scan(&ref.Kind, &ref.ID, &ref.Username, &ref.Hash, &ref.Location, &ref.TrashID); err != nil {
+			return nil, err
+		}
+		refs = append(refs, &ref)
+	}
+	return refs, rows.Err()
+}
+
+// UpdateRef points a row at another blob, e.g. because its recorded hash did
+// not match the stored content.
+func (r *BlobRepository) UpdateRef(ctx context.Context, ref *model.BlobRef, hash string, size int64) error {
+	var q string
+	switch ref.Kind {
+	case model.BlobRefFile:
+		q = `UPDATE files SET hash = ?, size = ? WHERE id = ?`
+	case model.BlobRefVersion:
+		q = `UPDATE file_versions SET hash = ?, size = ? WHERE id = ?`
+	case model.BlobRefTrash:
+		q = `UPDATE trash_entries SET hash = ?, size = ? WHERE id = ?`
+	default:
+		return fmt.Errorf("unknown blob reference kind %q", ref.Kind)
+	}
+	_, err := r.db.ExecContext(ctx, q, hash, size, ref.ID)
+	return err
+}
+
+// GetKey returns the data key of the encrypted blob hash, or nil if the blob
+// is not encrypted.
+func (r *BlobRepository) GetKey(ctx context.Context, hash string) (*model.BlobKey, error) {
+	const q = `SELECT hash, object, key_id, wrapped_key, created_at FROM blob_keys WHERE hash = ?`
+	var k model.BlobKey
+	err := r.db.QueryRowContext(ctx, q, hash).Scan(&k.Hash, &k.Object, &k.KeyID, &k.WrappedKey, &k.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// AddKey records the data key of an encrypted blob unless the blob already
+// has one and reports whether it did.
+func (r *BlobRepository) AddKey(ctx context.Context, k *model.BlobKey) (bool, error) {
+	const q = `INSERT OR IGNORE INTO blob_keys (hash, object, key_id, wrapped_key) VALUES (?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, q, k.Hash, k.Object, k.KeyID, k.WrappedKey)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UpdateKey stores a data key wrapped again with another master key.
+func (r *BlobRepository) UpdateKey(ctx context.Context, k *model.BlobKey) error {
+	const q = `UPDATE blob_keys SET key_id = ?, wrapped_key = ? WHERE hash = ?`
+	_, err := r.db.ExecContext(ctx, q, k.KeyID, k.WrappedKey, k.Hash)
+	return err
+}
+
+func (r *BlobRepository) DeleteKey(ctx context.Context, hash string) error {
+	const q = `DELETE FROM blob_keys WHERE hash = ?`
+	_, err := r.db.ExecContext(ctx, q, hash)
+	return err
+}
+
+// GetKeysNotWrappedBy returns the data keys wrapped by any master key other
+// than keyID.
+func (r *BlobRepository) GetKeysNotWrappedBy(ctx context.Context, keyID string) ([]*model.BlobKey, error) {
+	const q = `SELECT hash, object, key_id, wrapped_key, created_at FROM blob_keys WHERE key_id != ?`
+	rows, err := r.db.QueryContext(ctx, q, keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var keys []*model.BlobKey
+	for rows.Next() {
+		var k model.BlobKey
+		if err := rows.Scan(&k.Hash, &k.Object, &k.KeyID, &k.WrappedKey, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+	return keys, rows.Err()
+}
+
+// HasKeys reports whether any blob is stored encrypted.
+func (r *BlobRepository) HasKeys(ctx context.Context) (bool, error) {
+	const q = `SELECT EXISTS (SELECT 1 FROM blob_keys)`
+	var ok bool
+	err := r.db.QueryRowContext(ctx, q).Scan(&ok)
+	return ok, err
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+type DownloadLinkRepository struct{ baseRepo }
+
+func NewDownloadLinkRepository(db *sql.DB) *DownloadLinkRepository {
+	return &DownloadLinkRepository{newBaseRepo(db)}
+}
+
+const downloadLinkColumns = `l.id, l.user_id, l.folder_id, l.file_id, l.name, l.password, l.link_token, l.expires_at,
+	l.max_downloads, l.downloads, l.created_at, COALESCE(fo.name, fi.name, '')`
+
+const downloadLinkJoins = `FROM download_links l LEFT JOIN folders fo ON fo.id = l.folder_id LEFT JOIN files fi ON fi.id = l.file_id`
+
+func scanDownloadLink(row interface{ Scan(...any) error }) (*model.DownloadLink, error) {
+	var l model.DownloadLink
+	if err := row.Scan(
+		&l.ID, &l.UserID, &l.FolderID, &l.FileID, &l.Name, &l.HashedPassword, &l.LinkToken, &l.ExpiresAt,
+		&l.MaxDownloads, &l.Downloads, &l.CreatedAt, &l.ItemName,
+	); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (r *DownloadLinkRepository) Insert(ctx context.Context, l *model.DownloadLink) (int64, error) {
+	const q = `INSERT INTO download_links (user_id, folder_id, file_id, name, password, link_token, expires_at, max_downloads)
+		     VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, q, l.UserID, l.FolderID, l.FileID, l.Name, l.HashedPassword, l.LinkToken, l.ExpiresAt, l.MaxDownloads)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *DownloadLinkRepository) GetByToken(ctx context.Context, linkToken string) (*model.DownloadLink, error) {
+	const q = `SELECT ` + downloadLinkColumns + ` ` + downloadLinkJoins + ` WHERE l.link_token = ?`
+	return scanDownloadLink(r.db.QueryRowContext(ctx, q, linkToken))
+}
+
+// GetByUser returns the links created by userID, newest first.
+func (r *DownloadLinkRepository) GetByUser(ctx context.Context, userID int64) ([]*model.DownloadLink, error) {
+	const q = `SELECT ` + downloadLinkColumns + ` ` + downloadLinkJoins + ` WHERE l.user_id = ? ORDER BY l.created_at DESC, l.id DESC`
+	return r.query(ctx, q, userID)
+}
+
+// GetByItem returns the links of a folder or file, newest first.
+func (r *DownloadLinkRepository) GetByItem(ctx context.Context, folderID, fileID sql.NullInt64) ([]*model.DownloadLink, error) {
+	const q = `SELECT ` + downloadLinkColumns + ` ` + downloadLinkJoins + `
+		     WHERE IFNULL(l.folder_id, 0) = IFNULL(?, 0) AND IFNULL(l.file_id, 0) = IFNULL(?, 0)
+		     ORDER BY l.created_at DESC, l.id DESC`
+	return r.query(ctx, q, folderID, fileID)
+}
+
+func (r *DownloadLinkRepository) query(ctx context.Context, q string, args ...any) ([]*model.DownloadLink, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var links []*model.DownloadLink
+	for rows.Next() {
+		l, err := scanDownloadLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// CountDownload adds one to the downloads of the link unless its limit is
+// reached, which is reported as false. Checking and counting in one statement
+// keeps concurrent downloads from going past the limit.
+func (r *DownloadLinkRepository) CountDownload(ctx context.Context, id int64) (bool, error) {
+	const q = `UPDATE download_links SET downloads = downloads + 1
+		     WHERE id = ? AND (max_downloads = 0 OR downloads < max_downloads)`
+	res, err := r.db.ExecContext(ctx, q, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *DownloadLinkRepository) Delete(ctx context.Context, id int64) error {
+	const q = `DELETE FROM download_links WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, id)
+	return err
+}
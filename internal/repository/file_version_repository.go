@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+type FileVersionRepository struct{ baseRepo }
+
+func NewFileVersionRepository(db *sql.DB) *FileVersionRepository {
+	return &FileVersionRepository{newBaseRepo(db)}
+}
+
+const fileVersionColumns = `id, file_id, user_id, version, size, mime_type, hash, created_at, archived_at`
+
+func (r *FileVersionRepository) Insert(ctx context.Context, v *model.FileVersion) (int64, error) {
+	const q = `INSERT INTO file_versions (file_id, user_id, version, size, mime_type, hash, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, q, v.FileID, v.UserID, v.Version, v.Size, v.MimeType, v.Hash, v.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *FileVersionRepository) GetByFileAndVersion(ctx context.Context, fileID int64, version int) (*model.FileVersion, error) {
+	const q = `SELECT ` + fileVersionColumns + ` FROM file_versions WHERE file_id = ? AND version = ?`
+	var v model.FileVersion
+	if err := r.db.QueryRowContext(ctx, q, fileID, version).Scan(
+		&v.ID, &v.FileID, &v.UserID, &v.Version, &v.Size, &v.MimeType, &v.Hash, &v.CreatedAt, &v.ArchivedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// GetByFile returns the older versions of a file, newest first.
+func (r *FileVersionRepository) GetByFile(ctx context.Context, fileID int64) ([]*model.FileVersion, error) {
+	const q = `SELECT ` + fileVersionColumns + ` FROM file_versions WHERE file_id = ? ORDER BY version DESC`
+	return r.query(ctx, q, fileID)
+}
+
+// GetArchivedBefore returns the versions of all users replaced before t.
+func (r *FileVersionRepository) GetArchivedBefore(ctx context.Context, t time.Time) ([]*model.FileVersion, error) {
+	// archived_at is filled by CURRENT_TIMESTAMP, compare in the same format.
+	const q = `SELECT ` + fileVersionColumns + ` FROM file_versions WHERE archived_at < ?`
+	return r.query(ctx, q, t.UTC().Format(time.DateTime))
+}
+
+// GetOrphaned returns versions whose file neither exists nor waits in the
+// trash, e.g. because it was deleted for good.
+func (r *FileVersionRepository) GetOrphaned(ctx context.Context) ([]*model.FileVersion, error) {
+	const q = `SELECT ` + fileVersionColumns + ` FROM file_versions
+		     WHERE file_id NOT IN (SELECT id FROM files)
+		       AND file_id NOT IN (SELECT file_id FROM trash_entries WHERE file_id IS NOT NULL)`
+	return r.query(ctx, q)
+}
+
+func (r *FileVersionRepository) query(ctx context.Context, q string, args ...any) ([]*model.FileVersion, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var versions []*model.FileVersion
+	for rows.Next() {
+		var v model.FileVersion
+		if err := rows.Scan(&v.ID, &v.FileID, &v.UserID, &v.Version, &v.Size, &v.MimeType, &v.Hash, &v.CreatedAt, &v.ArchivedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, &v)
+	}
+	return versions, rows.Err()
+}
+
+func (r *FileVersionRepository) Delete(ctx context.Context, id int64) error {
+	const q = `DELETE FROM file_versions WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, id)
+	return err
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"unicode/utf8"
 
 	"github.com/NiClassic/go-cloud/internal/model"
 )
@@ -106,6 +107,27 @@ func (r *FolderRepository) GetByUserAndParent(ctx context.Context, userID int64,
 	return folders, rows.Err()
 }
 
+func (r *FolderRepository) GetAllByUser(ctx context.Context, userID int64) ([]*model.Folder, error) {
+	const q = `SELECT id, user_id, parent_id, name, path, created_at, updated_at
+		     FROM folders WHERE user_id = ? ORDER BY path`
+
+	rows, err := r.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var folders []*model.Folder
+	for rows.Next() {
+		var f model.Folder
+		if err := rows.Scan(&f.ID, &f.UserID, &f.ParentID, &f.Name, &f.Path, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		folders = append(folders, &f)
+	}
+	return folders, rows.Err()
+}
+
 func (r *FolderRepository) Delete(ctx context.Context, id int64) error {
 	const q = `DELETE FROM folders WHERE id = ?`
 	_, err := r.db.ExecContext(ctx, q, id)
@@ -123,3 +145,68 @@ func (r *FolderRepository) UpdateParent(ctx context.Context, folderID int64, new
 	_, err := r.db.ExecContext(ctx, q, newParentID, folderID)
 	return err
 }
+
+// DeleteSubtree deletes the given files and folders in one transaction.
+// Folders are deleted in reverse order, so they have to be passed parents
+// first.
+func (r *FolderRepository) DeleteSubtree(ctx context.Context, folderIDs, fileIDs []int64) (err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	const deleteFile = `DELETE FROM files WHERE id = ?`
+	for _, id := range fileIDs {
+		if _, err = tx.ExecContext(ctx, deleteFile, id); err != nil {
+			return err
+		}
+	}
+	const deleteFolder = `DELETE FROM folders WHERE id = ?`
+	for i := len(folderIDs) - 1; i >= 0; i-- {
+		if _, err = tx.ExecContext(ctx, deleteFolder, folderIDs[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MoveSubtree moves a folder below newParentID under newName and rewrites the
+// path of every descendant folder and the location of every file below it in
+// one transaction.
+func (r *FolderRepository) MoveSubtree(ctx context.Context, userID, folderID, newParentID int64, newName, oldPath, newPath string) (err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	const moveFolder = `UPDATE folders SET parent_id = ?, name = ?, path = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err = tx.ExecContext(ctx, moveFolder, newParentID, newName, newPath, folderID); err != nil {
+		return err
+	}
+
+	// Descendants start with the old path plus a slash. substr is used instead
+	// of LIKE so folder names containing % or _ need no escaping, it counts
+	// characters rather than bytes.
+	prefix := oldPath + "/"
+	prefixLen := utf8.RuneCountInString(prefix)
+	const moveFolders = `UPDATE folders SET path = ? || substr(path, ?), updated_at = CURRENT_TIMESTAMP
+		     WHERE user_id = ? AND substr(path, 1, ?) = ?`
+	if _, err = tx.ExecContext(ctx, moveFolders, newPath+"/", prefixLen+1, userID, prefixLen, prefix); err != nil {
+		return err
+	}
+	const moveFiles = `UPDATE files SET location = ? || substr(location, ?) WHERE user_id = ? AND substr(location, 1, ?) = ?`
+	if _, err = tx.ExecContext(ctx, moveFiles, newPath+"/", prefixLen+1, userID, prefixLen, prefix); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
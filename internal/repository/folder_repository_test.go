@@ -557,6 +557,86 @@ func TestFolderRepository_UpdateParent(t *testing.T) {
 	}
 }
 
+func TestFolderRepository_DeleteSubtree(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	folderRepo := repository.NewFolderRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	ctx := testutil.TestContext(t)
+
+	userID, _ := userRepo.Insert(ctx, "bob", "hashedpass")
+	rootID, _ := folderRepo.Insert(ctx, userID, nil, "/", "")
+	docsID, _ := folderRepo.Insert(ctx, userID, &rootID, "docs", "docs")
+	subID, _ := folderRepo.Insert(ctx, userID, &docsID, "sub", "docs/sub")
+	otherID, _ := folderRepo.Insert(ctx, userID, &rootID, "docs2", "docs2")
+	fileID, _ := fileRepo.Insert(ctx, "a.txt", "text/plain", "docs/sub/a.txt", "hash", userID, 1, subID)
+	otherFileID, _ := fileRepo.Insert(ctx, "b.txt", "text/plain", "docs2/b.txt", "hash", userID, 1, otherID)
+
+	if err := folderRepo.DeleteSubtree(ctx, []int64{docsID, subID}, []int64{fileID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, id := range []int64{docsID, subID} {
+		if _, err := folderRepo.GetByID(ctx, id); err == nil {
+			t.Errorf("expected folder %d to be deleted", id)
+		}
+	}
+	if _, err := fileRepo.GetById(ctx, fileID); err == nil {
+		t.Error("expected file to be deleted")
+	}
+	if _, err := folderRepo.GetByID(ctx, otherID); err != nil {
+		t.Errorf("expected sibling folder to be kept, got %v", err)
+	}
+	if _, err := fileRepo.GetById(ctx, otherFileID); err != nil {
+		t.Errorf("expected sibling file to be kept, got %v", err)
+	}
+}
+
+func TestFolderRepository_MoveSubtree(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	folderRepo := repository.NewFolderRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	ctx := testutil.TestContext(t)
+
+	userID, _ := userRepo.Insert(ctx, "bob", "hashedpass")
+	rootID, _ := folderRepo.Insert(ctx, userID, nil, "/", "")
+	docsID, _ := folderRepo.Insert(ctx, userID, &rootID, "docs", "docs")
+	subID, _ := folderRepo.Insert(ctx, userID, &docsID, "sub", "docs/sub")
+	// Shares the "docs" prefix without being a descendant.
+	otherID, _ := folderRepo.Insert(ctx, userID, &rootID, "docs2", "docs2")
+	workID, _ := folderRepo.Insert(ctx, userID, &rootID, "work", "work")
+	fileID, _ := fileRepo.Insert(ctx, "a.txt", "text/plain", "docs/sub/a.txt", "hash", userID, 1, subID)
+	otherFileID, _ := fileRepo.Insert(ctx, "b.txt", "text/plain", "docs2/b.txt", "hash", userID, 1, otherID)
+
+	t.Run("rewrites descendants", func(t *testing.T) {
+		err := folderRepo.MoveSubtree(ctx, userID, docsID, workID, "papers", "docs", "work/papers")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		docs, _ := folderRepo.GetByID(ctx, docsID)
+		if docs.Path != "work/papers" || docs.Name != "papers" || docs.ParentID.Int64 != workID {
+			t.Errorf("unexpected moved folder: %+v", docs)
+		}
+		sub, _ := folderRepo.GetByID(ctx, subID)
+		if sub.Path != "work/papers/sub" {
+			t.Errorf("expected descendant path work/papers/sub, got %q", sub.Path)
+		}
+		other, _ := folderRepo.GetByID(ctx, otherID)
+		if other.Path != "docs2" {
+			t.Errorf("expected sibling path to be unchanged, got %q", other.Path)
+		}
+		file, _ := fileRepo.GetById(ctx, fileID)
+		if file.Location != "work/papers/sub/a.txt" {
+			t.Errorf("expected file location work/papers/sub/a.txt, got %q", file.Location)
+		}
+		otherFile, _ := fileRepo.GetById(ctx, otherFileID)
+		if otherFile.Location != "docs2/b.txt" {
+			t.Errorf("expected sibling file location to be unchanged, got %q", otherFile.Location)
+		}
+	})
+}
+
 //func TestFolderRepository_CascadeDelete(t *testing.T) {
 //	folderRepo, userRepo := setupFolderRepositoryTest(t)
 //	ctx := testutil.TestContext(t)
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+type GroupRepository struct{ baseRepo }
+
+func NewGroupRepository(db *sql.DB) *GroupRepository {
+	return &GroupRepository{newBaseRepo(db)}
+}
+
+func (r *GroupRepository) Insert(ctx context.Context, ownerID int64, name string) (int64, error) {
+	const q = `INSERT INTO groups (owner_id, name) VALUES (?, ?)`
+	res, err := r.db.ExecContext(ctx, q, ownerID, name)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *GroupRepository) GetByID(ctx context.Context, id int64) (*model.Group, error) {
+	const q = `SELECT id, owner_id, name, created_at FROM groups WHERE id = ?`
+	var g model.Group
+	if err := r.db.QueryRowContext(ctx, q, id).Scan(&g.ID, &g.OwnerID, &g.Name, &g.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (r *GroupRepository) GetByOwnerAndName(ctx context.Context, ownerID int64, name string) (*model.Group, error) {
+	const q = `SELECT id, owner_id, name, created_at FROM groups WHERE owner_id = ? AND name = ?`
+	var g model.Group
+	if err := r.db.QueryRowContext(ctx, q, ownerID, name).Scan(&g.ID, &g.OwnerID, &g.Name, &g.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (r *GroupRepository) GetByOwner(ctx context.Context, ownerID int64) ([]*model.Group, error) {
+	const q = `SELECT id, owner_id, name, created_at FROM groups WHERE owner_id = ? ORDER BY name`
+
+	rows, err := r.db.QueryContext(ctx, q, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var groups []*model.Group
+	for rows.Next() {
+		var g model.Group
+		if err := rows.Scan(&g.ID, &g.OwnerID, &g.Name, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, &g)
+	}
+	return groups, rows.Err()
+}
+
+// GetMembers returns the members of a group ordered by username.
+func (r *GroupRepository) GetMembers(ctx context.Context, groupID int64) ([]*model.User, error) {
+	const q = `SELECT u.id, u.username FROM group_members m JOIN users u ON u.id = m.user_id
+		     WHERE m.group_id = ? ORDER BY u.username`
+
+	rows, err := r.db.QueryContext(ctx, q, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var users []*model.User
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+	return users, rows.Err()
+}
+
+func (r *GroupRepository) AddMember(ctx context.Context, groupID, userID int64) error {
+	const q = `INSERT OR IGNORE INTO group_members (group_id, user_id) VALUES (?, ?)`
+	_, err := r.db.ExecContext(ctx, q, groupID, userID)
+	return err
+}
+
+func (r *GroupRepository) RemoveMember(ctx context.Context, groupID, userID int64) error {
+	const q = `DELETE FROM group_members WHERE group_id = ? AND user_id = ?`
+	_, err := r.db.ExecContext(ctx, q, groupID, userID)
+	return err
+}
+
+func (r *GroupRepository) Delete(ctx context.Context, id int64) error {
+	const q = `DELETE FROM groups WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, id)
+	return err
+}
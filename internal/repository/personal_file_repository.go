@@ -22,7 +22,7 @@ func (p *PersonalFileRepository) Insert(ctx context.Context, name, mimeType, loc
 }
 
 func (p *PersonalFileRepository) GetByUser(ctx context.Context, id int64) ([]*model.File, error) {
-	const q = `SELECT id, user_id, name, size, mime_type, created_at, location, hash, folder_id FROM files WHERE user_id = ?`
+	const q = `SELECT id, user_id, name, size, mime_type, created_at, location, hash, folder_id, version, updated_at FROM files WHERE user_id = ?`
 	rows, err := p.db.QueryContext(ctx, q, id)
 	if err != nil {
 		return nil, err
@@ -33,7 +33,7 @@ func (p *PersonalFileRepository) GetByUser(ctx context.Context, id int64) ([]*mo
 	for rows.Next() {
 		var f model.File
 		if err := rows.Scan(
-			&f.ID, &f.UserID, &f.Name, &f.Size, &f.MimeType, &f.CreatedAt, &f.Location, &f.Hash, &f.FolderID); err != nil {
+			&f.ID, &f.UserID, &f.Name, &f.Size, &f.MimeType, &f.CreatedAt, &f.Location, &f.Hash, &f.FolderID, &f.Version, &f.UpdatedAt); err != nil {
 			return nil, err
 		}
 		files = append(files, &f)
@@ -42,7 +42,7 @@ func (p *PersonalFileRepository) GetByUser(ctx context.Context, id int64) ([]*mo
 }
 
 func (p *PersonalFileRepository) GetByUserAndFolder(ctx context.Context, userID int64, folderID int64) ([]*model.File, error) {
-	const q = `SELECT id, user_id, name, size, mime_type, created_at, location, hash, folder_id FROM files WHERE user_id = ? AND folder_id = ?`
+	const q = `SELECT id, user_id, name, size, mime_type, created_at, location, hash, folder_id, version, updated_at FROM files WHERE user_id = ? AND folder_id = ?`
 
 	rows, err := p.db.QueryContext(ctx, q, userID, folderID)
 	if err != nil {
@@ -54,7 +54,7 @@ func (p *PersonalFileRepository) GetByUserAndFolder(ctx context.Context, userID
 	for rows.Next() {
 		var f model.File
 		if err := rows.Scan(
-			&f.ID, &f.UserID, &f.Name, &f.Size, &f.MimeType, &f.CreatedAt, &f.Location, &f.Hash, &f.FolderID); err != nil {
+			&f.ID, &f.UserID, &f.Name, &f.Size, &f.MimeType, &f.CreatedAt, &f.Location, &f.Hash, &f.FolderID, &f.Version, &f.UpdatedAt); err != nil {
 			return nil, err
 		}
 		files = append(files, &f)
@@ -63,14 +63,36 @@ func (p *PersonalFileRepository) GetByUserAndFolder(ctx context.Context, userID
 }
 
 func (p *PersonalFileRepository) GetById(ctx context.Context, id int64) (*model.File, error) {
-	const q = `SELECT id, user_id, name, size, mime_type, created_at, location, hash, folder_id FROM files WHERE id = ?`
+	const q = `SELECT id, user_id, name, size, mime_type, created_at, location, hash, folder_id, version, updated_at FROM files WHERE id = ?`
 	var f model.File
-	if err := p.db.QueryRowContext(ctx, q, id).Scan(&f.ID, &f.UserID, &f.Name, &f.Size, &f.MimeType, &f.CreatedAt, &f.Location, &f.Hash, &f.FolderID); err != nil {
+	if err := p.db.QueryRowContext(ctx, q, id).Scan(&f.ID, &f.UserID, &f.Name, &f.Size, &f.MimeType, &f.CreatedAt, &f.Location, &f.Hash, &f.FolderID, &f.Version, &f.UpdatedAt); err != nil {
 		return nil, err
 	}
 	return &f, nil
 }
 
+func (p *PersonalFileRepository) GetByFolderAndName(ctx context.Context, folderID int64, name string) (*model.File, error) {
+	const q = `SELECT id, user_id, name, size, mime_type, created_at, location, hash, folder_id, version, updated_at FROM files WHERE folder_id = ? AND name = ?`
+	var f model.File
+	if err := p.db.QueryRowContext(ctx, q, folderID, name).Scan(&f.ID, &f.UserID, &f.Name, &f.Size, &f.MimeType, &f.CreatedAt, &f.Location, &f.Hash, &f.FolderID, &f.Version, &f.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// UpdateContent records new content for a file and makes it the next version.
+func (p *PersonalFileRepository) UpdateContent(ctx context.Context, fileID int64, mimeType, hash string, size int64) error {
+	const q = `UPDATE files SET mime_type = ?, hash = ?, size = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := p.db.ExecContext(ctx, q, mimeType, hash, size, fileID)
+	return err
+}
+
+func (p *PersonalFileRepository) UpdateLocation(ctx context.Context, fileID int64, folderID int64, name, location string) error {
+	const q = `UPDATE files SET folder_id = ?, name = ?, location = ? WHERE id = ?`
+	_, err := p.db.ExecContext(ctx, q, folderID, name, location, fileID)
+	return err
+}
+
 func (p *PersonalFileRepository) UpdateFolder(ctx context.Context, fileID int64, folderID int64) error {
 	const q = `UPDATE files SET folder_id = ? WHERE id = ?`
 	_, err := p.db.ExecContext(ctx, q, folderID, fileID)
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+// SearchRepository queries the full-text index of files. The index follows the
+// files table by triggers, only the texts of contents are added from here.
+type SearchRepository struct{ baseRepo }
+
+func NewSearchRepository(db *sql.DB) *SearchRepository {
+	return &SearchRepository{newBaseRepo(db)}
+}
+
+// HasText reports whether the text of the content hash was extracted yet.
+func (r *SearchRepository) HasText(ctx context.Context, hash string) (bool, error) {
+	const q = `SELECT EXISTS (SELECT 1 FROM search_texts WHERE hash = ?)`
+	var ok bool
+	err := r.db.QueryRowContext(ctx, q, hash).Scan(&ok)
+	return ok, err
+}
+
+// InsertText records the text of a content, which makes all files with that
+// content findable by it. A text recorded in the meantime is kept.
+func (r *SearchRepository) InsertText(ctx context.Context, hash, text string) error {
+	const q = `INSERT OR IGNORE INTO search_texts (hash, text) VALUES (?, ?)`
+	_, err := r.db.ExecContext(ctx, q, hash, text)
+	return err
+}
+
+// GetMissing returns up to limit hashes of files whose type matches the LIKE
+// pattern mimeType and whose text was not extracted yet.
+func (r *SearchRepository) GetMissing(ctx context.Context, mimeType string, limit int) ([]string, error) {
+	const q = `SELECT DISTINCT f.hash FROM files f
+	       WHERE f.mime_type LIKE ?
+	         AND NOT EXISTS (SELECT 1 FROM search_texts t WHERE t.hash = f.hash)
+	       LIMIT ?`
+	rows, err := r.db.QueryContext(ctx, q, mimeType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+// Search returns the files of the user matching the FTS5 query match and the
+// filters of q, names weigh more than folder paths and those more than
+// contents. folderPath limits the search to the files below it.
+func (r *SearchRepository) Search(ctx context.Context, userID int64, match, folderPath string, q *model.SearchQuery) ([]*model.SearchResult, error) {
+	query := `SELECT f.id, f.user_id, f.name, f.size, f.mime_type, f.created_at, f.location, f.hash, f.folder_id, f.version, f.updated_at,
+	                 snippet(files_search, 2, ?, ?, '…', 16)
+	            FROM files_search JOIN files f ON f.id = files_search.rowid
+	           WHERE files_search MATCH ? AND f.user_id = ?`
+	args := []any{model.MatchStart, model.MatchEnd, match, userID}
+	if folderPath != "" {
+		query += ` AND f.location LIKE ? ESCAPE '\'`
+		args = append(args, escapeLike(folderPath)+"/%")
+	}
+	if q.MimeType != "" {
+		query += ` AND f.mime_type LIKE ? ESCAPE '\'`
+		args = append(args, escapeLike(q.MimeType)+"%")
+	}
+	if q.MinSize > 0 {
+		query += ` AND f.size >= ?`
+		args = append(args, q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		query += ` AND f.size <= ?`
+		args = append(args, q.MaxSize)
+	}
+	// The timestamps are filled by CURRENT_TIMESTAMP, compare in the same format.
+	if !q.After.IsZero() {
+		query += ` AND COALESCE(f.updated_at, f.created_at) >= ?`
+		args = append(args, q.After.UTC().Format(time.DateTime))
+	}
+	if !q.Before.IsZero() {
+		query += ` AND COALESCE(f.updated_at, f.created_at) < ?`
+		args = append(args, q.Before.UTC().Format(time.DateTime))
+	}
+	query += ` ORDER BY bm25(files_search, 10.0, 4.0, 1.0) LIMIT ? OFFSET ?`
+	args = append(args, q.Limit, q.Offset)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var results []*model.SearchResult
+	for rows.Next() {
+		var res model.SearchResult
+		f := &res.File
+		if err := rows.Scan(
+			&f.ID, &f.UserID, &f.Name, &f.Size, &f.MimeType, &f.CreatedAt, &f.Location, &f.Hash, &f.FolderID, &f.Version, &f.UpdatedAt,
+			&res.Snippet); err != nil {
+			return nil, err
+		}
+		results = append(results, &res)
+	}
+	return results, rows.Err()
+}
+
+// escapeLike escapes the wildcards of LIKE in s, for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
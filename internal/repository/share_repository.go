@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+type ShareRepository struct{ baseRepo }
+
+func NewShareRepository(db *sql.DB) *ShareRepository {
+	return &ShareRepository{newBaseRepo(db)}
+}
+
+// sharedWith matches the shares of s granted to a user, given twice, directly
+// or through one of their groups.
+const sharedWith = `(s.user_id = ? OR s.group_id IN (SELECT group_id FROM group_members WHERE user_id = ?))`
+
+const shareColumns = `s.id, s.folder_id, s.file_id, s.user_id, s.group_id, s.permission, s.created_by, s.created_at,
+	COALESCE(u.username, g.name, '')`
+
+const shareJoins = `FROM shares s LEFT JOIN users u ON u.id = s.user_id LEFT JOIN groups g ON g.id = s.group_id`
+
+func scanShare(row interface{ Scan(...any) error }) (*model.Share, error) {
+	var s model.Share
+	if err := row.Scan(&s.ID, &s.FolderID, &s.FileID, &s.UserID, &s.GroupID, &s.Permission, &s.CreatedBy, &s.CreatedAt, &s.TargetName); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// FolderPermission returns the highest permission the shares on the folder
+// of ownerID at path, or on one of the folders above it, grant to userID.
+func (r *ShareRepository) FolderPermission(ctx context.Context, userID, ownerID int64, path string) (model.Permission, error) {
+	const q = `SELECT COALESCE(MAX(s.permission), 0) FROM shares s JOIN folders f ON f.id = s.folder_id
+		     WHERE ` + sharedWith + ` AND f.user_id = ?
+		     AND (f.path = ? OR substr(?, 1, length(f.path) + 1) = f.path || '/')`
+	var p model.Permission
+	err := r.db.QueryRowContext(ctx, q, userID, userID, ownerID, path, path).Scan(&p)
+	return p, err
+}
+
+// FilePermission returns the highest permission the shares on the file, or on
+// one of the folders of ownerID above its location, grant to userID.
+func (r *ShareRepository) FilePermission(ctx context.Context, userID, ownerID, fileID int64, location string) (model.Permission, error) {
+	const q = `SELECT COALESCE(MAX(s.permission), 0) FROM shares s LEFT JOIN folders f ON f.id = s.folder_id
+		     WHERE ` + sharedWith + `
+		     AND (s.file_id = ? OR (f.user_id = ? AND substr(?, 1, length(f.path) + 1) = f.path || '/'))`
+	var p model.Permission
+	err := r.db.QueryRowContext(ctx, q, userID, userID, fileID, ownerID, location).Scan(&p)
+	return p, err
+}
+
+func (r *ShareRepository) Insert(ctx context.Context, s *model.Share) (int64, error) {
+	const q = `INSERT INTO shares (folder_id, file_id, user_id, group_id, permission, created_by) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, q, s.FolderID, s.FileID, s.UserID, s.GroupID, s.Permission, s.CreatedBy)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *ShareRepository) UpdatePermission(ctx context.Context, id int64, p model.Permission) error {
+	const q = `UPDATE shares SET permission = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, p, id)
+	return err
+}
+
+func (r *ShareRepository) GetByID(ctx context.Context, id int64) (*model.Share, error) {
+	const q = `SELECT ` + shareColumns + ` ` + shareJoins + ` WHERE s.id = ?`
+	return scanShare(r.db.QueryRowContext(ctx, q, id))
+}
+
+// GetByItemAndTarget returns the share of a folder or file with a user or
+// group, exactly one of each pair is valid.
+func (r *ShareRepository) GetByItemAndTarget(ctx context.Context, folderID, fileID, userID, groupID sql.NullInt64) (*model.Share, error) {
+	const q = `SELECT ` + shareColumns + ` ` + shareJoins + `
+		     WHERE IFNULL(s.folder_id, 0) = IFNULL(?, 0) AND IFNULL(s.file_id, 0) = IFNULL(?, 0)
+		     AND IFNULL(s.user_id, 0) = IFNULL(?, 0) AND IFNULL(s.group_id, 0) = IFNULL(?, 0)`
+	return scanShare(r.db.QueryRowContext(ctx, q, folderID, fileID, userID, groupID))
+}
+
+// GetByItem returns the shares of a folder or file, users before groups.
+func (r *ShareRepository) GetByItem(ctx context.Context, folderID, fileID sql.NullInt64) ([]*model.Share, error) {
+	const q = `SELECT ` + shareColumns + ` ` + shareJoins + `
+		     WHERE IFNULL(s.folder_id, 0) = IFNULL(?, 0) AND IFNULL(s.file_id, 0) = IFNULL(?, 0)
+		     ORDER BY s.group_id IS NOT NULL, COALESCE(u.username, g.name)`
+
+	rows, err := r.db.QueryContext(ctx, q, folderID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var shares []*model.Share
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// GetSharedWith returns the folders and files of other users shared with
+// userID, folders first. An item shared more than once is returned once with
+// the highest permission, and with the ID of the share with userID itself if
+// there is one.
+func (r *ShareRepository) GetSharedWith(ctx context.Context, userID int64) ([]*model.SharedItem, error) {
+	const q = `SELECT s.folder_id, s.file_id, COALESCE(fo.name, fi.name), o.username, MAX(s.permission), s.created_at,
+		            MAX(CASE WHEN s.user_id = ? THEN s.id END)
+		     FROM shares s
+		     LEFT JOIN folders fo ON fo.id = s.folder_id
+		     LEFT JOIN files fi ON fi.id = s.file_id
+		     JOIN users o ON o.id = COALESCE(fo.user_id, fi.user_id)
+		     WHERE ` + sharedWith + ` AND o.id <> ?
+		     GROUP BY s.folder_id, s.file_id
+		     ORDER BY s.folder_id IS NULL, COALESCE(fo.name, fi.name)`
+
+	rows, err := r.db.QueryContext(ctx, q, userID, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var items []*model.SharedItem
+	for rows.Next() {
+		var it model.SharedItem
+		if err := rows.Scan(&it.FolderID, &it.FileID, &it.Name, &it.Owner, &it.Permission, &it.SharedAt, &it.ShareID); err != nil {
+			return nil, err
+		}
+		items = append(items, &it)
+	}
+	return items, rows.Err()
+}
+
+func (r *ShareRepository) Delete(ctx context.Context, id int64) error {
+	const q = `DELETE FROM shares WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, id)
+	return err
+}
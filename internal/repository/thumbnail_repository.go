@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+// ThumbnailRepository keeps the thumbnails made of stored contents. Their own
+// blobs are counted by triggers like those of files.
+type ThumbnailRepository struct{ baseRepo }
+
+func NewThumbnailRepository(db *sql.DB) *ThumbnailRepository {
+	return &ThumbnailRepository{newBaseRepo(db)}
+}
+
+func (r *ThumbnailRepository) Get(ctx context.Context, hash string, size int) (*model.Thumbnail, error) {
+	const q = `SELECT hash, size, thumb_hash, thumb_size, created_at FROM thumbnails WHERE hash = ? AND size = ?`
+	var t model.Thumbnail
+	if err := r.db.QueryRowContext(ctx, q, hash, size).Scan(&t.Hash, &t.Size, &t.ThumbHash, &t.ThumbSize, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Insert records a thumbnail unless another one of the same content and size
+// was recorded in the meantime.
+func (r *ThumbnailRepository) Insert(ctx context.Context, t *model.Thumbnail) error {
+	const q = `INSERT OR IGNORE INTO thumbnails (hash, size, thumb_hash, thumb_size) VALUES (?, ?, ?, ?)`
+	_, err := r.db.ExecContext(ctx, q, t.Hash, t.Size, t.ThumbHash, t.ThumbSize)
+	return err
+}
+
+// GetMissing returns up to limit hashes of files of one of the mime types
+// that have fewer than sizes thumbnails.
+func (r *ThumbnailRepository) GetMissing(ctx context.Context, mimeTypes []string, sizes, limit int) ([]string, error) {
+	q := `SELECT DISTINCT f.hash FROM files f
+	       WHERE f.mime_type IN (?` + strings.Repeat(", ?", len(mimeTypes)-1) + `)
+	         AND (SELECT COUNT(*) FROM thumbnails t WHERE t.hash = f.hash) < ?
+	       LIMIT ?`
+	args := make([]any, 0, len(mimeTypes)+2)
+	for _, m := range mimeTypes {
+		args = append(args, m)
+	}
+	args = append(args, sizes, limit)
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
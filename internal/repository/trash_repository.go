@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+type TrashRepository struct{ baseRepo }
+
+func NewTrashRepository(db *sql.DB) *TrashRepository {
+	return &TrashRepository{newBaseRepo(db)}
+}
+
+const trashItemColumns = `id, user_id, name, is_folder, original_path, original_folder_id, size, file_count, deleted_at`
+
+func scanTrashItem(row interface{ Scan(...any) error }) (*model.TrashItem, error) {
+	var t model.TrashItem
+	if err := row.Scan(
+		&t.ID, &t.UserID, &t.Name, &t.IsFolder, &t.OriginalPath, &t.OriginalFolderID, &t.Size, &t.FileCount, &t.DeletedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Trash records item with its entries and removes the given folders and files
+// from the folder tree in one transaction. Folders are deleted in reverse
+// order, so they have to be passed parents first. beforeCommit receives the ID
+// of the new trash item and may be nil; if it fails nothing is changed.
+func (r *TrashRepository) Trash(ctx context.Context, item *model.TrashItem, entries []*model.TrashEntry, folderIDs, fileIDs []int64, beforeCommit func(id int64) error) (id int64, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	const insertItem = `INSERT INTO trash_items (user_id, name, is_folder, original_path, original_folder_id, size, file_count)
+		     VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, insertItem,
+		item.UserID, item.Name, item.IsFolder, item.OriginalPath, item.OriginalFolderID, item.Size, item.FileCount)
+	if err != nil {
+		return 0, err
+	}
+	if id, err = res.LastInsertId(); err != nil {
+		return 0, err
+	}
+
+	const insertEntry = `INSERT INTO trash_entries (trash_id, is_folder, path, size, mime_type, hash, created_at, file_id, version)
+		     VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, e := range entries {
+		if _, err = tx.ExecContext(ctx, insertEntry, id, e.IsFolder, e.Path, e.Size, e.MimeType, e.Hash, e.CreatedAt, e.FileID, e.Version); err != nil {
+			return 0, err
+		}
+	}
+
+	const deleteFile = `DELETE FROM files WHERE id = ?`
+	for _, fileID := range fileIDs {
+		if _, err = tx.ExecContext(ctx, deleteFile, fileID); err != nil {
+			return 0, err
+		}
+	}
+	const deleteFolder = `DELETE FROM folders WHERE id = ?`
+	for i := len(folderIDs) - 1; i >= 0; i-- {
+		if _, err = tx.ExecContext(ctx, deleteFolder, folderIDs[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	if beforeCommit != nil {
+		if err = beforeCommit(id); err != nil {
+			return 0, fmt.Errorf("failed to move %q to the trash: %w", item.OriginalPath, err)
+		}
+	}
+	return id, tx.Commit()
+}
+
+// Restore recreates the folders and files rows of a trash item below the
+// folder parentID at targetPath, reattaches the older versions of its files and
+// removes the item from the trash in one transaction. beforeCommit runs inside
+// the transaction and may be nil; if it fails nothing is changed.
+func (r *TrashRepository) Restore(ctx context.Context, item *model.TrashItem, entries []*model.TrashEntry, parentID int64, targetPath string, beforeCommit func() error) (err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Sorting by path puts every folder before its contents.
+	sorted := make([]*model.TrashEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	const insertFolder = `INSERT INTO folders (user_id, parent_id, name, path, created_at) VALUES (?, ?, ?, ?, ?)`
+	const insertFile = `INSERT INTO files (user_id, name, size, mime_type, location, hash, folder_id, created_at, version)
+		     VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	const moveVersions = `UPDATE file_versions SET file_id = ? WHERE file_id = ?`
+	folderIDs := map[string]int64{}
+	for _, e := range sorted {
+		name := path.Base(targetPath)
+		location := targetPath
+		parent := parentID
+		if e.Path != "" {
+			name = path.Base(e.Path)
+			location = path.Join(targetPath, e.Path)
+			dir := path.Dir(e.Path)
+			if dir == "." {
+				dir = ""
+			}
+			var ok bool
+			if parent, ok = folderIDs[dir]; !ok {
+				return fmt.Errorf("trash entry %q has no parent folder", e.Path)
+			}
+		}
+
+		if !e.IsFolder {
+			res, err := tx.ExecContext(ctx, insertFile, item.UserID, name, e.Size, e.MimeType, location, e.Hash, parent, e.CreatedAt, e.Version)
+			if err != nil {
+				return err
+			}
+			if e.FileID.Valid {
+				fileID, err := res.LastInsertId()
+				if err != nil {
+					return err
+				}
+				if _, err = tx.ExecContext(ctx, moveVersions, fileID, e.FileID.Int64); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		res, err := tx.ExecContext(ctx, insertFolder, item.UserID, parent, name, location, e.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if folderIDs[e.Path], err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM trash_entries WHERE trash_id = ?`, item.ID); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM trash_items WHERE id = ?`, item.ID); err != nil {
+		return err
+	}
+
+	if beforeCommit != nil {
+		if err = beforeCommit(); err != nil {
+			return fmt.Errorf("failed to restore %q: %w", item.OriginalPath, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *TrashRepository) GetByID(ctx context.Context, id int64) (*model.TrashItem, error) {
+	const q = `SELECT ` + trashItemColumns + ` FROM trash_items WHERE id = ?`
+	return scanTrashItem(r.db.QueryRowContext(ctx, q, id))
+}
+
+func (r *TrashRepository) GetByUser(ctx context.Context, userID int64) ([]*model.TrashItem, error) {
+	const q = `SELECT ` + trashItemColumns + ` FROM trash_items WHERE user_id = ? ORDER BY deleted_at DESC, id DESC`
+	return r.query(ctx, q, userID)
+}
+
+// GetDeletedBefore returns the items of all users deleted before t.
+func (r *TrashRepository) GetDeletedBefore(ctx context.Context, t time.Time) ([]*model.TrashItem, error) {
+	// deleted_at is filled by CURRENT_TIMESTAMP, compare in the same format.
+	const q = `SELECT ` + trashItemColumns + ` FROM trash_items WHERE deleted_at < ? ORDER BY deleted_at`
+	return r.query(ctx, q, t.UTC().Format(time.DateTime))
+}
+
+func (r *TrashRepository) query(ctx context.Context, q string, args ...any) ([]*model.TrashItem, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var items []*model.TrashItem
+	for rows.Next() {
+		t, err := scanTrashItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, t)
+	}
+	return items, rows.Err()
+}
+
+func (r *TrashRepository) GetEntries(ctx context.Context, trashID int64) ([]*model.TrashEntry, error) {
+	const q = `SELECT id, trash_id, is_folder, path, size, mime_type, hash, created_at, file_id, version
+		     FROM trash_entries WHERE trash_id = ? ORDER BY path`
+	rows, err := r.db.QueryContext(ctx, q, trashID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRows(rows)
+
+	var entries []*model.TrashEntry
+	for rows.Next() {
+		var e model.TrashEntry
+		if err := rows.Scan(&e.ID, &e.TrashID, &e.IsFolder, &e.Path, &e.Size, &e.MimeType, &e.Hash, &e.CreatedAt, &e.FileID, &e.Version); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// Delete removes an item together with its entries in one transaction.
+func (r *TrashRepository) Delete(ctx context.Context, id int64) (err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	const deleteEntries = `DELETE FROM trash_entries WHERE trash_id = ?`
+	if _, err = tx.ExecContext(ctx, deleteEntries, id); err != nil {
+		return err
+	}
+	const deleteItem = `DELETE FROM trash_items WHERE id = ?`
+	if _, err = tx.ExecContext(ctx, deleteItem, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+type TusUploadRepository struct{ baseRepo }
+
+func NewTusUploadRepository(db *sql.DB) *TusUploadRepository {
+	return &TusUploadRepository{newBaseRepo(db)}
+}
+
+func (r *TusUploadRepository) Insert(ctx context.Context, userID, folderID int64, uploadToken, filename string, length int64) (int64, error) {
+	const q = `INSERT INTO tus_uploads (user_id, folder_id, upload_token, filename, upload_length) VALUES (?, ?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, q, userID, folderID, uploadToken, filename, length)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *TusUploadRepository) GetByToken(ctx context.Context, uploadToken string) (*model.TusUpload, error) {
+	const q = `SELECT id, user_id, folder_id, upload_token, filename, upload_length, upload_offset, created_at
+		     FROM tus_uploads WHERE upload_token = ?`
+	var u model.TusUpload
+	if err := r.db.QueryRowContext(ctx, q, uploadToken).Scan(
+		&u.ID, &u.UserID, &u.FolderID, &u.UploadToken, &u.Filename, &u.Length, &u.Offset, &u.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *TusUploadRepository) UpdateOffset(ctx context.Context, id int64, offset int64) error {
+	const q = `UPDATE tus_uploads SET upload_offset = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, offset, id)
+	return err
+}
+
+func (r *TusUploadRepository) Delete(ctx context.Context, id int64) error {
+	const q = `DELETE FROM tus_uploads WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, id)
+	return err
+}
@@ -16,11 +16,12 @@ func NewUploadLinkRepository(db *sql.DB) *UploadLinkRepository {
 
 func (r *UploadLinkRepository) Insert(
 	ctx context.Context,
+	userID, folderID int64,
 	hashedPassword, linkToken, name string,
 	expiresAt time.Time,
 ) (int64, error) {
-	const q = `INSERT INTO upload_links (password, expires_at, link_token, name) VALUES (?, ?, ?, ?)`
-	res, err := r.db.ExecContext(ctx, q, hashedPassword, expiresAt, linkToken, name)
+	const q = `INSERT INTO upload_links (user_id, folder_id, password, expires_at, link_token, name) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, q, userID, folderID, hashedPassword, expiresAt, linkToken, name)
 	if err != nil {
 		return 0, err
 	}
@@ -32,21 +33,22 @@ func (r *UploadLinkRepository) GetByToken(
 	linkToken string,
 ) (*model.UploadLink, error) {
 	const q = `
-		SELECT id, password, name, created_at, expires_at, link_token
+		SELECT id, password, name, created_at, expires_at, link_token, user_id, folder_id
 		FROM upload_links
 		WHERE link_token = ?`
 	var ul model.UploadLink
 	if err := r.db.QueryRowContext(ctx, q, linkToken).Scan(
-		&ul.ID, &ul.HashedPassword, &ul.Name, &ul.CreatedAt, &ul.ExpiresAt, &ul.LinkToken,
+		&ul.ID, &ul.HashedPassword, &ul.Name, &ul.CreatedAt, &ul.ExpiresAt, &ul.LinkToken, &ul.UserID, &ul.FolderID,
 	); err != nil {
 		return nil, err
 	}
 	return &ul, nil
 }
 
-func (r *UploadLinkRepository) GetAll(ctx context.Context) ([]*model.UploadLink, error) {
-	const q = `SELECT id, name, created_at, expires_at, link_token FROM upload_links`
-	rows, err := r.db.QueryContext(ctx, q)
+func (r *UploadLinkRepository) GetByUser(ctx context.Context, userID int64) ([]*model.UploadLink, error) {
+	const q = `SELECT id, name, created_at, expires_at, link_token, user_id, folder_id
+		     FROM upload_links WHERE user_id = ? ORDER BY created_at DESC`
+	rows, err := r.db.QueryContext(ctx, q, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +58,7 @@ func (r *UploadLinkRepository) GetAll(ctx context.Context) ([]*model.UploadLink,
 	for rows.Next() {
 		var ul model.UploadLink
 		if err := rows.Scan(
-			&ul.ID, &ul.Name, &ul.CreatedAt, &ul.ExpiresAt, &ul.LinkToken,
+			&ul.ID, &ul.Name, &ul.CreatedAt, &ul.ExpiresAt, &ul.LinkToken, &ul.UserID, &ul.FolderID,
 		); err != nil {
 			return nil, err
 		}
@@ -64,3 +66,15 @@ func (r *UploadLinkRepository) GetAll(ctx context.Context) ([]*model.UploadLink,
 	}
 	return links, rows.Err()
 }
+
+func (r *UploadLinkRepository) Update(ctx context.Context, id int64, name string, expiresAt time.Time) error {
+	const q = `UPDATE upload_links SET name = ?, expires_at = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, name, expiresAt, id)
+	return err
+}
+
+func (r *UploadLinkRepository) Delete(ctx context.Context, id int64) error {
+	const q = `DELETE FROM upload_links WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, id)
+	return err
+}
@@ -46,3 +46,20 @@ func (r *UserRepository) GetByID(ctx context.Context, id int64) (*model.User, er
 	}
 	return &u, nil
 }
+
+// GetUsage returns the bytes used by the files of a user and their own quota,
+// which is invalid if the instance default applies.
+func (r *UserRepository) GetUsage(ctx context.Context, id int64) (int64, sql.NullInt64, error) {
+	const q = `SELECT used_bytes, quota_bytes FROM users WHERE id = ?`
+	var used int64
+	var quota sql.NullInt64
+	err := r.db.QueryRowContext(ctx, q, id).Scan(&used, &quota)
+	return used, quota, err
+}
+
+// UpdateQuota sets the quota of a user, an invalid quota applies the instance default.
+func (r *UserRepository) UpdateQuota(ctx context.Context, id int64, quota sql.NullInt64) error {
+	const q = `UPDATE users SET quota_bytes = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, q, quota, id)
+	return err
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// AccessService decides what a user may do with a folder or file. Owners may
+// do everything, other users what the shares on the item or on a folder
+// above it grant them, directly or through a group. Every service checks
+// access through it.
+type AccessService struct {
+	shares     *repository.ShareRepository
+	folderRepo *repository.FolderRepository
+	fileRepo   *repository.PersonalFileRepository
+}
+
+func NewAccessService(shares *repository.ShareRepository, folderRepo *repository.FolderRepository, fileRepo *repository.PersonalFileRepository) *AccessService {
+	return &AccessService{shares, folderRepo, fileRepo}
+}
+
+// Folder returns the folder with the given ID after CheckFolder.
+func (s *AccessService) Folder(ctx context.Context, userID, folderID int64, want model.Permission) (*model.Folder, error) {
+	folder, err := s.folderRepo.GetByID(ctx, folderID)
+	if err != nil {
+		return nil, ErrFolderNotFound
+	}
+	if err := s.CheckFolder(ctx, userID, folder, want); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// File returns the file with the given ID after CheckFile.
+func (s *AccessService) File(ctx context.Context, userID, fileID int64, want model.Permission) (*model.File, error) {
+	file, err := s.fileRepo.GetById(ctx, fileID)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	if err := s.CheckFile(ctx, userID, file, want); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// FolderPermission returns the permission of userID on folder.
+func (s *AccessService) FolderPermission(ctx context.Context, userID int64, folder *model.Folder) (model.Permission, error) {
+	if folder.UserID == userID {
+		return model.PermissionManage, nil
+	}
+	return s.shares.FolderPermission(ctx, userID, folder.UserID, folder.Path)
+}
+
+// FilePermission returns the permission of userID on file.
+func (s *AccessService) FilePermission(ctx context.Context, userID int64, file *model.File) (model.Permission, error) {
+	if file.UserID == userID {
+		return model.PermissionManage, nil
+	}
+	return s.shares.FilePermission(ctx, userID, file.UserID, file.ID, file.Location)
+}
+
+// CheckFolder fails with ErrFolderNotFound if userID may not see folder at
+// all and with ErrPermissionDenied if it may, but not with want.
+func (s *AccessService) CheckFolder(ctx context.Context, userID int64, folder *model.Folder, want model.Permission) error {
+	p, err := s.FolderPermission(ctx, userID, folder)
+	if err != nil {
+		return err
+	}
+	return check(p, want, ErrFolderNotFound)
+}
+
+// CheckFile is CheckFolder for files, hiding them with ErrFileNotFound.
+func (s *AccessService) CheckFile(ctx context.Context, userID int64, file *model.File, want model.Permission) error {
+	p, err := s.FilePermission(ctx, userID, file)
+	if err != nil {
+		return err
+	}
+	return check(p, want, ErrFileNotFound)
+}
+
+func check(p, want model.Permission, notFound error) error {
+	switch {
+	case p == model.PermissionNone:
+		return notFound
+	case p < want:
+		return ErrPermissionDenied
+	}
+	return nil
+}
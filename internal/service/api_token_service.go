@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+)
+
+const (
+	// ScopeRead allows requests that do not change anything.
+	ScopeRead = "read"
+	// ScopeWrite allows requests that create, change or delete data.
+	ScopeWrite = "write"
+
+	// APITokenPrefix starts every API token so they are easy to recognize,
+	// e.g. by secret scanners or when sent as a Basic auth password.
+	APITokenPrefix = "gct_"
+
+	// lastUsedResolution limits how often the last use of a token is written.
+	lastUsedResolution = time.Minute
+)
+
+var (
+	ErrTokenNotFound     = errors.New("api token not found")
+	ErrTokenExpired      = errors.New("api token expired")
+	ErrInvalidTokenScope = errors.New("invalid api token scope")
+	ErrEmptyTokenName    = errors.New("api token name required")
+)
+
+type APITokenService struct {
+	repo  *repository.APITokenRepository
+	users *repository.UserRepository
+}
+
+func NewAPITokenService(repo *repository.APITokenRepository, users *repository.UserRepository) *APITokenService {
+	return &APITokenService{repo: repo, users: users}
+}
+
+func hashAPIToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken creates a token for the user and returns it in plain text. The
+// plain token is not stored and cannot be shown again.
+func (s *APITokenService) CreateToken(ctx context.Context, userID int64, name string, scopes []string, expiresAt *time.Time) (string, *model.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, ErrEmptyTokenName
+	}
+	// Normalize the scopes to a fixed order without duplicates.
+	var read, write bool
+	for _, sc := range scopes {
+		switch sc {
+		case ScopeRead:
+			read = true
+		case ScopeWrite:
+			write = true
+		default:
+			return "", nil, ErrInvalidTokenScope
+		}
+	}
+	var normalized []string
+	if read {
+		normalized = append(normalized, ScopeRead)
+	}
+	if write {
+		normalized = append(normalized, ScopeWrite)
+	}
+	if len(normalized) == 0 {
+		return "", nil, ErrInvalidTokenScope
+	}
+
+	tok, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+	plain := APITokenPrefix + tok
+
+	var expiry sql.NullTime
+	if expiresAt != nil {
+		expiry = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+	id, err := s.repo.Insert(ctx, userID, name, hashAPIToken(plain), strings.Join(normalized, ","), expiry)
+	if err != nil {
+		return "", nil, err
+	}
+	t, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return plain, t, nil
+}
+
+// Authenticate returns the owner of a plain token and records its use.
+func (s *APITokenService) Authenticate(ctx context.Context, plain string) (*model.User, *model.APIToken, error) {
+	if !strings.HasPrefix(plain, APITokenPrefix) {
+		return nil, nil, ErrTokenNotFound
+	}
+	t, err := s.repo.GetByHash(ctx, hashAPIToken(plain))
+	if err != nil {
+		return nil, nil, ErrTokenNotFound
+	}
+	now := time.Now().UTC()
+	if t.ExpiresAt.Valid && now.After(t.ExpiresAt.Time) {
+		return nil, nil, ErrTokenExpired
+	}
+	u, err := s.users.GetByID(ctx, t.UserID)
+	if err != nil {
+		return nil, nil, ErrTokenNotFound
+	}
+	if !t.LastUsedAt.Valid || now.Sub(t.LastUsedAt.Time) >= lastUsedResolution {
+		if err := s.repo.UpdateLastUsed(ctx, t.ID, now); err != nil {
+			return nil, nil, err
+		}
+		t.LastUsedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	return u, t, nil
+}
+
+// GetUserTokens returns the tokens created by the user.
+func (s *APITokenService) GetUserTokens(ctx context.Context, userID int64) ([]*model.APIToken, error) {
+	return s.repo.GetByUser(ctx, userID)
+}
+
+// RevokeToken deletes a token of the user. Tokens of other users are reported
+// as not found.
+func (s *APITokenService) RevokeToken(ctx context.Context, userID, tokenID int64) error {
+	t, err := s.repo.GetByID(ctx, tokenID)
+	if err != nil || t.UserID != userID {
+		return ErrTokenNotFound
+	}
+	return s.repo.Delete(ctx, t.ID)
+}
@@ -0,0 +1,148 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+func setupAPITokenTest(t *testing.T) (*service.APITokenService, int64, int64) {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+
+	userRepo := repository.NewUserRepository(db)
+	tokenSvc := service.NewAPITokenService(repository.NewAPITokenRepository(db), userRepo)
+
+	ownerID, err := userRepo.Insert(ctx, "owner", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	otherID, err := userRepo.Insert(ctx, "other", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	return tokenSvc, ownerID, otherID
+}
+
+func TestAPITokenService_CreateToken(t *testing.T) {
+	tokenSvc, ownerID, _ := setupAPITokenTest(t)
+	ctx := testutil.TestContext(t)
+
+	tests := []struct {
+		name       string
+		tokenName  string
+		scopes     []string
+		wantScopes string
+		wantErr    error
+	}{
+		{"read only", "ci", []string{"read"}, "read", nil},
+		{"normalized scopes", "ci", []string{"write", "read", "write"}, "read,write", nil},
+		{"empty name", " ", []string{"read"}, "", service.ErrEmptyTokenName},
+		{"no scopes", "ci", nil, "", service.ErrInvalidTokenScope},
+		{"unknown scope", "ci", []string{"admin"}, "", service.ErrInvalidTokenScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, tok, err := tokenSvc.CreateToken(ctx, ownerID, tt.tokenName, tt.scopes, nil)
+			if tt.wantErr != nil {
+				if err != tt.wantErr {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(plain, service.APITokenPrefix) {
+				t.Errorf("token %q does not start with %q", plain, service.APITokenPrefix)
+			}
+			if tok.Scopes != tt.wantScopes {
+				t.Errorf("expected scopes %q, got %q", tt.wantScopes, tok.Scopes)
+			}
+			if strings.Contains(tok.TokenHash, plain) {
+				t.Error("plain token must not be stored")
+			}
+		})
+	}
+}
+
+func TestAPITokenService_Authenticate(t *testing.T) {
+	tokenSvc, ownerID, _ := setupAPITokenTest(t)
+	ctx := testutil.TestContext(t)
+
+	valid, _, err := tokenSvc.CreateToken(ctx, ownerID, "valid", []string{"read"}, nil)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	expired, _, err := tokenSvc.CreateToken(ctx, ownerID, "expired", []string{"read"}, &past)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		plain   string
+		wantErr error
+	}{
+		{"valid token", valid, nil},
+		{"expired token", expired, service.ErrTokenExpired},
+		{"unknown token", service.APITokenPrefix + "deadbeef", service.ErrTokenNotFound},
+		{"missing prefix", strings.TrimPrefix(valid, service.APITokenPrefix), service.ErrTokenNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, tok, err := tokenSvc.Authenticate(ctx, tt.plain)
+			if tt.wantErr != nil {
+				if err != tt.wantErr {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if user.ID != ownerID {
+				t.Errorf("expected user %d, got %d", ownerID, user.ID)
+			}
+			if !tok.LastUsedAt.Valid {
+				t.Error("expected last used timestamp to be set")
+			}
+		})
+	}
+}
+
+func TestAPITokenService_RevokeToken(t *testing.T) {
+	tokenSvc, ownerID, otherID := setupAPITokenTest(t)
+	ctx := testutil.TestContext(t)
+
+	plain, tok, err := tokenSvc.CreateToken(ctx, ownerID, "ci", []string{"write"}, nil)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	if err := tokenSvc.RevokeToken(ctx, otherID, tok.ID); err != service.ErrTokenNotFound {
+		t.Fatalf("expected ErrTokenNotFound for other user, got %v", err)
+	}
+	if err := tokenSvc.RevokeToken(ctx, ownerID, tok.ID); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	if _, _, err := tokenSvc.Authenticate(ctx, plain); err != service.ErrTokenNotFound {
+		t.Errorf("expected revoked token to be rejected, got %v", err)
+	}
+	tokens, err := tokenSvc.GetUserTokens(ctx, ownerID)
+	if err != nil {
+		t.Fatalf("GetUserTokens failed: %v", err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("expected no tokens, got %d", len(tokens))
+	}
+}
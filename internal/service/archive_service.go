@@ -0,0 +1,277 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/storage"
+)
+
+var (
+	ErrInvalidArchiveFormat = errors.New("archive format must be zip or tar.gz")
+	ErrEmptySelection       = errors.New("nothing selected")
+)
+
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+// ParseArchiveFormat parses the format of a download, zip if s is empty.
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "zip":
+		return ArchiveZip, nil
+	case "tar.gz", "tgz":
+		return ArchiveTarGz, nil
+	}
+	return "", ErrInvalidArchiveFormat
+}
+
+func (f ArchiveFormat) ContentType() string {
+	if f == ArchiveTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// Archive is a selection of files and folders to be written as one archive.
+// It only holds the database records, contents are read while writing.
+type Archive struct {
+	// Name is the suggested file name of the archive including its extension.
+	Name    string
+	Format  ArchiveFormat
+	entries []archiveEntry
+	st      storage.FileManager
+}
+
+// archiveEntry is a file or, if file is nil, a folder. Folders are listed so
+// empty ones are kept.
+type archiveEntry struct {
+	name    string
+	file    *model.File
+	modTime time.Time
+}
+
+// ArchiveService bundles files and whole folders into ZIP or tar.gz archives
+// that are streamed to the client without being built on disk first.
+type ArchiveService struct {
+	folderRepo *repository.FolderRepository
+	fileRepo   *repository.PersonalFileRepository
+	access     *AccessService
+	st         storage.FileManager
+}
+
+func NewArchiveService(folderRepo *repository.FolderRepository, fileRepo *repository.PersonalFileRepository, access *AccessService, st storage.FileManager) *ArchiveService {
+	return &ArchiveService{folderRepo, fileRepo, access, st}
+}
+
+// Folder returns an archive of the folder with its whole subtree, which is
+// the top level directory of the archive.
+func (s *ArchiveService) Folder(ctx context.Context, user *model.User, folderID int64, format ArchiveFormat) (*Archive, error) {
+	a, err := s.Select(ctx, user, []int64{folderID}, nil, format)
+	if err != nil {
+		return nil, err
+	}
+	a.Name = strings.TrimSuffix(a.entries[0].name, "/") + "." + string(format)
+	return a, nil
+}
+
+// Select returns an archive of the given folders and files, each at the top
+// level of the archive. Selections the user may not read fail with
+// ErrFolderNotFound or ErrFileNotFound before anything is written. Items
+// that are already part of a selected folder are only added once, clashing
+// names at the top level get a number appended.
+func (s *ArchiveService) Select(ctx context.Context, user *model.User, folderIDs, fileIDs []int64, format ArchiveFormat) (*Archive, error) {
+	if len(folderIDs) == 0 && len(fileIDs) == 0 {
+		return nil, ErrEmptySelection
+	}
+	folders := make([]*model.Folder, 0, len(folderIDs))
+	for _, id := range folderIDs {
+		folder, err := s.access.Folder(ctx, user.ID, id, model.PermissionRead)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	files := make([]*model.File, 0, len(fileIDs))
+	for _, id := range fileIDs {
+		file, err := s.access.File(ctx, user.ID, id, model.PermissionRead)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	w := &archiveWalk{
+		folders: make(map[int64]bool),
+		files:   make(map[int64]bool),
+		names:   make(map[string]bool),
+	}
+	for _, folder := range folders {
+		if w.folders[folder.ID] {
+			continue
+		}
+		name := folder.Name
+		if !folder.ParentID.Valid {
+			// The root folder is named after the user in archives.
+			name = user.Username
+		}
+		if err := s.walk(ctx, w, folder, w.unique(name)); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range files {
+		if !w.files[file.ID] {
+			w.files[file.ID] = true
+			w.entries = append(w.entries, archiveEntry{w.unique(file.Name), file, file.ContentCreatedAt()})
+		}
+	}
+	return &Archive{Name: "download." + string(format), Format: format, entries: w.entries, st: s.st}, nil
+}
+
+type archiveWalk struct {
+	entries []archiveEntry
+	folders map[int64]bool
+	files   map[int64]bool
+	// names taken at the top level of the archive.
+	names map[string]bool
+}
+
+// unique returns name, or name with a number before its extension if it is
+// already taken at the top level.
+func (w *archiveWalk) unique(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; w.names[candidate]; i++ {
+		candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	w.names[candidate] = true
+	return candidate
+}
+
+func (s *ArchiveService) walk(ctx context.Context, w *archiveWalk, folder *model.Folder, name string) error {
+	w.folders[folder.ID] = true
+	w.entries = append(w.entries, archiveEntry{name + "/", nil, folder.UpdatedAt})
+
+	files, err := s.fileRepo.GetByUserAndFolder(ctx, folder.UserID, folder.ID)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !w.files[f.ID] {
+			w.files[f.ID] = true
+			w.entries = append(w.entries, archiveEntry{name + "/" + f.Name, f, f.ContentCreatedAt()})
+		}
+	}
+
+	children, err := s.folderRepo.GetByUserAndParent(ctx, folder.UserID, folder.ID)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if w.folders[child.ID] {
+			continue
+		}
+		if err := s.walk(ctx, w, child, name+"/"+child.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write streams the archive to dst. An error halfway leaves a truncated
+// archive behind.
+func (a *Archive) Write(dst io.Writer) error {
+	if a.Format == ArchiveTarGz {
+		return a.writeTarGz(dst)
+	}
+	return a.writeZip(dst)
+}
+
+func (a *Archive) writeZip(dst io.Writer) error {
+	zw := zip.NewWriter(dst)
+	for _, e := range a.entries {
+		hdr := &zip.FileHeader{Name: e.name, Modified: e.modTime}
+		if e.file != nil && compressible(e.file.MimeType) {
+			hdr.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if e.file != nil {
+			if err := a.copyContent(fw, e.file); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+func (a *Archive) writeTarGz(dst io.Writer) error {
+	gw := gzip.NewWriter(dst)
+	tw := tar.NewWriter(gw)
+	for _, e := range a.entries {
+		hdr := &tar.Header{Name: e.name, ModTime: e.modTime, Typeflag: tar.TypeDir, Mode: 0o755, Format: tar.FormatPAX}
+		if e.file != nil {
+			hdr.Typeflag = tar.TypeReg
+			hdr.Mode = 0o644
+			hdr.Size = e.file.Size
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if e.file != nil {
+			if err := a.copyContent(tw, e.file); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func (a *Archive) copyContent(dst io.Writer, file *model.File) error {
+	rc, err := a.st.OpenFile(file.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", file.Name, err)
+	}
+	defer rc.Close()
+	if _, err := io.Copy(dst, rc); err != nil {
+		return fmt.Errorf("failed to add %q: %w", file.Name, err)
+	}
+	return nil
+}
+
+// compressible reports whether deflating content of the mime type is worth
+// it, media and archives are compressed already and stored as they are.
+func compressible(mimeType string) bool {
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(mimeType, prefix) {
+			return !strings.HasPrefix(mimeType, "image/svg") && mimeType != "image/bmp"
+		}
+	}
+	switch mimeType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/x-bzip2", "application/x-xz", "application/zstd":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,234 @@
+package service_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type archiveTest struct {
+	archives *service.ArchiveService
+	files    *service.PersonalFileService
+	folders  *service.FolderService
+	user     *model.User
+	root     *model.Folder
+	// docs holds a.txt and the folder sub with b.txt, and the empty folder empty.
+	docs *model.Folder
+	sub  *model.Folder
+	a    *model.File
+	b    *model.File
+}
+
+func setupArchiveTest(t *testing.T) *archiveTest {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+	c := path.New(tmpDir)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	at := &archiveTest{
+		archives: service.NewArchiveService(folderRepo, fileRepo, access, st),
+		files:    service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c),
+		folders:  folderSvc,
+	}
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	at.user = &model.User{ID: userID, Username: "testuser"}
+	if at.root, err = at.folders.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/"); err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	if at.docs, err = at.folders.CreateFolder(ctx, userID, "testuser", at.root.ID, "docs", "/docs"); err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	if at.sub, err = at.folders.CreateFolder(ctx, userID, "testuser", at.docs.ID, "sub", "/docs/sub"); err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	if _, err = at.folders.CreateFolder(ctx, userID, "testuser", at.docs.ID, "empty", "/docs/empty"); err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	if at.a, err = at.files.StoreFile(ctx, at.user, at.docs.ID, at.docs.Path, "a.txt", strings.NewReader("content of a")); err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	if at.b, err = at.files.StoreFile(ctx, at.user, at.sub.ID, at.sub.Path, "b.txt", strings.NewReader("content of b")); err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	return at
+}
+
+// readZip returns the entries of a ZIP archive by name, folders with an
+// empty content.
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("could not open %s: %v", f.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("could not read %s: %v", f.Name, err)
+		}
+		entries[f.Name] = string(content)
+	}
+	return entries
+}
+
+func readTarGz(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	entries := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid tar: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("could not read %s: %v", hdr.Name, err)
+		}
+		entries[hdr.Name] = string(content)
+	}
+	return entries
+}
+
+func writeArchive(t *testing.T, a *service.Archive) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := a.Write(&buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func names(entries map[string]string) []string {
+	res := make([]string, 0, len(entries))
+	for name := range entries {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestArchiveService_FolderZip(t *testing.T) {
+	at := setupArchiveTest(t)
+
+	a, err := at.archives.Folder(testutil.TestContext(t), at.user, at.docs.ID, service.ArchiveZip)
+	if err != nil {
+		t.Fatalf("Folder failed: %v", err)
+	}
+	if a.Name != "docs.zip" {
+		t.Errorf("expected docs.zip, got %q", a.Name)
+	}
+	entries := readZip(t, writeArchive(t, a))
+	want := []string{"docs/", "docs/a.txt", "docs/empty/", "docs/sub/", "docs/sub/b.txt"}
+	if got := names(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected entries %v, got %v", want, got)
+	}
+	if entries["docs/sub/b.txt"] != "content of b" {
+		t.Errorf("unexpected content %q", entries["docs/sub/b.txt"])
+	}
+}
+
+func TestArchiveService_FolderTarGz(t *testing.T) {
+	at := setupArchiveTest(t)
+
+	a, err := at.archives.Folder(testutil.TestContext(t), at.user, at.root.ID, service.ArchiveTarGz)
+	if err != nil {
+		t.Fatalf("Folder failed: %v", err)
+	}
+	if a.Name != "testuser.tar.gz" {
+		t.Errorf("expected testuser.tar.gz, got %q", a.Name)
+	}
+	entries := readTarGz(t, writeArchive(t, a))
+	want := []string{"testuser/", "testuser/docs/", "testuser/docs/a.txt", "testuser/docs/empty/", "testuser/docs/sub/", "testuser/docs/sub/b.txt"}
+	if got := names(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected entries %v, got %v", want, got)
+	}
+	if entries["testuser/docs/a.txt"] != "content of a" {
+		t.Errorf("unexpected content %q", entries["testuser/docs/a.txt"])
+	}
+}
+
+func TestArchiveService_Select(t *testing.T) {
+	at := setupArchiveTest(t)
+	ctx := testutil.TestContext(t)
+
+	// b.txt is part of sub already, the a.txt in the root clashes with the one in docs.
+	rootA, err := at.files.StoreFile(ctx, at.user, at.root.ID, at.root.Path, "a.txt", strings.NewReader("root a"))
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	a, err := at.archives.Select(ctx, at.user, []int64{at.sub.ID, at.sub.ID}, []int64{at.b.ID, at.a.ID, rootA.ID}, service.ArchiveZip)
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	entries := readZip(t, writeArchive(t, a))
+	want := []string{"a (2).txt", "a.txt", "sub/", "sub/b.txt"}
+	if got := names(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected entries %v, got %v", want, got)
+	}
+	if entries["a.txt"] != "content of a" || entries["a (2).txt"] != "root a" {
+		t.Errorf("unexpected contents %v", entries)
+	}
+}
+
+func TestArchiveService_Errors(t *testing.T) {
+	at := setupArchiveTest(t)
+	ctx := testutil.TestContext(t)
+	other := &model.User{ID: at.user.ID + 1, Username: "other"}
+
+	if _, err := at.archives.Folder(ctx, other, at.docs.ID, service.ArchiveZip); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound for another user, got %v", err)
+	}
+	if _, err := at.archives.Select(ctx, other, nil, []int64{at.a.ID}, service.ArchiveZip); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for another user, got %v", err)
+	}
+	if _, err := at.archives.Select(ctx, at.user, nil, nil, service.ArchiveZip); !errors.Is(err, service.ErrEmptySelection) {
+		t.Errorf("expected ErrEmptySelection, got %v", err)
+	}
+	if _, err := service.ParseArchiveFormat("rar"); !errors.Is(err, service.ErrInvalidArchiveFormat) {
+		t.Errorf("expected ErrInvalidArchiveFormat, got %v", err)
+	}
+	if f, err := service.ParseArchiveFormat(""); err != nil || f != service.ArchiveZip {
+		t.Errorf("expected zip by default, got %q, %v", f, err)
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/storage"
+)
+
+var ErrBlobCollected = errors.New("stored content was removed while it was saved, try again")
+
+// BlobService removes stored contents nothing refers to any more and moves
+// contents of the legacy per-user layout into the blob store.
+type BlobService struct {
+	repo *repository.BlobRepository
+	st   storage.FileManager
+	// mu keeps Claim from running while Collect deletes a blob, so a content
+	// is never claimed in between its row and its stored content being deleted.
+	mu sync.Mutex
+}
+
+func NewBlobService(repo *repository.BlobRepository, st storage.FileManager) *BlobService {
+	return &BlobService{repo: repo, st: st}
+}
+
+// Claim has to be called once a content is saved to storage and before it is
+// recorded in the database. Saving content that is already stored keeps the
+// stored one, which Collect may be about to delete if nothing uses it; Claim
+// restarts its grace period. If Collect deleted it after it was saved, Claim
+// fails with ErrBlobCollected and the content has to be saved again.
+func (s *BlobService) Claim(ctx context.Context, hash string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.repo.Claim(ctx, hash, size); err != nil {
+		return err
+	}
+	f, err := s.st.OpenFile(hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrBlobCollected
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Collect deletes the contents that have not been used by any file, version
+// or trashed file since releasedBefore and returns how many it deleted.
+// Contents are claimed before they are recorded in the database, so the grace
+// period has to be well above the time it takes to record one after claiming
+// it.
+func (s *BlobService) Collect(ctx context.Context, releasedBefore time.Time) (int, error) {
+	hashes, err := s.repo.GetReleasedBefore(ctx, releasedBefore)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, hash := range hashes {
+		ok, err := s.deleteReleased(ctx, hash, releasedBefore)
+		if err != nil {
+			return deleted, err
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// deleteReleased deletes the blob of hash and its content unless it was used
+// or claimed again after it was listed.
+func (s *BlobService) deleteReleased(ctx context.Context, hash string, releasedBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok, err := s.repo.DeleteReleased(ctx, hash, releasedBefore)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := s.st.DeleteFile(hash); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("failed to delete blob %s: %w", hash, err)
+	}
+	return true, nil
+}
+
+// LegacyImport summarizes a MigrateLegacyLayout run.
+type LegacyImport struct {
+	// Imported is the number of files moved into the blob store.
+	Imported int
+	// Missing is the number of rows whose file was not found on disk, e.g.
+	// because an interrupted earlier run already imported it.
+	Missing int
+	// Left is the number of files on disk no row referred to, they are kept.
+	Left int
+}
+
+// MigrateLegacyLayout moves the contents of every file, version and trashed
+// file from the legacy per-user directories into the blob store and removes
+// the emptied directories. It is a no-op once nothing of the legacy layout is
+// left and can be resumed after an interruption.
+func (s *BlobService) MigrateLegacyLayout(ctx context.Context, legacy *storage.LegacyLayout) (*LegacyImport, error) {
+	res := &LegacyImport{}
+	if !legacy.Exists() {
+		return res, nil
+	}
+	refs, err := s.repo.GetRefs(ctx)
+	if err != nil {
+		return res, err
+	}
+	for _, ref := range refs {
+		var p string
+		switch ref.Kind {
+		case model.BlobRefFile:
+			p = legacy.FilePath(ref.Username, ref.Location)
+		case model.BlobRefVersion:
+			p = legacy.VersionPath(ref.Username, ref.ID)
+		case model.BlobRefTrash:
+			p = legacy.TrashPath(ref.Username, ref.TrashID, ref.Location)
+		}
+		hash, size, err := legacy.Import(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			res.Missing++
+			continue
+		}
+		if err != nil {
+			return res, fmt.Errorf("failed to import %q: %w", p, err)
+		}
+		if hash != ref.Hash {
+			if err := s.repo.UpdateRef(ctx, ref, hash, size); err != nil {
+				return res, err
+			}
+		}
+		res.Imported++
+	}
+	if res.Left, err = legacy.Cleanup(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
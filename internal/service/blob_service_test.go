@@ -0,0 +1,440 @@
+package service_test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type blobTest struct {
+	db       *sql.DB
+	dataDir  string
+	st       storage.FileManager
+	repo     *repository.BlobRepository
+	blobs    *service.BlobService
+	files    *service.PersonalFileService
+	folders  *service.FolderService
+	trash    *service.TrashService
+	versions *repository.FileVersionRepository
+	user     *model.User
+	root     *model.Folder
+}
+
+func setupBlobTest(t *testing.T) *blobTest {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+	c := path.New(tmpDir)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	versionRepo := repository.NewFileVersionRepository(db)
+	blobRepo := repository.NewBlobRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(versionRepo, fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	blobSvc := service.NewBlobService(blobRepo, st)
+	bt := &blobTest{
+		db:       db,
+		dataDir:  tmpDir,
+		st:       st,
+		repo:     blobRepo,
+		blobs:    blobSvc,
+		files:    service.NewPersonalFileService(st, blobSvc, fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c),
+		folders:  folderSvc,
+		trash:    service.NewTrashService(repository.NewTrashRepository(db), folderRepo, fileRepo, service.NewQuotaService(userRepo, 0), access, c),
+		versions: versionRepo,
+	}
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	bt.user = &model.User{ID: userID, Username: "testuser"}
+	if bt.root, err = bt.folders.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/"); err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	return bt
+}
+
+func (bt *blobTest) refCount(t *testing.T, hash string) int {
+	t.Helper()
+	b, err := bt.repo.GetByHash(testutil.TestContext(t), hash)
+	if err != nil {
+		t.Fatalf("failed to get blob %s: %v", hash, err)
+	}
+	return b.RefCount
+}
+
+// countBlobs returns the number of contents stored below dataDir.
+func countBlobs(t *testing.T, dataDir string) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(filepath.Join(dataDir, ".blobs"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("failed to walk blob store: %v", err)
+	}
+	return n
+}
+
+func readFile(t *testing.T, files *service.PersonalFileService, user *model.User, file *model.File) string {
+	t.Helper()
+	rc, err := files.OpenFile(testutil.TestContext(t), user, file)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", file.Location, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", file.Location, err)
+	}
+	return string(data)
+}
+
+func TestBlobService_IdenticalContentIsStoredOnce(t *testing.T) {
+	bt := setupBlobTest(t)
+	ctx := testutil.TestContext(t)
+
+	docs, err := bt.folders.CreateFolder(ctx, bt.user.ID, bt.user.Username, bt.root.ID, "docs", "/docs")
+	if err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	a, err := bt.files.StoreFile(ctx, bt.user, bt.root.ID, bt.root.Path, "a.txt", strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("failed to store a.txt: %v", err)
+	}
+	b, err := bt.files.StoreFile(ctx, bt.user, docs.ID, docs.Path, "b.txt", strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("failed to store b.txt: %v", err)
+	}
+
+	if a.Hash != b.Hash {
+		t.Fatalf("expected identical hashes, got %s and %s", a.Hash, b.Hash)
+	}
+	if got := countBlobs(t, bt.dataDir); got != 1 {
+		t.Errorf("expected 1 blob in storage, got %d", got)
+	}
+	if got := bt.refCount(t, a.Hash); got != 2 {
+		t.Errorf("expected 2 references, got %d", got)
+	}
+
+	if err := bt.files.DeleteFile(ctx, bt.user, a.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if n, err := bt.blobs.Collect(ctx, time.Now().Add(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("expected nothing to collect, got %d, %v", n, err)
+	}
+	if got := readFile(t, bt.files, bt.user, b); got != "same content" {
+		t.Errorf("expected b.txt to keep its content, got %q", got)
+	}
+}
+
+func TestBlobService_CopyFile(t *testing.T) {
+	bt := setupBlobTest(t)
+	ctx := testutil.TestContext(t)
+
+	docs, err := bt.folders.CreateFolder(ctx, bt.user.ID, bt.user.Username, bt.root.ID, "docs", "/docs")
+	if err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	orig, err := bt.files.StoreFile(ctx, bt.user, bt.root.ID, bt.root.Path, "a.txt", strings.NewReader("copy me"))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	cp, err := bt.files.CopyFile(ctx, bt.user, orig.ID, docs, "")
+	if err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+	if cp.ID == orig.ID || cp.Location != "docs/a.txt" || cp.Hash != orig.Hash || cp.Size != orig.Size {
+		t.Errorf("unexpected copy %+v of %+v", cp, orig)
+	}
+	if got := countBlobs(t, bt.dataDir); got != 1 {
+		t.Errorf("expected the copy to share the blob, got %d blobs", got)
+	}
+	if _, err := bt.files.CopyFile(ctx, bt.user, orig.ID, docs, ""); !errors.Is(err, service.ErrFileAlreadyExists) {
+		t.Errorf("expected ErrFileAlreadyExists, got %v", err)
+	}
+	if _, err := bt.files.CopyFile(ctx, bt.user, orig.ID, docs, "../b.txt"); !errors.Is(err, service.ErrInvalidFileName) {
+		t.Errorf("expected ErrInvalidFileName, got %v", err)
+	}
+	other := &model.User{ID: bt.user.ID + 1, Username: "other"}
+	if _, err := bt.files.CopyFile(ctx, other, orig.ID, docs, "c.txt"); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for another user, got %v", err)
+	}
+
+	// Replacing the original leaves the copy alone.
+	if _, err := bt.files.StoreFile(ctx, bt.user, bt.root.ID, bt.root.Path, "a.txt", strings.NewReader("changed")); err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+	if got := readFile(t, bt.files, bt.user, cp); got != "copy me" {
+		t.Errorf("expected the copy to keep its content, got %q", got)
+	}
+}
+
+func TestBlobService_Collect(t *testing.T) {
+	bt := setupBlobTest(t)
+	ctx := testutil.TestContext(t)
+
+	deleted, err := bt.files.StoreFile(ctx, bt.user, bt.root.ID, bt.root.Path, "deleted.txt", strings.NewReader("gone"))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	trashed, err := bt.files.StoreFile(ctx, bt.user, bt.root.ID, bt.root.Path, "trashed.txt", strings.NewReader("in the trash"))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := bt.files.DeleteFile(ctx, bt.user, deleted.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	item, err := bt.trash.TrashFile(ctx, bt.user, trashed.ID)
+	if err != nil {
+		t.Fatalf("TrashFile failed: %v", err)
+	}
+
+	// Released just now, still within the grace period.
+	if n, err := bt.blobs.Collect(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected nothing to collect within the grace period, got %d, %v", n, err)
+	}
+	if n, err := bt.blobs.Collect(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected 1 collected blob, got %d, %v", n, err)
+	}
+	if _, err := bt.st.OpenFile(deleted.Hash); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the deleted content to be removed, got %v", err)
+	}
+	if _, err := bt.repo.GetByHash(ctx, deleted.Hash); err == nil {
+		t.Error("expected the blob row to be removed")
+	}
+
+	restored, err := bt.trash.Restore(ctx, bt.user, item.ID)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	file, err := bt.files.GetFileByName(ctx, bt.user.ID, bt.root.ID, restored.Name)
+	if err != nil {
+		t.Fatalf("restored file not found: %v", err)
+	}
+	if got := readFile(t, bt.files, bt.user, file); got != "in the trash" {
+		t.Errorf("expected trashed content to survive, got %q", got)
+	}
+
+	// Content uploaded again after its release is kept.
+	if _, err := bt.files.StoreFile(ctx, bt.user, bt.root.ID, bt.root.Path, "again.txt", strings.NewReader("gone")); err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if n, err := bt.blobs.Collect(ctx, time.Now().Add(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("expected nothing to collect, got %d, %v", n, err)
+	}
+}
+
+func TestBlobService_Claim(t *testing.T) {
+	bt := setupBlobTest(t)
+	ctx := testutil.TestContext(t)
+
+	file, err := bt.files.StoreFile(ctx, bt.user, bt.root.ID, bt.root.Path, "old.txt", strings.NewReader("released long ago"))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := bt.files.DeleteFile(ctx, bt.user, file.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if _, err := bt.db.ExecContext(ctx, `UPDATE blobs SET released_at = datetime('now', '-1 day') WHERE hash = ?`, file.Hash); err != nil {
+		t.Fatalf("failed to backdate the release: %v", err)
+	}
+
+	// Saving the content again finds it stored already, claiming it keeps
+	// Collect from deleting it before the upload is recorded.
+	hash, size, err := bt.st.SaveFile(strings.NewReader("released long ago"))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if err := bt.blobs.Claim(ctx, hash, size); err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	if n, err := bt.blobs.Collect(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected the claimed blob to be kept, got %d, %v", n, err)
+	}
+	if _, err := bt.repo.GetByHash(ctx, hash); err != nil {
+		t.Errorf("expected the claimed blob to be kept, got %v", err)
+	}
+	// Nothing recorded it, so it is collected once its new grace period ends.
+	if n, err := bt.blobs.Collect(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected the unused claimed blob to be collected, got %d, %v", n, err)
+	}
+
+	// Collected after it was saved and before it was claimed.
+	hash, size, err = bt.st.SaveFile(strings.NewReader("collected"))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if err := bt.st.DeleteFile(hash); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if err := bt.blobs.Claim(ctx, hash, size); !errors.Is(err, service.ErrBlobCollected) {
+		t.Errorf("expected ErrBlobCollected, got %v", err)
+	}
+}
+
+func TestBlobService_CollectDeletedFolder(t *testing.T) {
+	bt := setupBlobTest(t)
+	ctx := testutil.TestContext(t)
+
+	docs, err := bt.folders.CreateFolder(ctx, bt.user.ID, bt.user.Username, bt.root.ID, "docs", "/docs")
+	if err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	var hashes []string
+	for i := range 3 {
+		f, err := bt.files.StoreFile(ctx, bt.user, docs.ID, docs.Path, fmt.Sprintf("%d.txt", i), strings.NewReader(fmt.Sprintf("file %d", i)))
+		if err != nil {
+			t.Fatalf("failed to store file: %v", err)
+		}
+		hashes = append(hashes, f.Hash)
+	}
+	if _, err := bt.folders.DeleteFolder(ctx, bt.user, docs.ID); err != nil {
+		t.Fatalf("DeleteFolder failed: %v", err)
+	}
+
+	if n, err := bt.blobs.Collect(ctx, time.Now().Add(time.Minute)); err != nil || n != len(hashes) {
+		t.Fatalf("expected %d collected blobs, got %d, %v", len(hashes), n, err)
+	}
+	if got := countBlobs(t, bt.dataDir); got != 0 {
+		t.Errorf("expected an empty blob store, got %d blobs", got)
+	}
+}
+
+// writeLegacy writes content to the legacy layout below dataDir and returns
+// its hash.
+func writeLegacy(t *testing.T, dataDir, rel, content string) string {
+	t.Helper()
+	p := filepath.Join(dataDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatalf("failed to create %s: %v", filepath.Dir(p), err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", p, err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+}
+
+func TestBlobService_MigrateLegacyLayout(t *testing.T) {
+	bt := setupBlobTest(t)
+	ctx := testutil.TestContext(t)
+	legacy := storage.NewLegacyLayout(bt.dataDir, bt.st)
+	fileRepo := repository.NewPersonalFileRepository(bt.db)
+
+	docs, err := bt.folders.CreateFolder(ctx, bt.user.ID, bt.user.Username, bt.root.ID, "docs", "/docs")
+	if err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	insertFile := func(folder *model.Folder, name, hash string) int64 {
+		t.Helper()
+		id, err := fileRepo.Insert(ctx, name, "text/plain", path.New(bt.dataDir).JoinDBPath(folder.Path, name), hash, bt.user.ID, 5, folder.ID)
+		if err != nil {
+			t.Fatalf("failed to insert %s: %v", name, err)
+		}
+		return id
+	}
+
+	hash := writeLegacy(t, bt.dataDir, "testuser/docs/a.txt", "hello")
+	a := insertFile(docs, "a.txt", hash)
+	// A recorded hash that does not match the content is corrected.
+	writeLegacy(t, bt.dataDir, "testuser/b.txt", "world")
+	b := insertFile(bt.root, "b.txt", "stale")
+	insertFile(bt.root, "missing.txt", "missing")
+
+	versionHash := writeLegacy(t, bt.dataDir, ".versions/testuser/1", "older")
+	if _, err := bt.versions.Insert(ctx, &model.FileVersion{FileID: a, UserID: bt.user.ID, Version: 1, Size: 5, MimeType: "text/plain", Hash: versionHash}); err != nil {
+		t.Fatalf("failed to insert version: %v", err)
+	}
+
+	trashHash := writeLegacy(t, bt.dataDir, "testuser/trashed.txt", "trash")
+	trashedID := insertFile(bt.root, "trashed.txt", trashHash)
+	item, err := bt.trash.TrashFile(ctx, bt.user, trashedID)
+	if err != nil {
+		t.Fatalf("TrashFile failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(bt.dataDir, ".trash", "testuser"), 0755); err != nil {
+		t.Fatalf("failed to create trash dir: %v", err)
+	}
+	if err := os.Rename(filepath.Join(bt.dataDir, "testuser", "trashed.txt"), filepath.Join(bt.dataDir, ".trash", "testuser", fmt.Sprint(item.ID))); err != nil {
+		t.Fatalf("failed to move file to the trash: %v", err)
+	}
+
+	writeLegacy(t, bt.dataDir, "testuser/docs/stray.txt", "nobody knows me")
+	if err := os.MkdirAll(filepath.Join(bt.dataDir, "testuser", "empty"), 0755); err != nil {
+		t.Fatalf("failed to create empty dir: %v", err)
+	}
+
+	res, err := bt.blobs.MigrateLegacyLayout(ctx, legacy)
+	if err != nil {
+		t.Fatalf("MigrateLegacyLayout failed: %v", err)
+	}
+	want := service.LegacyImport{Imported: 4, Missing: 1, Left: 1}
+	if *res != want {
+		t.Errorf("expected %+v, got %+v", want, *res)
+	}
+
+	for id, content := range map[int64]string{a: "hello", b: "world"} {
+		file, err := bt.files.GetFileById(ctx, id)
+		if err != nil {
+			t.Fatalf("file %d not found: %v", id, err)
+		}
+		if got := readFile(t, bt.files, bt.user, file); got != content {
+			t.Errorf("expected %q, got %q", content, got)
+		}
+	}
+	if file, _ := bt.files.GetFileById(ctx, b); file.Hash == "stale" || bt.refCount(t, file.Hash) != 1 {
+		t.Errorf("expected the hash of b.txt to be corrected, got %s", file.Hash)
+	}
+	if _, err := bt.trash.Restore(ctx, bt.user, item.ID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if rc, err := bt.st.OpenFile(trashHash); err != nil {
+		t.Errorf("expected trashed content in the store: %v", err)
+	} else {
+		_ = rc.Close()
+	}
+
+	for _, dir := range []string{".versions", ".trash", "testuser/empty"} {
+		if _, err := os.Stat(filepath.Join(bt.dataDir, dir)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to be removed, got %v", dir, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(bt.dataDir, "testuser", "docs", "stray.txt")); err != nil {
+		t.Errorf("expected unreferenced file to be kept: %v", err)
+	}
+
+	// A second run has nothing left to do but the stray file.
+	if res, err = bt.blobs.MigrateLegacyLayout(ctx, legacy); err != nil || res.Imported != 0 {
+		t.Errorf("expected nothing to import again, got %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+)
+
+var (
+	ErrDownloadLinkNotFound = errors.New("download link not found")
+	ErrDownloadLinkExpired  = errors.New("download link expired")
+	ErrDownloadLimitReached = errors.New("download limit of the link reached")
+	ErrInvalidMaxDownloads  = errors.New("max downloads must not be negative")
+)
+
+// DownloadLinkOptions are the settings of a new download link.
+type DownloadLinkOptions struct {
+	// Name defaults to the name of the folder or file.
+	Name string
+	// Password is empty for links anyone with the token may use.
+	Password string
+	// ExpiresAt is zero for links that do not expire.
+	ExpiresAt time.Time
+	// MaxDownloads is 0 for unlimited downloads.
+	MaxDownloads int64
+}
+
+// DownloadLinkService publishes folders and files read-only to people
+// without an account. Creating a link needs PermissionManage on the item,
+// like sharing it. Visitors get what the creator of the link may read, so a
+// link stops working once the creator loses access.
+type DownloadLinkService struct {
+	repo     *repository.DownloadLinkRepository
+	userRepo *repository.UserRepository
+	files    *PersonalFileService
+	folders  *FolderService
+	archives *ArchiveService
+	access   *AccessService
+	// secret signs the unlock proofs of links, see linkUnlockProof.
+	secret []byte
+}
+
+func NewDownloadLinkService(repo *repository.DownloadLinkRepository, userRepo *repository.UserRepository, files *PersonalFileService, folders *FolderService, archives *ArchiveService, access *AccessService, secret []byte) *DownloadLinkService {
+	return &DownloadLinkService{repo, userRepo, files, folders, archives, access, secret}
+}
+
+// CreateFolderLink creates a link to a folder with everything below it.
+func (s *DownloadLinkService) CreateFolderLink(ctx context.Context, user *model.User, folderID int64, opts DownloadLinkOptions) (*model.DownloadLink, error) {
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	if !folder.ParentID.Valid {
+		return nil, ErrCannotShareRoot
+	}
+	link := &model.DownloadLink{FolderID: sql.NullInt64{Int64: folder.ID, Valid: true}, ItemName: folder.Name}
+	return s.create(ctx, user, link, opts)
+}
+
+// CreateFileLink creates a link to a single file.
+func (s *DownloadLinkService) CreateFileLink(ctx context.Context, user *model.User, fileID int64, opts DownloadLinkOptions) (*model.DownloadLink, error) {
+	file, err := s.access.File(ctx, user.ID, fileID, model.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	link := &model.DownloadLink{FileID: sql.NullInt64{Int64: file.ID, Valid: true}, ItemName: file.Name}
+	return s.create(ctx, user, link, opts)
+}
+
+func (s *DownloadLinkService) create(ctx context.Context, user *model.User, link *model.DownloadLink, opts DownloadLinkOptions) (*model.DownloadLink, error) {
+	if opts.MaxDownloads < 0 {
+		return nil, ErrInvalidMaxDownloads
+	}
+	link.Name = strings.TrimSpace(opts.Name)
+	if link.Name == "" {
+		link.Name = link.ItemName
+	}
+	if opts.Password != "" {
+		hash, err := hashLinkPassword(opts.Password)
+		if err != nil {
+			return nil, err
+		}
+		link.HashedPassword = hash
+	}
+	tok, err := generateUploadToken()
+	if err != nil {
+		return nil, err
+	}
+	link.UserID, link.LinkToken, link.MaxDownloads = user.ID, tok, opts.MaxDownloads
+	if !opts.ExpiresAt.IsZero() {
+		link.ExpiresAt = sql.NullTime{Time: opts.ExpiresAt.UTC(), Valid: true}
+	}
+	id, err := s.repo.Insert(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	link.ID, link.CreatedAt = id, time.Now().UTC()
+	return link, nil
+}
+
+// GetUserLinks returns the download links created by the user.
+func (s *DownloadLinkService) GetUserLinks(ctx context.Context, user *model.User) ([]*model.DownloadLink, error) {
+	return s.repo.GetByUser(ctx, user.ID)
+}
+
+// GetFolderLinks returns the links of a folder by anyone who may manage it.
+func (s *DownloadLinkService) GetFolderLinks(ctx context.Context, user *model.User, folderID int64) ([]*model.DownloadLink, error) {
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByItem(ctx, sql.NullInt64{Int64: folder.ID, Valid: true}, sql.NullInt64{})
+}
+
+// GetFileLinks returns the links of a file by anyone who may manage it.
+func (s *DownloadLinkService) GetFileLinks(ctx context.Context, user *model.User, fileID int64) ([]*model.DownloadLink, error) {
+	file, err := s.access.File(ctx, user.ID, fileID, model.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByItem(ctx, sql.NullInt64{}, sql.NullInt64{Int64: file.ID, Valid: true})
+}
+
+// GetManagedLink returns the link if the user created it or may manage its
+// folder or file. Other links are reported as not found so their tokens do
+// not leak.
+func (s *DownloadLinkService) GetManagedLink(ctx context.Context, user *model.User, linkToken string) (*model.DownloadLink, error) {
+	link, err := s.repo.GetByToken(ctx, linkToken)
+	if err != nil {
+		return nil, ErrDownloadLinkNotFound
+	}
+	if link.UserID == user.ID {
+		return link, nil
+	}
+	if link.FolderID.Valid {
+		_, err = s.access.Folder(ctx, user.ID, link.FolderID.Int64, model.PermissionManage)
+	} else {
+		_, err = s.access.File(ctx, user.ID, link.FileID.Int64, model.PermissionManage)
+	}
+	if err != nil {
+		return nil, ErrDownloadLinkNotFound
+	}
+	return link, nil
+}
+
+// RevokeDownloadLink deletes a link, see GetManagedLink for who may.
+func (s *DownloadLinkService) RevokeDownloadLink(ctx context.Context, user *model.User, linkToken string) error {
+	link, err := s.GetManagedLink(ctx, user, linkToken)
+	if err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, link.ID)
+}
+
+// Open returns the link with the token for a visitor. It fails once the link
+// expired or all of its downloads are used up.
+func (s *DownloadLinkService) Open(ctx context.Context, linkToken string) (*model.DownloadLink, error) {
+	link, err := s.repo.GetByToken(ctx, linkToken)
+	if err != nil {
+		return nil, ErrDownloadLinkNotFound
+	}
+	if link.Expired(time.Now()) {
+		return nil, ErrDownloadLinkExpired
+	}
+	if link.Exhausted() {
+		return nil, ErrDownloadLimitReached
+	}
+	return link, nil
+}
+
+// ValidatePassword opens the link like Open and checks its password.
+func (s *DownloadLinkService) ValidatePassword(ctx context.Context, linkToken, plain string) (*model.DownloadLink, error) {
+	link, err := s.Open(ctx, linkToken)
+	if err != nil {
+		return nil, err
+	}
+	if !link.HasPassword() {
+		return link, nil
+	}
+	if err := checkLinkPassword(link.HashedPassword, plain); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// UnlockProof returns what a visitor keeps, in a cookie, to show they entered
+// the password of the link.
+func (s *DownloadLinkService) UnlockProof(link *model.DownloadLink) string {
+	return linkUnlockProof(s.secret, link.HashedPassword, link.LinkToken)
+}
+
+// Unlocked reports whether the link has not expired and either has no
+// password or proof came from UnlockProof.
+func (s *DownloadLinkService) Unlocked(link *model.DownloadLink, proof string) bool {
+	if link.Expired(time.Now()) {
+		return false
+	}
+	if !link.HasPassword() {
+		return true
+	}
+	return checkLinkUnlockProof(s.secret, link.HashedPassword, link.LinkToken, proof)
+}
+
+// Folder returns a folder of a folder link with its contents. folderID 0 is
+// the linked folder itself, other folders must be below it.
+func (s *DownloadLinkService) Folder(ctx context.Context, link *model.DownloadLink, folderID int64) (*model.Folder, []*model.Folder, []*model.File, error) {
+	creator, err := s.creator(ctx, link)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	folder, err := s.folder(ctx, link, creator, folderID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	folders, files, err := s.folders.GetFolderContents(ctx, creator.ID, folder.ID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return folder, folders, files, nil
+}
+
+// File returns a file of the link. fileID 0 is the file of a file link,
+// other files must be below the folder of a folder link.
+func (s *DownloadLinkService) File(ctx context.Context, link *model.DownloadLink, fileID int64) (*model.File, error) {
+	creator, err := s.creator(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	return s.file(ctx, link, creator, fileID)
+}
+
+// Download opens a file of the link like File for sending. It counts as one
+// download once its content is read from the first byte, so range requests
+// that resume a download or seek in media, and revalidations answered with
+// 304 Not Modified, do not use up the downloads of the link.
+func (s *DownloadLinkService) Download(ctx context.Context, link *model.DownloadLink, fileID int64) (*Download, error) {
+	if link.Exhausted() {
+		return nil, ErrDownloadLimitReached
+	}
+	creator, err := s.creator(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	file, err := s.file(ctx, link, creator, fileID)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.files.Download(ctx, creator, file)
+	if err != nil {
+		return nil, err
+	}
+	d.Content = &countedContent{ReadSeekCloser: d.Content, count: func() error { return s.count(ctx, link) }}
+	return d, nil
+}
+
+// countedContent calls count before the first read from offset 0. Reading
+// fails if count does, like when the last download was used up meanwhile.
+type countedContent struct {
+	io.ReadSeekCloser
+	count   func() error
+	pos     int64
+	counted bool
+}
+
+func (c *countedContent) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.ReadSeekCloser.Seek(offset, whence)
+	if err == nil {
+		c.pos = pos
+	}
+	return pos, err
+}
+
+func (c *countedContent) Read(p []byte) (int, error) {
+	if !c.counted && c.pos == 0 {
+		if err := c.count(); err != nil {
+			return 0, err
+		}
+		c.counted = true
+	}
+	n, err := c.ReadSeekCloser.Read(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+// Archive returns an archive of a folder of the link like Folder and counts
+// it as one download.
+func (s *DownloadLinkService) Archive(ctx context.Context, link *model.DownloadLink, folderID int64, format ArchiveFormat) (*Archive, error) {
+	creator, err := s.creator(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := s.folder(ctx, link, creator, folderID)
+	if err != nil {
+		return nil, err
+	}
+	a, err := s.archives.Folder(ctx, creator, folder.ID, format)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.count(ctx, link); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *DownloadLinkService) count(ctx context.Context, link *model.DownloadLink) error {
+	ok, err := s.repo.CountDownload(ctx, link.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDownloadLimitReached
+	}
+	link.Downloads++
+	return nil
+}
+
+func (s *DownloadLinkService) creator(ctx context.Context, link *model.DownloadLink) (*model.User, error) {
+	user, err := s.userRepo.GetByID(ctx, link.UserID)
+	if err != nil {
+		return nil, ErrDownloadLinkNotFound
+	}
+	return user, nil
+}
+
+func (s *DownloadLinkService) folder(ctx context.Context, link *model.DownloadLink, creator *model.User, folderID int64) (*model.Folder, error) {
+	if !link.FolderID.Valid {
+		return nil, ErrFolderNotFound
+	}
+	root, err := s.access.Folder(ctx, creator.ID, link.FolderID.Int64, model.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	if folderID == 0 || folderID == root.ID {
+		return root, nil
+	}
+	folder, err := s.access.Folder(ctx, creator.ID, folderID, model.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	if folder.UserID != root.UserID || !strings.HasPrefix(folder.Path, root.Path+"/") {
+		return nil, ErrFolderNotFound
+	}
+	return folder, nil
+}
+
+func (s *DownloadLinkService) file(ctx context.Context, link *model.DownloadLink, creator *model.User, fileID int64) (*model.File, error) {
+	if link.FileID.Valid {
+		if fileID != 0 && fileID != link.FileID.Int64 {
+			return nil, ErrFileNotFound
+		}
+		return s.access.File(ctx, creator.ID, link.FileID.Int64, model.PermissionRead)
+	}
+	if fileID == 0 {
+		return nil, ErrFileNotFound
+	}
+	root, err := s.folder(ctx, link, creator, 0)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	file, err := s.access.File(ctx, creator.ID, fileID, model.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	if file.UserID != root.UserID || !strings.HasPrefix(file.Location, root.Path+"/") {
+		return nil, ErrFileNotFound
+	}
+	return file, nil
+}
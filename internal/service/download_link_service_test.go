@@ -0,0 +1,321 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+func readDownload(t *testing.T, d *service.Download) string {
+	t.Helper()
+	defer d.Content.Close()
+	b, err := io.ReadAll(d.Content)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	return string(b)
+}
+
+func TestDownloadLink_FolderSubtree(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	sub, err := tt.folders.CreateFolder(ctx, tt.alice.ID, "alice", tt.docsID, "2024", "")
+	if err != nil {
+		t.Fatalf("failed to create subfolder: %v", err)
+	}
+	report := tt.store(t, tt.alice, sub.ID, "report.txt", "quarterly numbers")
+	secret := tt.store(t, tt.alice, tt.aliceRoot, "secret.txt", "not linked")
+
+	created, err := tt.links.CreateFolderLink(ctx, tt.alice, tt.docsID, service.DownloadLinkOptions{})
+	if err != nil {
+		t.Fatalf("CreateFolderLink failed: %v", err)
+	}
+	if created.Name != "docs" {
+		t.Errorf("expected the link to be named after the folder, got %q", created.Name)
+	}
+	link, err := tt.links.Open(ctx, created.LinkToken)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	folder, folders, _, err := tt.links.Folder(ctx, link, 0)
+	if err != nil {
+		t.Fatalf("Folder failed: %v", err)
+	}
+	if folder.ID != tt.docsID || len(folders) != 1 || folders[0].ID != sub.ID {
+		t.Errorf("expected docs with 2024 in it, got %q with %d folders", folder.Name, len(folders))
+	}
+	if _, _, files, err := tt.links.Folder(ctx, link, sub.ID); err != nil || len(files) != 1 {
+		t.Errorf("expected report.txt in the subfolder, got %d files, %v", len(files), err)
+	}
+	if _, _, _, err := tt.links.Folder(ctx, link, tt.aliceRoot); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound above the linked folder, got %v", err)
+	}
+
+	d, err := tt.links.Download(ctx, link, report.ID)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if got := readDownload(t, d); got != "quarterly numbers" {
+		t.Errorf("expected the linked content, got %q", got)
+	}
+	if _, err := tt.links.Download(ctx, link, secret.ID); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound outside the linked folder, got %v", err)
+	}
+
+	a, err := tt.links.Archive(ctx, link, 0, service.ArchiveZip)
+	if err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := a.Write(&buf); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if !names["docs/2024/report.txt"] || names["secret.txt"] {
+		t.Errorf("unexpected archive entries: %v", names)
+	}
+
+	stored, err := tt.links.Open(ctx, link.LinkToken)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if stored.Downloads != 2 {
+		t.Errorf("expected the download and the archive to be counted, got %d", stored.Downloads)
+	}
+}
+
+func TestDownloadLink_File(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	file := tt.store(t, tt.alice, tt.docsID, "a.txt", "linked")
+	other := tt.store(t, tt.alice, tt.docsID, "b.txt", "not linked")
+
+	link, err := tt.links.CreateFileLink(ctx, tt.alice, file.ID, service.DownloadLinkOptions{Name: "For Bob"})
+	if err != nil {
+		t.Fatalf("CreateFileLink failed: %v", err)
+	}
+	d, err := tt.links.Download(ctx, link, 0)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if got := readDownload(t, d); got != "linked" {
+		t.Errorf("expected the linked content, got %q", got)
+	}
+	if _, err := tt.links.Download(ctx, link, other.ID); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for another file, got %v", err)
+	}
+	if _, _, _, err := tt.links.Folder(ctx, link, tt.docsID); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound for the folder of a file link, got %v", err)
+	}
+}
+
+func TestDownloadLink_Password(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	link, err := tt.links.CreateFolderLink(ctx, tt.alice, tt.docsID, service.DownloadLinkOptions{Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateFolderLink failed: %v", err)
+	}
+	if link.HashedPassword == "secret" {
+		t.Fatal("password stored in plain text")
+	}
+	if tt.links.Unlocked(link, "") {
+		t.Error("expected a link with password to be locked without proof")
+	}
+	if _, err := tt.links.ValidatePassword(ctx, link.LinkToken, "wrong"); !errors.Is(err, service.ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, got %v", err)
+	}
+	unlocked, err := tt.links.ValidatePassword(ctx, link.LinkToken, "secret")
+	if err != nil {
+		t.Fatalf("ValidatePassword failed: %v", err)
+	}
+	if !tt.links.Unlocked(link, tt.links.UnlockProof(unlocked)) {
+		t.Error("expected the proof of the password to unlock the link")
+	}
+
+	other, err := tt.links.CreateFolderLink(ctx, tt.alice, tt.docsID, service.DownloadLinkOptions{Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateFolderLink failed: %v", err)
+	}
+	if tt.links.Unlocked(other, tt.links.UnlockProof(link)) {
+		t.Error("expected the proof of one link not to unlock another")
+	}
+	expired := *unlocked
+	expired.ExpiresAt = sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}
+	if tt.links.Unlocked(&expired, tt.links.UnlockProof(unlocked)) {
+		t.Error("expected an expired link to stay locked")
+	}
+
+	open, err := tt.links.CreateFolderLink(ctx, tt.alice, tt.docsID, service.DownloadLinkOptions{})
+	if err != nil {
+		t.Fatalf("CreateFolderLink failed: %v", err)
+	}
+	if !tt.links.Unlocked(open, "") {
+		t.Error("expected a link without password to be unlocked")
+	}
+}
+
+// serveLink sends a file of the link like the handler does and returns the
+// status of the response.
+func serveLink(t *testing.T, tt *shareTest, link *model.DownloadLink, header, value string) int {
+	t.Helper()
+	d, err := tt.links.Download(testutil.TestContext(t), link, 0)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	defer d.Content.Close()
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"`+d.Hash+`"`)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	http.ServeContent(w, r, d.Name, d.ModTime, d.Content)
+	return w.Code
+}
+
+func TestDownloadLink_RangeRequests(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+	file := tt.store(t, tt.alice, tt.docsID, "video.mp4", "0123456789abcdef")
+
+	link, err := tt.links.CreateFileLink(ctx, tt.alice, file.ID, service.DownloadLinkOptions{MaxDownloads: 3})
+	if err != nil {
+		t.Fatalf("CreateFileLink failed: %v", err)
+	}
+	downloads := func() int64 {
+		t.Helper()
+		stored, err := tt.links.Open(ctx, link.LinkToken)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		return stored.Downloads
+	}
+
+	for _, tc := range []struct {
+		header, value string
+		status        int
+		downloads     int64
+	}{
+		{"", "", http.StatusOK, 1},
+		{"Range", "bytes=4-9", http.StatusPartialContent, 1},
+		{"Range", "bytes=10-", http.StatusPartialContent, 1},
+		{"If-None-Match", `"` + file.Hash + `"`, http.StatusNotModified, 1},
+		{"Range", "bytes=0-3", http.StatusPartialContent, 2},
+	} {
+		if got := serveLink(t, tt, link, tc.header, tc.value); got != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.header, tc.value, tc.status, got)
+		}
+		if got := downloads(); got != tc.downloads {
+			t.Errorf("%s %s: expected %d downloads, got %d", tc.header, tc.value, tc.downloads, got)
+		}
+	}
+}
+
+func TestDownloadLink_Limits(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+	file := tt.store(t, tt.alice, tt.docsID, "a.txt", "content")
+
+	t.Run("max downloads", func(t *testing.T) {
+		link, err := tt.links.CreateFileLink(ctx, tt.alice, file.ID, service.DownloadLinkOptions{MaxDownloads: 2})
+		if err != nil {
+			t.Fatalf("CreateFileLink failed: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			d, err := tt.links.Download(ctx, link, 0)
+			if err != nil {
+				t.Fatalf("download %d failed: %v", i+1, err)
+			}
+			readDownload(t, d)
+		}
+		if _, err := tt.links.Download(ctx, link, 0); !errors.Is(err, service.ErrDownloadLimitReached) {
+			t.Errorf("expected ErrDownloadLimitReached, got %v", err)
+		}
+		if _, err := tt.links.Open(ctx, link.LinkToken); !errors.Is(err, service.ErrDownloadLimitReached) {
+			t.Errorf("expected Open to fail with ErrDownloadLimitReached, got %v", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		link, err := tt.links.CreateFileLink(ctx, tt.alice, file.ID, service.DownloadLinkOptions{ExpiresAt: time.Now().Add(-time.Hour)})
+		if err != nil {
+			t.Fatalf("CreateFileLink failed: %v", err)
+		}
+		if _, err := tt.links.Open(ctx, link.LinkToken); !errors.Is(err, service.ErrDownloadLinkExpired) {
+			t.Errorf("expected ErrDownloadLinkExpired, got %v", err)
+		}
+	})
+
+	t.Run("negative max downloads", func(t *testing.T) {
+		_, err := tt.links.CreateFileLink(ctx, tt.alice, file.ID, service.DownloadLinkOptions{MaxDownloads: -1})
+		if !errors.Is(err, service.ErrInvalidMaxDownloads) {
+			t.Errorf("expected ErrInvalidMaxDownloads, got %v", err)
+		}
+	})
+
+	t.Run("root folder", func(t *testing.T) {
+		_, err := tt.links.CreateFolderLink(ctx, tt.alice, tt.aliceRoot, service.DownloadLinkOptions{})
+		if !errors.Is(err, service.ErrCannotShareRoot) {
+			t.Errorf("expected ErrCannotShareRoot, got %v", err)
+		}
+	})
+}
+
+func TestDownloadLink_FollowsAccessOfCreator(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	read := tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionRead)
+	if _, err := tt.links.CreateFolderLink(ctx, tt.bob, tt.docsID, service.DownloadLinkOptions{}); !errors.Is(err, service.ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied with read access, got %v", err)
+	}
+	tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionManage)
+	link, err := tt.links.CreateFolderLink(ctx, tt.bob, tt.docsID, service.DownloadLinkOptions{})
+	if err != nil {
+		t.Fatalf("CreateFolderLink with manage access failed: %v", err)
+	}
+
+	// Links are managed by their creator and everyone who may manage the item.
+	if err := tt.links.RevokeDownloadLink(ctx, tt.carol, link.LinkToken); !errors.Is(err, service.ErrDownloadLinkNotFound) {
+		t.Errorf("expected ErrDownloadLinkNotFound for carol, got %v", err)
+	}
+	if links, err := tt.links.GetFolderLinks(ctx, tt.alice, tt.docsID); err != nil || len(links) != 1 {
+		t.Errorf("expected alice to see the link of bob, got %d, %v", len(links), err)
+	}
+
+	if err := tt.shares.RevokeShare(ctx, tt.alice, read.ID); err != nil {
+		t.Fatalf("RevokeShare failed: %v", err)
+	}
+	if _, _, _, err := tt.links.Folder(ctx, link, 0); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected the link to stop working with the share, got %v", err)
+	}
+
+	if err := tt.links.RevokeDownloadLink(ctx, tt.alice, link.LinkToken); err != nil {
+		t.Fatalf("RevokeDownloadLink by the owner failed: %v", err)
+	}
+	if _, err := tt.links.Open(ctx, link.LinkToken); !errors.Is(err, service.ErrDownloadLinkNotFound) {
+		t.Errorf("expected ErrDownloadLinkNotFound after revoking, got %v", err)
+	}
+}
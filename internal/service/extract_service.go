@@ -0,0 +1,396 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+var (
+	ErrUnsupportedArchive = errors.New("not a zip, tar or tar.gz archive")
+	ErrUnsafeArchivePath  = errors.New("archive contains a path outside of its folder")
+	ErrArchiveTooLarge    = errors.New("archive exceeds the extraction limits")
+)
+
+// ExtractLimits protect against archives that unpack to far more than they
+// take up. 0 disables a limit.
+type ExtractLimits struct {
+	// MaxEntries is the number of files and folders an archive may contain.
+	MaxEntries int
+	// MaxSize is the number of bytes the files of an archive may add up to.
+	MaxSize int64
+}
+
+// Extraction summarizes an extracted archive.
+type Extraction struct {
+	// Folder is the new folder the archive was extracted into.
+	Folder  *model.Folder
+	Folders int
+	Files   int
+	Bytes   int64
+	// Skipped lists the entries that were left out: links, devices, invalid
+	// names, duplicates and files clashing with a folder.
+	Skipped []string
+}
+
+// ExtractService unpacks uploaded archives into a folder tree.
+type ExtractService struct {
+	folders *FolderService
+	files   *PersonalFileService
+	quota   *QuotaService
+	limits  ExtractLimits
+}
+
+func NewExtractService(folders *FolderService, files *PersonalFileService, quota *QuotaService, limits ExtractLimits) *ExtractService {
+	return &ExtractService{folders, files, quota, limits}
+}
+
+// extractEntry is one entry of an archive as it is read. open is only valid
+// until the next entry is read.
+type extractEntry struct {
+	name    string
+	dir     bool
+	regular bool
+	size    int64
+	open    func() (io.ReadCloser, error)
+}
+
+// plannedEntry is an entry that passed the checks, path is relative to the
+// folder the archive is extracted into.
+type plannedEntry struct {
+	path string
+	dir  bool
+}
+
+// Extract unpacks the ZIP, tar or tar.gz archive in src into a new folder in
+// dst, named after the single top level folder of the archive or else after
+// the archive. A number is appended if the name is taken. The archive is
+// spooled to a temporary file and checked as a whole before anything is
+// stored, so unsafe paths, archives beyond the limits or the quota fail
+// without leaving anything behind. A failure while storing removes the new
+// folder again.
+func (s *ExtractService) Extract(ctx context.Context, user *model.User, dst *model.Folder, name string, src io.Reader) (*Extraction, error) {
+	if err := s.folders.access.CheckFolder(ctx, user.ID, dst, model.PermissionWrite); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "go-cloud-extract-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool archive: %w", err)
+	}
+
+	res := &Extraction{}
+	plan := make(map[int]plannedEntry)
+	kinds := make(map[string]bool) // path -> dir
+	var total int64
+	count := 0
+	err = archiveEntries(tmp, size, func(i int, e *extractEntry) error {
+		count++
+		if s.limits.MaxEntries > 0 && count > s.limits.MaxEntries {
+			return ErrArchiveTooLarge
+		}
+		p, ok, err := entryPath(e.name)
+		if err != nil {
+			return err
+		}
+		if !ok || !e.regular && !e.dir {
+			if p != "" {
+				res.Skipped = append(res.Skipped, e.name)
+			}
+			return nil
+		}
+		if !plannable(kinds, p, e.dir) {
+			res.Skipped = append(res.Skipped, e.name)
+			return nil
+		}
+		if !e.dir {
+			total += e.size
+			if s.limits.MaxSize > 0 && total > s.limits.MaxSize {
+				return ErrArchiveTooLarge
+			}
+		}
+		plan[i] = plannedEntry{p, e.dir}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.quota.Check(ctx, dst.UserID, total); err != nil {
+		return nil, err
+	}
+
+	rootName, strip := topLevelFolder(plan)
+	if rootName == "" {
+		rootName = archiveBaseName(name)
+	}
+	root, err := s.createRoot(ctx, user, dst, rootName)
+	if err != nil {
+		return nil, err
+	}
+	res.Folder = root
+
+	created := map[string]*model.Folder{"": root}
+	err = archiveEntries(tmp, size, func(i int, e *extractEntry) error {
+		planned, ok := plan[i]
+		if !ok {
+			return nil
+		}
+		p := planned.path
+		if strip {
+			p = strings.TrimPrefix(strings.TrimPrefix(p, rootName), "/")
+		}
+		if planned.dir {
+			_, err := s.ensureFolder(ctx, user, created, p, res)
+			return err
+		}
+		dir, base := "", p
+		if slash := strings.LastIndex(p, "/"); slash >= 0 {
+			dir, base = p[:slash], p[slash+1:]
+		}
+		parent, err := s.ensureFolder(ctx, user, created, dir, res)
+		if err != nil {
+			return err
+		}
+		rc, err := e.open()
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", e.name, err)
+		}
+		defer rc.Close()
+		// The zip reader fails entries that hold more than their header
+		// says, so the checked total is an upper bound.
+		file, err := s.files.StoreFile(ctx, user, parent.ID, parent.Path, base, rc)
+		if err != nil {
+			return err
+		}
+		res.Files++
+		res.Bytes += file.Size
+		return nil
+	})
+	if err != nil {
+		if _, delErr := s.folders.DeleteFolder(ctx, user, root.ID); delErr != nil {
+			return nil, errors.Join(err, delErr)
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// archiveEntries calls fn for every entry of the archive in f with its index.
+func archiveEntries(f *os.File, size int64, fn func(int, *extractEntry) error) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrUnsupportedArchive
+	}
+	head = head[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		zr, err := zip.NewReader(f, size)
+		if err != nil {
+			return ErrUnsupportedArchive
+		}
+		for i, zf := range zr.File {
+			mode := zf.Mode()
+			e := &extractEntry{
+				name:    zf.Name,
+				dir:     mode.IsDir(),
+				regular: mode.IsRegular(),
+				size:    int64(zf.UncompressedSize64),
+				open:    zf.Open,
+			}
+			if err := fn(i, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return ErrUnsupportedArchive
+		}
+		defer gr.Close()
+		return tarEntries(gr, fn)
+	case len(head) > 262 && string(head[257:262]) == "ustar":
+		return tarEntries(f, fn)
+	}
+	return ErrUnsupportedArchive
+}
+
+func tarEntries(r io.Reader, fn func(int, *extractEntry) error) error {
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+		}
+		e := &extractEntry{
+			name:    hdr.Name,
+			dir:     hdr.Typeflag == tar.TypeDir,
+			regular: hdr.Typeflag == tar.TypeReg,
+			size:    hdr.Size,
+			open:    func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if err := fn(i, e); err != nil {
+			return err
+		}
+	}
+}
+
+// entryPath cleans the name of an entry. Paths escaping the archive fail with
+// ErrUnsafeArchivePath, names no file or folder may have are not ok.
+func entryPath(name string) (string, bool, error) {
+	p := strings.TrimSuffix(name, "/")
+	for strings.HasPrefix(p, "./") {
+		p = p[2:]
+	}
+	if p == "" || p == "." {
+		return "", false, nil
+	}
+	if strings.HasPrefix(p, "/") || strings.Contains(p, "\\") {
+		return "", false, fmt.Errorf("%w: %q", ErrUnsafeArchivePath, name)
+	}
+	ok := true
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", false, fmt.Errorf("%w: %q", ErrUnsafeArchivePath, name)
+		}
+		if seg == "" || seg == "." || strings.ContainsAny(seg, invalidNameChars) {
+			ok = false
+		}
+	}
+	return p, ok, nil
+}
+
+// plannable records p in kinds and reports whether it can be extracted. A
+// file may not share its path with another entry, and no entry may be below
+// a file.
+func plannable(kinds map[string]bool, p string, dir bool) bool {
+	segs := strings.Split(p, "/")
+	for i := 1; i < len(segs); i++ {
+		parent := strings.Join(segs[:i], "/")
+		if isDir, seen := kinds[parent]; seen && !isDir {
+			return false
+		}
+	}
+	if isDir, seen := kinds[p]; seen {
+		// Folders listed twice are harmless.
+		return dir && isDir
+	}
+	for i := 1; i < len(segs); i++ {
+		kinds[strings.Join(segs[:i], "/")] = true
+	}
+	kinds[p] = dir
+	return true
+}
+
+// topLevelFolder returns the name of the folder all planned entries are in,
+// if there is exactly one, and whether it is to be stripped from their paths.
+func topLevelFolder(plan map[int]plannedEntry) (string, bool) {
+	top := ""
+	for _, e := range plan {
+		first, _, nested := strings.Cut(e.path, "/")
+		if !nested && !e.dir {
+			return "", false
+		}
+		if top != "" && first != top {
+			return "", false
+		}
+		top = first
+	}
+	return top, top != ""
+}
+
+// archiveBaseName returns the file name of an archive without its extension.
+func archiveBaseName(name string) string {
+	if i := strings.LastIndexAny(name, "/\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, invalidNameChars) {
+		return "archive"
+	}
+	return name
+}
+
+// createRoot creates the folder name in dst, with a number appended if a file
+// or folder of that name already exists.
+func (s *ExtractService) createRoot(ctx context.Context, user *model.User, dst *model.Folder, name string) (*model.Folder, error) {
+	folders, files, err := s.folders.GetFolderContents(ctx, user.ID, dst.ID)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool)
+	for _, f := range folders {
+		taken[f.Name] = true
+	}
+	for _, f := range files {
+		taken[f.Name] = true
+	}
+	candidate := name
+	for i := 2; taken[candidate]; i++ {
+		candidate = name + " (" + strconv.Itoa(i) + ")"
+	}
+	return s.createFolder(ctx, user, dst, candidate)
+}
+
+func (s *ExtractService) createFolder(ctx context.Context, user *model.User, parent *model.Folder, name string) (*model.Folder, error) {
+	return s.folders.CreateFolder(ctx, user.ID, user.Username, parent.ID, name, "/"+s.folders.converter.JoinDBPath(parent.Path, name))
+}
+
+// ensureFolder returns the folder at p below the extraction root, creating
+// it and any missing parents.
+func (s *ExtractService) ensureFolder(ctx context.Context, user *model.User, created map[string]*model.Folder, p string, res *Extraction) (*model.Folder, error) {
+	if f, ok := created[p]; ok {
+		return f, nil
+	}
+	parentPath, name := "", p
+	if slash := strings.LastIndex(p, "/"); slash >= 0 {
+		parentPath, name = p[:slash], p[slash+1:]
+	}
+	parent, err := s.ensureFolder(ctx, user, created, parentPath, res)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.createFolder(ctx, user, parent, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folder %q: %w", p, err)
+	}
+	created[p] = f
+	res.Folders++
+	return f, nil
+}
@@ -0,0 +1,275 @@
+package service_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type extractTest struct {
+	extract *service.ExtractService
+	files   *service.PersonalFileService
+	folders *service.FolderService
+	user    *model.User
+	root    *model.Folder
+}
+
+func setupExtractTest(t *testing.T, limits service.ExtractLimits, quota int64) *extractTest {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+	c := path.New(tmpDir)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+
+	quotaSvc := service.NewQuotaService(userRepo, quota)
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	et := &extractTest{
+		files:   service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, quotaSvc, folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c),
+		folders: folderSvc,
+	}
+	et.extract = service.NewExtractService(et.folders, et.files, quotaSvc, limits)
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	et.user = &model.User{ID: userID, Username: "testuser"}
+	if et.root, err = et.folders.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/"); err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	return et
+}
+
+// tree returns the paths below folder, folders with a trailing slash and
+// files with their content.
+func (et *extractTest) tree(t *testing.T, folder *model.Folder) map[string]string {
+	t.Helper()
+	res := make(map[string]string)
+	var walk func(f *model.Folder, prefix string)
+	walk = func(f *model.Folder, prefix string) {
+		folders, files, err := et.folders.GetFolderContents(testutil.TestContext(t), et.user.ID, f.ID)
+		if err != nil {
+			t.Fatalf("GetFolderContents failed: %v", err)
+		}
+		for _, file := range files {
+			rc, err := et.files.OpenFile(testutil.TestContext(t), et.user, file)
+			if err != nil {
+				t.Fatalf("OpenFile failed: %v", err)
+			}
+			content, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			res[prefix+file.Name] = string(content)
+		}
+		for _, sub := range folders {
+			res[prefix+sub.Name+"/"] = ""
+			walk(sub, prefix+sub.Name+"/")
+		}
+	}
+	walk(folder, "")
+	return res
+}
+
+type archiveFile struct {
+	name    string
+	content string
+}
+
+func makeZip(t *testing.T, files ...archiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatalf("could not create zip entry: %v", err)
+		}
+		_, _ = w.Write([]byte(f.content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("could not close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// makeTarGz writes names ending in a slash as folders and names starting with
+// an @ as links to their content.
+func makeTarGz(t *testing.T, files ...archiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(f.content))}
+		switch {
+		case strings.HasSuffix(f.name, "/"):
+			hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+		case strings.HasPrefix(f.name, "@"):
+			hdr.Name, hdr.Typeflag, hdr.Linkname, hdr.Size = f.name[1:], tar.TypeSymlink, f.content, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("could not write tar header: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			_, _ = tw.Write([]byte(f.content))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("could not close tar: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("could not close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestExtractService_Zip(t *testing.T) {
+	et := setupExtractTest(t, service.ExtractLimits{}, 0)
+	ctx := testutil.TestContext(t)
+
+	data := makeZip(t,
+		archiveFile{"project/", ""},
+		archiveFile{"project/README.md", "readme"},
+		archiveFile{"project/src/main.go", "package main"},
+		archiveFile{"project/docs/guide/intro.txt", "intro"},
+	)
+	res, err := et.extract.Extract(ctx, et.user, et.root, "upload.zip", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if res.Folder.Name != "project" || res.Files != 3 || res.Folders != 3 || res.Bytes != 23 {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	got := et.tree(t, et.root)
+	want := map[string]string{
+		"project/":                     "",
+		"project/README.md":            "readme",
+		"project/src/":                 "",
+		"project/src/main.go":          "package main",
+		"project/docs/":                "",
+		"project/docs/guide/":          "",
+		"project/docs/guide/intro.txt": "intro",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", sortedKeys(want), sortedKeys(got))
+	}
+	sub, err := et.folders.GetByDBPath(ctx, et.user.ID, "project/docs/guide")
+	if err != nil || sub.UserID != et.user.ID {
+		t.Errorf("expected the nested folder to be stored under its path, got %v", err)
+	}
+}
+
+func TestExtractService_TarGzNamedAfterArchive(t *testing.T) {
+	et := setupExtractTest(t, service.ExtractLimits{}, 0)
+	ctx := testutil.TestContext(t)
+
+	if _, err := et.folders.CreateFolder(ctx, et.user.ID, "testuser", et.root.ID, "photos", "/photos"); err != nil {
+		t.Fatalf("CreateFolder failed: %v", err)
+	}
+	data := makeTarGz(t,
+		archiveFile{"./", ""},
+		archiveFile{"./a.jpg", "a"},
+		archiveFile{"./b/", ""},
+		archiveFile{"@link", "/etc/passwd"},
+		archiveFile{"a.jpg", "duplicate"},
+		archiveFile{"b", "clashes with the folder"},
+		archiveFile{"a.jpg/c.jpg", "below a file"},
+		archiveFile{"what?.txt", "invalid name"},
+	)
+	res, err := et.extract.Extract(ctx, et.user, et.root, "photos.tar.gz", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if res.Folder.Name != "photos (2)" {
+		t.Errorf("expected a new folder next to the existing one, got %q", res.Folder.Name)
+	}
+	want := []string{"link", "a.jpg", "b", "a.jpg/c.jpg", "what?.txt"}
+	if !reflect.DeepEqual(res.Skipped, want) {
+		t.Errorf("expected %v to be skipped, got %v", want, res.Skipped)
+	}
+	got := et.tree(t, res.Folder)
+	if !reflect.DeepEqual(got, map[string]string{"a.jpg": "a", "b/": ""}) {
+		t.Errorf("unexpected contents %v", got)
+	}
+}
+
+func TestExtractService_UnsafePaths(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "docs/../../evil.txt", "/etc/evil", "docs\\..\\evil.txt"} {
+		t.Run(name, func(t *testing.T) {
+			et := setupExtractTest(t, service.ExtractLimits{}, 0)
+			data := makeZip(t, archiveFile{"ok.txt", "fine"}, archiveFile{name, "evil"})
+			if _, err := et.extract.Extract(testutil.TestContext(t), et.user, et.root, "evil.zip", bytes.NewReader(data)); !errors.Is(err, service.ErrUnsafeArchivePath) {
+				t.Errorf("expected ErrUnsafeArchivePath, got %v", err)
+			}
+			if got := et.tree(t, et.root); len(got) != 0 {
+				t.Errorf("expected nothing to be stored, got %v", got)
+			}
+		})
+	}
+}
+
+func TestExtractService_Limits(t *testing.T) {
+	ctx := testutil.TestContext(t)
+	data := makeZip(t, archiveFile{"a.txt", "12345"}, archiveFile{"b.txt", "12345"}, archiveFile{"c.txt", "12345"})
+
+	et := setupExtractTest(t, service.ExtractLimits{MaxEntries: 2}, 0)
+	if _, err := et.extract.Extract(ctx, et.user, et.root, "many.zip", bytes.NewReader(data)); !errors.Is(err, service.ErrArchiveTooLarge) {
+		t.Errorf("expected ErrArchiveTooLarge for too many entries, got %v", err)
+	}
+
+	et = setupExtractTest(t, service.ExtractLimits{MaxSize: 14}, 0)
+	if _, err := et.extract.Extract(ctx, et.user, et.root, "big.zip", bytes.NewReader(data)); !errors.Is(err, service.ErrArchiveTooLarge) {
+		t.Errorf("expected ErrArchiveTooLarge for too many bytes, got %v", err)
+	}
+
+	et = setupExtractTest(t, service.ExtractLimits{}, 14)
+	if _, err := et.extract.Extract(ctx, et.user, et.root, "big.zip", bytes.NewReader(data)); !errors.Is(err, service.ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if got := et.tree(t, et.root); len(got) != 0 {
+		t.Errorf("expected nothing to be stored, got %v", got)
+	}
+
+	et = setupExtractTest(t, service.ExtractLimits{MaxEntries: 3, MaxSize: 15}, 15)
+	if _, err := et.extract.Extract(ctx, et.user, et.root, "fits.zip", bytes.NewReader(data)); err != nil {
+		t.Errorf("expected an archive at the limits to be extracted, got %v", err)
+	}
+}
+
+func TestExtractService_Unsupported(t *testing.T) {
+	et := setupExtractTest(t, service.ExtractLimits{}, 0)
+	if _, err := et.extract.Extract(testutil.TestContext(t), et.user, et.root, "notes.zip", strings.NewReader("just some text")); !errors.Is(err, service.ErrUnsupportedArchive) {
+		t.Errorf("expected ErrUnsupportedArchive, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/storage"
+)
+
+var ErrVersionNotFound = errors.New("file version not found")
+
+// VersionPolicy decides how long replaced file contents are kept. A zero
+// value in either field disables that limit.
+type VersionPolicy struct {
+	// Keep is the number of older versions kept per file.
+	Keep int
+	// MaxAge is how long a version is kept after it was replaced.
+	MaxAge time.Duration
+}
+
+// FileVersionService keeps the previous content of a file whenever a new
+// upload replaces it. Versions only refer to their content by hash, the
+// content itself stays in storage as long as anything uses it.
+type FileVersionService struct {
+	repo     *repository.FileVersionRepository
+	fileRepo *repository.PersonalFileRepository
+	access   *AccessService
+	st       storage.FileManager
+	policy   VersionPolicy
+}
+
+func NewFileVersionService(repo *repository.FileVersionRepository, fileRepo *repository.PersonalFileRepository, access *AccessService, st storage.FileManager, policy VersionPolicy) *FileVersionService {
+	return &FileVersionService{repo, fileRepo, access, st, policy}
+}
+
+// Archive keeps the current content of file as an older version. It has to run
+// before the file record gets the new content.
+func (s *FileVersionService) Archive(ctx context.Context, file *model.File) error {
+	_, err := s.repo.Insert(ctx, &model.FileVersion{
+		FileID:    file.ID,
+		UserID:    file.UserID,
+		Version:   file.Version,
+		Size:      file.Size,
+		MimeType:  file.MimeType,
+		Hash:      file.Hash,
+		CreatedAt: file.ContentCreatedAt(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record version %d of %q: %w", file.Version, file.Name, err)
+	}
+	return s.pruneFile(ctx, file.ID)
+}
+
+// pruneFile drops the versions of a file beyond the number the policy keeps.
+func (s *FileVersionService) pruneFile(ctx context.Context, fileID int64) error {
+	if s.policy.Keep <= 0 {
+		return nil
+	}
+	versions, err := s.repo.GetByFile(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions[min(s.policy.Keep, len(versions)):] {
+		if err := s.repo.Delete(ctx, v.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetHistory returns a file together with its older versions, newest first.
+func (s *FileVersionService) GetHistory(ctx context.Context, user *model.User, fileID int64) (*model.File, []*model.FileVersion, error) {
+	file, err := s.access.File(ctx, user.ID, fileID, model.PermissionRead)
+	if err != nil {
+		return nil, nil, err
+	}
+	versions, err := s.repo.GetByFile(ctx, file.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, versions, nil
+}
+
+// GetVersion returns an older version of a file.
+func (s *FileVersionService) GetVersion(ctx context.Context, user *model.User, fileID int64, version int) (*model.FileVersion, error) {
+	if _, err := s.access.File(ctx, user.ID, fileID, model.PermissionRead); err != nil {
+		return nil, err
+	}
+	v, err := s.repo.GetByFileAndVersion(ctx, fileID, version)
+	if err != nil {
+		return nil, ErrVersionNotFound
+	}
+	return v, nil
+}
+
+// OpenVersion opens the stored bytes of an older version for reading.
+func (s *FileVersionService) OpenVersion(ctx context.Context, user *model.User, v *model.FileVersion) (io.ReadSeekCloser, error) {
+	if _, err := s.access.File(ctx, user.ID, v.FileID, model.PermissionRead); err != nil {
+		return nil, ErrVersionNotFound
+	}
+	return s.st.OpenFile(v.Hash)
+}
+
+// DownloadVersion opens an older version for sending under the current name
+// of its file.
+func (s *FileVersionService) DownloadVersion(ctx context.Context, user *model.User, v *model.FileVersion) (*Download, error) {
+	file, err := s.access.File(ctx, user.ID, v.FileID, model.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := s.OpenVersion(ctx, user, v)
+	if err != nil {
+		return nil, err
+	}
+	return &Download{
+		Content:  rc,
+		Name:     file.Name,
+		MimeType: v.MimeType,
+		Hash:     v.Hash,
+		Size:     v.Size,
+		ModTime:  v.CreatedAt,
+	}, nil
+}
+
+// Prune deletes the versions of all users that are older than the policy
+// allows at now, and the versions of files that were deleted for good. It
+// returns the number of deleted versions.
+func (s *FileVersionService) Prune(ctx context.Context, now time.Time) (int, error) {
+	versions, err := s.repo.GetOrphaned(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if s.policy.MaxAge > 0 {
+		expired, err := s.repo.GetArchivedBefore(ctx, now.Add(-s.policy.MaxAge))
+		if err != nil {
+			return 0, err
+		}
+		versions = append(versions, expired...)
+	}
+
+	deleted := map[int64]bool{}
+	for _, v := range versions {
+		if deleted[v.ID] {
+			continue
+		}
+		if err := s.repo.Delete(ctx, v.ID); err != nil {
+			return len(deleted), fmt.Errorf("failed to delete version %d of file %d: %w", v.Version, v.FileID, err)
+		}
+		deleted[v.ID] = true
+	}
+	return len(deleted), nil
+}
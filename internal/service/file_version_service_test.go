@@ -0,0 +1,271 @@
+package service_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+func setupFileVersionTest(t *testing.T, policy service.VersionPolicy) (*service.FileVersionService, *service.PersonalFileService, *model.User, int64) {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+	c := path.New(tmpDir)
+
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, policy)
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	fileSvc := service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c)
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	user := &model.User{ID: userID, Username: "testuser"}
+
+	root, err := folderSvc.CreateFolder(ctx, userID, user.Username, -1, user.Username, "/")
+	if err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	return versionSvc, fileSvc, user, root.ID
+}
+
+func readVersion(t *testing.T, versions *service.FileVersionService, user *model.User, v *model.FileVersion) string {
+	t.Helper()
+	rc, err := versions.OpenVersion(testutil.TestContext(t), user, v)
+	if err != nil {
+		t.Fatalf("could not open version %d: %v", v.Version, err)
+	}
+	defer func() { _ = rc.Close() }()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("could not read version %d: %v", v.Version, err)
+	}
+	return string(b)
+}
+
+func TestFileVersionService_KeepsReplacedContent(t *testing.T) {
+	versions, files, user, rootID := setupFileVersionTest(t, service.VersionPolicy{})
+	ctx := testutil.TestContext(t)
+
+	first, err := files.StoreFile(ctx, user, rootID, "", "notes.txt", strings.NewReader("first draft"))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	second, err := files.StoreFile(ctx, user, rootID, "", "notes.txt", strings.NewReader("second draft"))
+	if err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+	if second.ID != first.ID || second.Version != 2 {
+		t.Fatalf("expected file %d at version 2, got file %d at version %d", first.ID, second.ID, second.Version)
+	}
+	again, err := files.StoreFile(ctx, user, rootID, "", "notes.txt", strings.NewReader("second draft"))
+	if err != nil {
+		t.Fatalf("failed to upload the same content again: %v", err)
+	}
+	if again.Version != 2 {
+		t.Errorf("expected the same content to stay version 2, got version %d", again.Version)
+	}
+
+	_, history, err := versions.GetHistory(ctx, user, second.ID)
+	if err != nil {
+		t.Fatalf("GetHistory failed: %v", err)
+	}
+	if len(history) != 1 || history[0].Version != 1 {
+		t.Fatalf("expected version 1 in the history, got %+v", history)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("first draft"))); history[0].Hash != want {
+		t.Errorf("expected hash %s, got %s", want, history[0].Hash)
+	}
+	if got := readVersion(t, versions, user, history[0]); got != "first draft" {
+		t.Errorf("version 1 content = %q, want %q", got, "first draft")
+	}
+
+	other := &model.User{ID: user.ID + 1, Username: "other"}
+	if _, _, err := versions.GetHistory(ctx, other, second.ID); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for another user, got %v", err)
+	}
+	if _, err := versions.GetVersion(ctx, user, second.ID, 2); !errors.Is(err, service.ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound for the current version, got %v", err)
+	}
+}
+
+func TestFileVersionService_RestoreVersion(t *testing.T) {
+	versions, files, user, rootID := setupFileVersionTest(t, service.VersionPolicy{})
+	ctx := testutil.TestContext(t)
+
+	if _, err := files.StoreFile(ctx, user, rootID, "", "notes.txt", strings.NewReader("good")); err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	bad, err := files.StoreFile(ctx, user, rootID, "", "notes.txt", strings.NewReader("broken"))
+	if err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+
+	restored, err := files.RestoreVersion(ctx, user, bad.ID, 1)
+	if err != nil {
+		t.Fatalf("RestoreVersion failed: %v", err)
+	}
+	if restored.Version != 3 || restored.Hash != fmt.Sprintf("%x", sha256.Sum256([]byte("good"))) {
+		t.Errorf("expected version 3 with the content of version 1, got %+v", restored)
+	}
+
+	_, history, err := versions.GetHistory(ctx, user, bad.ID)
+	if err != nil {
+		t.Fatalf("GetHistory failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Version != 2 || history[1].Version != 1 {
+		t.Fatalf("expected versions 2 and 1 in the history, got %+v", history)
+	}
+	if got := readVersion(t, versions, user, history[0]); got != "broken" {
+		t.Errorf("version 2 content = %q, want %q", got, "broken")
+	}
+
+	if _, err := files.RestoreVersion(ctx, user, bad.ID, 7); !errors.Is(err, service.ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound, got %v", err)
+	}
+}
+
+func TestFileVersionService_Download(t *testing.T) {
+	versions, files, user, rootID := setupFileVersionTest(t, service.VersionPolicy{})
+	ctx := testutil.TestContext(t)
+
+	if _, err := files.StoreFile(ctx, user, rootID, "", "notes.txt", strings.NewReader("first draft")); err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	file, err := files.StoreFile(ctx, user, rootID, "", "notes.txt", strings.NewReader("<p>second draft</p>"))
+	if err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+
+	d, err := files.Download(ctx, user, file)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	defer func() { _ = d.Content.Close() }()
+	if d.Name != "notes.txt" || d.Hash != file.Hash || d.Size != 19 || d.MimeType != file.MimeType || !d.ModTime.Equal(file.ContentCreatedAt()) {
+		t.Errorf("unexpected download %+v for file %+v", d, file)
+	}
+	if _, err := d.Content.Seek(3, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if b, _ := io.ReadAll(d.Content); string(b) != "second draft</p>" {
+		t.Errorf("expected the content after the offset, got %q", b)
+	}
+
+	v, err := versions.GetVersion(ctx, user, file.ID, 1)
+	if err != nil {
+		t.Fatalf("GetVersion failed: %v", err)
+	}
+	vd, err := versions.DownloadVersion(ctx, user, v)
+	if err != nil {
+		t.Fatalf("DownloadVersion failed: %v", err)
+	}
+	defer func() { _ = vd.Content.Close() }()
+	if vd.Name != "notes.txt" || vd.Hash != v.Hash || vd.Size != 11 || !vd.ModTime.Equal(v.CreatedAt) {
+		t.Errorf("unexpected download %+v for version %+v", vd, v)
+	}
+
+	other := &model.User{ID: user.ID + 1, Username: "other"}
+	if _, err := files.Download(ctx, other, file); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for another user, got %v", err)
+	}
+	if _, err := versions.DownloadVersion(ctx, other, v); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for another user, got %v", err)
+	}
+}
+
+func TestFileVersionService_Policy(t *testing.T) {
+	t.Run("keeps the newest versions", func(t *testing.T) {
+		versions, files, user, rootID := setupFileVersionTest(t, service.VersionPolicy{Keep: 2})
+		ctx := testutil.TestContext(t)
+
+		var file *model.File
+		for i := 1; i <= 5; i++ {
+			var err error
+			file, err = files.StoreFile(ctx, user, rootID, "", "log.txt", strings.NewReader(fmt.Sprintf("run %d", i)))
+			if err != nil {
+				t.Fatalf("failed to store upload %d: %v", i, err)
+			}
+		}
+		_, history, err := versions.GetHistory(ctx, user, file.ID)
+		if err != nil {
+			t.Fatalf("GetHistory failed: %v", err)
+		}
+		if len(history) != 2 || history[0].Version != 4 || history[1].Version != 3 {
+			t.Fatalf("expected versions 4 and 3, got %+v", history)
+		}
+		if got := readVersion(t, versions, user, history[1]); got != "run 3" {
+			t.Errorf("version 3 content = %q, want %q", got, "run 3")
+		}
+	})
+
+	t.Run("drops expired versions", func(t *testing.T) {
+		versions, files, user, rootID := setupFileVersionTest(t, service.VersionPolicy{MaxAge: 24 * time.Hour})
+		ctx := testutil.TestContext(t)
+
+		if _, err := files.StoreFile(ctx, user, rootID, "", "a.txt", strings.NewReader("old")); err != nil {
+			t.Fatalf("failed to store file: %v", err)
+		}
+		file, err := files.StoreFile(ctx, user, rootID, "", "a.txt", strings.NewReader("new"))
+		if err != nil {
+			t.Fatalf("failed to replace file: %v", err)
+		}
+
+		if n, err := versions.Prune(ctx, time.Now()); err != nil || n != 0 {
+			t.Fatalf("expected nothing to prune yet, got %d, %v", n, err)
+		}
+		n, err := versions.Prune(ctx, time.Now().Add(48*time.Hour))
+		if err != nil {
+			t.Fatalf("Prune failed: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("expected 1 pruned version, got %d", n)
+		}
+		if _, history, _ := versions.GetHistory(ctx, user, file.ID); len(history) != 0 {
+			t.Errorf("expected empty history, got %+v", history)
+		}
+	})
+
+	t.Run("drops versions of deleted files", func(t *testing.T) {
+		versions, files, user, rootID := setupFileVersionTest(t, service.VersionPolicy{})
+		ctx := testutil.TestContext(t)
+
+		if _, err := files.StoreFile(ctx, user, rootID, "", "a.txt", strings.NewReader("old")); err != nil {
+			t.Fatalf("failed to store file: %v", err)
+		}
+		file, err := files.StoreFile(ctx, user, rootID, "", "a.txt", strings.NewReader("new"))
+		if err != nil {
+			t.Fatalf("failed to replace file: %v", err)
+		}
+		if err := files.DeleteFile(ctx, user, file.ID); err != nil {
+			t.Fatalf("DeleteFile failed: %v", err)
+		}
+
+		n, err := versions.Prune(ctx, time.Now())
+		if err != nil {
+			t.Fatalf("Prune failed: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("expected 1 pruned version, got %d", n)
+		}
+	})
+}
@@ -3,117 +3,155 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/NiClassic/go-cloud/internal/model"
 	"github.com/NiClassic/go-cloud/internal/path"
 	"github.com/NiClassic/go-cloud/internal/repository"
-	"github.com/NiClassic/go-cloud/internal/storage"
+	"strings"
 )
 
 var (
 	ErrFolderNotFound      = errors.New("folder not found")
 	ErrInvalidFolderName   = errors.New("invalid folder name")
 	ErrFolderAlreadyExists = errors.New("folder already exists")
-	ErrCannotMoveToChild   = errors.New("cannot folder to its own child")
+	ErrCannotMoveToChild   = errors.New("cannot move folder into itself or its own child")
 	ErrFileNotFound        = errors.New("file not found")
+	ErrInvalidFileName     = errors.New("invalid file name")
+	ErrFileAlreadyExists   = errors.New("file already exists")
 	ErrInvalidFolderPath   = errors.New("invalid folder path")
+	ErrCannotDeleteRoot    = errors.New("cannot delete the root folder")
+	ErrCannotMoveRoot      = errors.New("cannot move or rename the root folder")
+	ErrDifferentOwner      = errors.New("cannot move between the folders of different owners")
 )
 
+// invalidNameChars may not appear in folder names.
+const invalidNameChars = "/\\<>:|?*\""
+
 type FolderService struct {
 	folderRepo *repository.FolderRepository
 	fileRepo   *repository.PersonalFileRepository
-	st         storage.FileManager
+	access     *AccessService
 	converter  *path.Converter
 }
 
-func NewFolderService(folderRepo *repository.FolderRepository, fileRepo *repository.PersonalFileRepository, st storage.FileManager, c *path.Converter) *FolderService {
-	return &FolderService{folderRepo, fileRepo, st, c}
+func NewFolderService(folderRepo *repository.FolderRepository, fileRepo *repository.PersonalFileRepository, access *AccessService, c *path.Converter) *FolderService {
+	return &FolderService{folderRepo, fileRepo, access, c}
 }
 
+// CreateFolder creates the folder name below parentID, or the root folder of
+// the user at path if parentID is -1. A folder in a folder shared with the
+// user belongs to the owner of the parent.
 func (s *FolderService) CreateFolder(ctx context.Context, userID int64, username string, parentID int64, name, path string) (*model.Folder, error) {
 	if name == "" {
 		return nil, ErrInvalidFolderName
 	}
+	// The root folder is called "/", every other name is a single segment.
+	if parentID != -1 && strings.ContainsAny(name, invalidNameChars) {
+		return nil, ErrInvalidFolderName
+	}
 
 	// Convert input path to DB format (no leading/trailing slashes, relative to user)
 	dbPath := s.converter.ToDBPath(username, path)
+	ownerID := userID
+
+	// Check parent if specified, the path is relative to the root of its owner
+	if parentID != -1 {
+		parent, err := s.access.Folder(ctx, userID, parentID, model.PermissionWrite)
+		if err != nil {
+			return nil, err
+		}
+		dbPath, ownerID = s.converter.JoinDBPath(parent.Path, name), parent.UserID
+	}
 
 	// Validate the path
 	if !s.converter.IsValidPath(dbPath) {
 		return nil, ErrInvalidFolderPath
 	}
 
-	// Check parent if specified
-	if parentID != -1 {
-		parent, err := s.folderRepo.GetByID(ctx, parentID)
-		if err != nil {
-			return nil, ErrFolderNotFound
-		}
-		if parent.UserID != userID {
-			return nil, ErrFolderNotFound
-		}
+	if _, err := s.folderRepo.GetByPathAndUser(ctx, dbPath, ownerID); err == nil {
+		return nil, ErrFolderAlreadyExists
 	}
 
 	// Store in database with clean path
 	var id int64
 	var err error
 	if parentID == -1 {
-		id, err = s.folderRepo.Insert(ctx, userID, nil, name, dbPath)
+		id, err = s.folderRepo.Insert(ctx, ownerID, nil, name, dbPath)
 	} else {
-		id, err = s.folderRepo.Insert(ctx, userID, &parentID, name, dbPath)
+		id, err = s.folderRepo.Insert(ctx, ownerID, &parentID, name, dbPath)
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Ensure directory exists on filesystem
-	if err := s.converter.EnsureDir(username, dbPath); err != nil {
-		return nil, err
-	}
-
 	return s.folderRepo.GetByID(ctx, id)
 }
 
-func (s *FolderService) GetById(ctx context.Context, userID, folderID int64) (*model.Folder, error) {
-	folder, err := s.folderRepo.GetByID(ctx, folderID)
-	if err != nil {
+// MkdirAll returns the folder at the relative path rel below parent, creating
+// it and any missing folders on the way.
+func (s *FolderService) MkdirAll(ctx context.Context, user *model.User, parent *model.Folder, rel string) (*model.Folder, error) {
+	if err := s.access.CheckFolder(ctx, user.ID, parent, model.PermissionWrite); err != nil {
 		return nil, err
 	}
-
-	if folder.UserID != userID {
-		return nil, ErrFolderNotFound
+	folder := parent
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" {
+			continue
+		}
+		dbPath := s.converter.JoinDBPath(folder.Path, name)
+		if next, err := s.folderRepo.GetByPathAndUser(ctx, dbPath, parent.UserID); err == nil {
+			folder = next
+			continue
+		}
+		next, err := s.CreateFolder(ctx, user.ID, user.Username, folder.ID, name, "/"+dbPath)
+		if err != nil {
+			return nil, err
+		}
+		folder = next
 	}
 	return folder, nil
 }
 
+// GetById returns a folder the user may read, their own or a shared one.
+func (s *FolderService) GetById(ctx context.Context, userID, folderID int64) (*model.Folder, error) {
+	return s.access.Folder(ctx, userID, folderID, model.PermissionRead)
+}
+
+// Permission returns what the user may do with the folder.
+func (s *FolderService) Permission(ctx context.Context, userID int64, folder *model.Folder) (model.Permission, error) {
+	return s.access.FolderPermission(ctx, userID, folder)
+}
+
 func (s *FolderService) GetByPath(ctx context.Context, userID int64, username string, path string) (*model.Folder, error) {
 	dbPath := s.converter.ToDBPath(username, path)
 
+	return s.folderRepo.GetByPathAndUser(ctx, dbPath, userID)
+}
+
+// GetByDBPath looks up a folder by its path as stored in the database,
+// without stripping a leading username like GetByPath does.
+func (s *FolderService) GetByDBPath(ctx context.Context, userID int64, dbPath string) (*model.Folder, error) {
 	folder, err := s.folderRepo.GetByPathAndUser(ctx, dbPath, userID)
 	if err != nil {
-		return nil, err
-	}
-
-	if folder.UserID != userID {
 		return nil, ErrFolderNotFound
 	}
 	return folder, nil
 }
 
+// GetFolderContents returns the subfolders and files of a folder the user
+// may read. Everything in a folder belongs to the owner of the folder.
 func (s *FolderService) GetFolderContents(ctx context.Context, userID int64, folderID int64) ([]*model.Folder, []*model.File, error) {
-	folder, err := s.folderRepo.GetByID(ctx, folderID)
+	folder, err := s.access.Folder(ctx, userID, folderID, model.PermissionRead)
 	if err != nil {
-		return nil, nil, ErrFolderNotFound
-	}
-	if folder.UserID != userID {
-		return nil, nil, ErrFolderNotFound
+		return nil, nil, err
 	}
 
-	folders, err := s.folderRepo.GetByUserAndParent(ctx, userID, folderID)
+	folders, err := s.folderRepo.GetByUserAndParent(ctx, folder.UserID, folderID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	files, err := s.fileRepo.GetByUserAndFolder(ctx, userID, folderID)
+	files, err := s.fileRepo.GetByUserAndFolder(ctx, folder.UserID, folderID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -121,54 +159,141 @@ func (s *FolderService) GetFolderContents(ctx context.Context, userID int64, fol
 	return folders, files, nil
 }
 
-func (s *FolderService) MoveFolder(ctx context.Context, userID, folderID int64, newParentID int64) error {
-	folder, err := s.folderRepo.GetByID(ctx, folderID)
+// GetAllFolders returns every folder of the user ordered by path.
+func (s *FolderService) GetAllFolders(ctx context.Context, userID int64) ([]*model.Folder, error) {
+	return s.folderRepo.GetAllByUser(ctx, userID)
+}
+
+// MoveFolder moves a folder below newParentID under newName, an empty name
+// keeps the current one. The paths of the whole subtree are rewritten in one
+// transaction. Both folders need write permission and the same owner.
+func (s *FolderService) MoveFolder(ctx context.Context, user *model.User, folderID, newParentID int64, newName string) error {
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionWrite)
 	if err != nil {
 		return err
 	}
-	if folder.UserID != userID {
-		return ErrFolderNotFound
+	if !folder.ParentID.Valid {
+		return ErrCannotMoveRoot
+	}
+	if newName == "" {
+		newName = folder.Name
+	}
+	if strings.ContainsAny(newName, invalidNameChars) || newName == "." || newName == ".." {
+		return ErrInvalidFolderName
 	}
 
-	parent, err := s.folderRepo.GetByID(ctx, newParentID)
+	parent, err := s.access.Folder(ctx, user.ID, newParentID, model.PermissionWrite)
 	if err != nil {
-		return ErrFolderNotFound
+		return err
+	}
+	if parent.UserID != folder.UserID {
+		return ErrDifferentOwner
+	}
+	if parent.ID == folder.ID || s.converter.IsChildOf(parent.Path, folder.Path) {
+		return ErrCannotMoveToChild
+	}
+
+	newPath := s.converter.JoinDBPath(parent.Path, newName)
+	if newPath == folder.Path {
+		return nil
+	}
+	if _, err := s.folderRepo.GetByPathAndUser(ctx, newPath, folder.UserID); err == nil {
+		return ErrFolderAlreadyExists
 	}
-	if parent.UserID != userID {
-		return ErrFolderNotFound
+	if _, err := s.fileRepo.GetByFolderAndName(ctx, parent.ID, newName); err == nil {
+		return ErrFolderAlreadyExists
 	}
 
-	return s.folderRepo.UpdateParent(ctx, folderID, newParentID)
+	return s.folderRepo.MoveSubtree(ctx, folder.UserID, folder.ID, parent.ID, newName, folder.Path, newPath)
 }
 
-func (s *FolderService) MoveFile(ctx context.Context, userID, fileID int64, folderID int64) error {
-	file, err := s.fileRepo.GetById(ctx, fileID)
+// RenameFolder renames a folder in place.
+func (s *FolderService) RenameFolder(ctx context.Context, user *model.User, folderID int64, newName string) error {
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionWrite)
 	if err != nil {
 		return err
 	}
-	if file.UserID != userID {
-		return ErrFileNotFound
+	if !folder.ParentID.Valid {
+		return ErrCannotMoveRoot
+	}
+	if newName == "" {
+		return ErrInvalidFolderName
 	}
+	return s.MoveFolder(ctx, user, folder.ID, folder.ParentID.Int64, newName)
+}
 
-	folder, err := s.folderRepo.GetByID(ctx, folderID)
+func (s *FolderService) MoveFile(ctx context.Context, userID, fileID int64, folderID int64) error {
+	file, err := s.access.File(ctx, userID, fileID, model.PermissionWrite)
 	if err != nil {
-		return ErrFolderNotFound
+		return err
+	}
+
+	folder, err := s.access.Folder(ctx, userID, folderID, model.PermissionWrite)
+	if err != nil {
+		return err
 	}
-	if folder.UserID != userID {
-		return ErrFolderNotFound
+	if folder.UserID != file.UserID {
+		return ErrDifferentOwner
 	}
 
 	return s.fileRepo.UpdateFolder(ctx, fileID, folderID)
 }
 
-func (s *FolderService) DeleteFolder(ctx context.Context, userID, folderID int64) error {
-	folder, err := s.folderRepo.GetByID(ctx, folderID)
+// FolderDeletion reports what a recursive folder delete removed.
+type FolderDeletion struct {
+	Folders int
+	Files   int
+	Bytes   int64
+}
+
+// DeleteFolder deletes the folder together with its whole subtree in one
+// transaction, so a failure leaves the tree as it was. The contents of its
+// files are removed from storage by BlobService.Collect once nothing else uses
+// them. Nothing goes to the trash, see TrashService.TrashFolder for that.
+func (s *FolderService) DeleteFolder(ctx context.Context, user *model.User, folderID int64) (*FolderDeletion, error) {
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	if !folder.ParentID.Valid {
+		return nil, ErrCannotDeleteRoot
+	}
+
+	res := &FolderDeletion{}
+	var folderIDs, fileIDs []int64
+	if err := s.collectSubtree(ctx, folder, res, &folderIDs, &fileIDs); err != nil {
+		return nil, err
+	}
+	if err := s.folderRepo.DeleteSubtree(ctx, folderIDs, fileIDs); err != nil {
+		return nil, fmt.Errorf("failed to delete folder %q: %w", folder.Path, err)
+	}
+	return res, nil
+}
+
+// collectSubtree appends the IDs of folder and the folders below it, parents
+// first, and of the files in them, and counts them in res.
+func (s *FolderService) collectSubtree(ctx context.Context, folder *model.Folder, res *FolderDeletion, folderIDs, fileIDs *[]int64) error {
+	*folderIDs = append(*folderIDs, folder.ID)
+	res.Folders++
+
+	files, err := s.fileRepo.GetByUserAndFolder(ctx, folder.UserID, folder.ID)
 	if err != nil {
 		return err
 	}
-	if folder.UserID != userID {
-		return ErrFolderNotFound
+	for _, f := range files {
+		*fileIDs = append(*fileIDs, f.ID)
+		res.Files++
+		res.Bytes += f.Size
 	}
 
-	return s.folderRepo.Delete(ctx, folderID)
+	children, err := s.folderRepo.GetByUserAndParent(ctx, folder.UserID, folder.ID)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := s.collectSubtree(ctx, child, res, folderIDs, fileIDs); err != nil {
+			return err
+		}
+	}
+	return nil
 }
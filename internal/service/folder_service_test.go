@@ -1,11 +1,14 @@
 package service_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
 	"github.com/NiClassic/go-cloud/internal/repository"
 	"github.com/NiClassic/go-cloud/internal/service"
-	"github.com/NiClassic/go-cloud/internal/storage"
 	"github.com/NiClassic/go-cloud/internal/testutil"
 )
 
@@ -19,9 +22,9 @@ func setupFolderTest(t *testing.T) (*service.FolderService, int64, string, strin
 	userRepo := repository.NewUserRepository(db)
 	folderRepo := repository.NewFolderRepository(db)
 	fileRepo := repository.NewPersonalFileRepository(db)
-	st := storage.NewIOStorage(tmpDir)
 
-	folderSvc := service.NewFolderService(folderRepo, fileRepo, st)
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, path.New(tmpDir))
 
 	// Create a test user
 	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
@@ -66,6 +69,22 @@ func TestFolderService_CreateFolder(t *testing.T) {
 			wantErr:     true,
 			expectedErr: service.ErrInvalidFolderName,
 		},
+		{
+			name:        "duplicate folder",
+			folderName:  "documents",
+			parentID:    -1,
+			path:        "/documents",
+			wantErr:     true,
+			expectedErr: service.ErrFolderAlreadyExists,
+		},
+		{
+			name:        "folder name with slash",
+			folderName:  "a/b",
+			parentID:    1,
+			path:        "/a/b",
+			wantErr:     true,
+			expectedErr: service.ErrInvalidFolderName,
+		},
 	}
 
 	for _, tt := range tests {
@@ -251,6 +270,7 @@ func TestFolderService_GetFolderContents(t *testing.T) {
 func TestFolderService_MoveFolder(t *testing.T) {
 	folderSvc, userID, username, _ := setupFolderTest(t)
 	ctx := testutil.TestContext(t)
+	user := &model.User{ID: userID, Username: username}
 
 	// Create folder structure
 	root, _ := folderSvc.CreateFolder(ctx, userID, username, -1, username, "/")
@@ -258,7 +278,7 @@ func TestFolderService_MoveFolder(t *testing.T) {
 	work, _ := folderSvc.CreateFolder(ctx, userID, username, root.ID, "work", "/work")
 
 	t.Run("move folder to new parent", func(t *testing.T) {
-		err := folderSvc.MoveFolder(ctx, userID, docs.ID, work.ID)
+		err := folderSvc.MoveFolder(ctx, user, docs.ID, work.ID, "")
 		if err != nil {
 			t.Fatalf("failed to move folder: %v", err)
 		}
@@ -279,7 +299,7 @@ func TestFolderService_MoveFolder(t *testing.T) {
 	})
 
 	t.Run("move non-existent folder", func(t *testing.T) {
-		err := folderSvc.MoveFolder(ctx, userID, 99999, work.ID)
+		err := folderSvc.MoveFolder(ctx, user, 99999, work.ID, "")
 		if err == nil {
 			t.Error("expected error for non-existent folder")
 		}
@@ -289,6 +309,7 @@ func TestFolderService_MoveFolder(t *testing.T) {
 func TestFolderService_DeleteFolder(t *testing.T) {
 	folderSvc, userID, username, _ := setupFolderTest(t)
 	ctx := testutil.TestContext(t)
+	user := &model.User{ID: userID, Username: username}
 
 	// Create a test folder
 	root, _ := folderSvc.CreateFolder(ctx, userID, username, -1, username, "/")
@@ -298,10 +319,13 @@ func TestFolderService_DeleteFolder(t *testing.T) {
 	}
 
 	t.Run("delete folder", func(t *testing.T) {
-		err := folderSvc.DeleteFolder(ctx, userID, folder.ID)
+		res, err := folderSvc.DeleteFolder(ctx, user, folder.ID)
 		if err != nil {
 			t.Fatalf("failed to delete folder: %v", err)
 		}
+		if res.Folders != 1 || res.Files != 0 {
+			t.Errorf("expected 1 folder and 0 files removed, got %+v", res)
+		}
 
 		_, err = folderSvc.GetById(ctx, userID, folder.ID)
 		if err == nil {
@@ -310,7 +334,7 @@ func TestFolderService_DeleteFolder(t *testing.T) {
 	})
 
 	t.Run("delete non-existent folder", func(t *testing.T) {
-		err := folderSvc.DeleteFolder(ctx, userID, 99999)
+		_, err := folderSvc.DeleteFolder(ctx, user, 99999)
 		if err == nil {
 			t.Error("expected error for non-existent folder")
 		}
@@ -318,9 +342,145 @@ func TestFolderService_DeleteFolder(t *testing.T) {
 
 	t.Run("delete with wrong user", func(t *testing.T) {
 		folder2, _ := folderSvc.CreateFolder(ctx, userID, username, root.ID, "another", "/another")
-		err := folderSvc.DeleteFolder(ctx, userID+999, folder2.ID)
+		other := &model.User{ID: userID + 999, Username: "other"}
+		_, err := folderSvc.DeleteFolder(ctx, other, folder2.ID)
 		if err != service.ErrFolderNotFound {
 			t.Errorf("expected ErrFolderNotFound, got %v", err)
 		}
 	})
+
+	t.Run("delete root folder", func(t *testing.T) {
+		_, err := folderSvc.DeleteFolder(ctx, user, root.ID)
+		if err != service.ErrCannotDeleteRoot {
+			t.Errorf("expected ErrCannotDeleteRoot, got %v", err)
+		}
+	})
+}
+
+func TestFolderService_DeleteFolderRecursive(t *testing.T) {
+	fileSvc, folderSvc, user, rootID := setupPersonalFileTest(t)
+	ctx := testutil.TestContext(t)
+
+	docs, err := folderSvc.CreateFolder(ctx, user.ID, user.Username, rootID, "docs", "/docs")
+	if err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	sub, err := folderSvc.CreateFolder(ctx, user.ID, user.Username, docs.ID, "sub", "/docs/sub")
+	if err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	var deleted []*model.File
+	for _, f := range []struct {
+		folder  *model.Folder
+		name    string
+		content string
+	}{
+		{docs, "a.txt", "hello"},
+		{sub, "b.txt", "nested file"},
+	} {
+		file, err := fileSvc.StoreFile(ctx, user, f.folder.ID, f.folder.Path, f.name, strings.NewReader(f.content))
+		if err != nil {
+			t.Fatalf("failed to store %s: %v", f.name, err)
+		}
+		deleted = append(deleted, file)
+	}
+	kept, err := fileSvc.StoreFile(ctx, user, rootID, "", "kept.txt", strings.NewReader("keep me"))
+	if err != nil {
+		t.Fatalf("failed to store kept.txt: %v", err)
+	}
+
+	res, err := folderSvc.DeleteFolder(ctx, user, docs.ID)
+	if err != nil {
+		t.Fatalf("DeleteFolder failed: %v", err)
+	}
+	want := service.FolderDeletion{Folders: 2, Files: 2, Bytes: int64(len("hello") + len("nested file"))}
+	if *res != want {
+		t.Errorf("expected %+v, got %+v", want, *res)
+	}
+
+	files, err := fileSvc.GetUserFiles(ctx, user)
+	if err != nil {
+		t.Fatalf("GetUserFiles failed: %v", err)
+	}
+	if len(files) != 1 || files[0].ID != kept.ID {
+		t.Errorf("expected only kept.txt to remain, got %d files", len(files))
+	}
+	if _, err := folderSvc.GetById(ctx, user.ID, sub.ID); err == nil {
+		t.Error("expected subfolder to be deleted")
+	}
+
+	rc, err := fileSvc.OpenFile(ctx, user, files[0])
+	if err != nil {
+		t.Fatalf("kept file should still be in storage: %v", err)
+	}
+	_ = rc.Close()
+	for _, f := range deleted {
+		if _, err := fileSvc.GetFileById(ctx, f.ID); err == nil {
+			t.Errorf("expected %s to be deleted", f.Location)
+		}
+	}
+}
+
+func TestFolderService_MoveFolderSubtree(t *testing.T) {
+	fileSvc, folderSvc, user, rootID := setupPersonalFileTest(t)
+	ctx := testutil.TestContext(t)
+
+	docs, _ := folderSvc.CreateFolder(ctx, user.ID, user.Username, rootID, "docs", "/docs")
+	sub, _ := folderSvc.CreateFolder(ctx, user.ID, user.Username, docs.ID, "sub", "/docs/sub")
+	work, _ := folderSvc.CreateFolder(ctx, user.ID, user.Username, rootID, "work", "/work")
+	file, err := fileSvc.StoreFile(ctx, user, sub.ID, sub.Path, "a.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	if err := folderSvc.MoveFolder(ctx, user, docs.ID, work.ID, "papers"); err != nil {
+		t.Fatalf("MoveFolder failed: %v", err)
+	}
+
+	moved, err := folderSvc.GetByDBPath(ctx, user.ID, "work/papers/sub")
+	if err != nil || moved.ID != sub.ID {
+		t.Fatalf("expected subfolder at work/papers/sub, got %v", err)
+	}
+	file, err = fileSvc.GetFileById(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("GetFileById failed: %v", err)
+	}
+	if file.Location != "work/papers/sub/a.txt" {
+		t.Errorf("expected location work/papers/sub/a.txt, got %q", file.Location)
+	}
+	rc, err := fileSvc.OpenFile(ctx, user, file)
+	if err != nil {
+		t.Fatalf("file should have moved on disk: %v", err)
+	}
+	_ = rc.Close()
+
+	if err := folderSvc.RenameFolder(ctx, user, docs.ID, "archive"); err != nil {
+		t.Fatalf("RenameFolder failed: %v", err)
+	}
+	if _, err := folderSvc.GetByDBPath(ctx, user.ID, "work/archive/sub"); err != nil {
+		t.Errorf("expected subfolder at work/archive/sub: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		folderID int64
+		parentID int64
+		newName  string
+		wantErr  error
+	}{
+		{"into itself", docs.ID, docs.ID, "", service.ErrCannotMoveToChild},
+		{"into descendant", docs.ID, sub.ID, "", service.ErrCannotMoveToChild},
+		{"root folder", rootID, work.ID, "", service.ErrCannotMoveRoot},
+		{"name with slash", sub.ID, rootID, "a/b", service.ErrInvalidFolderName},
+		{"name taken by folder", sub.ID, rootID, "work", service.ErrFolderAlreadyExists},
+		{"unknown parent", sub.ID, 99999, "", service.ErrFolderNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := folderSvc.MoveFolder(ctx, user, tt.folderID, tt.parentID, tt.newName)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
 }
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+)
+
+var (
+	ErrGroupNotFound      = errors.New("group not found")
+	ErrInvalidGroupName   = errors.New("invalid group name")
+	ErrGroupAlreadyExists = errors.New("group already exists")
+)
+
+// GroupService manages the groups a user shares folders and files with.
+// Only the owner of a group sees and changes it, its members only notice the
+// items shared with it.
+type GroupService struct {
+	repo     *repository.GroupRepository
+	userRepo *repository.UserRepository
+}
+
+func NewGroupService(repo *repository.GroupRepository, userRepo *repository.UserRepository) *GroupService {
+	return &GroupService{repo, userRepo}
+}
+
+func (s *GroupService) CreateGroup(ctx context.Context, user *model.User, name string) (*model.Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrInvalidGroupName
+	}
+	if _, err := s.repo.GetByOwnerAndName(ctx, user.ID, name); err == nil {
+		return nil, ErrGroupAlreadyExists
+	}
+	id, err := s.repo.Insert(ctx, user.ID, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(ctx, id)
+}
+
+// GetGroup returns a group of the user with its members.
+func (s *GroupService) GetGroup(ctx context.Context, user *model.User, groupID int64) (*model.Group, error) {
+	g, err := s.repo.GetByID(ctx, groupID)
+	if err != nil || g.OwnerID != user.ID {
+		return nil, ErrGroupNotFound
+	}
+	if g.Members, err = s.repo.GetMembers(ctx, g.ID); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// GetUserGroups returns the groups of the user with their members.
+func (s *GroupService) GetUserGroups(ctx context.Context, user *model.User) ([]*model.Group, error) {
+	groups, err := s.repo.GetByOwner(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if g.Members, err = s.repo.GetMembers(ctx, g.ID); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// AddMember adds the user called username to a group of the user.
+func (s *GroupService) AddMember(ctx context.Context, user *model.User, groupID int64, username string) error {
+	g, err := s.GetGroup(ctx, user, groupID)
+	if err != nil {
+		return err
+	}
+	member, err := s.userRepo.GetByUsername(ctx, strings.TrimSpace(username))
+	if err != nil {
+		return ErrUserNotFound
+	}
+	return s.repo.AddMember(ctx, g.ID, member.ID)
+}
+
+// RemoveMember removes the user called username from a group of the user.
+func (s *GroupService) RemoveMember(ctx context.Context, user *model.User, groupID int64, username string) error {
+	g, err := s.GetGroup(ctx, user, groupID)
+	if err != nil {
+		return err
+	}
+	member, err := s.userRepo.GetByUsername(ctx, username)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	return s.repo.RemoveMember(ctx, g.ID, member.ID)
+}
+
+// DeleteGroup deletes a group of the user together with its shares.
+func (s *GroupService) DeleteGroup(ctx context.Context, user *model.User, groupID int64) error {
+	g, err := s.GetGroup(ctx, user, groupID)
+	if err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, g.ID)
+}
@@ -1,7 +1,7 @@
 package service
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"fmt"
 	"github.com/NiClassic/go-cloud/internal/model"
@@ -9,19 +9,27 @@ import (
 	"github.com/NiClassic/go-cloud/internal/repository"
 	"github.com/NiClassic/go-cloud/internal/storage"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 )
 
 type PersonalFileService struct {
 	sto       storage.FileManager
+	blobs     *BlobService
 	repo      *repository.PersonalFileRepository
+	versions  *FileVersionService
+	quota     *QuotaService
+	folders   *FolderService
+	search    *SearchService
+	access    *AccessService
 	converter *path.Converter
 }
 
-func NewPersonalFileService(sto storage.FileManager, repo *repository.PersonalFileRepository, c *path.Converter) *PersonalFileService {
-	return &PersonalFileService{sto, repo, c}
+func NewPersonalFileService(sto storage.FileManager, blobs *BlobService, repo *repository.PersonalFileRepository, versions *FileVersionService, quota *QuotaService, folders *FolderService, search *SearchService, access *AccessService, c *path.Converter) *PersonalFileService {
+	return &PersonalFileService{sto, blobs, repo, versions, quota, folders, search, access, c}
 }
 
 func (p *PersonalFileService) GetUserFiles(ctx context.Context, user *model.User) ([]*model.File, error) {
@@ -32,78 +40,328 @@ func (p *PersonalFileService) GetFileById(ctx context.Context, id int64) (*model
 	return p.repo.GetById(ctx, id)
 }
 
+// GetFile returns a file the user may read, their own or a shared one.
+func (p *PersonalFileService) GetFile(ctx context.Context, userID, id int64) (*model.File, error) {
+	return p.access.File(ctx, userID, id, model.PermissionRead)
+}
+
+// Permission returns what the user may do with the file.
+func (p *PersonalFileService) Permission(ctx context.Context, userID int64, file *model.File) (model.Permission, error) {
+	return p.access.FilePermission(ctx, userID, file)
+}
+
+// sniffLen is the number of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// maxPathFieldSize is the longest relative path a path field may hold.
+const maxPathFieldSize = 4096
+
+// StoreFiles streams every file part of reader into the folder. Only the first
+// bytes of a part are buffered to detect its content type, so memory usage does
+// not depend on the file size.
+//
+// Files of a folder upload keep their place in the tree: the relative path is
+// taken from a path field right before the file part or else from the file
+// name the browser sent. Missing folders on the way are created.
 func (p *PersonalFileService) StoreFiles(ctx context.Context, user *model.User, reader *multipart.Reader, folderID int64, folderPath string) error {
+	relPath := ""
 	for {
 		part, err := reader.NextPart()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to get next part: %v", err)
+			return fmt.Errorf("failed to get next part: %w", err)
 		}
 
-		defer part.Close()
-
 		if part.FileName() == "" {
+			if part.FormName() == "path" {
+				b, err := io.ReadAll(io.LimitReader(part, maxPathFieldSize))
+				if err != nil {
+					_ = part.Close()
+					return fmt.Errorf("failed to read path: %w", err)
+				}
+				relPath = string(b)
+			}
+			_ = part.Close()
 			continue // Skip non-file parts
 		}
 
-		// Read file content
-		var fileContentBuf bytes.Buffer
-		n, copyErr := io.Copy(&fileContentBuf, part)
-		if copyErr != nil {
-			return fmt.Errorf("failed to read content for file %q: %v", part.FileName(), copyErr)
+		err = p.storePart(ctx, user, part, folderID, folderPath, relPath)
+		relPath = ""
+		_ = part.Close()
+		if err != nil {
+			return err
 		}
+	}
 
-		fileSize := n
-		fileBytes := fileContentBuf.Bytes()
-		mimeType := http.DetectContentType(fileBytes)
+	return nil
+}
 
-		// Build the file path in DB format
-		var fileDBPath string
-		if folderPath == "" {
-			fileDBPath = part.FileName() // Root folder
-		} else {
-			fileDBPath = p.converter.JoinDBPath(folderPath, part.FileName())
+func (p *PersonalFileService) storePart(ctx context.Context, user *model.User, part *multipart.Part, folderID int64, folderPath, relPath string) error {
+	dir, name, err := p.partPath(part, relPath)
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		parent, err := p.folders.GetById(ctx, user.ID, folderID)
+		if err != nil {
+			return err
 		}
+		folder, err := p.folders.MkdirAll(ctx, user, parent, dir)
+		if err != nil {
+			return err
+		}
+		folderID, folderPath = folder.ID, folder.Path
+	}
+	_, err = p.StoreFile(ctx, user, folderID, folderPath, name, part)
+	return err
+}
 
-		// Save to storage
-		fileReaderForStorage := bytes.NewReader(fileBytes)
-		_, hash, _, err := p.sto.SaveFile(user.Username, folderPath, part.FileName(), fileReaderForStorage)
+// partPath splits the relative path of a file part into its folder and name.
+// An invalid relPath fails with ErrInvalidFolderPath, a file name that is no
+// valid path falls back to its last element.
+func (p *PersonalFileService) partPath(part *multipart.Part, relPath string) (string, string, error) {
+	explicit := relPath != ""
+	if !explicit {
+		// part.FileName only keeps the last element of the name.
+		_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
 		if err != nil {
-			return fmt.Errorf("failed to save file %q to storage: %w", part.FileName(), err)
+			return "", part.FileName(), nil
+		}
+		relPath = params["filename"]
+	}
+	cleaned, err := p.converter.SanitizePath(relPath)
+	if err != nil || cleaned == "." || strings.HasPrefix(cleaned, "/") {
+		if explicit {
+			return "", "", ErrInvalidFolderPath
 		}
+		return "", part.FileName(), nil
+	}
+	i := strings.LastIndex(cleaned, "/")
+	if i < 0 {
+		return "", cleaned, nil
+	}
+	return cleaned[:i], cleaned[i+1:], nil
+}
 
-		// Store in database with DB path format
-		if _, err := p.repo.Insert(ctx,
-			part.FileName(),
-			mimeType,
-			fileDBPath, // Store relative path in DB
-			hash,
-			user.ID,
-			fileSize,
-			folderID,
-		); err != nil {
-			return fmt.Errorf("failed to insert file record for %q into database: %w", part.FileName(), err)
+// StoreFile streams src into the folder under filename. An existing file with
+// the same name in the folder gets src as its next version, the previous
+// content is kept in its history. The file belongs to the owner of the folder
+// and fails with ErrQuotaExceeded once src grows beyond their quota.
+func (p *PersonalFileService) StoreFile(ctx context.Context, user *model.User, folderID int64, folderPath, filename string, src io.Reader) (*model.File, error) {
+	folder, err := p.access.Folder(ctx, user.ID, folderID, model.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	ownerID := folder.UserID
+
+	var replaced int64
+	if existing, err := p.repo.GetByFolderAndName(ctx, folderID, filename); err == nil {
+		replaced = existing.Size
+	}
+	src, err = p.quota.Limit(ctx, ownerID, replaced, src)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReaderSize(src, sniffLen)
+	head, err := buffered.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read content for file %q: %w", filename, err)
+	}
+	mimeType := http.DetectContentType(head)
+
+	// Build the file path in DB format
+	fileDBPath := p.converter.JoinDBPath(folderPath, filename)
+
+	// Save to storage, the peeked bytes are still part of the buffered reader
+	hash, size, err := p.sto.SaveFile(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file %q to storage: %w", filename, err)
+	}
+
+	return p.putContent(ctx, folder, fileDBPath, filename, mimeType, hash, size)
+}
+
+// putContent makes the saved content with hash the current content of the
+// file filename in folder, at location in DB format. An existing file keeps
+// its ID and its old content becomes a version, unless the content is the
+// same, like for a retried upload; then the file is kept as it is instead of
+// adding a version that is no different. Otherwise a new file is recorded.
+func (p *PersonalFileService) putContent(ctx context.Context, folder *model.Folder, location, filename, mimeType, hash string, size int64) (*model.File, error) {
+	if err := p.blobs.Claim(ctx, hash, size); err != nil {
+		return nil, fmt.Errorf("failed to save file %q: %w", filename, err)
+	}
+	if existing, err := p.repo.GetByFolderAndName(ctx, folder.ID, filename); err == nil {
+		if existing.Hash == hash {
+			return existing, nil
+		}
+		if err := p.versions.Archive(ctx, existing); err != nil {
+			return nil, err
+		}
+		if err := p.repo.UpdateContent(ctx, existing.ID, mimeType, hash, size); err != nil {
+			return nil, fmt.Errorf("failed to update file record for %q: %w", filename, err)
 		}
+		return p.indexed(ctx, existing.ID)
 	}
 
-	return nil
+	id, err := p.repo.Insert(ctx, filename, mimeType, location, hash, folder.UserID, size, folder.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert file record for %q into database: %w", filename, err)
+	}
+	return p.indexed(ctx, id)
 }
 
-func (p *PersonalFileService) DeleteFile(ctx context.Context, user *model.User, fileID int64) error {
-	file, err := p.repo.GetById(ctx, fileID)
+// indexed returns the stored file after making its text searchable. Failing
+// that does not fail the upload, SearchService.Index tries again later.
+func (p *PersonalFileService) indexed(ctx context.Context, id int64) (*model.File, error) {
+	file, err := p.repo.GetById(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	_ = p.search.IndexFile(ctx, file)
+	return file, nil
+}
 
-	if file.UserID != user.ID {
-		return fmt.Errorf("unauthorized")
+// RestoreVersion makes an older version the current content of a file. The
+// content it replaces becomes a version itself, so a restore can be undone.
+func (p *PersonalFileService) RestoreVersion(ctx context.Context, user *model.User, fileID int64, version int) (*model.File, error) {
+	file, err := p.access.File(ctx, user.ID, fileID, model.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.versions.GetVersion(ctx, user, fileID, version)
+	if err != nil {
+		return nil, err
+	}
+	src, err := p.versions.OpenVersion(ctx, user, v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open version %d of %q: %w", version, file.Name, err)
+	}
+	defer func() { _ = src.Close() }()
+
+	folderID := file.FolderID.Int64
+	return p.StoreFile(ctx, user, folderID, p.converter.GetParentDBPath(file.Location), file.Name, src)
+}
+
+// GetFileByName returns the file called name inside the folder.
+func (p *PersonalFileService) GetFileByName(ctx context.Context, userID, folderID int64, name string) (*model.File, error) {
+	file, err := p.repo.GetByFolderAndName(ctx, folderID, name)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	if err := p.access.CheckFile(ctx, userID, file, model.PermissionRead); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// OpenFile opens the stored bytes of a file for reading.
+func (p *PersonalFileService) OpenFile(ctx context.Context, user *model.User, file *model.File) (io.ReadSeekCloser, error) {
+	if err := p.access.CheckFile(ctx, user.ID, file, model.PermissionRead); err != nil {
+		return nil, err
+	}
+	return p.sto.OpenFile(file.Hash)
+}
+
+// Download is stored content opened for sending, together with what a client
+// needs to cache it and to save it under its original name.
+type Download struct {
+	Content  io.ReadSeekCloser
+	Name     string
+	MimeType string
+	// Hash is the SHA-256 of the content and doubles as its entity tag.
+	Hash    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Download opens the current content of a file for sending.
+func (p *PersonalFileService) Download(ctx context.Context, user *model.User, file *model.File) (*Download, error) {
+	rc, err := p.OpenFile(ctx, user, file)
+	if err != nil {
+		return nil, err
 	}
+	return &Download{
+		Content:  rc,
+		Name:     file.Name,
+		MimeType: file.MimeType,
+		Hash:     file.Hash,
+		Size:     file.Size,
+		ModTime:  file.ContentCreatedAt(),
+	}, nil
+}
 
-	if err := os.Remove(file.Location); err != nil && !os.IsNotExist(err) {
+// MoveFile moves a file into dst under newName. Only the database record
+// changes, the content stays where it is in storage. Files cannot move to
+// the folders of another owner, copy them instead.
+func (p *PersonalFileService) MoveFile(ctx context.Context, user *model.User, fileID int64, dst *model.Folder, newName string) error {
+	file, err := p.access.File(ctx, user.ID, fileID, model.PermissionWrite)
+	if err != nil {
+		return err
+	}
+	if err := p.access.CheckFolder(ctx, user.ID, dst, model.PermissionWrite); err != nil {
 		return err
 	}
+	if dst.UserID != file.UserID {
+		return ErrDifferentOwner
+	}
+	if newName == "" || newName != p.converter.GetBaseName(newName) {
+		return ErrInvalidFileName
+	}
+	newPath := p.converter.JoinDBPath(dst.Path, newName)
+	if dst.ID == file.FolderID.Int64 && newName == file.Name {
+		return nil
+	}
+	if _, err := p.repo.GetByFolderAndName(ctx, dst.ID, newName); err == nil {
+		return ErrFileAlreadyExists
+	}
+	if _, err := p.folders.GetByDBPath(ctx, dst.UserID, newPath); err == nil {
+		return ErrFolderAlreadyExists
+	}
+
+	return p.repo.UpdateLocation(ctx, file.ID, dst.ID, newName, newPath)
+}
 
+// CopyFile copies a file into dst under newName. The copy shares the stored
+// content with the original, so it takes no additional space in storage. It
+// belongs to the owner of dst, so shared files can be copied to one's own.
+func (p *PersonalFileService) CopyFile(ctx context.Context, user *model.User, fileID int64, dst *model.Folder, newName string) (*model.File, error) {
+	file, err := p.access.File(ctx, user.ID, fileID, model.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.access.CheckFolder(ctx, user.ID, dst, model.PermissionWrite); err != nil {
+		return nil, err
+	}
+	if newName == "" {
+		newName = file.Name
+	}
+	if newName != p.converter.GetBaseName(newName) {
+		return nil, ErrInvalidFileName
+	}
+	if _, err := p.repo.GetByFolderAndName(ctx, dst.ID, newName); err == nil {
+		return nil, ErrFileAlreadyExists
+	}
+	// The copy shares the content but counts towards the quota like any file.
+	if err := p.quota.Check(ctx, dst.UserID, file.Size); err != nil {
+		return nil, err
+	}
+
+	id, err := p.repo.Insert(ctx, newName, file.MimeType, p.converter.JoinDBPath(dst.Path, newName), file.Hash, dst.UserID, file.Size, dst.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert file record for copy of %q: %w", file.Name, err)
+	}
+	return p.repo.GetById(ctx, id)
+}
+
+// DeleteFile deletes a file for good, use TrashService.TrashFile for
+// deletions a user may want to undo. Its content is removed from storage by
+// BlobService.Collect once nothing else uses it.
+func (p *PersonalFileService) DeleteFile(ctx context.Context, user *model.User, fileID int64) error {
+	if _, err := p.access.File(ctx, user.ID, fileID, model.PermissionWrite); err != nil {
+		return err
+	}
 	return p.repo.Delete(ctx, fileID)
 }
@@ -2,13 +2,17 @@ package service_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/textproto"
+	"strings"
 	"testing"
 
 	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
 	"github.com/NiClassic/go-cloud/internal/repository"
 	"github.com/NiClassic/go-cloud/internal/service"
 	"github.com/NiClassic/go-cloud/internal/storage"
@@ -27,8 +31,10 @@ func setupPersonalFileTest(t *testing.T) (*service.PersonalFileService, *service
 	folderRepo := repository.NewFolderRepository(db)
 	st := storage.NewIOStorage(tmpDir)
 
-	fileSvc := service.NewPersonalFileService(st, fileRepo)
-	folderSvc := service.NewFolderService(folderRepo, fileRepo, st)
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, path.New(tmpDir))
+	fileSvc := service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, path.New(tmpDir))
 
 	// Create a test user
 	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
@@ -138,6 +144,77 @@ func TestPersonalFileService_StoreFiles(t *testing.T) {
 	}
 }
 
+func TestPersonalFileService_StoreFilesKeepsFolders(t *testing.T) {
+	fileSvc, folderSvc, user, folderID := setupPersonalFileTest(t)
+	ctx := testutil.TestContext(t)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	add := func(relPath, filename, content string) {
+		if relPath != "" {
+			if err := writer.WriteField("path", relPath); err != nil {
+				t.Fatalf("failed to write path: %v", err)
+			}
+		}
+		part, err := writer.CreateFormFile("files", filename)
+		if err != nil {
+			t.Fatalf("failed to create part: %v", err)
+		}
+		_, _ = io.WriteString(part, content)
+	}
+	add("project/src/main.go", "main.go", "package main")
+	add("project/README.md", "README.md", "readme")
+	add("", "project/docs/guide.txt", "guide")
+	add("", "flat.txt", "flat")
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+
+	if err := fileSvc.StoreFiles(ctx, user, multipart.NewReader(body, writer.Boundary()), folderID, ""); err != nil {
+		t.Fatalf("StoreFiles failed: %v", err)
+	}
+
+	for _, want := range []struct{ folder, name string }{
+		{"project/src", "main.go"},
+		{"project", "README.md"},
+		{"project/docs", "guide.txt"},
+		{"", "flat.txt"},
+	} {
+		folder, err := folderSvc.GetByDBPath(ctx, user.ID, want.folder)
+		if err != nil {
+			t.Errorf("expected folder %q to be created: %v", want.folder, err)
+			continue
+		}
+		if _, err := fileSvc.GetFileByName(ctx, user.ID, folder.ID, want.name); err != nil {
+			t.Errorf("expected %s in %q: %v", want.name, want.folder, err)
+		}
+	}
+}
+
+func TestPersonalFileService_StoreFilesRejectsInvalidPaths(t *testing.T) {
+	for _, relPath := range []string{"../evil.txt", "/etc/evil.txt", "docs/what?.txt"} {
+		t.Run(relPath, func(t *testing.T) {
+			fileSvc, _, user, folderID := setupPersonalFileTest(t)
+			ctx := testutil.TestContext(t)
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			_ = writer.WriteField("path", relPath)
+			part, _ := writer.CreateFormFile("files", "evil.txt")
+			_, _ = io.WriteString(part, "evil")
+			_ = writer.Close()
+
+			err := fileSvc.StoreFiles(ctx, user, multipart.NewReader(body, writer.Boundary()), folderID, "")
+			if !errors.Is(err, service.ErrInvalidFolderPath) {
+				t.Errorf("expected ErrInvalidFolderPath, got %v", err)
+			}
+			if files, _ := fileSvc.GetUserFiles(ctx, user); len(files) != 0 {
+				t.Errorf("expected nothing to be stored, got %d files", len(files))
+			}
+		})
+	}
+}
+
 func TestPersonalFileService_GetUserFiles(t *testing.T) {
 	fileSvc, _, user, folderID := setupPersonalFileTest(t)
 	ctx := testutil.TestContext(t)
@@ -292,6 +369,53 @@ func TestPersonalFileService_DeleteFile(t *testing.T) {
 	})
 }
 
+func TestPersonalFileService_MoveFile(t *testing.T) {
+	fileSvc, folderSvc, user, folderID := setupPersonalFileTest(t)
+	ctx := testutil.TestContext(t)
+
+	reader := createMultipartReader(t, map[string]string{"a.txt": "first", "b.txt": "second"})
+	if err := fileSvc.StoreFiles(ctx, user, reader, folderID, "/"); err != nil {
+		t.Fatalf("failed to store files: %v", err)
+	}
+	root, err := folderSvc.GetById(ctx, user.ID, folderID)
+	if err != nil {
+		t.Fatalf("failed to get root folder: %v", err)
+	}
+	sub, err := folderSvc.CreateFolder(ctx, user.ID, user.Username, folderID, "sub", "")
+	if err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	byName := map[string]*model.File{}
+	files, err := fileSvc.GetUserFiles(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to get user files: %v", err)
+	}
+	for _, f := range files {
+		byName[f.Name] = f
+	}
+	a := byName["a.txt"]
+
+	if err := fileSvc.MoveFile(ctx, user, a.ID, root, "b.txt"); !errors.Is(err, service.ErrFileAlreadyExists) {
+		t.Errorf("expected ErrFileAlreadyExists moving onto b.txt, got %v", err)
+	}
+	if err := fileSvc.MoveFile(ctx, user, a.ID, root, "sub"); !errors.Is(err, service.ErrFolderAlreadyExists) {
+		t.Errorf("expected ErrFolderAlreadyExists moving onto the folder sub, got %v", err)
+	}
+	if err := fileSvc.MoveFile(ctx, user, a.ID, root, "a.txt"); err != nil {
+		t.Errorf("expected moving a file onto itself to do nothing, got %v", err)
+	}
+	if err := fileSvc.MoveFile(ctx, user, a.ID, sub, "b.txt"); err != nil {
+		t.Fatalf("failed to move a.txt to sub/b.txt: %v", err)
+	}
+	moved, err := fileSvc.GetFileById(ctx, a.ID)
+	if err != nil {
+		t.Fatalf("failed to get moved file: %v", err)
+	}
+	if moved.Location != "sub/b.txt" {
+		t.Errorf("expected the file at sub/b.txt, got %q", moved.Location)
+	}
+}
+
 func TestPersonalFileService_FileHashing(t *testing.T) {
 	fileSvc, _, user, folderID := setupPersonalFileTest(t)
 	ctx := testutil.TestContext(t)
@@ -475,3 +599,31 @@ func TestPersonalFileService_MimeTypeDetection(t *testing.T) {
 		})
 	}
 }
+
+func TestPersonalFileService_StreamsLargeFiles(t *testing.T) {
+	fileSvc, _, user, folderID := setupPersonalFileTest(t)
+	ctx := testutil.TestContext(t)
+
+	// Larger than the sniffing buffer so the peeked bytes and the rest of the
+	// stream both have to end up on disk.
+	content := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 64*1024)
+	reader := createMultipartReader(t, map[string]string{"image.png": content})
+	if err := fileSvc.StoreFiles(ctx, user, reader, folderID, "/"); err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	files, err := fileSvc.GetUserFiles(ctx, user)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d (%v)", len(files), err)
+	}
+	if files[0].Size != int64(len(content)) {
+		t.Errorf("expected size %d, got %d", len(content), files[0].Size)
+	}
+	if files[0].MimeType != "image/png" {
+		t.Errorf("expected image/png, got %s", files[0].MimeType)
+	}
+	want := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	if files[0].Hash != want {
+		t.Errorf("expected hash %s, got %s", want, files[0].Hash)
+	}
+}
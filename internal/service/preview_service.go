@@ -0,0 +1,161 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// PreviewKind is the viewer a file is shown with in the browser.
+type PreviewKind string
+
+const (
+	// PreviewNone is for files that can only be downloaded.
+	PreviewNone     PreviewKind = ""
+	PreviewText     PreviewKind = "text"
+	PreviewMarkdown PreviewKind = "markdown"
+	PreviewImage    PreviewKind = "image"
+	PreviewPDF      PreviewKind = "pdf"
+	PreviewAudio    PreviewKind = "audio"
+	PreviewVideo    PreviewKind = "video"
+)
+
+// maxPreviewText is how much of a text file is rendered, longer ones are cut.
+const maxPreviewText = 256 << 10
+
+// PreviewKindOf picks the viewer of file from its detected MIME type. Markdown
+// is detected as plain text and told apart by its extension.
+func PreviewKindOf(file *model.File) PreviewKind {
+	mimeType, _, _ := strings.Cut(file.MimeType, ";")
+	switch {
+	case mimeType == "application/pdf":
+		return PreviewPDF
+	case strings.HasPrefix(mimeType, "image/") && !strings.HasPrefix(mimeType, "image/svg"):
+		return PreviewImage
+	case strings.HasPrefix(mimeType, "audio/"), mimeType == "application/ogg":
+		return PreviewAudio
+	case strings.HasPrefix(mimeType, "video/"):
+		return PreviewVideo
+	case strings.HasPrefix(mimeType, "text/"):
+		switch strings.ToLower(path.Ext(file.Name)) {
+		case ".md", ".markdown", ".mdown":
+			return PreviewMarkdown
+		}
+		return PreviewText
+	}
+	return PreviewNone
+}
+
+// Inline reports whether contents of the kind are sent for the browser to
+// show itself. Text is rendered into the page instead, so a file cannot run
+// scripts in it.
+func (k PreviewKind) Inline() bool {
+	switch k {
+	case PreviewImage, PreviewPDF, PreviewAudio, PreviewVideo:
+		return true
+	}
+	return false
+}
+
+// Preview is what the preview page of a file shows.
+type Preview struct {
+	Kind PreviewKind
+	// HTML is the highlighted text or the rendered Markdown, sanitized and
+	// safe to put into a page.
+	HTML string
+	// Truncated is set if the text was longer than what is rendered.
+	Truncated bool
+}
+
+// PreviewService renders text files for the browser. Media and PDFs are shown
+// by the browser from their content.
+type PreviewService struct {
+	files    *PersonalFileService
+	markdown goldmark.Markdown
+	policy   *bluemonday.Policy
+}
+
+func NewPreviewService(files *PersonalFileService) *PreviewService {
+	return &PreviewService{
+		files:    files,
+		markdown: goldmark.New(goldmark.WithExtensions(extension.GFM)),
+		policy:   bluemonday.UGCPolicy(),
+	}
+}
+
+// Preview returns the preview of file, rendering its start if it is text.
+func (s *PreviewService) Preview(ctx context.Context, user *model.User, file *model.File) (*Preview, error) {
+	if err := s.files.access.CheckFile(ctx, user.ID, file, model.PermissionRead); err != nil {
+		return nil, err
+	}
+	p := &Preview{Kind: PreviewKindOf(file)}
+	if p.Kind != PreviewText && p.Kind != PreviewMarkdown {
+		return p, nil
+	}
+
+	rc, err := s.files.OpenFile(ctx, user, file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	src, err := io.ReadAll(io.LimitReader(rc, maxPreviewText+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", file.Name, err)
+	}
+	if len(src) > maxPreviewText {
+		src, p.Truncated = src[:maxPreviewText], true
+	}
+	text := strings.ToValidUTF8(string(src), "�")
+
+	if p.Kind == PreviewMarkdown {
+		p.HTML, err = s.renderMarkdown(text)
+	} else {
+		p.HTML, err = highlight(file.Name, text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %q: %w", file.Name, err)
+	}
+	return p, nil
+}
+
+func (s *PreviewService) renderMarkdown(text string) (string, error) {
+	var buf bytes.Buffer
+	if err := s.markdown.Convert([]byte(text), &buf); err != nil {
+		return "", err
+	}
+	return s.policy.Sanitize(buf.String()), nil
+}
+
+// highlight returns text as HTML with line numbers, highlighted by the
+// language its file name or else its content suggests.
+func highlight(name, text string) (string, error) {
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		lexer = lexers.Analyse(text)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	formatter := chromahtml.New(chromahtml.WithLineNumbers(true), chromahtml.TabWidth(4))
+	if err := formatter.Format(&buf, styles.Get("github"), it); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,142 @@
+package service_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+func setupPreviewTest(t *testing.T) (*service.PreviewService, *service.PersonalFileService, *model.User, *model.Folder) {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+	c := path.New(tmpDir)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+
+	access := service.NewAccessService(repository.NewShareRepository(db), repository.NewFolderRepository(db), fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(repository.NewFolderRepository(db), fileRepo, access, c)
+	fileSvc := service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c)
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	root, err := folderSvc.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/")
+	if err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	return service.NewPreviewService(fileSvc), fileSvc, &model.User{ID: userID, Username: "testuser"}, root
+}
+
+func TestPreviewKindOf(t *testing.T) {
+	tests := []struct {
+		name, mimeType string
+		want           service.PreviewKind
+	}{
+		{"main.go", "text/plain; charset=utf-8", service.PreviewText},
+		{"index.html", "text/html; charset=utf-8", service.PreviewText},
+		{"README.md", "text/plain; charset=utf-8", service.PreviewMarkdown},
+		{"notes.MARKDOWN", "text/plain; charset=utf-8", service.PreviewMarkdown},
+		{"photo.jpg", "image/jpeg", service.PreviewImage},
+		{"paper.pdf", "application/pdf", service.PreviewPDF},
+		{"song.mp3", "audio/mpeg", service.PreviewAudio},
+		{"song.ogg", "application/ogg", service.PreviewAudio},
+		{"clip.mp4", "video/mp4", service.PreviewVideo},
+		{"data.bin", "application/octet-stream", service.PreviewNone},
+		{"archive.zip", "application/zip", service.PreviewNone},
+	}
+	for _, tt := range tests {
+		if got := service.PreviewKindOf(&model.File{Name: tt.name, MimeType: tt.mimeType}); got != tt.want {
+			t.Errorf("PreviewKindOf(%s, %s) = %q, want %q", tt.name, tt.mimeType, got, tt.want)
+		}
+	}
+	if service.PreviewText.Inline() || service.PreviewNone.Inline() || !service.PreviewPDF.Inline() {
+		t.Error("expected only media and PDFs to be shown inline")
+	}
+}
+
+func TestPreviewService_Markdown(t *testing.T) {
+	previews, files, user, root := setupPreviewTest(t)
+	ctx := testutil.TestContext(t)
+
+	src := "# Title\n\nSome *text* and [a link](javascript:alert(1)).\n\n<script>alert(1)</script>\n\n| a | b |\n|---|---|\n| 1 | 2 |\n"
+	file, err := files.StoreFile(ctx, user, root.ID, root.Path, "README.md", strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	p, err := previews.Preview(ctx, user, file)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	if p.Kind != service.PreviewMarkdown {
+		t.Fatalf("expected a Markdown preview, got %q", p.Kind)
+	}
+	for _, want := range []string{"<h1", "Title</h1>", "<em>text</em>", "<table>"} {
+		if !strings.Contains(p.HTML, want) {
+			t.Errorf("expected %q in the rendered Markdown, got %s", want, p.HTML)
+		}
+	}
+	for _, unsafe := range []string{"<script", "javascript:"} {
+		if strings.Contains(p.HTML, unsafe) {
+			t.Errorf("expected %q to be removed, got %s", unsafe, p.HTML)
+		}
+	}
+
+	other := &model.User{ID: user.ID + 1, Username: "other"}
+	if _, err := previews.Preview(ctx, other, file); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for another user, got %v", err)
+	}
+}
+
+func TestPreviewService_Text(t *testing.T) {
+	previews, files, user, root := setupPreviewTest(t)
+	ctx := testutil.TestContext(t)
+
+	src := "package main\n\n// <b>not bold</b>\nfunc main() {}\n"
+	file, err := files.StoreFile(ctx, user, root.ID, root.Path, "main.go", strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	p, err := previews.Preview(ctx, user, file)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	if p.Kind != service.PreviewText || p.Truncated {
+		t.Fatalf("expected a complete text preview, got %q, truncated %v", p.Kind, p.Truncated)
+	}
+	if strings.Contains(p.HTML, "<b>") || !strings.Contains(p.HTML, "&lt;b&gt;") {
+		t.Errorf("expected the text to be escaped, got %s", p.HTML)
+	}
+	if !strings.Contains(p.HTML, "<pre") || !strings.Contains(p.HTML, ">package<") {
+		t.Errorf("expected highlighted Go, got %s", p.HTML)
+	}
+
+	long, err := files.StoreFile(ctx, user, root.ID, root.Path, "long.txt", strings.NewReader(strings.Repeat("line\n", 100_000)))
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	if p, err = previews.Preview(ctx, user, long); err != nil || !p.Truncated {
+		t.Errorf("expected a long text to be cut, got %v", err)
+	}
+
+	bin, err := files.StoreFile(ctx, user, root.ID, root.Path, "data.bin", strings.NewReader("\x00\x01\x02"))
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	if p, err = previews.Preview(ctx, user, bin); err != nil || p.Kind != service.PreviewNone || p.HTML != "" {
+		t.Errorf("expected no preview for binary data, got %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	ErrInvalidQuota  = errors.New("quota must not be negative")
+	ErrUserNotFound  = errors.New("user not found")
+)
+
+// QuotaService limits how much space the files of a user take up. Usage is
+// the total size of their current files, kept up to date by triggers on the
+// files table. Older versions and the trash are not counted by design, they
+// are bounded by the version and trash retention instead. Restoring from the
+// trash counts the restored files again and is checked like an upload.
+type QuotaService struct {
+	repo *repository.UserRepository
+	// defaultQuota applies to users without a quota of their own, 0 means unlimited.
+	defaultQuota int64
+}
+
+func NewQuotaService(repo *repository.UserRepository, defaultQuota int64) *QuotaService {
+	return &QuotaService{repo, defaultQuota}
+}
+
+func (s *QuotaService) GetUsage(ctx context.Context, userID int64) (*model.Usage, error) {
+	used, quota, err := s.repo.GetUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	usage := &model.Usage{Used: used, Quota: s.defaultQuota}
+	if quota.Valid {
+		usage.Quota = quota.Int64
+	}
+	return usage, nil
+}
+
+// Check returns ErrQuotaExceeded if the files of a user cannot grow by size
+// bytes. A negative size always fits.
+func (s *QuotaService) Check(ctx context.Context, userID, size int64) error {
+	usage, err := s.GetUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if available := usage.Available(); available >= 0 && size > available {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Limit wraps src in a reader that fails with ErrQuotaExceeded as soon as
+// more is read than the quota of the user leaves. replaced is the size of a
+// file src replaces, its bytes are freed once src is stored.
+func (s *QuotaService) Limit(ctx context.Context, userID, replaced int64, src io.Reader) (io.Reader, error) {
+	usage, err := s.GetUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	available := usage.Available()
+	if available < 0 {
+		return src, nil
+	}
+	return &quotaReader{src, available + replaced}, nil
+}
+
+// SetQuota sets the quota of a user in bytes, 0 being unlimited. An invalid
+// quota applies the instance default again.
+func (s *QuotaService) SetQuota(ctx context.Context, username string, quota sql.NullInt64) error {
+	if quota.Valid && quota.Int64 < 0 {
+		return ErrInvalidQuota
+	}
+	user, err := s.repo.GetByUsername(ctx, username)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	return s.repo.UpdateQuota(ctx, user.ID, quota)
+}
+
+// quotaReader fails once more than remaining bytes were read, so storage
+// drops the partial content instead of saving it.
+type quotaReader struct {
+	src       io.Reader
+	remaining int64
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, ErrQuotaExceeded
+	}
+	// Read one byte past the quota to tell a file that fits exactly from one that does not.
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.src.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrQuotaExceeded
+	}
+	return n, err
+}
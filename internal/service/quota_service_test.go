@@ -0,0 +1,250 @@
+package service_test
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type quotaTest struct {
+	quota  *service.QuotaService
+	files  *service.PersonalFileService
+	tus    *service.TusService
+	trash  *service.TrashService
+	folder *model.Folder
+	user   *model.User
+	dir    string
+}
+
+// setupQuotaTest uses an instance default of 10 bytes.
+func setupQuotaTest(t *testing.T) *quotaTest {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+	c := path.New(tmpDir)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+
+	quotaSvc := service.NewQuotaService(userRepo, 10)
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	root, err := folderSvc.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/")
+	if err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	fileSvc := service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, quotaSvc, folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c)
+	return &quotaTest{
+		quota:  quotaSvc,
+		files:  fileSvc,
+		tus:    service.NewTusService(repository.NewTusUploadRepository(db), fileRepo, folderRepo, fileSvc, quotaSvc, access, st, c),
+		trash:  service.NewTrashService(repository.NewTrashRepository(db), folderRepo, fileRepo, quotaSvc, access, c),
+		folder: root,
+		user:   &model.User{ID: userID, Username: "testuser"},
+		dir:    tmpDir,
+	}
+}
+
+func (qt *quotaTest) store(t *testing.T, name, content string) (*model.File, error) {
+	t.Helper()
+	return qt.files.StoreFile(testutil.TestContext(t), qt.user, qt.folder.ID, qt.folder.Path, name, strings.NewReader(content))
+}
+
+func (qt *quotaTest) used(t *testing.T) int64 {
+	t.Helper()
+	usage, err := qt.quota.GetUsage(testutil.TestContext(t), qt.user.ID)
+	if err != nil {
+		t.Fatalf("GetUsage failed: %v", err)
+	}
+	return usage.Used
+}
+
+func TestQuotaService_TracksUsage(t *testing.T) {
+	qt := setupQuotaTest(t)
+	ctx := testutil.TestContext(t)
+
+	a, err := qt.store(t, "a.txt", "12345")
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	if _, err := qt.store(t, "b.txt", "123"); err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	if used := qt.used(t); used != 8 {
+		t.Errorf("expected 8 bytes used, got %d", used)
+	}
+
+	// A new version replaces the size of the file.
+	if _, err := qt.store(t, "a.txt", "1"); err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	if used := qt.used(t); used != 4 {
+		t.Errorf("expected 4 bytes used after replacing a file, got %d", used)
+	}
+
+	if err := qt.files.DeleteFile(ctx, qt.user, a.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if used := qt.used(t); used != 3 {
+		t.Errorf("expected 3 bytes used after delete, got %d", used)
+	}
+}
+
+func TestQuotaService_RejectsUploadsBeyondQuota(t *testing.T) {
+	qt := setupQuotaTest(t)
+
+	if _, err := qt.store(t, "fits.txt", "1234567890"); err != nil {
+		t.Fatalf("expected a file filling the quota exactly to be stored, got %v", err)
+	}
+	if _, err := qt.store(t, "more.txt", "x"); !errors.Is(err, service.ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if used := qt.used(t); used != 10 {
+		t.Errorf("expected 10 bytes used, got %d", used)
+	}
+	if _, err := qt.files.GetFileByName(testutil.TestContext(t), qt.user.ID, qt.folder.ID, "more.txt"); err == nil {
+		t.Error("expected the rejected file not to be recorded")
+	}
+	if n := countBlobs(t, qt.dir); n != 1 {
+		t.Errorf("expected the rejected content not to be stored, got %d blobs", n)
+	}
+
+	// Replacing a file frees its bytes.
+	if _, err := qt.store(t, "fits.txt", "0987654321"); err != nil {
+		t.Errorf("expected replacing a file with one of the same size to fit, got %v", err)
+	}
+	if _, err := qt.store(t, "fits.txt", "0987654321!"); !errors.Is(err, service.ErrQuotaExceeded) {
+		t.Errorf("expected a larger replacement to exceed the quota, got %v", err)
+	}
+}
+
+func TestQuotaService_RestoreFromTrash(t *testing.T) {
+	qt := setupQuotaTest(t)
+	ctx := testutil.TestContext(t)
+
+	file, err := qt.store(t, "a.txt", "1234567")
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	item, err := qt.trash.TrashFile(ctx, qt.user, file.ID)
+	if err != nil {
+		t.Fatalf("TrashFile failed: %v", err)
+	}
+	if _, err := qt.store(t, "b.txt", "12345"); err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+
+	if _, err := qt.trash.Restore(ctx, qt.user, item.ID); !errors.Is(err, service.ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if used := qt.used(t); used != 5 {
+		t.Errorf("expected 5 bytes used, got %d", used)
+	}
+
+	other, err := qt.files.GetFileByName(ctx, qt.user.ID, qt.folder.ID, "b.txt")
+	if err != nil {
+		t.Fatalf("GetFileByName failed: %v", err)
+	}
+	if err := qt.files.DeleteFile(ctx, qt.user, other.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if _, err := qt.trash.Restore(ctx, qt.user, item.ID); err != nil {
+		t.Errorf("expected the item to be restored once it fits, got %v", err)
+	}
+	if used := qt.used(t); used != 7 {
+		t.Errorf("expected 7 bytes used, got %d", used)
+	}
+}
+
+func TestQuotaService_UserQuota(t *testing.T) {
+	qt := setupQuotaTest(t)
+	ctx := testutil.TestContext(t)
+
+	if err := qt.quota.SetQuota(ctx, "testuser", sql.NullInt64{Int64: 20, Valid: true}); err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+	if _, err := qt.store(t, "big.txt", strings.Repeat("x", 15)); err != nil {
+		t.Errorf("expected the user quota to replace the default, got %v", err)
+	}
+
+	if err := qt.quota.SetQuota(ctx, "testuser", sql.NullInt64{Int64: 0, Valid: true}); err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+	if _, err := qt.store(t, "huge.txt", strings.Repeat("x", 100)); err != nil {
+		t.Errorf("expected a quota of 0 to be unlimited, got %v", err)
+	}
+	usage, err := qt.quota.GetUsage(ctx, qt.user.ID)
+	if err != nil || usage.Available() != -1 {
+		t.Errorf("expected unlimited usage, got %+v, %v", usage, err)
+	}
+
+	if err := qt.quota.SetQuota(ctx, "testuser", sql.NullInt64{}); err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+	usage, err = qt.quota.GetUsage(ctx, qt.user.ID)
+	if err != nil || usage.Quota != 10 || usage.Available() != 0 || usage.Percent() != 100 {
+		t.Errorf("expected the default quota to apply again, got %+v, %v", usage, err)
+	}
+
+	if err := qt.quota.SetQuota(ctx, "testuser", sql.NullInt64{Int64: -1, Valid: true}); !errors.Is(err, service.ErrInvalidQuota) {
+		t.Errorf("expected ErrInvalidQuota, got %v", err)
+	}
+	if err := qt.quota.SetQuota(ctx, "nobody", sql.NullInt64{}); !errors.Is(err, service.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestQuotaService_CopyFile(t *testing.T) {
+	qt := setupQuotaTest(t)
+	ctx := testutil.TestContext(t)
+
+	file, err := qt.store(t, "a.txt", "123456")
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	if _, err := qt.files.CopyFile(ctx, qt.user, file.ID, qt.folder, "b.txt"); !errors.Is(err, service.ErrQuotaExceeded) {
+		t.Errorf("expected copies to count towards the quota, got %v", err)
+	}
+}
+
+func TestQuotaService_TusUpload(t *testing.T) {
+	qt := setupQuotaTest(t)
+	ctx := testutil.TestContext(t)
+
+	if _, err := qt.tus.CreateUpload(ctx, qt.user, qt.folder.ID, "big.bin", 11); !errors.Is(err, service.ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded for a declared length beyond the quota, got %v", err)
+	}
+
+	upload, err := qt.tus.CreateUpload(ctx, qt.user, qt.folder.ID, "late.bin", 6)
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+	// Another upload uses up the quota in the meantime.
+	if _, err := qt.store(t, "other.txt", "12345"); err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	if _, err := qt.tus.WriteChunk(ctx, qt.user, upload.UploadToken, 0, strings.NewReader("abcdef")); !errors.Is(err, service.ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded when completing, got %v", err)
+	}
+	if _, err := qt.tus.GetUpload(ctx, qt.user.ID, upload.UploadToken); !errors.Is(err, service.ErrUploadNotFound) {
+		t.Errorf("expected the rejected upload to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/ledongthuc/pdf"
+)
+
+var (
+	ErrEmptySearch = errors.New("search query is empty")
+)
+
+const (
+	// maxSearchText is how much text of a content is indexed, the rest of
+	// longer texts cannot be found.
+	maxSearchText = 1 << 20
+	// searchBatch is how many contents Index looks up at once.
+	searchBatch = 20
+
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// searchTextTypes are LIKE patterns of the detected types whose text is
+// indexed. Markdown is detected as plain text.
+var searchTextTypes = []string{"text/%", "application/pdf"}
+
+// SearchService finds files by their name, folder path and text. Names and
+// paths are indexed by the database as files are stored, moved and deleted;
+// the text of a content is extracted once and shared by all files with that
+// content.
+type SearchService struct {
+	repo    *repository.SearchRepository
+	folders *FolderService
+	st      storage.FileManager
+}
+
+func NewSearchService(repo *repository.SearchRepository, folders *FolderService, st storage.FileManager) *SearchService {
+	return &SearchService{repo: repo, folders: folders, st: st}
+}
+
+// Search returns the files of user matching all words of q.Text and its
+// filters, best matches first. Words match as prefixes, so "rep" finds a
+// report. A search in a folder shared with the user covers the files of its
+// owner below it.
+func (s *SearchService) Search(ctx context.Context, user *model.User, q *model.SearchQuery) ([]*model.SearchResult, error) {
+	match := matchQuery(q.Text)
+	if match == "" {
+		return nil, ErrEmptySearch
+	}
+	ownerID, folderPath := user.ID, ""
+	if q.FolderID != 0 {
+		folder, err := s.folders.GetById(ctx, user.ID, q.FolderID)
+		if err != nil {
+			return nil, err
+		}
+		ownerID, folderPath = folder.UserID, folder.Path
+	}
+	query := *q
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchLimit
+	}
+	query.Limit = min(query.Limit, maxSearchLimit)
+	query.Offset = max(query.Offset, 0)
+	return s.repo.Search(ctx, ownerID, match, folderPath, &query)
+}
+
+// matchQuery turns the words of text into an FTS5 query for files containing
+// all of them. Every word is quoted, so nothing in text is taken as syntax.
+func matchQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		// Words of only punctuation hold no token to look for.
+		if !strings.ContainsFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) {
+			continue
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// IndexFile extracts the text of the content of file unless that was done
+// before, so the file can be found by its text right away.
+func (s *SearchService) IndexFile(ctx context.Context, file *model.File) error {
+	if !hasSearchText(file.MimeType) {
+		return nil
+	}
+	return s.index(ctx, file.Hash, file.MimeType)
+}
+
+// Index extracts the missing texts of all contents, e.g. of uploads that did
+// not go through PersonalFileService, and returns how many it extracted.
+func (s *SearchService) Index(ctx context.Context) (int, error) {
+	indexed := 0
+	for _, mimeType := range searchTextTypes {
+		for {
+			hashes, err := s.repo.GetMissing(ctx, mimeType, searchBatch)
+			if err != nil {
+				return indexed, err
+			}
+			if len(hashes) == 0 {
+				break
+			}
+			for _, hash := range hashes {
+				if err := s.index(ctx, hash, mimeType); err != nil {
+					return indexed, err
+				}
+				indexed++
+			}
+		}
+	}
+	return indexed, nil
+}
+
+func hasSearchText(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") || mimeType == "application/pdf"
+}
+
+// index records the text of the content hash. Contents without text, like a
+// PDF that cannot be parsed, are recorded with an empty one, so they are not
+// tried again.
+func (s *SearchService) index(ctx context.Context, hash, mimeType string) error {
+	if ok, err := s.repo.HasText(ctx, hash); err != nil || ok {
+		return err
+	}
+	rc, err := s.st.OpenFile(hash)
+	if err != nil {
+		return fmt.Errorf("failed to open content %s: %w", hash, err)
+	}
+	defer func() { _ = rc.Close() }()
+
+	var text string
+	if mimeType == "application/pdf" {
+		text = pdfText(rc)
+	} else {
+		b, err := io.ReadAll(io.LimitReader(rc, maxSearchText))
+		if err != nil {
+			return fmt.Errorf("failed to read content %s: %w", hash, err)
+		}
+		text = string(b)
+	}
+	return s.repo.InsertText(ctx, hash, strings.ToValidUTF8(text, " "))
+}
+
+// pdfText returns the text of the pages of a PDF, empty if it cannot be
+// parsed. The parser panics on some broken files, which counts as such.
+func pdfText(rs io.ReadSeeker) (text string) {
+	defer func() {
+		if recover() != nil {
+			text = ""
+		}
+	}()
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return ""
+	}
+	ra, ok := rs.(io.ReaderAt)
+	if !ok {
+		ra = &seekReaderAt{rs: rs}
+	}
+	r, err := pdf.NewReader(ra, size)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for i := 1; i <= r.NumPage() && b.Len() < maxSearchText; i++ {
+		page, err := r.Page(i).GetPlainText(nil)
+		if err != nil {
+			continue
+		}
+		b.WriteString(page)
+		b.WriteByte('\n')
+	}
+	text = b.String()
+	if len(text) > maxSearchText {
+		text = text[:maxSearchText]
+	}
+	return text
+}
+
+// seekReaderAt reads at offsets of a content that cannot do so itself, like
+// an encrypted one.
+type seekReaderAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (r *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.rs, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,267 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type searchTest struct {
+	search   *service.SearchService
+	files    *service.PersonalFileService
+	folders  *service.FolderService
+	trash    *service.TrashService
+	fileRepo *repository.PersonalFileRepository
+	st       storage.FileManager
+	user     *model.User
+	root     *model.Folder
+}
+
+func setupSearchTest(t *testing.T) *searchTest {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+	c := path.New(tmpDir)
+
+	userRepo := repository.NewUserRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+
+	tt := &searchTest{fileRepo: repository.NewPersonalFileRepository(db), st: st}
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, tt.fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), tt.fileRepo, access, st, service.VersionPolicy{})
+	tt.folders = service.NewFolderService(folderRepo, tt.fileRepo, access, c)
+	tt.search = service.NewSearchService(repository.NewSearchRepository(db), tt.folders, st)
+	tt.files = service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), tt.fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), tt.folders, tt.search, access, c)
+	tt.trash = service.NewTrashService(repository.NewTrashRepository(db), folderRepo, tt.fileRepo, service.NewQuotaService(userRepo, 0), access, c)
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	tt.user = &model.User{ID: userID, Username: "testuser"}
+	if tt.root, err = tt.folders.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/"); err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	return tt
+}
+
+func (tt *searchTest) store(t *testing.T, folder *model.Folder, name, content string) *model.File {
+	t.Helper()
+	file, err := tt.files.StoreFile(testutil.TestContext(t), tt.user, folder.ID, folder.Path, name, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	return file
+}
+
+func (tt *searchTest) mkdir(t *testing.T, rel string) *model.Folder {
+	t.Helper()
+	folder, err := tt.folders.MkdirAll(testutil.TestContext(t), tt.user, tt.root, rel)
+	if err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	return folder
+}
+
+// find returns the sorted paths of the files q finds.
+func (tt *searchTest) find(t *testing.T, q model.SearchQuery) []string {
+	t.Helper()
+	results, err := tt.search.Search(testutil.TestContext(t), tt.user, &q)
+	if err != nil {
+		t.Fatalf("Search for %q failed: %v", q.Text, err)
+	}
+	paths := make([]string, len(results))
+	for i, r := range results {
+		paths[i] = r.File.Location
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (tt *searchTest) expect(t *testing.T, q model.SearchQuery, want ...string) {
+	t.Helper()
+	got := tt.find(t, q)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("search for %q: expected %v, got %v", q.Text, want, got)
+	}
+}
+
+func TestSearchService_Search(t *testing.T) {
+	tt := setupSearchTest(t)
+	ctx := testutil.TestContext(t)
+
+	projects := tt.mkdir(t, "projects/alpha")
+	tt.store(t, tt.root, "Quarterly_Report.txt", "Revenue grew in the third quarter.")
+	tt.store(t, tt.root, "notes.md", "# Notes\n\nThe café on the corner serves good coffee.")
+	tt.store(t, projects, "plan.txt", "Milestones for the launch.")
+	tt.store(t, projects, "logo.png", string(encodePNG(t, testImage(8, 8, color.White))))
+
+	tt.expect(t, model.SearchQuery{Text: "report"}, "Quarterly_Report.txt")
+	tt.expect(t, model.SearchQuery{Text: "quart"}, "Quarterly_Report.txt")
+	tt.expect(t, model.SearchQuery{Text: "revenue"}, "Quarterly_Report.txt")
+	tt.expect(t, model.SearchQuery{Text: "cafe"}, "notes.md")
+	tt.expect(t, model.SearchQuery{Text: "alpha"}, "projects/alpha/logo.png", "projects/alpha/plan.txt")
+	tt.expect(t, model.SearchQuery{Text: "alpha launch"}, "projects/alpha/plan.txt")
+	// Query syntax is taken literally.
+	tt.expect(t, model.SearchQuery{Text: `"the* (`}, "Quarterly_Report.txt", "notes.md", "projects/alpha/plan.txt")
+	tt.expect(t, model.SearchQuery{Text: "missing"})
+
+	tt.expect(t, model.SearchQuery{Text: "the", FolderID: projects.ID}, "projects/alpha/plan.txt")
+	tt.expect(t, model.SearchQuery{Text: "alpha", MimeType: "image/"}, "projects/alpha/logo.png")
+	tt.expect(t, model.SearchQuery{Text: "the", MinSize: 30}, "Quarterly_Report.txt", "notes.md")
+	tt.expect(t, model.SearchQuery{Text: "the", MaxSize: 30}, "projects/alpha/plan.txt")
+	tt.expect(t, model.SearchQuery{Text: "the", After: time.Now().Add(-time.Hour)}, "Quarterly_Report.txt", "notes.md", "projects/alpha/plan.txt")
+	tt.expect(t, model.SearchQuery{Text: "the", Before: time.Now().Add(-time.Hour)})
+
+	results, err := tt.search.Search(ctx, tt.user, &model.SearchQuery{Text: "coffee"})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected one result, got %v, %v", results, err)
+	}
+	if want := model.MatchStart + "coffee" + model.MatchEnd; !strings.Contains(results[0].Snippet, want) {
+		t.Errorf("expected the snippet to mark the match, got %q", results[0].Snippet)
+	}
+
+	if _, err := tt.search.Search(ctx, tt.user, &model.SearchQuery{Text: " - * "}); !errors.Is(err, service.ErrEmptySearch) {
+		t.Errorf("expected ErrEmptySearch, got %v", err)
+	}
+	other := &model.User{ID: tt.user.ID + 1, Username: "other"}
+	if res, err := tt.search.Search(ctx, other, &model.SearchQuery{Text: "report"}); err != nil || len(res) != 0 {
+		t.Errorf("expected no results for another user, got %v, %v", res, err)
+	}
+	if _, err := tt.search.Search(ctx, other, &model.SearchQuery{Text: "report", FolderID: projects.ID}); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound for a folder of another user, got %v", err)
+	}
+}
+
+func TestSearchService_FollowsChanges(t *testing.T) {
+	tt := setupSearchTest(t)
+	ctx := testutil.TestContext(t)
+
+	docs := tt.mkdir(t, "docs")
+	file := tt.store(t, docs, "draft.txt", "first words")
+	tt.expect(t, model.SearchQuery{Text: "first"}, "docs/draft.txt")
+
+	// A new upload replaces the text of the old content.
+	tt.store(t, docs, "draft.txt", "second words")
+	tt.expect(t, model.SearchQuery{Text: "first"})
+	tt.expect(t, model.SearchQuery{Text: "second"}, "docs/draft.txt")
+
+	if err := tt.files.MoveFile(ctx, tt.user, file.ID, tt.root, "final.txt"); err != nil {
+		t.Fatalf("MoveFile failed: %v", err)
+	}
+	tt.expect(t, model.SearchQuery{Text: "draft"})
+	tt.expect(t, model.SearchQuery{Text: "final second"}, "final.txt")
+
+	if _, err := tt.files.CopyFile(ctx, tt.user, file.ID, docs, "copy.txt"); err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+	tt.expect(t, model.SearchQuery{Text: "second"}, "docs/copy.txt", "final.txt")
+
+	archive := tt.mkdir(t, "archive")
+	if err := tt.folders.MoveFolder(ctx, tt.user, docs.ID, archive.ID, "old"); err != nil {
+		t.Fatalf("MoveFolder failed: %v", err)
+	}
+	tt.expect(t, model.SearchQuery{Text: "docs"})
+	tt.expect(t, model.SearchQuery{Text: "archive old"}, "archive/old/copy.txt")
+
+	item, err := tt.trash.TrashFolder(ctx, tt.user, archive.ID)
+	if err != nil {
+		t.Fatalf("TrashFolder failed: %v", err)
+	}
+	tt.expect(t, model.SearchQuery{Text: "second"}, "final.txt")
+	if _, err := tt.trash.Restore(ctx, tt.user, item.ID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	tt.expect(t, model.SearchQuery{Text: "second"}, "archive/old/copy.txt", "final.txt")
+
+	if err := tt.files.DeleteFile(ctx, tt.user, file.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	// Restoring made a new folder.
+	if archive, err = tt.folders.GetByDBPath(ctx, tt.user.ID, "archive"); err != nil {
+		t.Fatalf("GetByDBPath failed: %v", err)
+	}
+	if _, err := tt.folders.DeleteFolder(ctx, tt.user, archive.ID); err != nil {
+		t.Fatalf("DeleteFolder failed: %v", err)
+	}
+	tt.expect(t, model.SearchQuery{Text: "second"})
+}
+
+// insert records a file the way resumable uploads do, without indexing it.
+func (tt *searchTest) insert(t *testing.T, name string, content []byte, mimeType string) {
+	t.Helper()
+	hash, size, err := tt.st.SaveFile(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if _, err := tt.fileRepo.Insert(context.Background(), name, mimeType, name, hash, tt.user.ID, size, tt.root.ID); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+}
+
+// minimalPDF returns a one page PDF showing text.
+func minimalPDF(text string) []byte {
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+func TestSearchService_Index(t *testing.T) {
+	tt := setupSearchTest(t)
+	ctx := testutil.TestContext(t)
+
+	tt.insert(t, "a.txt", []byte("resumable upload"), "text/plain; charset=utf-8")
+	tt.insert(t, "b.pdf", minimalPDF("Invoice for consulting"), "application/pdf")
+	tt.insert(t, "broken.pdf", []byte("%PDF-1.4\nnot really"), "application/pdf")
+	tt.insert(t, "c.bin", []byte{0, 1, 2}, "application/octet-stream")
+	tt.expect(t, model.SearchQuery{Text: "resumable"})
+
+	if n, err := tt.search.Index(ctx); err != nil || n != 3 {
+		t.Fatalf("expected the texts of 3 contents, got %d, %v", n, err)
+	}
+	if n, err := tt.search.Index(ctx); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to do, got %d, %v", n, err)
+	}
+	tt.expect(t, model.SearchQuery{Text: "resumable"}, "a.txt")
+	tt.expect(t, model.SearchQuery{Text: "consulting"}, "b.pdf")
+	tt.expect(t, model.SearchQuery{Text: "pdf"}, "b.pdf", "broken.pdf")
+
+	// A stored PDF is indexed right away.
+	tt.store(t, tt.root, "stored.pdf", string(minimalPDF("Quarterly figures")))
+	tt.expect(t, model.SearchQuery{Text: "figures"}, "stored.pdf")
+}
@@ -8,34 +8,87 @@ import (
 )
 
 type Services struct {
-	Auth       *AuthService
-	UploadLink *UploadLinkService
-	LinkUnlock *LinkUnlockService
-	PFile      *PersonalFileService
-	Folder     *FolderService
+	Auth         *AuthService
+	UploadLink   *UploadLinkService
+	PFile        *PersonalFileService
+	Folder       *FolderService
+	Tus          *TusService
+	APIToken     *APITokenService
+	Trash        *TrashService
+	Version      *FileVersionService
+	Blob         *BlobService
+	Quota        *QuotaService
+	Archive      *ArchiveService
+	Extract      *ExtractService
+	Thumbnail    *ThumbnailService
+	Preview      *PreviewService
+	Search       *SearchService
+	Access       *AccessService
+	Share        *ShareService
+	Group        *GroupService
+	DownloadLink *DownloadLinkService
 }
 
 // InitServices wires all services and repositories together. It is the main
-// dependency injection point.
-func InitServices(db *sql.DB, st storage.FileManager, c *path.Converter) *Services {
+// dependency injection point. defaultQuota is the quota in bytes of users
+// without one of their own, 0 means unlimited. linkSecret signs the cookies
+// of visitors that entered the password of an upload or download link.
+func InitServices(db *sql.DB, st storage.FileManager, c *path.Converter, versions VersionPolicy, defaultQuota int64, extract ExtractLimits, linkSecret []byte) *Services {
 	userRepo := repository.NewUserRepository(db)
 	sessRepo := repository.NewSessionRepository(db)
 	linkRepo := repository.NewUploadLinkRepository(db)
-	linkUnlockRepo := repository.NewLinkUnlockRepository(db)
 	fileRepo := repository.NewPersonalFileRepository(db)
 	folderRepo := repository.NewFolderRepository(db)
+	tusRepo := repository.NewTusUploadRepository(db)
+	apiTokenRepo := repository.NewAPITokenRepository(db)
+	trashRepo := repository.NewTrashRepository(db)
+	versionRepo := repository.NewFileVersionRepository(db)
+	blobRepo := repository.NewBlobRepository(db)
+	thumbnailRepo := repository.NewThumbnailRepository(db)
+	searchRepo := repository.NewSearchRepository(db)
+	shareRepo := repository.NewShareRepository(db)
+	groupRepo := repository.NewGroupRepository(db)
+	downloadLinkRepo := repository.NewDownloadLinkRepository(db)
 
 	authSvc := NewAuthService(userRepo, sessRepo)
-	linkSvc := NewUploadLinkService(linkRepo)
-	linkUnlockSvc := NewLinkUnlockService(linkUnlockRepo)
-	folderSvc := NewFolderService(folderRepo, fileRepo, st, c)
-	pFileSvc := NewPersonalFileService(st, fileRepo, c)
+	accessSvc := NewAccessService(shareRepo, folderRepo, fileRepo)
+	folderSvc := NewFolderService(folderRepo, fileRepo, accessSvc, c)
+	versionSvc := NewFileVersionService(versionRepo, fileRepo, accessSvc, st, versions)
+	quotaSvc := NewQuotaService(userRepo, defaultQuota)
+	searchSvc := NewSearchService(searchRepo, folderSvc, st)
+	blobSvc := NewBlobService(blobRepo, st)
+	pFileSvc := NewPersonalFileService(st, blobSvc, fileRepo, versionSvc, quotaSvc, folderSvc, searchSvc, accessSvc, c)
+	linkSvc := NewUploadLinkService(linkRepo, userRepo, pFileSvc, linkSecret)
+	tusSvc := NewTusService(tusRepo, fileRepo, folderRepo, pFileSvc, quotaSvc, accessSvc, st, c)
+	apiTokenSvc := NewAPITokenService(apiTokenRepo, userRepo)
+	trashSvc := NewTrashService(trashRepo, folderRepo, fileRepo, quotaSvc, accessSvc, c)
+	archiveSvc := NewArchiveService(folderRepo, fileRepo, accessSvc, st)
+	extractSvc := NewExtractService(folderSvc, pFileSvc, quotaSvc, extract)
+	thumbnailSvc := NewThumbnailService(thumbnailRepo, accessSvc, st, blobSvc)
+	previewSvc := NewPreviewService(pFileSvc)
+	groupSvc := NewGroupService(groupRepo, userRepo)
+	shareSvc := NewShareService(shareRepo, userRepo, groupSvc, accessSvc)
+	downloadLinkSvc := NewDownloadLinkService(downloadLinkRepo, userRepo, pFileSvc, folderSvc, archiveSvc, accessSvc, linkSecret)
 
 	return &Services{
-		Auth:       authSvc,
-		UploadLink: linkSvc,
-		LinkUnlock: linkUnlockSvc,
-		PFile:      pFileSvc,
-		Folder:     folderSvc,
+		Auth:         authSvc,
+		UploadLink:   linkSvc,
+		PFile:        pFileSvc,
+		Folder:       folderSvc,
+		Tus:          tusSvc,
+		APIToken:     apiTokenSvc,
+		Trash:        trashSvc,
+		Version:      versionSvc,
+		Blob:         blobSvc,
+		Quota:        quotaSvc,
+		Archive:      archiveSvc,
+		Extract:      extractSvc,
+		Thumbnail:    thumbnailSvc,
+		Preview:      previewSvc,
+		Search:       searchSvc,
+		Access:       accessSvc,
+		Share:        shareSvc,
+		Group:        groupSvc,
+		DownloadLink: downloadLinkSvc,
 	}
 }
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+)
+
+var (
+	ErrShareNotFound     = errors.New("share not found")
+	ErrInvalidPermission = errors.New("permission must be read, write or manage")
+	ErrCannotShareRoot   = errors.New("cannot share the root folder")
+	ErrShareWithOwner    = errors.New("cannot share an item with its owner")
+)
+
+// ShareTarget is who an item is shared with: the user called Username or, if
+// GroupID is set, a group of the user sharing it.
+type ShareTarget struct {
+	Username string
+	GroupID  int64
+}
+
+// ShareService shares folders and files with other users and groups. Sharing
+// and listing the shares of an item needs PermissionManage on it, which the
+// owner always has.
+type ShareService struct {
+	repo     *repository.ShareRepository
+	userRepo *repository.UserRepository
+	groups   *GroupService
+	access   *AccessService
+}
+
+func NewShareService(repo *repository.ShareRepository, userRepo *repository.UserRepository, groups *GroupService, access *AccessService) *ShareService {
+	return &ShareService{repo, userRepo, groups, access}
+}
+
+// ShareFolder shares a folder with everything below it. Sharing with the same
+// target again changes the permission.
+func (s *ShareService) ShareFolder(ctx context.Context, user *model.User, folderID int64, to ShareTarget, p model.Permission) (*model.Share, error) {
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	if !folder.ParentID.Valid {
+		return nil, ErrCannotShareRoot
+	}
+	return s.share(ctx, user, folder.UserID, &model.Share{FolderID: sql.NullInt64{Int64: folder.ID, Valid: true}}, to, p)
+}
+
+// ShareFile shares a single file. Sharing with the same target again changes
+// the permission.
+func (s *ShareService) ShareFile(ctx context.Context, user *model.User, fileID int64, to ShareTarget, p model.Permission) (*model.Share, error) {
+	file, err := s.access.File(ctx, user.ID, fileID, model.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	return s.share(ctx, user, file.UserID, &model.Share{FileID: sql.NullInt64{Int64: file.ID, Valid: true}}, to, p)
+}
+
+func (s *ShareService) share(ctx context.Context, user *model.User, ownerID int64, share *model.Share, to ShareTarget, p model.Permission) (*model.Share, error) {
+	if p < model.PermissionRead || p > model.PermissionManage {
+		return nil, ErrInvalidPermission
+	}
+	if to.GroupID != 0 {
+		g, err := s.groups.GetGroup(ctx, user, to.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		share.GroupID = sql.NullInt64{Int64: g.ID, Valid: true}
+	} else {
+		target, err := s.userRepo.GetByUsername(ctx, strings.TrimSpace(to.Username))
+		if err != nil {
+			return nil, ErrUserNotFound
+		}
+		if target.ID == ownerID {
+			return nil, ErrShareWithOwner
+		}
+		share.UserID = sql.NullInt64{Int64: target.ID, Valid: true}
+	}
+
+	if existing, err := s.repo.GetByItemAndTarget(ctx, share.FolderID, share.FileID, share.UserID, share.GroupID); err == nil {
+		if err := s.repo.UpdatePermission(ctx, existing.ID, p); err != nil {
+			return nil, err
+		}
+		return s.repo.GetByID(ctx, existing.ID)
+	}
+	share.Permission, share.CreatedBy = p, user.ID
+	id, err := s.repo.Insert(ctx, share)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(ctx, id)
+}
+
+// GetFolderShares returns the shares of a folder. Shares of the folders above
+// it apply as well but are not listed.
+func (s *ShareService) GetFolderShares(ctx context.Context, user *model.User, folderID int64) ([]*model.Share, error) {
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByItem(ctx, sql.NullInt64{Int64: folder.ID, Valid: true}, sql.NullInt64{})
+}
+
+// GetFileShares returns the shares of a file.
+func (s *ShareService) GetFileShares(ctx context.Context, user *model.User, fileID int64) ([]*model.Share, error) {
+	file, err := s.access.File(ctx, user.ID, fileID, model.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByItem(ctx, sql.NullInt64{}, sql.NullInt64{Int64: file.ID, Valid: true})
+}
+
+// RevokeShare deletes a share. Users with PermissionManage on the item may
+// revoke any of its shares, everyone else only leave a share with themselves.
+func (s *ShareService) RevokeShare(ctx context.Context, user *model.User, shareID int64) error {
+	share, err := s.repo.GetByID(ctx, shareID)
+	if err != nil {
+		return ErrShareNotFound
+	}
+	if !share.UserID.Valid || share.UserID.Int64 != user.ID {
+		if share.FolderID.Valid {
+			_, err = s.access.Folder(ctx, user.ID, share.FolderID.Int64, model.PermissionManage)
+		} else {
+			_, err = s.access.File(ctx, user.ID, share.FileID.Int64, model.PermissionManage)
+		}
+		if errors.Is(err, ErrFolderNotFound) || errors.Is(err, ErrFileNotFound) {
+			return ErrShareNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.repo.Delete(ctx, share.ID)
+}
+
+// GetSharedWithUser returns the folders and files of others shared with the
+// user, directly or through a group.
+func (s *ShareService) GetSharedWithUser(ctx context.Context, user *model.User) ([]*model.SharedItem, error) {
+	return s.repo.GetSharedWith(ctx, user.ID)
+}
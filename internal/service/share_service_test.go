@@ -0,0 +1,489 @@
+package service_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type shareTest struct {
+	shares  *service.ShareService
+	groups  *service.GroupService
+	folders *service.FolderService
+	files   *service.PersonalFileService
+	trash   *service.TrashService
+	quota   *service.QuotaService
+	links   *service.DownloadLinkService
+	// alice owns the folders, bob and carol are the users she shares with.
+	alice, bob, carol          *model.User
+	aliceRoot, bobRoot, docsID int64
+}
+
+func setupShareTest(t *testing.T) *shareTest {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+	c := path.New(tmpDir)
+
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	groupSvc := service.NewGroupService(repository.NewGroupRepository(db), userRepo)
+	quotaSvc := service.NewQuotaService(userRepo, 0)
+	tt := &shareTest{
+		shares:  service.NewShareService(repository.NewShareRepository(db), userRepo, groupSvc, access),
+		groups:  groupSvc,
+		folders: folderSvc,
+		files:   service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, quotaSvc, folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c),
+		trash:   service.NewTrashService(repository.NewTrashRepository(db), folderRepo, fileRepo, service.NewQuotaService(userRepo, 0), access, c),
+		quota:   quotaSvc,
+	}
+	tt.links = service.NewDownloadLinkService(repository.NewDownloadLinkRepository(db), userRepo, tt.files, folderSvc, service.NewArchiveService(folderRepo, fileRepo, access, st), access, []byte("test secret"))
+
+	var roots [3]int64
+	for i, name := range []string{"alice", "bob", "carol"} {
+		id, err := userRepo.Insert(ctx, name, "hashedpass")
+		if err != nil {
+			t.Fatalf("failed to create user %s: %v", name, err)
+		}
+		root, err := folderSvc.CreateFolder(ctx, id, name, -1, name, "/")
+		if err != nil {
+			t.Fatalf("failed to create root folder of %s: %v", name, err)
+		}
+		roots[i] = root.ID
+		u := &model.User{ID: id, Username: name}
+		switch i {
+		case 0:
+			tt.alice = u
+		case 1:
+			tt.bob = u
+		case 2:
+			tt.carol = u
+		}
+	}
+	tt.aliceRoot, tt.bobRoot = roots[0], roots[1]
+
+	docs, err := folderSvc.CreateFolder(ctx, tt.alice.ID, "alice", tt.aliceRoot, "docs", "")
+	if err != nil {
+		t.Fatalf("failed to create docs: %v", err)
+	}
+	tt.docsID = docs.ID
+	return tt
+}
+
+func (tt *shareTest) store(t *testing.T, user *model.User, folderID int64, name, content string) *model.File {
+	t.Helper()
+	ctx := testutil.TestContext(t)
+	folder, err := tt.folders.GetById(ctx, user.ID, folderID)
+	if err != nil {
+		t.Fatalf("folder %d not found for %s: %v", folderID, user.Username, err)
+	}
+	f, err := tt.files.StoreFile(ctx, user, folder.ID, folder.Path, name, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("failed to store %s: %v", name, err)
+	}
+	return f
+}
+
+func (tt *shareTest) share(t *testing.T, folderID int64, to service.ShareTarget, p model.Permission) *model.Share {
+	t.Helper()
+	s, err := tt.shares.ShareFolder(testutil.TestContext(t), tt.alice, folderID, to, p)
+	if err != nil {
+		t.Fatalf("ShareFolder failed: %v", err)
+	}
+	return s
+}
+
+func TestShareFolder_GrantsAccessToSubtree(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	sub, err := tt.folders.CreateFolder(ctx, tt.alice.ID, "alice", tt.docsID, "2024", "")
+	if err != nil {
+		t.Fatalf("failed to create subfolder: %v", err)
+	}
+	file := tt.store(t, tt.alice, sub.ID, "report.txt", "quarterly numbers")
+
+	if _, err := tt.files.GetFile(ctx, tt.bob.ID, file.ID); !errors.Is(err, service.ErrFileNotFound) {
+		t.Fatalf("expected ErrFileNotFound before sharing, got %v", err)
+	}
+
+	tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionRead)
+
+	got, err := tt.files.GetFile(ctx, tt.bob.ID, file.ID)
+	if err != nil {
+		t.Fatalf("GetFile of a file in a shared subfolder failed: %v", err)
+	}
+	rc, err := tt.files.OpenFile(ctx, tt.bob, got)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer rc.Close()
+	if b, _ := io.ReadAll(rc); string(b) != "quarterly numbers" {
+		t.Errorf("expected the shared content, got %q", b)
+	}
+
+	folders, _, err := tt.folders.GetFolderContents(ctx, tt.bob.ID, tt.docsID)
+	if err != nil {
+		t.Fatalf("GetFolderContents failed: %v", err)
+	}
+	if len(folders) != 1 || folders[0].ID != sub.ID {
+		t.Errorf("expected the subfolder 2024, got %v", folders)
+	}
+
+	// The folders above stay hidden, and so does carol's view.
+	if _, err := tt.folders.GetById(ctx, tt.bob.ID, tt.aliceRoot); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound for the root folder, got %v", err)
+	}
+	if _, err := tt.folders.GetById(ctx, tt.carol.ID, tt.docsID); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound for carol, got %v", err)
+	}
+}
+
+func TestShareFile_OnlyGrantsThatFile(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	shared := tt.store(t, tt.alice, tt.docsID, "shared.txt", "a")
+	other := tt.store(t, tt.alice, tt.docsID, "other.txt", "b")
+
+	if _, err := tt.shares.ShareFile(ctx, tt.alice, shared.ID, service.ShareTarget{Username: "bob"}, model.PermissionRead); err != nil {
+		t.Fatalf("ShareFile failed: %v", err)
+	}
+	if _, err := tt.files.GetFile(ctx, tt.bob.ID, shared.ID); err != nil {
+		t.Errorf("expected bob to read the shared file, got %v", err)
+	}
+	if _, err := tt.files.GetFile(ctx, tt.bob.ID, other.ID); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for the other file, got %v", err)
+	}
+	if _, err := tt.folders.GetById(ctx, tt.bob.ID, tt.docsID); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound for the folder of the file, got %v", err)
+	}
+}
+
+func TestShareFolder_ReadOnlyDeniesChanges(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	file := tt.store(t, tt.alice, tt.docsID, "notes.txt", "content")
+	tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionRead)
+	docs, err := tt.folders.GetById(ctx, tt.bob.ID, tt.docsID)
+	if err != nil {
+		t.Fatalf("GetById failed: %v", err)
+	}
+
+	if _, err := tt.files.StoreFile(ctx, tt.bob, docs.ID, docs.Path, "new.txt", strings.NewReader("x")); !errors.Is(err, service.ErrPermissionDenied) {
+		t.Errorf("StoreFile: expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := tt.folders.CreateFolder(ctx, tt.bob.ID, "bob", docs.ID, "sub", ""); !errors.Is(err, service.ErrPermissionDenied) {
+		t.Errorf("CreateFolder: expected ErrPermissionDenied, got %v", err)
+	}
+	if err := tt.files.DeleteFile(ctx, tt.bob, file.ID); !errors.Is(err, service.ErrPermissionDenied) {
+		t.Errorf("DeleteFile: expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := tt.trash.TrashFile(ctx, tt.bob, file.ID); !errors.Is(err, service.ErrPermissionDenied) {
+		t.Errorf("TrashFile: expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := tt.shares.ShareFolder(ctx, tt.bob, docs.ID, service.ShareTarget{Username: "carol"}, model.PermissionRead); !errors.Is(err, service.ErrPermissionDenied) {
+		t.Errorf("ShareFolder: expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := tt.files.GetFile(ctx, tt.bob.ID, file.ID); err != nil {
+		t.Errorf("the file should still be readable, got %v", err)
+	}
+}
+
+func TestShareFolder_WriteUploadsBelongToOwner(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionWrite)
+	file := tt.store(t, tt.bob, tt.docsID, "from-bob.txt", "hello alice")
+
+	if file.UserID != tt.alice.ID {
+		t.Errorf("expected the upload to belong to alice, got user %d", file.UserID)
+	}
+	if file.Location != "docs/from-bob.txt" {
+		t.Errorf("expected location docs/from-bob.txt, got %q", file.Location)
+	}
+	aliceUsage, err := tt.quota.GetUsage(ctx, tt.alice.ID)
+	if err != nil {
+		t.Fatalf("GetUsage failed: %v", err)
+	}
+	bobUsage, err := tt.quota.GetUsage(ctx, tt.bob.ID)
+	if err != nil {
+		t.Fatalf("GetUsage failed: %v", err)
+	}
+	if aliceUsage.Used != int64(len("hello alice")) || bobUsage.Used != 0 {
+		t.Errorf("expected the upload to count for alice only, got alice %d and bob %d", aliceUsage.Used, bobUsage.Used)
+	}
+
+	// Deleting moves the file to the trash of its owner.
+	if _, err := tt.trash.TrashFile(ctx, tt.bob, file.ID); err != nil {
+		t.Fatalf("TrashFile failed: %v", err)
+	}
+	items, err := tt.trash.GetUserTrash(ctx, tt.alice)
+	if err != nil {
+		t.Fatalf("GetUserTrash failed: %v", err)
+	}
+	if len(items) != 1 || items[0].Name != "from-bob.txt" {
+		t.Errorf("expected the file in the trash of alice, got %v", items)
+	}
+}
+
+func TestShareFolder_MoveBetweenOwnersFails(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionWrite)
+	own := tt.store(t, tt.bob, tt.bobRoot, "mine.txt", "bob's")
+	docs, err := tt.folders.GetById(ctx, tt.bob.ID, tt.docsID)
+	if err != nil {
+		t.Fatalf("GetById failed: %v", err)
+	}
+
+	if err := tt.files.MoveFile(ctx, tt.bob, own.ID, docs, ""); !errors.Is(err, service.ErrDifferentOwner) {
+		t.Errorf("expected ErrDifferentOwner, got %v", err)
+	}
+	copied, err := tt.files.CopyFile(ctx, tt.bob, own.ID, docs, "")
+	if err != nil {
+		t.Fatalf("CopyFile into the shared folder failed: %v", err)
+	}
+	if copied.UserID != tt.alice.ID {
+		t.Errorf("expected the copy to belong to alice, got user %d", copied.UserID)
+	}
+}
+
+func TestShareFolder_ThroughGroup(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	g, err := tt.groups.CreateGroup(ctx, tt.alice, "team")
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	if err := tt.groups.AddMember(ctx, tt.alice, g.ID, "carol"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	tt.share(t, tt.docsID, service.ShareTarget{GroupID: g.ID}, model.PermissionRead)
+
+	if _, err := tt.folders.GetById(ctx, tt.carol.ID, tt.docsID); err != nil {
+		t.Errorf("expected carol to see the folder through the group, got %v", err)
+	}
+	if _, err := tt.folders.GetById(ctx, tt.bob.ID, tt.docsID); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound for bob, got %v", err)
+	}
+
+	if err := tt.groups.RemoveMember(ctx, tt.alice, g.ID, "carol"); err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+	if _, err := tt.folders.GetById(ctx, tt.carol.ID, tt.docsID); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound after leaving the group, got %v", err)
+	}
+
+	// Groups of others cannot be shared with.
+	bobGroup, err := tt.groups.CreateGroup(ctx, tt.bob, "friends")
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	if _, err := tt.shares.ShareFolder(ctx, tt.alice, tt.docsID, service.ShareTarget{GroupID: bobGroup.ID}, model.PermissionRead); !errors.Is(err, service.ErrGroupNotFound) {
+		t.Errorf("expected ErrGroupNotFound, got %v", err)
+	}
+}
+
+func TestShareFolder_HighestPermissionWins(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	g, err := tt.groups.CreateGroup(ctx, tt.alice, "team")
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	if err := tt.groups.AddMember(ctx, tt.alice, g.ID, "bob"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	tt.share(t, tt.docsID, service.ShareTarget{GroupID: g.ID}, model.PermissionWrite)
+	tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionRead)
+
+	docs, err := tt.folders.GetById(ctx, tt.bob.ID, tt.docsID)
+	if err != nil {
+		t.Fatalf("GetById failed: %v", err)
+	}
+	p, err := tt.folders.Permission(ctx, tt.bob.ID, docs)
+	if err != nil {
+		t.Fatalf("Permission failed: %v", err)
+	}
+	if p != model.PermissionWrite {
+		t.Errorf("expected write, got %s", p)
+	}
+
+	items, err := tt.shares.GetSharedWithUser(ctx, tt.bob)
+	if err != nil {
+		t.Fatalf("GetSharedWithUser failed: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected the folder once, got %d items", len(items))
+	}
+	it := items[0]
+	if it.Name != "docs" || it.Owner != "alice" || it.Permission != model.PermissionWrite || !it.ShareID.Valid {
+		t.Errorf("unexpected shared item %+v", it)
+	}
+}
+
+func TestShareFolder_Validation(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	tests := []struct {
+		name     string
+		folderID int64
+		to       service.ShareTarget
+		p        model.Permission
+		want     error
+	}{
+		{"root folder", tt.aliceRoot, service.ShareTarget{Username: "bob"}, model.PermissionRead, service.ErrCannotShareRoot},
+		{"owner", tt.docsID, service.ShareTarget{Username: "alice"}, model.PermissionRead, service.ErrShareWithOwner},
+		{"unknown user", tt.docsID, service.ShareTarget{Username: "mallory"}, model.PermissionRead, service.ErrUserNotFound},
+		{"no permission", tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionNone, service.ErrInvalidPermission},
+		{"folder of others", tt.bobRoot, service.ShareTarget{Username: "carol"}, model.PermissionRead, service.ErrFolderNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tt.shares.ShareFolder(ctx, tt.alice, tc.folderID, tc.to, tc.p); !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestShareFolder_AgainChangesPermission(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	first := tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionRead)
+	second := tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionManage)
+	if first.ID != second.ID || second.Permission != model.PermissionManage {
+		t.Errorf("expected share %d to be updated to manage, got share %d with %s", first.ID, second.ID, second.Permission)
+	}
+
+	// With manage bob may share further and list the shares.
+	if _, err := tt.shares.ShareFolder(ctx, tt.bob, tt.docsID, service.ShareTarget{Username: "carol"}, model.PermissionRead); err != nil {
+		t.Fatalf("ShareFolder by a manager failed: %v", err)
+	}
+	shares, err := tt.shares.GetFolderShares(ctx, tt.bob, tt.docsID)
+	if err != nil {
+		t.Fatalf("GetFolderShares failed: %v", err)
+	}
+	if len(shares) != 2 {
+		t.Errorf("expected 2 shares, got %d", len(shares))
+	}
+}
+
+func TestRevokeShare(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	toBob := tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionRead)
+	toCarol := tt.share(t, tt.docsID, service.ShareTarget{Username: "carol"}, model.PermissionRead)
+
+	// Readers may leave their own share, but not revoke those of others.
+	if err := tt.shares.RevokeShare(ctx, tt.bob, toCarol.ID); !errors.Is(err, service.ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied, got %v", err)
+	}
+	if err := tt.shares.RevokeShare(ctx, tt.bob, toBob.ID); err != nil {
+		t.Fatalf("leaving a share failed: %v", err)
+	}
+	if _, err := tt.folders.GetById(ctx, tt.bob.ID, tt.docsID); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound after leaving, got %v", err)
+	}
+
+	if err := tt.shares.RevokeShare(ctx, tt.alice, toCarol.ID); err != nil {
+		t.Fatalf("RevokeShare by the owner failed: %v", err)
+	}
+	if _, err := tt.folders.GetById(ctx, tt.carol.ID, tt.docsID); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected ErrFolderNotFound after revoking, got %v", err)
+	}
+	if err := tt.shares.RevokeShare(ctx, tt.alice, toCarol.ID); !errors.Is(err, service.ErrShareNotFound) {
+		t.Errorf("expected ErrShareNotFound, got %v", err)
+	}
+}
+
+func TestShares_RemovedWithItem(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	tt.share(t, tt.docsID, service.ShareTarget{Username: "bob"}, model.PermissionRead)
+	if _, err := tt.trash.TrashFolder(ctx, tt.alice, tt.docsID); err != nil {
+		t.Fatalf("TrashFolder failed: %v", err)
+	}
+	items, err := tt.shares.GetSharedWithUser(ctx, tt.bob)
+	if err != nil {
+		t.Fatalf("GetSharedWithUser failed: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected no shared items after deleting the folder, got %d", len(items))
+	}
+}
+
+func TestGroupService(t *testing.T) {
+	tt := setupShareTest(t)
+	ctx := testutil.TestContext(t)
+
+	if _, err := tt.groups.CreateGroup(ctx, tt.alice, "  "); !errors.Is(err, service.ErrInvalidGroupName) {
+		t.Errorf("expected ErrInvalidGroupName, got %v", err)
+	}
+	g, err := tt.groups.CreateGroup(ctx, tt.alice, "team")
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	if _, err := tt.groups.CreateGroup(ctx, tt.alice, "team"); !errors.Is(err, service.ErrGroupAlreadyExists) {
+		t.Errorf("expected ErrGroupAlreadyExists, got %v", err)
+	}
+	if _, err := tt.groups.CreateGroup(ctx, tt.bob, "team"); err != nil {
+		t.Errorf("other users may use the same name, got %v", err)
+	}
+
+	if err := tt.groups.AddMember(ctx, tt.alice, g.ID, "mallory"); !errors.Is(err, service.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	if err := tt.groups.AddMember(ctx, tt.bob, g.ID, "carol"); !errors.Is(err, service.ErrGroupNotFound) {
+		t.Errorf("expected ErrGroupNotFound for a group of others, got %v", err)
+	}
+	for _, name := range []string{"bob", "carol", "bob"} {
+		if err := tt.groups.AddMember(ctx, tt.alice, g.ID, name); err != nil {
+			t.Fatalf("AddMember %s failed: %v", name, err)
+		}
+	}
+	got, err := tt.groups.GetGroup(ctx, tt.alice, g.ID)
+	if err != nil {
+		t.Fatalf("GetGroup failed: %v", err)
+	}
+	if len(got.Members) != 2 {
+		t.Errorf("expected 2 members, got %d", len(got.Members))
+	}
+
+	tt.share(t, tt.docsID, service.ShareTarget{GroupID: g.ID}, model.PermissionRead)
+	if err := tt.groups.DeleteGroup(ctx, tt.alice, g.ID); err != nil {
+		t.Fatalf("DeleteGroup failed: %v", err)
+	}
+	if _, err := tt.folders.GetById(ctx, tt.bob.ID, tt.docsID); !errors.Is(err, service.ErrFolderNotFound) {
+		t.Errorf("expected the share to go with the group, got %v", err)
+	}
+	if _, err := tt.groups.GetGroup(ctx, tt.alice, g.ID); !errors.Is(err, service.ErrGroupNotFound) {
+		t.Errorf("expected ErrGroupNotFound after deleting, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrNoThumbnail          = errors.New("file has no thumbnail")
+	ErrInvalidThumbnailSize = errors.New("thumbnail size must be small or large")
+)
+
+// ThumbnailSize is the length in pixels of the longer edge of a thumbnail.
+type ThumbnailSize int
+
+const (
+	ThumbnailSmall ThumbnailSize = 64
+	ThumbnailLarge ThumbnailSize = 256
+)
+
+var thumbnailSizes = []ThumbnailSize{ThumbnailSmall, ThumbnailLarge}
+
+// thumbnailMimeTypes are the detected types of the images thumbnails are
+// made of.
+var thumbnailMimeTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+const (
+	// maxThumbnailPixels bounds the images that are decoded, so a small file
+	// claiming huge dimensions cannot use up the memory.
+	maxThumbnailPixels = 50_000_000
+	// thumbnailBatch is how many contents Generate looks up at once.
+	thumbnailBatch = 20
+)
+
+// ParseThumbnailSize parses the size of a thumbnail, small if s is empty.
+func ParseThumbnailSize(s string) (ThumbnailSize, error) {
+	switch s {
+	case "", "small":
+		return ThumbnailSmall, nil
+	case "large":
+		return ThumbnailLarge, nil
+	}
+	return 0, ErrInvalidThumbnailSize
+}
+
+// HasThumbnail reports whether thumbnails are made of content of mimeType.
+func HasThumbnail(mimeType string) bool {
+	for _, m := range thumbnailMimeTypes {
+		if m == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// ThumbnailService makes JPEG thumbnails of images in all sizes and keeps
+// them as blobs. Thumbnails belong to a content rather than a file, so a file
+// with a new content gets new ones and they are deleted with their content.
+type ThumbnailService struct {
+	repo   *repository.ThumbnailRepository
+	access *AccessService
+	st     storage.FileManager
+	blobs  *BlobService
+	// mu keeps a content from being decoded twice when a request asks for a
+	// thumbnail Generate is just making.
+	mu sync.Mutex
+}
+
+func NewThumbnailService(repo *repository.ThumbnailRepository, access *AccessService, st storage.FileManager, blobs *BlobService) *ThumbnailService {
+	return &ThumbnailService{repo: repo, access: access, st: st, blobs: blobs}
+}
+
+// Thumbnail opens the thumbnail of file in size for sending. It is made right
+// away if Generate did not get to it yet. Files that are no image or could
+// not be decoded fail with ErrNoThumbnail.
+func (s *ThumbnailService) Thumbnail(ctx context.Context, user *model.User, file *model.File, size ThumbnailSize) (*Download, error) {
+	if err := s.access.CheckFile(ctx, user.ID, file, model.PermissionRead); err != nil {
+		return nil, err
+	}
+	if !HasThumbnail(file.MimeType) {
+		return nil, ErrNoThumbnail
+	}
+	t, err := s.repo.Get(ctx, file.Hash, int(size))
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := s.generate(ctx, file.Hash); err != nil {
+			return nil, err
+		}
+		t, err = s.repo.Get(ctx, file.Hash, int(size))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !t.ThumbHash.Valid {
+		return nil, ErrNoThumbnail
+	}
+	rc, err := s.st.OpenFile(t.ThumbHash.String)
+	if err != nil {
+		return nil, err
+	}
+	return &Download{
+		Content:  rc,
+		Name:     strings.TrimSuffix(file.Name, path.Ext(file.Name)) + ".jpg",
+		MimeType: "image/jpeg",
+		Hash:     t.ThumbHash.String,
+		Size:     t.ThumbSize,
+		ModTime:  t.CreatedAt,
+	}, nil
+}
+
+// Generate makes the missing thumbnails of all images and returns of how many
+// contents it made them.
+func (s *ThumbnailService) Generate(ctx context.Context) (int, error) {
+	made := 0
+	for {
+		hashes, err := s.repo.GetMissing(ctx, thumbnailMimeTypes, len(thumbnailSizes), thumbnailBatch)
+		if err != nil {
+			return made, err
+		}
+		if len(hashes) == 0 {
+			return made, nil
+		}
+		for _, hash := range hashes {
+			if err := s.generate(ctx, hash); err != nil {
+				return made, err
+			}
+			made++
+		}
+	}
+}
+
+// generate makes the missing thumbnails of the content hash. Contents that
+// cannot be decoded are recorded without a thumbnail, so they are not tried
+// again.
+func (s *ThumbnailService) generate(ctx context.Context, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missing []ThumbnailSize
+	for _, size := range thumbnailSizes {
+		_, err := s.repo.Get(ctx, hash, int(size))
+		if errors.Is(err, sql.ErrNoRows) {
+			missing = append(missing, size)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	img, err := s.decode(hash)
+	if err != nil {
+		for _, size := range missing {
+			if err := s.repo.Insert(ctx, &model.Thumbnail{Hash: hash, Size: int(size)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, size := range missing {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scaleImage(img, int(size)), &jpeg.Options{Quality: 80}); err != nil {
+			return fmt.Errorf("failed to encode thumbnail of %s: %w", hash, err)
+		}
+		thumbHash, thumbSize, err := s.st.SaveFile(&buf)
+		if err != nil {
+			return fmt.Errorf("failed to save thumbnail of %s: %w", hash, err)
+		}
+		if err := s.blobs.Claim(ctx, thumbHash, thumbSize); err != nil {
+			return fmt.Errorf("failed to save thumbnail of %s: %w", hash, err)
+		}
+		if err := s.repo.Insert(ctx, &model.Thumbnail{
+			Hash:      hash,
+			Size:      int(size),
+			ThumbHash: sql.NullString{String: thumbHash, Valid: true},
+			ThumbSize: thumbSize,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ThumbnailService) decode(hash string) (image.Image, error) {
+	rc, err := s.st.OpenFile(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+
+	cfg, _, err := image.DecodeConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxThumbnailPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", cfg.Width, cfg.Height)
+	}
+	if _, err := rc.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(rc)
+	return img, err
+}
+
+// scaleImage scales src down to fit into a square of size pixels, keeping its
+// aspect ratio. Transparent parts become white, as JPEG has no alpha channel.
+func scaleImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	switch {
+	case w <= size && h <= size:
+	case w >= h:
+		w, h = size, max(1, h*size/w)
+	default:
+		w, h = max(1, w*size/h), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,212 @@
+package service_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type thumbnailTest struct {
+	thumbs *service.ThumbnailService
+	files  *service.PersonalFileService
+	blobs  *service.BlobService
+	repo   *repository.ThumbnailRepository
+	user   *model.User
+	root   *model.Folder
+}
+
+func setupThumbnailTest(t *testing.T) *thumbnailTest {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+	c := path.New(tmpDir)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	blobSvc := service.NewBlobService(repository.NewBlobRepository(db), st)
+	tt := &thumbnailTest{
+		files: service.NewPersonalFileService(st, blobSvc, fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c),
+		blobs: blobSvc,
+		repo:  repository.NewThumbnailRepository(db),
+	}
+	tt.thumbs = service.NewThumbnailService(tt.repo, access, st, blobSvc)
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	tt.user = &model.User{ID: userID, Username: "testuser"}
+	if tt.root, err = folderSvc.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/"); err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	return tt
+}
+
+func testImage(w, h int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("could not encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func (tt *thumbnailTest) store(t *testing.T, name string, content []byte) *model.File {
+	t.Helper()
+	file, err := tt.files.StoreFile(testutil.TestContext(t), tt.user, tt.root.ID, tt.root.Path, name, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("StoreFile failed: %v", err)
+	}
+	return file
+}
+
+// thumbnail returns the bounds of the thumbnail of file in size and its hash.
+func (tt *thumbnailTest) thumbnail(t *testing.T, file *model.File, size service.ThumbnailSize) (image.Rectangle, string) {
+	t.Helper()
+	d, err := tt.thumbs.Thumbnail(testutil.TestContext(t), tt.user, file, size)
+	if err != nil {
+		t.Fatalf("Thumbnail failed: %v", err)
+	}
+	defer func() { _ = d.Content.Close() }()
+	if d.MimeType != "image/jpeg" {
+		t.Errorf("expected a JPEG, got %q", d.MimeType)
+	}
+	data, err := io.ReadAll(d.Content)
+	if err != nil {
+		t.Fatalf("could not read thumbnail: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid thumbnail: %v", err)
+	}
+	return img.Bounds(), d.Hash
+}
+
+func TestThumbnailService_Thumbnail(t *testing.T) {
+	tt := setupThumbnailTest(t)
+	ctx := testutil.TestContext(t)
+
+	file := tt.store(t, "wide.png", encodePNG(t, testImage(200, 100, color.RGBA{R: 255, A: 255})))
+	if b, _ := tt.thumbnail(t, file, service.ThumbnailSmall); b.Dx() != 64 || b.Dy() != 32 {
+		t.Errorf("expected a small thumbnail of 64x32, got %v", b)
+	}
+	if b, _ := tt.thumbnail(t, file, service.ThumbnailLarge); b.Dx() != 200 || b.Dy() != 100 {
+		t.Errorf("expected images smaller than a thumbnail to keep their size, got %v", b)
+	}
+	if n, err := tt.thumbs.Generate(ctx); err != nil || n != 0 {
+		t.Errorf("expected the thumbnails made on request to be kept, got %d, %v", n, err)
+	}
+
+	text := tt.store(t, "notes.txt", []byte("no image"))
+	if _, err := tt.thumbs.Thumbnail(ctx, tt.user, text, service.ThumbnailSmall); !errors.Is(err, service.ErrNoThumbnail) {
+		t.Errorf("expected ErrNoThumbnail for text, got %v", err)
+	}
+	other := &model.User{ID: tt.user.ID + 1, Username: "other"}
+	if _, err := tt.thumbs.Thumbnail(ctx, other, file, service.ThumbnailSmall); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for another user, got %v", err)
+	}
+	if _, err := service.ParseThumbnailSize("huge"); !errors.Is(err, service.ErrInvalidThumbnailSize) {
+		t.Errorf("expected ErrInvalidThumbnailSize, got %v", err)
+	}
+}
+
+func TestThumbnailService_Generate(t *testing.T) {
+	tt := setupThumbnailTest(t)
+	ctx := testutil.TestContext(t)
+
+	var jpg, gf bytes.Buffer
+	if err := jpeg.Encode(&jpg, testImage(100, 400, color.White), nil); err != nil {
+		t.Fatalf("could not encode jpeg: %v", err)
+	}
+	if err := gif.Encode(&gf, testImage(10, 10, color.Black), nil); err != nil {
+		t.Fatalf("could not encode gif: %v", err)
+	}
+	tall := tt.store(t, "tall.jpg", jpg.Bytes())
+	small := tt.store(t, "small.gif", gf.Bytes())
+	// A PNG signature without a valid image after it.
+	broken := tt.store(t, "broken.png", append([]byte("\x89PNG\r\n\x1a\n"), strings.Repeat("x", 64)...))
+	tt.store(t, "notes.txt", []byte("no image"))
+
+	if n, err := tt.thumbs.Generate(ctx); err != nil || n != 3 {
+		t.Fatalf("expected thumbnails of 3 images, got %d, %v", n, err)
+	}
+	if n, err := tt.thumbs.Generate(ctx); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to do, got %d, %v", n, err)
+	}
+	if b, _ := tt.thumbnail(t, tall, service.ThumbnailLarge); b.Dx() != 64 || b.Dy() != 256 {
+		t.Errorf("expected a large thumbnail of 64x256, got %v", b)
+	}
+	if b, _ := tt.thumbnail(t, small, service.ThumbnailSmall); b.Dx() != 10 || b.Dy() != 10 {
+		t.Errorf("expected a thumbnail of 10x10, got %v", b)
+	}
+	if _, err := tt.thumbs.Thumbnail(ctx, tt.user, broken, service.ThumbnailSmall); !errors.Is(err, service.ErrNoThumbnail) {
+		t.Errorf("expected ErrNoThumbnail for a broken image, got %v", err)
+	}
+}
+
+func TestThumbnailService_Invalidation(t *testing.T) {
+	tt := setupThumbnailTest(t)
+	ctx := testutil.TestContext(t)
+
+	red := tt.store(t, "photo.png", encodePNG(t, testImage(20, 20, color.RGBA{R: 255, A: 255})))
+	_, redThumb := tt.thumbnail(t, red, service.ThumbnailSmall)
+
+	blue := tt.store(t, "photo.png", encodePNG(t, testImage(20, 20, color.RGBA{B: 255, A: 255})))
+	if blue.ID != red.ID || blue.Hash == red.Hash {
+		t.Fatalf("expected the file to get a new content, got %+v", blue)
+	}
+	if _, blueThumb := tt.thumbnail(t, blue, service.ThumbnailSmall); blueThumb == redThumb {
+		t.Error("expected a new thumbnail for the new content")
+	}
+
+	if err := tt.files.DeleteFile(ctx, tt.user, blue.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if n, err := tt.blobs.Collect(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected the deleted content to be collected, got %d, %v", n, err)
+	}
+	if _, err := tt.repo.Get(ctx, blue.Hash, int(service.ThumbnailSmall)); err == nil {
+		t.Error("expected the thumbnail to be deleted with its content")
+	}
+	// The old content is still kept as a version.
+	if _, err := tt.repo.Get(ctx, red.Hash, int(service.ThumbnailSmall)); err != nil {
+		t.Errorf("expected the thumbnail of the version to be kept, got %v", err)
+	}
+	// The thumbnail itself is released along with its content.
+	if n, err := tt.blobs.Collect(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("expected the thumbnail to be collected, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+)
+
+var (
+	ErrTrashItemNotFound = errors.New("trash item not found")
+	ErrRestoreConflict   = errors.New("an item with the same name already exists at the original location")
+)
+
+// TrashService moves deleted files and folders into a per-user trash, from
+// where they can be restored until they are purged. Trashed files keep
+// referring to their content by hash, so nothing moves in storage.
+type TrashService struct {
+	repo       *repository.TrashRepository
+	folderRepo *repository.FolderRepository
+	fileRepo   *repository.PersonalFileRepository
+	quota      *QuotaService
+	access     *AccessService
+	converter  *path.Converter
+}
+
+func NewTrashService(repo *repository.TrashRepository, folderRepo *repository.FolderRepository, fileRepo *repository.PersonalFileRepository, quota *QuotaService, access *AccessService, c *path.Converter) *TrashService {
+	return &TrashService{repo, folderRepo, fileRepo, quota, access, c}
+}
+
+// TrashFile moves a file into the trash of its owner, also if a user it is
+// shared with deletes it.
+func (s *TrashService) TrashFile(ctx context.Context, user *model.User, fileID int64) (*model.TrashItem, error) {
+	file, err := s.access.File(ctx, user.ID, fileID, model.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &model.TrashItem{
+		UserID:           file.UserID,
+		Name:             file.Name,
+		OriginalPath:     file.Location,
+		OriginalFolderID: file.FolderID,
+		Size:             file.Size,
+		FileCount:        1,
+	}
+	entries := []*model.TrashEntry{{
+		Path:      "",
+		Size:      file.Size,
+		MimeType:  file.MimeType,
+		Hash:      file.Hash,
+		CreatedAt: file.CreatedAt,
+		FileID:    sql.NullInt64{Int64: file.ID, Valid: true},
+		Version:   file.Version,
+	}}
+	id, err := s.repo.Trash(ctx, item, entries, nil, []int64{file.ID}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(ctx, id)
+}
+
+// TrashFolder moves a folder with everything in it into the trash of its
+// owner.
+func (s *TrashService) TrashFolder(ctx context.Context, user *model.User, folderID int64) (*model.TrashItem, error) {
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	if !folder.ParentID.Valid {
+		return nil, ErrCannotDeleteRoot
+	}
+
+	item := &model.TrashItem{
+		UserID:           folder.UserID,
+		Name:             folder.Name,
+		IsFolder:         true,
+		OriginalPath:     folder.Path,
+		OriginalFolderID: folder.ParentID,
+	}
+	var entries []*model.TrashEntry
+	var folderIDs, fileIDs []int64
+	if err := s.collectSubtree(ctx, folder, folder.Path, item, &entries, &folderIDs, &fileIDs); err != nil {
+		return nil, err
+	}
+
+	id, err := s.repo.Trash(ctx, item, entries, folderIDs, fileIDs, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(ctx, id)
+}
+
+// collectSubtree records folder and everything below it as entries relative to
+// root, parents first.
+func (s *TrashService) collectSubtree(ctx context.Context, folder *model.Folder, root string, item *model.TrashItem, entries *[]*model.TrashEntry, folderIDs, fileIDs *[]int64) error {
+	rel := strings.TrimPrefix(strings.TrimPrefix(folder.Path, root), "/")
+	*entries = append(*entries, &model.TrashEntry{IsFolder: true, Path: rel, CreatedAt: folder.CreatedAt})
+	*folderIDs = append(*folderIDs, folder.ID)
+
+	files, err := s.fileRepo.GetByUserAndFolder(ctx, folder.UserID, folder.ID)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		*entries = append(*entries, &model.TrashEntry{
+			Path:      s.converter.JoinDBPath(rel, f.Name),
+			Size:      f.Size,
+			MimeType:  f.MimeType,
+			Hash:      f.Hash,
+			CreatedAt: f.CreatedAt,
+			FileID:    sql.NullInt64{Int64: f.ID, Valid: true},
+			Version:   f.Version,
+		})
+		*fileIDs = append(*fileIDs, f.ID)
+		item.Size += f.Size
+		item.FileCount++
+	}
+
+	children, err := s.folderRepo.GetByUserAndParent(ctx, folder.UserID, folder.ID)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := s.collectSubtree(ctx, child, root, item, entries, folderIDs, fileIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserTrash returns the trash of the user, most recently deleted first.
+func (s *TrashService) GetUserTrash(ctx context.Context, user *model.User) ([]*model.TrashItem, error) {
+	return s.repo.GetByUser(ctx, user.ID)
+}
+
+func (s *TrashService) getItem(ctx context.Context, user *model.User, id int64) (*model.TrashItem, error) {
+	item, err := s.repo.GetByID(ctx, id)
+	if err != nil || item.UserID != user.ID {
+		return nil, ErrTrashItemNotFound
+	}
+	return item, nil
+}
+
+// Restore moves an item back into the folder it was deleted from. If that
+// folder is gone, the folders of the original path are recreated. The restored
+// item is returned with its new location in OriginalPath. Its files count
+// towards the quota again, so it fails with ErrQuotaExceeded if they do not
+// fit.
+func (s *TrashService) Restore(ctx context.Context, user *model.User, id int64) (*model.TrashItem, error) {
+	item, err := s.getItem(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.quota.Check(ctx, item.UserID, item.Size); err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.GetEntries(ctx, item.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var parent *model.Folder
+	if item.OriginalFolderID.Valid {
+		if f, err := s.folderRepo.GetByID(ctx, item.OriginalFolderID.Int64); err == nil && f.UserID == user.ID {
+			parent = f
+		}
+	}
+	if parent == nil {
+		if parent, err = s.ensureFolderPath(ctx, user, s.converter.GetParentDBPath(item.OriginalPath)); err != nil {
+			return nil, err
+		}
+	}
+
+	target := s.converter.JoinDBPath(parent.Path, item.Name)
+	if _, err := s.folderRepo.GetByPathAndUser(ctx, target, user.ID); err == nil {
+		return nil, ErrRestoreConflict
+	}
+	if _, err := s.fileRepo.GetByFolderAndName(ctx, parent.ID, item.Name); err == nil {
+		return nil, ErrRestoreConflict
+	}
+
+	if err := s.repo.Restore(ctx, item, entries, parent.ID, target, nil); err != nil {
+		return nil, err
+	}
+	item.OriginalPath = target
+	return item, nil
+}
+
+// ensureFolderPath returns the folder at dbPath, creating every missing folder
+// on the way down from the root folder.
+func (s *TrashService) ensureFolderPath(ctx context.Context, user *model.User, dbPath string) (*model.Folder, error) {
+	folder, err := s.folderRepo.GetByPathAndUser(ctx, "", user.ID)
+	if err != nil {
+		return nil, ErrFolderNotFound
+	}
+	if dbPath == "" {
+		return folder, nil
+	}
+
+	for _, name := range strings.Split(dbPath, "/") {
+		p := s.converter.JoinDBPath(folder.Path, name)
+		next, err := s.folderRepo.GetByPathAndUser(ctx, p, user.ID)
+		if err != nil {
+			id, err := s.folderRepo.Insert(ctx, user.ID, &folder.ID, name, p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to recreate folder %q: %w", p, err)
+			}
+			if next, err = s.folderRepo.GetByID(ctx, id); err != nil {
+				return nil, err
+			}
+		}
+		folder = next
+	}
+	return folder, nil
+}
+
+// Purge permanently deletes an item from the trash.
+func (s *TrashService) Purge(ctx context.Context, user *model.User, id int64) error {
+	item, err := s.getItem(ctx, user, id)
+	if err != nil {
+		return err
+	}
+	return s.purge(ctx, item)
+}
+
+// EmptyTrash permanently deletes everything in the trash of the user and
+// returns the number of purged items.
+func (s *TrashService) EmptyTrash(ctx context.Context, user *model.User) (int, error) {
+	items, err := s.repo.GetByUser(ctx, user.ID)
+	if err != nil {
+		return 0, err
+	}
+	for i, item := range items {
+		if err := s.purge(ctx, item); err != nil {
+			return i, err
+		}
+	}
+	return len(items), nil
+}
+
+// PurgeExpired permanently deletes the items of all users that were deleted
+// before t and returns the number of purged items.
+func (s *TrashService) PurgeExpired(ctx context.Context, t time.Time) (int, error) {
+	items, err := s.repo.GetDeletedBefore(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+	for i, item := range items {
+		if err := s.purge(ctx, item); err != nil {
+			return i, err
+		}
+	}
+	return len(items), nil
+}
+
+// purge deletes the records of an item. Contents nothing else uses any more
+// are removed from storage by BlobService.Collect.
+func (s *TrashService) purge(ctx context.Context, item *model.TrashItem) error {
+	if err := s.repo.Delete(ctx, item.ID); err != nil {
+		return fmt.Errorf("failed to purge %q: %w", item.OriginalPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package service_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type trashTest struct {
+	trash    *service.TrashService
+	files    *service.PersonalFileService
+	versions *service.FileVersionService
+	folders  *service.FolderService
+	user     *model.User
+	rootID   int64
+}
+
+func setupTrashTest(t *testing.T) *trashTest {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	trashRepo := repository.NewTrashRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+	c := path.New(tmpDir)
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	tt := &trashTest{
+		trash:    service.NewTrashService(trashRepo, folderRepo, fileRepo, service.NewQuotaService(userRepo, 0), access, c),
+		files:    service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c),
+		versions: versionSvc,
+		folders:  folderSvc,
+		user:     &model.User{ID: userID, Username: "testuser"},
+	}
+	root, err := tt.folders.CreateFolder(ctx, userID, "testuser", -1, "testuser", "/")
+	if err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	tt.rootID = root.ID
+	return tt
+}
+
+func (tt *trashTest) readFile(t *testing.T, dbPath string) string {
+	t.Helper()
+	ctx := testutil.TestContext(t)
+
+	parentPath, name := "", dbPath
+	if i := strings.LastIndex(dbPath, "/"); i >= 0 {
+		parentPath, name = dbPath[:i], dbPath[i+1:]
+	}
+	folder, err := tt.folders.GetByDBPath(ctx, tt.user.ID, parentPath)
+	if err != nil {
+		t.Fatalf("folder %q not found: %v", parentPath, err)
+	}
+	file, err := tt.files.GetFileByName(ctx, tt.user.ID, folder.ID, name)
+	if err != nil {
+		t.Fatalf("file %q not found: %v", dbPath, err)
+	}
+	rc, err := tt.files.OpenFile(ctx, tt.user, file)
+	if err != nil {
+		t.Fatalf("could not open %q: %v", dbPath, err)
+	}
+	defer func() { _ = rc.Close() }()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("could not read %q: %v", dbPath, err)
+	}
+	return string(b)
+}
+
+func TestTrashService_TrashAndRestoreFile(t *testing.T) {
+	tt := setupTrashTest(t)
+	ctx := testutil.TestContext(t)
+
+	file, err := tt.files.StoreFile(ctx, tt.user, tt.rootID, "", "a.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	item, err := tt.trash.TrashFile(ctx, tt.user, file.ID)
+	if err != nil {
+		t.Fatalf("TrashFile failed: %v", err)
+	}
+	if item.Name != "a.txt" || item.IsFolder || item.OriginalPath != "a.txt" || item.Size != 5 {
+		t.Errorf("unexpected trash item: %+v", item)
+	}
+	if _, err := tt.files.GetFileByName(ctx, tt.user.ID, tt.rootID, "a.txt"); err == nil {
+		t.Error("expected file to be gone from its folder")
+	}
+
+	other := &model.User{ID: tt.user.ID + 1, Username: "other"}
+	if _, err := tt.trash.Restore(ctx, other, item.ID); !errors.Is(err, service.ErrTrashItemNotFound) {
+		t.Errorf("expected ErrTrashItemNotFound for another user, got %v", err)
+	}
+
+	if _, err := tt.trash.Restore(ctx, tt.user, item.ID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if got := tt.readFile(t, "a.txt"); got != "hello" {
+		t.Errorf("restored content = %q, want %q", got, "hello")
+	}
+	items, err := tt.trash.GetUserTrash(ctx, tt.user)
+	if err != nil {
+		t.Fatalf("GetUserTrash failed: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected empty trash after restore, got %d items", len(items))
+	}
+}
+
+func TestTrashService_RestoreFolderRecreatesParents(t *testing.T) {
+	tt := setupTrashTest(t)
+	ctx := testutil.TestContext(t)
+
+	docs, _ := tt.folders.CreateFolder(ctx, tt.user.ID, tt.user.Username, tt.rootID, "docs", "/docs")
+	sub, _ := tt.folders.CreateFolder(ctx, tt.user.ID, tt.user.Username, docs.ID, "sub", "/docs/sub")
+	deep, _ := tt.folders.CreateFolder(ctx, tt.user.ID, tt.user.Username, sub.ID, "deep", "/docs/sub/deep")
+	_, _ = tt.folders.CreateFolder(ctx, tt.user.ID, tt.user.Username, sub.ID, "empty", "/docs/sub/empty")
+	for _, f := range []struct {
+		folder  *model.Folder
+		content string
+	}{{sub, "in sub"}, {deep, "in deep"}} {
+		if _, err := tt.files.StoreFile(ctx, tt.user, f.folder.ID, f.folder.Path, "f.txt", strings.NewReader(f.content)); err != nil {
+			t.Fatalf("failed to store file: %v", err)
+		}
+	}
+
+	subItem, err := tt.trash.TrashFolder(ctx, tt.user, sub.ID)
+	if err != nil {
+		t.Fatalf("TrashFolder failed: %v", err)
+	}
+	if subItem.FileCount != 2 || subItem.Size != int64(len("in sub")+len("in deep")) {
+		t.Errorf("unexpected trash item: %+v", subItem)
+	}
+	if _, err := tt.trash.TrashFolder(ctx, tt.user, docs.ID); err != nil {
+		t.Fatalf("TrashFolder failed: %v", err)
+	}
+	if _, err := tt.folders.GetByDBPath(ctx, tt.user.ID, "docs"); err == nil {
+		t.Fatal("expected docs to be gone")
+	}
+
+	// docs went to the trash separately, restoring sub has to recreate it.
+	if _, err := tt.trash.Restore(ctx, tt.user, subItem.ID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	for _, p := range []string{"docs", "docs/sub", "docs/sub/deep", "docs/sub/empty"} {
+		if _, err := tt.folders.GetByDBPath(ctx, tt.user.ID, p); err != nil {
+			t.Errorf("expected folder %q after restore: %v", p, err)
+		}
+	}
+	if got := tt.readFile(t, "docs/sub/deep/f.txt"); got != "in deep" {
+		t.Errorf("restored content = %q, want %q", got, "in deep")
+	}
+
+	// The trashed docs folder now collides with the recreated one.
+	items, _ := tt.trash.GetUserTrash(ctx, tt.user)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item left in the trash, got %d", len(items))
+	}
+	if _, err := tt.trash.Restore(ctx, tt.user, items[0].ID); !errors.Is(err, service.ErrRestoreConflict) {
+		t.Errorf("expected ErrRestoreConflict, got %v", err)
+	}
+}
+
+func TestTrashService_TrashRoot(t *testing.T) {
+	tt := setupTrashTest(t)
+	ctx := testutil.TestContext(t)
+
+	if _, err := tt.trash.TrashFolder(ctx, tt.user, tt.rootID); !errors.Is(err, service.ErrCannotDeleteRoot) {
+		t.Errorf("expected ErrCannotDeleteRoot, got %v", err)
+	}
+}
+
+func TestTrashService_Purge(t *testing.T) {
+	tt := setupTrashTest(t)
+	ctx := testutil.TestContext(t)
+
+	var ids []int64
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		file, err := tt.files.StoreFile(ctx, tt.user, tt.rootID, "", name, strings.NewReader(name))
+		if err != nil {
+			t.Fatalf("failed to store file: %v", err)
+		}
+		item, err := tt.trash.TrashFile(ctx, tt.user, file.ID)
+		if err != nil {
+			t.Fatalf("TrashFile failed: %v", err)
+		}
+		ids = append(ids, item.ID)
+	}
+
+	if err := tt.trash.Purge(ctx, tt.user, ids[0]); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if _, err := tt.trash.Restore(ctx, tt.user, ids[0]); !errors.Is(err, service.ErrTrashItemNotFound) {
+		t.Errorf("expected purged item to be gone, got %v", err)
+	}
+
+	n, err := tt.trash.PurgeExpired(ctx, time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Errorf("expected nothing to expire yet, got %d, %v", n, err)
+	}
+	n, err = tt.trash.PurgeExpired(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 expired items, got %d, %v", n, err)
+	}
+	items, _ := tt.trash.GetUserTrash(ctx, tt.user)
+	if len(items) != 0 {
+		t.Errorf("expected empty trash, got %d items", len(items))
+	}
+}
+
+func TestTrashService_RestoreKeepsFileVersions(t *testing.T) {
+	tt := setupTrashTest(t)
+	ctx := testutil.TestContext(t)
+
+	if _, err := tt.files.StoreFile(ctx, tt.user, tt.rootID, "", "a.txt", strings.NewReader("v1")); err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	file, err := tt.files.StoreFile(ctx, tt.user, tt.rootID, "", "a.txt", strings.NewReader("v2"))
+	if err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+
+	item, err := tt.trash.TrashFile(ctx, tt.user, file.ID)
+	if err != nil {
+		t.Fatalf("TrashFile failed: %v", err)
+	}
+	// Versions of trashed files are no orphans.
+	if n, err := tt.versions.Prune(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected nothing to prune, got %d, %v", n, err)
+	}
+	if _, err := tt.trash.Restore(ctx, tt.user, item.ID); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	restored, err := tt.files.GetFileByName(ctx, tt.user.ID, tt.rootID, "a.txt")
+	if err != nil {
+		t.Fatalf("restored file not found: %v", err)
+	}
+	if restored.Version != 2 {
+		t.Errorf("expected restored file at version 2, got %d", restored.Version)
+	}
+	_, history, err := tt.versions.GetHistory(ctx, tt.user, restored.ID)
+	if err != nil {
+		t.Fatalf("GetHistory failed: %v", err)
+	}
+	if len(history) != 1 || history[0].Version != 1 {
+		t.Errorf("expected version 1 in the history of the restored file, got %+v", history)
+	}
+}
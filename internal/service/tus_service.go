@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/storage"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadTooLarge       = errors.New("upload exceeds its declared length")
+	ErrInvalidUploadLength  = errors.New("invalid upload length")
+)
+
+// TusService keeps track of resumable uploads. Chunks are written to a staging
+// area of the storage and the file is only registered in its folder once all
+// bytes have arrived.
+type TusService struct {
+	repo       *repository.TusUploadRepository
+	fileRepo   *repository.PersonalFileRepository
+	folderRepo *repository.FolderRepository
+	files      *PersonalFileService
+	quota      *QuotaService
+	access     *AccessService
+	st         storage.FileManager
+	converter  *path.Converter
+
+	// mu guards writing, which holds a lock for every upload a PATCH is
+	// writing to or waiting for, so chunks of one upload never overlap.
+	mu      sync.Mutex
+	writing map[string]*uploadLock
+}
+
+type uploadLock struct {
+	sync.Mutex
+	holders int
+}
+
+func NewTusService(repo *repository.TusUploadRepository, fileRepo *repository.PersonalFileRepository, folderRepo *repository.FolderRepository, files *PersonalFileService, quota *QuotaService, access *AccessService, st storage.FileManager, c *path.Converter) *TusService {
+	return &TusService{
+		repo:       repo,
+		fileRepo:   fileRepo,
+		folderRepo: folderRepo,
+		files:      files,
+		quota:      quota,
+		access:     access,
+		st:         st,
+		converter:  c,
+		writing:    make(map[string]*uploadLock),
+	}
+}
+
+// lockUpload waits until no other request writes to the upload and returns
+// the function to release it. Locks are dropped once nobody holds or waits
+// for them.
+func (s *TusService) lockUpload(uploadToken string) func() {
+	s.mu.Lock()
+	l, ok := s.writing[uploadToken]
+	if !ok {
+		l = &uploadLock{}
+		s.writing[uploadToken] = l
+	}
+	l.holders++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		if l.holders--; l.holders == 0 {
+			delete(s.writing, uploadToken)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// checkQuota fails with ErrQuotaExceeded if a file of length bytes does not
+// fit into the quota of the folder owner, counting a file it replaces as freed.
+func (s *TusService) checkQuota(ctx context.Context, folder *model.Folder, filename string, length int64) error {
+	if existing, err := s.fileRepo.GetByFolderAndName(ctx, folder.ID, filename); err == nil {
+		length -= existing.Size
+	}
+	return s.quota.Check(ctx, folder.UserID, length)
+}
+
+func (s *TusService) CreateUpload(ctx context.Context, user *model.User, folderID int64, filename string, length int64) (*model.TusUpload, error) {
+	if length < 0 {
+		return nil, ErrInvalidUploadLength
+	}
+	if filename == "" || filename != s.converter.GetBaseName(filename) || filename == "." || filename == ".." {
+		return nil, ErrInvalidFileName
+	}
+	folder, err := s.access.Folder(ctx, user.ID, folderID, model.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkQuota(ctx, folder, filename, length); err != nil {
+		return nil, err
+	}
+
+	tok, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.Insert(ctx, user.ID, folder.ID, tok, filename, length); err != nil {
+		return nil, err
+	}
+	upload, err := s.repo.GetByToken(ctx, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	// Zero byte uploads never receive a PATCH, so they are complete right away.
+	if length == 0 {
+		if _, err := s.st.WriteStaging(user.Username, tok, 0, strings.NewReader("")); err != nil {
+			return nil, err
+		}
+		if err := s.complete(ctx, user, upload); err != nil {
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+func (s *TusService) GetUpload(ctx context.Context, userID int64, uploadToken string) (*model.TusUpload, error) {
+	upload, err := s.repo.GetByToken(ctx, uploadToken)
+	if err != nil || upload.UserID != userID {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// WriteChunk appends src to the upload at offset and returns the new offset.
+// Bytes that arrived before a connection failure are kept, so the client can
+// resume from the returned offset. Once the declared length is reached the
+// file is registered in its folder. Requests for the same upload are handled
+// one after another, so a second one sent meanwhile fails with
+// ErrUploadOffsetMismatch.
+func (s *TusService) WriteChunk(ctx context.Context, user *model.User, uploadToken string, offset int64, src io.Reader) (int64, error) {
+	unlock := s.lockUpload(uploadToken)
+	defer unlock()
+	upload, err := s.GetUpload(ctx, user.ID, uploadToken)
+	if err != nil {
+		return 0, err
+	}
+	if offset != upload.Offset {
+		return upload.Offset, ErrUploadOffsetMismatch
+	}
+
+	remaining := upload.Length - upload.Offset
+	n, writeErr := s.st.WriteStaging(user.Username, upload.UploadToken, offset, io.LimitReader(src, remaining))
+	// Nothing past the declared length is staged. A chunk that is too long
+	// does not count, the client sends it again from the same offset, which
+	// overwrites what was staged of it.
+	if writeErr == nil && n == remaining {
+		if more, _ := io.ReadFull(src, make([]byte, 1)); more > 0 {
+			return upload.Offset, ErrUploadTooLarge
+		}
+	}
+
+	upload.Offset += n
+	if err := s.repo.UpdateOffset(ctx, upload.ID, upload.Offset); err != nil {
+		return 0, err
+	}
+	if writeErr != nil {
+		return upload.Offset, fmt.Errorf("failed to write chunk: %w", writeErr)
+	}
+
+	if upload.Offset == upload.Length {
+		if err := s.complete(ctx, user, upload); err != nil {
+			return upload.Offset, err
+		}
+	}
+	return upload.Offset, nil
+}
+
+// Terminate aborts an upload and removes the bytes received so far.
+func (s *TusService) Terminate(ctx context.Context, user *model.User, uploadToken string) error {
+	unlock := s.lockUpload(uploadToken)
+	defer unlock()
+	upload, err := s.GetUpload(ctx, user.ID, uploadToken)
+	if err != nil {
+		return err
+	}
+	if err := s.st.DeleteStaging(user.Username, upload.UploadToken); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, upload.ID)
+}
+
+func (s *TusService) complete(ctx context.Context, user *model.User, upload *model.TusUpload) error {
+	// The folder may have been unshared since the upload was created.
+	folder, err := s.access.Folder(ctx, user.ID, upload.FolderID, model.PermissionWrite)
+	if err != nil {
+		return err
+	}
+	// Other uploads may have used up the quota since this one was created.
+	if quotaErr := s.checkQuota(ctx, folder, upload.Filename, upload.Length); quotaErr != nil {
+		if err := s.st.DeleteStaging(user.Username, upload.UploadToken); err != nil {
+			return err
+		}
+		if err := s.repo.Delete(ctx, upload.ID); err != nil {
+			return err
+		}
+		return quotaErr
+	}
+
+	hash, size, err := s.st.CommitStaging(user.Username, upload.UploadToken)
+	if err != nil {
+		return fmt.Errorf("failed to commit upload %q: %w", upload.Filename, err)
+	}
+
+	mimeType, err := s.detectContentType(hash)
+	if err != nil {
+		return err
+	}
+
+	location := s.converter.JoinDBPath(folder.Path, upload.Filename)
+	if _, err := s.files.putContent(ctx, folder, location, upload.Filename, mimeType, hash, size); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, upload.ID)
+}
+
+func (s *TusService) detectContentType(hash string) (string, error) {
+	f, err := s.st.OpenFile(hash)
+	if err != nil {
+		return "", err
+	}
+	defer func(f io.ReadCloser) {
+		_ = f.Close()
+	}(f)
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
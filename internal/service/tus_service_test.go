@@ -0,0 +1,302 @@
+package service_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/storage/storagetest"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+func setupTusTest(t *testing.T) (*service.TusService, *service.FolderService, *model.User, int64) {
+	t.Helper()
+	return setupTusTestWith(t, storage.NewIOStorage(testutil.SetupTestStorage(t)))
+}
+
+func setupTusTestWith(t *testing.T, st storage.FileManager) (*service.TusService, *service.FolderService, *model.User, int64) {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	tusRepo := repository.NewTusUploadRepository(db)
+	c := path.New(testutil.SetupTestStorage(t))
+
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	quotaSvc := service.NewQuotaService(userRepo, 0)
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	searchSvc := service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st)
+	fileSvc := service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, quotaSvc, folderSvc, searchSvc, access, c)
+	tusSvc := service.NewTusService(tusRepo, fileRepo, folderRepo, fileSvc, quotaSvc, access, st, c)
+
+	userID, err := userRepo.Insert(ctx, "testuser", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	user := &model.User{ID: userID, Username: "testuser"}
+
+	root, err := folderSvc.CreateFolder(ctx, userID, user.Username, -1, user.Username, "/")
+	if err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	return tusSvc, folderSvc, user, root.ID
+}
+
+func TestTusService_ResumableUpload(t *testing.T) {
+	tusSvc, folderSvc, user, folderID := setupTusTest(t)
+	ctx := testutil.TestContext(t)
+
+	content := "first chunk|second chunk"
+	upload, err := tusSvc.CreateUpload(ctx, user, folderID, "video.mp4", int64(len(content)))
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+
+	offset, err := tusSvc.WriteChunk(ctx, user, upload.UploadToken, 0, strings.NewReader(content[:12]))
+	if err != nil {
+		t.Fatalf("failed to write first chunk: %v", err)
+	}
+	if offset != 12 {
+		t.Fatalf("expected offset 12, got %d", offset)
+	}
+
+	t.Run("offset is persisted", func(t *testing.T) {
+		stored, err := tusSvc.GetUpload(ctx, user.ID, upload.UploadToken)
+		if err != nil {
+			t.Fatalf("failed to get upload: %v", err)
+		}
+		if stored.Offset != 12 {
+			t.Errorf("expected stored offset 12, got %d", stored.Offset)
+		}
+	})
+
+	t.Run("stale offset is rejected", func(t *testing.T) {
+		_, err := tusSvc.WriteChunk(ctx, user, upload.UploadToken, 0, strings.NewReader(content))
+		if err != service.ErrUploadOffsetMismatch {
+			t.Errorf("expected ErrUploadOffsetMismatch, got %v", err)
+		}
+	})
+
+	t.Run("other user cannot resume", func(t *testing.T) {
+		other := &model.User{ID: user.ID + 999, Username: "other"}
+		_, err := tusSvc.WriteChunk(ctx, other, upload.UploadToken, 12, strings.NewReader(content[12:]))
+		if err != service.ErrUploadNotFound {
+			t.Errorf("expected ErrUploadNotFound, got %v", err)
+		}
+	})
+
+	t.Run("last chunk registers the file", func(t *testing.T) {
+		offset, err := tusSvc.WriteChunk(ctx, user, upload.UploadToken, 12, strings.NewReader(content[12:]))
+		if err != nil {
+			t.Fatalf("failed to write last chunk: %v", err)
+		}
+		if offset != int64(len(content)) {
+			t.Fatalf("expected offset %d, got %d", len(content), offset)
+		}
+
+		_, files, err := folderSvc.GetFolderContents(ctx, user.ID, folderID)
+		if err != nil {
+			t.Fatalf("failed to get folder contents: %v", err)
+		}
+		if len(files) != 1 {
+			t.Fatalf("expected 1 file, got %d", len(files))
+		}
+		want := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+		if files[0].Hash != want {
+			t.Errorf("expected hash %s, got %s", want, files[0].Hash)
+		}
+		if files[0].Size != int64(len(content)) {
+			t.Errorf("expected size %d, got %d", len(content), files[0].Size)
+		}
+
+		if _, err := tusSvc.GetUpload(ctx, user.ID, upload.UploadToken); err != service.ErrUploadNotFound {
+			t.Errorf("expected finished upload to be removed, got %v", err)
+		}
+	})
+}
+
+func TestTusService_CreateUpload(t *testing.T) {
+	tusSvc, _, user, folderID := setupTusTest(t)
+	ctx := testutil.TestContext(t)
+
+	tests := []struct {
+		name        string
+		folderID    int64
+		filename    string
+		length      int64
+		expectedErr error
+	}{
+		{"valid upload", folderID, "a.bin", 10, nil},
+		{"negative length", folderID, "a.bin", -1, service.ErrInvalidUploadLength},
+		{"empty name", folderID, "", 10, service.ErrInvalidFileName},
+		{"name with path", folderID, "../a.bin", 10, service.ErrInvalidFileName},
+		{"unknown folder", 99999, "a.bin", 10, service.ErrFolderNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tusSvc.CreateUpload(ctx, user, tt.folderID, tt.filename, tt.length)
+			if err != tt.expectedErr {
+				t.Errorf("expected error %v, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestTusService_TooLargeAndTerminate(t *testing.T) {
+	tusSvc, folderSvc, user, folderID := setupTusTest(t)
+	ctx := testutil.TestContext(t)
+
+	upload, err := tusSvc.CreateUpload(ctx, user, folderID, "small.txt", 4)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+
+	if _, err := tusSvc.WriteChunk(ctx, user, upload.UploadToken, 0, strings.NewReader("too long")); err != service.ErrUploadTooLarge {
+		t.Errorf("expected ErrUploadTooLarge, got %v", err)
+	}
+	if got, err := tusSvc.GetUpload(ctx, user.ID, upload.UploadToken); err != nil || got.Offset != 0 {
+		t.Fatalf("expected the chunk that was too long not to count, got %+v, %v", got, err)
+	}
+
+	// Sending the right chunk afterwards stores exactly the declared bytes.
+	retry, err := tusSvc.CreateUpload(ctx, user, folderID, "retry.txt", 4)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	if _, err := tusSvc.WriteChunk(ctx, user, retry.UploadToken, 0, strings.NewReader("abcdef")); err != service.ErrUploadTooLarge {
+		t.Fatalf("expected ErrUploadTooLarge, got %v", err)
+	}
+	if _, err := tusSvc.WriteChunk(ctx, user, retry.UploadToken, 0, strings.NewReader("abcd")); err != nil {
+		t.Fatalf("failed to write chunk: %v", err)
+	}
+	_, files, err := folderSvc.GetFolderContents(ctx, user.ID, folderID)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected retry.txt, got %d files, %v", len(files), err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("abcd"))); files[0].Size != 4 || files[0].Hash != want {
+		t.Errorf("expected the 4 declared bytes, got %d bytes with hash %s", files[0].Size, files[0].Hash)
+	}
+
+	if err := tusSvc.Terminate(ctx, user, upload.UploadToken); err != nil {
+		t.Fatalf("failed to terminate upload: %v", err)
+	}
+	if _, err := tusSvc.GetUpload(ctx, user.ID, upload.UploadToken); err != service.ErrUploadNotFound {
+		t.Errorf("expected ErrUploadNotFound after termination, got %v", err)
+	}
+}
+
+func TestTusService_ConcurrentChunks(t *testing.T) {
+	tusSvc, _, user, folderID := setupTusTest(t)
+	ctx := testutil.TestContext(t)
+
+	content := "the same chunk sent twice"
+	upload, err := tusSvc.CreateUpload(ctx, user, folderID, "twice.txt", int64(len(content)))
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := tusSvc.WriteChunk(ctx, user, upload.UploadToken, 0, strings.NewReader(content))
+			errs <- err
+		}()
+	}
+	var ok, mismatch int
+	for i := 0; i < 2; i++ {
+		switch err := <-errs; {
+		case err == nil:
+			ok++
+		case errors.Is(err, service.ErrUploadOffsetMismatch), errors.Is(err, service.ErrUploadNotFound):
+			mismatch++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if ok != 1 || mismatch != 1 {
+		t.Errorf("expected one chunk to be written and one refused, got %d and %d", ok, mismatch)
+	}
+}
+
+func TestTusService_UploadReplacesExistingFile(t *testing.T) {
+	tusSvc, folderSvc, user, folderID := setupTusTest(t)
+	ctx := testutil.TestContext(t)
+
+	// The same content again, like a retried upload, adds no version.
+	for _, content := range []string{"old", "new content", "new content"} {
+		upload, err := tusSvc.CreateUpload(ctx, user, folderID, "report.pdf", int64(len(content)))
+		if err != nil {
+			t.Fatalf("failed to create upload: %v", err)
+		}
+		if _, err := tusSvc.WriteChunk(ctx, user, upload.UploadToken, 0, strings.NewReader(content)); err != nil {
+			t.Fatalf("failed to write upload: %v", err)
+		}
+	}
+
+	_, files, err := folderSvc.GetFolderContents(ctx, user.ID, folderID)
+	if err != nil {
+		t.Fatalf("failed to get folder contents: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+	if files[0].Version != 2 || files[0].Size != int64(len("new content")) {
+		t.Errorf("expected version 2 with the new content, got %+v", files[0])
+	}
+}
+
+func TestTusService_S3Storage(t *testing.T) {
+	st, err := storage.NewS3Storage(storagetest.SetupS3(t))
+	if err != nil {
+		t.Fatalf("failed to connect to test bucket: %v", err)
+	}
+	tusSvc, folderSvc, user, folderID := setupTusTestWith(t, st)
+	ctx := testutil.TestContext(t)
+
+	content := "first chunk|second chunk"
+	upload, err := tusSvc.CreateUpload(ctx, user, folderID, "video.mp4", int64(len(content)))
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	for _, chunk := range []struct{ from, to int }{{0, 12}, {12, len(content)}} {
+		if _, err := tusSvc.WriteChunk(ctx, user, upload.UploadToken, int64(chunk.from), strings.NewReader(content[chunk.from:chunk.to])); err != nil {
+			t.Fatalf("failed to write chunk at %d: %v", chunk.from, err)
+		}
+	}
+	if _, err := tusSvc.CreateUpload(ctx, user, folderID, "empty.txt", 0); err != nil {
+		t.Fatalf("failed to create empty upload: %v", err)
+	}
+
+	_, files, err := folderSvc.GetFolderContents(ctx, user.ID, folderID)
+	if err != nil {
+		t.Fatalf("failed to get folder contents: %v", err)
+	}
+	want := map[string]string{"video.mp4": content, "empty.txt": ""}
+	if len(files) != len(want) {
+		t.Fatalf("expected %d files, got %d", len(want), len(files))
+	}
+	for _, f := range files {
+		if hash := fmt.Sprintf("%x", sha256.Sum256([]byte(want[f.Name]))); f.Hash != hash {
+			t.Errorf("expected %s to have hash %s, got %s", f.Name, hash, f.Hash)
+		}
+		rc, err := st.OpenFile(f.Hash)
+		if err != nil {
+			t.Errorf("expected %s in the bucket: %v", f.Name, err)
+			continue
+		}
+		_ = rc.Close()
+	}
+}
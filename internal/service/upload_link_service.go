@@ -2,56 +2,113 @@ package service
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
-	"github.com/NiClassic/go-cloud/internal/token"
+	"mime/multipart"
 	"time"
 
+	"github.com/NiClassic/go-cloud/internal/token"
+
 	"github.com/NiClassic/go-cloud/internal/model"
 	"github.com/NiClassic/go-cloud/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UploadLinkService struct {
-	repo *repository.UploadLinkRepository
+	repo     *repository.UploadLinkRepository
+	userRepo *repository.UserRepository
+	files    *PersonalFileService
+	// secret signs the unlock proofs of links, see linkUnlockProof.
+	secret []byte
 }
 
-func NewUploadLinkService(r *repository.UploadLinkRepository) *UploadLinkService {
-	return &UploadLinkService{repo: r}
+func NewUploadLinkService(r *repository.UploadLinkRepository, userRepo *repository.UserRepository, files *PersonalFileService, secret []byte) *UploadLinkService {
+	return &UploadLinkService{repo: r, userRepo: userRepo, files: files, secret: secret}
 }
 
 func (s *UploadLinkService) CreateUploadLink(
 	ctx context.Context,
+	userID, folderID int64,
 	name string,
 	plain string,
 	expiresAt time.Time,
 ) (*model.UploadLink, error) {
-	if name == "" || plain == "" {
+	if name == "" {
 		return nil, ErrEmptyLinkFields
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
-	if err != nil {
+	if _, err := s.files.access.Folder(ctx, userID, folderID, model.PermissionWrite); err != nil {
 		return nil, err
 	}
+	var hash string
+	if plain != "" {
+		var err error
+		if hash, err = hashLinkPassword(plain); err != nil {
+			return nil, err
+		}
+	}
 	tok, err := generateUploadToken()
 	if err != nil {
 		return nil, err
 	}
-	id, err := s.repo.Insert(ctx, string(hash), tok, name, expiresAt)
+	id, err := s.repo.Insert(ctx, userID, folderID, hash, tok, name, expiresAt)
 	if err != nil {
 		return nil, err
 	}
 	return &model.UploadLink{
 		ID:             id,
-		HashedPassword: string(hash),
+		HashedPassword: hash,
 		Name:           name,
 		CreatedAt:      time.Now().UTC(),
 		ExpiresAt:      expiresAt,
 		LinkToken:      tok,
+		UserID:         sql.NullInt64{Int64: userID, Valid: true},
+		FolderID:       sql.NullInt64{Int64: folderID, Valid: true},
 	}, nil
 }
 
-func (s *UploadLinkService) GetAllLinks(ctx context.Context) ([]*model.UploadLink, error) {
-	return s.repo.GetAll(ctx)
+// GetUserLinks returns the upload links created by the user.
+func (s *UploadLinkService) GetUserLinks(ctx context.Context, userID int64) ([]*model.UploadLink, error) {
+	return s.repo.GetByUser(ctx, userID)
+}
+
+// GetOwnedLink returns the link only if it was created by the user. Links of
+// other users are reported as not found so their tokens do not leak.
+func (s *UploadLinkService) GetOwnedLink(ctx context.Context, userID int64, linkToken string) (*model.UploadLink, error) {
+	ul, err := s.repo.GetByToken(ctx, linkToken)
+	if err != nil {
+		return nil, ErrLinkNotFound
+	}
+	if !ul.UserID.Valid || ul.UserID.Int64 != userID {
+		return nil, ErrLinkNotFound
+	}
+	return ul, nil
+}
+
+func (s *UploadLinkService) UpdateUploadLink(ctx context.Context, userID int64, linkToken, name string, expiresAt time.Time) (*model.UploadLink, error) {
+	if name == "" {
+		return nil, ErrEmptyLinkFields
+	}
+	ul, err := s.GetOwnedLink(ctx, userID, linkToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Update(ctx, ul.ID, name, expiresAt); err != nil {
+		return nil, err
+	}
+	ul.Name = name
+	ul.ExpiresAt = expiresAt
+	return ul, nil
+}
+
+// RevokeUploadLink deletes the link, which voids its unlock proofs as well.
+func (s *UploadLinkService) RevokeUploadLink(ctx context.Context, userID int64, linkToken string) error {
+	ul, err := s.GetOwnedLink(ctx, userID, linkToken)
+	if err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, ul.ID)
 }
 
 func (s *UploadLinkService) ValidatePassword(
@@ -62,19 +119,95 @@ func (s *UploadLinkService) ValidatePassword(
 	if err != nil {
 		return nil, ErrLinkNotFound
 	}
-	if time.Now().After(ul.ExpiresAt) {
+	if ul.Expired(time.Now()) {
 		return nil, ErrLinkExpired
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(ul.HashedPassword), []byte(plain)); err != nil {
-		return nil, ErrInvalidPassword
+	if !ul.HasPassword() {
+		return ul, nil
+	}
+	if err := checkLinkPassword(ul.HashedPassword, plain); err != nil {
+		return nil, err
 	}
 	return ul, nil
 }
 
+// UnlockProof returns what a visitor keeps, in a cookie, to show they entered
+// the password of the link.
+func (s *UploadLinkService) UnlockProof(link *model.UploadLink) string {
+	return linkUnlockProof(s.secret, link.HashedPassword, link.LinkToken)
+}
+
+// Unlocked reports whether user, nil for visitors without an account, may
+// upload to the link without entering its password: nobody once the link
+// expired, otherwise the creator of the link always may, others if the link
+// has no password or proof came from UnlockProof.
+func (s *UploadLinkService) Unlocked(link *model.UploadLink, user *model.User, proof string) bool {
+	if link.Expired(time.Now()) {
+		return false
+	}
+	if user != nil && link.UserID.Valid && link.UserID.Int64 == user.ID {
+		return true
+	}
+	return !link.HasPassword() || checkLinkUnlockProof(s.secret, link.HashedPassword, link.LinkToken, proof)
+}
+
 func (s *UploadLinkService) GetByToken(ctx context.Context, linkToken string) (*model.UploadLink, error) {
 	return s.repo.GetByToken(ctx, linkToken)
 }
 
+// StoreFiles stores every file part of reader in the destination folder of
+// the link. The files are owned by the creator of the link, so the visitor
+// does not need an account.
+func (s *UploadLinkService) StoreFiles(ctx context.Context, link *model.UploadLink, reader *multipart.Reader) error {
+	if link.Expired(time.Now()) {
+		return ErrLinkExpired
+	}
+	if !link.UserID.Valid || !link.FolderID.Valid {
+		return ErrLinkNoDestination
+	}
+	owner, err := s.userRepo.GetByID(ctx, link.UserID.Int64)
+	if err != nil {
+		return ErrLinkNoDestination
+	}
+	// The folder may be gone or no longer shared with the creator of the link.
+	folder, err := s.files.access.Folder(ctx, owner.ID, link.FolderID.Int64, model.PermissionWrite)
+	if err != nil {
+		return ErrLinkNoDestination
+	}
+	return s.files.StoreFiles(ctx, owner, reader, folder.ID, folder.Path)
+}
+
+// hashLinkPassword returns the bcrypt hash of the password of a link.
+func hashLinkPassword(plain string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// checkLinkPassword fails with ErrInvalidPassword unless plain is the
+// password hashed by hashLinkPassword.
+func checkLinkPassword(hash, plain string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)); err != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// linkUnlockProof signs the token of a link together with its password hash
+// using the secret of the server, which is not kept in the database, so a
+// copy of the database is not enough to make proofs. Proofs need no storage
+// and are void once the password changes or the link is deleted.
+func linkUnlockProof(secret []byte, hashedPassword, linkToken string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(linkToken))
+	mac.Write([]byte{0})
+	mac.Write([]byte(hashedPassword))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func checkLinkUnlockProof(secret []byte, hashedPassword, linkToken, proof string) bool {
+	return hmac.Equal([]byte(proof), []byte(linkUnlockProof(secret, hashedPassword, linkToken)))
+}
+
 func generateUploadToken() (string, error) {
 	b, err := token.Bytes(32)
 	if err != nil {
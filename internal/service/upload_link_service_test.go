@@ -0,0 +1,281 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+	"github.com/NiClassic/go-cloud/internal/path"
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/service"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+func setupUploadLinkTest(t *testing.T) (*service.UploadLinkService, *service.FolderService, *model.User, *model.Folder) {
+	t.Helper()
+
+	db := testutil.SetupTestDB(t)
+	ctx := testutil.TestContext(t)
+	tmpDir := testutil.SetupTestStorage(t)
+
+	userRepo := repository.NewUserRepository(db)
+	fileRepo := repository.NewPersonalFileRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	linkRepo := repository.NewUploadLinkRepository(db)
+	st := storage.NewIOStorage(tmpDir)
+	c := path.New(tmpDir)
+
+	access := service.NewAccessService(repository.NewShareRepository(db), folderRepo, fileRepo)
+	versionSvc := service.NewFileVersionService(repository.NewFileVersionRepository(db), fileRepo, access, st, service.VersionPolicy{})
+	folderSvc := service.NewFolderService(folderRepo, fileRepo, access, c)
+	fileSvc := service.NewPersonalFileService(st, service.NewBlobService(repository.NewBlobRepository(db), st), fileRepo, versionSvc, service.NewQuotaService(userRepo, 0), folderSvc, service.NewSearchService(repository.NewSearchRepository(db), folderSvc, st), access, c)
+	linkSvc := service.NewUploadLinkService(linkRepo, userRepo, fileSvc, []byte("test secret"))
+
+	userID, err := userRepo.Insert(ctx, "owner", "hashedpass")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	user := &model.User{ID: userID, Username: "owner"}
+
+	root, err := folderSvc.CreateFolder(ctx, userID, user.Username, -1, user.Username, "/")
+	if err != nil {
+		t.Fatalf("failed to create root folder: %v", err)
+	}
+	inbox, err := folderSvc.CreateFolder(ctx, userID, user.Username, root.ID, "inbox", "/inbox")
+	if err != nil {
+		t.Fatalf("failed to create inbox folder: %v", err)
+	}
+
+	return linkSvc, folderSvc, user, inbox
+}
+
+func TestUploadLinkService_CreateUploadLink(t *testing.T) {
+	linkSvc, _, user, folder := setupUploadLinkTest(t)
+	ctx := testutil.TestContext(t)
+	exp := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		userID      int64
+		folderID    int64
+		linkName    string
+		password    string
+		expectedErr error
+	}{
+		{"valid link", user.ID, folder.ID, "Inbox", "secret", nil},
+		{"empty name", user.ID, folder.ID, "", "secret", service.ErrEmptyLinkFields},
+		{"without password", user.ID, folder.ID, "Inbox", "", nil},
+		{"foreign folder", user.ID + 999, folder.ID, "Inbox", "secret", service.ErrFolderNotFound},
+		{"missing folder", user.ID, 99999, "Inbox", "secret", service.ErrFolderNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := linkSvc.CreateUploadLink(ctx, tt.userID, tt.folderID, tt.linkName, tt.password, exp)
+			if tt.expectedErr != nil {
+				if err != tt.expectedErr {
+					t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			stored, err := linkSvc.GetByToken(ctx, link.LinkToken)
+			if err != nil {
+				t.Fatalf("failed to get link: %v", err)
+			}
+			if stored.UserID.Int64 != tt.userID || stored.FolderID.Int64 != tt.folderID {
+				t.Errorf("expected owner %d and folder %d, got %v and %v", tt.userID, tt.folderID, stored.UserID, stored.FolderID)
+			}
+		})
+	}
+}
+
+func TestUploadLinkService_StoreFiles(t *testing.T) {
+	linkSvc, folderSvc, user, folder := setupUploadLinkTest(t)
+	ctx := testutil.TestContext(t)
+
+	t.Run("files land in the link folder", func(t *testing.T) {
+		link, err := linkSvc.CreateUploadLink(ctx, user.ID, folder.ID, "Inbox", "secret", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+
+		reader := createMultipartReader(t, map[string]string{"report.txt": "quarterly numbers"})
+		if err := linkSvc.StoreFiles(ctx, link, reader); err != nil {
+			t.Fatalf("failed to store files: %v", err)
+		}
+
+		_, files, err := folderSvc.GetFolderContents(ctx, user.ID, folder.ID)
+		if err != nil {
+			t.Fatalf("failed to get folder contents: %v", err)
+		}
+		if len(files) != 1 || files[0].Name != "report.txt" {
+			t.Fatalf("expected report.txt in inbox, got %v", files)
+		}
+		if files[0].UserID != user.ID {
+			t.Errorf("expected file to be owned by %d, got %d", user.ID, files[0].UserID)
+		}
+	})
+
+	t.Run("expired link", func(t *testing.T) {
+		link, err := linkSvc.CreateUploadLink(ctx, user.ID, folder.ID, "Old", "secret", time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+
+		reader := createMultipartReader(t, map[string]string{"late.txt": "too late"})
+		if err := linkSvc.StoreFiles(ctx, link, reader); err != service.ErrLinkExpired {
+			t.Errorf("expected ErrLinkExpired, got %v", err)
+		}
+	})
+
+	t.Run("link without destination", func(t *testing.T) {
+		link := &model.UploadLink{ID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+		reader := createMultipartReader(t, map[string]string{"lost.txt": "nowhere"})
+		if err := linkSvc.StoreFiles(ctx, link, reader); err != service.ErrLinkNoDestination {
+			t.Errorf("expected ErrLinkNoDestination, got %v", err)
+		}
+	})
+}
+
+func TestUploadLinkService_Ownership(t *testing.T) {
+	linkSvc, _, user, folder := setupUploadLinkTest(t)
+	ctx := testutil.TestContext(t)
+	otherID := user.ID + 999
+
+	link, err := linkSvc.CreateUploadLink(ctx, user.ID, folder.ID, "Inbox", "secret", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	t.Run("links are scoped to their owner", func(t *testing.T) {
+		links, err := linkSvc.GetUserLinks(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("failed to get links: %v", err)
+		}
+		if len(links) != 1 || links[0].LinkToken != link.LinkToken {
+			t.Errorf("expected the owner's link, got %v", links)
+		}
+
+		links, err = linkSvc.GetUserLinks(ctx, otherID)
+		if err != nil {
+			t.Fatalf("failed to get links: %v", err)
+		}
+		if len(links) != 0 {
+			t.Errorf("expected no links for other user, got %d", len(links))
+		}
+	})
+
+	t.Run("other user cannot edit", func(t *testing.T) {
+		_, err := linkSvc.UpdateUploadLink(ctx, otherID, link.LinkToken, "Stolen", time.Now().Add(time.Hour))
+		if err != service.ErrLinkNotFound {
+			t.Errorf("expected ErrLinkNotFound, got %v", err)
+		}
+	})
+
+	t.Run("owner can edit", func(t *testing.T) {
+		exp := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+		if _, err := linkSvc.UpdateUploadLink(ctx, user.ID, link.LinkToken, "Renamed", exp); err != nil {
+			t.Fatalf("failed to update link: %v", err)
+		}
+		stored, err := linkSvc.GetByToken(ctx, link.LinkToken)
+		if err != nil {
+			t.Fatalf("failed to get link: %v", err)
+		}
+		if stored.Name != "Renamed" || !stored.ExpiresAt.Equal(exp) {
+			t.Errorf("expected Renamed expiring at %v, got %s expiring at %v", exp, stored.Name, stored.ExpiresAt)
+		}
+	})
+
+	t.Run("other user cannot revoke", func(t *testing.T) {
+		if err := linkSvc.RevokeUploadLink(ctx, otherID, link.LinkToken); err != service.ErrLinkNotFound {
+			t.Errorf("expected ErrLinkNotFound, got %v", err)
+		}
+	})
+
+	t.Run("owner can revoke", func(t *testing.T) {
+		if err := linkSvc.RevokeUploadLink(ctx, user.ID, link.LinkToken); err != nil {
+			t.Fatalf("failed to revoke link: %v", err)
+		}
+		if _, err := linkSvc.GetByToken(ctx, link.LinkToken); err == nil {
+			t.Error("expected revoked link to be gone")
+		}
+	})
+}
+
+func TestUploadLinkService_Unlock(t *testing.T) {
+	linkSvc, _, user, folder := setupUploadLinkTest(t)
+	ctx := testutil.TestContext(t)
+	exp := time.Now().Add(time.Hour)
+	visitor := &model.User{ID: user.ID + 999, Username: "visitor"}
+
+	link, err := linkSvc.CreateUploadLink(ctx, user.ID, folder.ID, "Inbox", "secret", exp)
+	if err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	t.Run("password", func(t *testing.T) {
+		if _, err := linkSvc.ValidatePassword(ctx, link.LinkToken, "wrong"); err != service.ErrInvalidPassword {
+			t.Errorf("expected ErrInvalidPassword, got %v", err)
+		}
+		if _, err := linkSvc.ValidatePassword(ctx, link.LinkToken, "secret"); err != nil {
+			t.Errorf("expected the password to be valid, got %v", err)
+		}
+	})
+
+	t.Run("signed proof", func(t *testing.T) {
+		if linkSvc.Unlocked(link, nil, "") || linkSvc.Unlocked(link, visitor, "forged") {
+			t.Error("expected the link to be locked without proof")
+		}
+		proof := linkSvc.UnlockProof(link)
+		if !linkSvc.Unlocked(link, nil, proof) || !linkSvc.Unlocked(link, visitor, proof) {
+			t.Error("expected the proof to unlock the link with and without an account")
+		}
+		other, err := linkSvc.CreateUploadLink(ctx, user.ID, folder.ID, "Other", "secret", exp)
+		if err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+		if linkSvc.Unlocked(other, nil, proof) {
+			t.Error("expected the proof of one link not to unlock another")
+		}
+
+		// The database alone is not enough to make a proof.
+		forged := service.NewUploadLinkService(nil, nil, nil, []byte("other secret")).UnlockProof(link)
+		if linkSvc.Unlocked(link, nil, forged) {
+			t.Error("expected a proof signed with another secret to be rejected")
+		}
+		changed := *link
+		changed.HashedPassword = other.HashedPassword
+		if linkSvc.Unlocked(&changed, nil, proof) {
+			t.Error("expected a new password to void the proof")
+		}
+		expired := *link
+		expired.ExpiresAt = time.Now().Add(-time.Minute)
+		if linkSvc.Unlocked(&expired, nil, proof) || linkSvc.Unlocked(&expired, user, "") {
+			t.Error("expected an expired link to stay locked")
+		}
+	})
+
+	t.Run("creator", func(t *testing.T) {
+		if !linkSvc.Unlocked(link, user, "") {
+			t.Error("expected the creator not to need the password")
+		}
+	})
+
+	t.Run("without password", func(t *testing.T) {
+		open, err := linkSvc.CreateUploadLink(ctx, user.ID, folder.ID, "Open", "", exp)
+		if err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+		if open.HasPassword() || !linkSvc.Unlocked(open, nil, "") {
+			t.Error("expected a link without password to be unlocked")
+		}
+		if _, err := linkSvc.ValidatePassword(ctx, open.LinkToken, ""); err != nil {
+			t.Errorf("expected no password to be needed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,435 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NiClassic/go-cloud/internal/model"
+)
+
+const (
+	// chunkSize is the amount of plain text sealed at once. A range request
+	// decrypts at most one chunk it does not need at either end.
+	chunkSize = 64 << 10
+	// frameSize is a sealed chunk with its authentication tag.
+	frameSize = chunkSize + 16
+	// keySize selects AES-256 for master and data keys.
+	keySize = 32
+)
+
+// ErrCorruptBlob is returned when an encrypted content fails authentication,
+// because it was damaged or tampered with.
+var ErrCorruptBlob = errors.New("encrypted content is corrupt or was tampered with")
+
+// Keyring holds the master keys data keys are wrapped with. New data keys
+// are wrapped with the current key; older keys are kept to read contents
+// until they are wrapped again, see EncryptedStorage.Rewrap.
+type Keyring struct {
+	current *masterKey
+	keys    map[string]*masterKey
+}
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// ParseKeyring reads base64 encoded 32 byte keys separated by commas or
+// newlines. The first key is the current one. Blank lines and lines starting
+// with # are ignored, so a key file can be commented.
+func ParseKeyring(s string) (*Keyring, error) {
+	kr := &Keyring{keys: map[string]*masterKey{}}
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, field := range strings.Split(line, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			raw, err := base64.StdEncoding.DecodeString(field)
+			if err != nil || len(raw) != keySize {
+				return nil, fmt.Errorf("master keys must be %d bytes encoded in base64, e.g. from `openssl rand -base64 %d`", keySize, keySize)
+			}
+			aead, err := newAEAD(raw)
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(raw)
+			k := &masterKey{hex.EncodeToString(sum[:8]), aead}
+			if kr.current == nil {
+				kr.current = k
+			}
+			kr.keys[k.id] = k
+		}
+	}
+	if kr.current == nil {
+		return nil, errors.New("no master key given")
+	}
+	return kr, nil
+}
+
+// CurrentKeyID names the key new data keys are wrapped with. It is derived
+// from the key, so it does not reveal it.
+func (kr *Keyring) CurrentKeyID() string {
+	return kr.current.id
+}
+
+// wrap encrypts dek with the current key. The hash is authenticated along,
+// so a wrapped key cannot be moved to another blob.
+func (kr *Keyring) wrap(hash string, dek []byte) (string, []byte, error) {
+	nonce := make([]byte, kr.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return kr.current.id, kr.current.aead.Seal(nonce, nonce, dek, []byte(hash)), nil
+}
+
+func (kr *Keyring) unwrap(k *model.BlobKey) ([]byte, error) {
+	mk, ok := kr.keys[k.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("content %s is encrypted with unknown master key %s", k.Hash, k.KeyID)
+	}
+	n := mk.aead.NonceSize()
+	if len(k.WrappedKey) < n {
+		return nil, fmt.Errorf("data key of %s: %w", k.Hash, ErrCorruptBlob)
+	}
+	dek, err := mk.aead.Open(nil, k.WrappedKey[:n], k.WrappedKey[n:], []byte(k.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("data key of %s: %w", k.Hash, ErrCorruptBlob)
+	}
+	return dek, nil
+}
+
+// KeyStore keeps the wrapped data keys of encrypted contents, see
+// repository.BlobRepository.
+type KeyStore interface {
+	// GetKey returns the key of hash, or nil if its content is not encrypted.
+	GetKey(ctx context.Context, hash string) (*model.BlobKey, error)
+	// AddKey records k unless its hash already has a key and reports whether it did.
+	AddKey(ctx context.Context, k *model.BlobKey) (bool, error)
+	UpdateKey(ctx context.Context, k *model.BlobKey) error
+	DeleteKey(ctx context.Context, hash string) error
+	GetKeysNotWrappedBy(ctx context.Context, keyID string) ([]*model.BlobKey, error)
+}
+
+// EncryptedStorage encrypts contents before they reach another FileManager.
+// Every content gets its own random data key and is sealed with AES-256-GCM
+// in chunks, each bound to its position and to whether it is the last one,
+// so chunks cannot be reordered, dropped or truncated unnoticed. The data key
+// is kept in the KeyStore, wrapped with the current master key.
+//
+// Contents are still addressed by the hash of their plain text, so identical
+// uploads are stored once. The encrypted content is stored in inner under its
+// own hash, which the KeyStore maps to. Contents stored before encryption was
+// enabled have no key and are read as they are; uploading them again
+// replaces them with an encrypted copy.
+//
+// Unfinished uploads are kept unencrypted in the staging area of inner until
+// they are committed.
+type EncryptedStorage struct {
+	inner FileManager
+	keys  *Keyring
+	store KeyStore
+}
+
+func NewEncryptedStorage(inner FileManager, keys *Keyring, store KeyStore) *EncryptedStorage {
+	return &EncryptedStorage{inner, keys, store}
+}
+
+func (s *EncryptedStorage) SaveFile(src io.Reader) (string, int64, error) {
+	ctx := context.Background()
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", 0, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", 0, err
+	}
+
+	hasher := sha256.New()
+	enc := &encryptReader{src: bufio.NewReaderSize(io.TeeReader(src, hasher), chunkSize), aead: aead}
+	object, _, err := s.inner.SaveFile(enc)
+	if err != nil {
+		return "", 0, err
+	}
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	keyID, wrapped, err := s.keys.wrap(hash, dek)
+	if err != nil {
+		_ = s.inner.DeleteFile(object)
+		return "", 0, err
+	}
+	added, err := s.store.AddKey(ctx, &model.BlobKey{Hash: hash, Object: object, KeyID: keyID, WrappedKey: wrapped})
+	if err != nil || !added {
+		// The content is already stored encrypted, keep that copy.
+		_ = s.inner.DeleteFile(object)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	// Drops a copy stored before encryption was enabled.
+	if err := s.inner.DeleteFile(hash); err != nil {
+		return "", 0, err
+	}
+	return hash, enc.size, nil
+}
+
+// OpenFile returns a reader that decrypts the content on demand. Seeking
+// only decrypts the chunk it lands in, so ranges are served without
+// decrypting the content up to the range.
+func (s *EncryptedStorage) OpenFile(hash string) (io.ReadSeekCloser, error) {
+	k, err := s.store.GetKey(context.Background(), hash)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return s.inner.OpenFile(hash)
+	}
+	dek, err := s.keys.unwrap(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := s.inner.OpenFile(k.Object)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newDecryptReader(rc, aead)
+	if err != nil {
+		_ = rc.Close()
+		return nil, fmt.Errorf("content %s: %w", hash, err)
+	}
+	return r, nil
+}
+
+// DeleteFile deletes the encrypted content of hash together with its key.
+func (s *EncryptedStorage) DeleteFile(hash string) error {
+	ctx := context.Background()
+	k, err := s.store.GetKey(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if k != nil {
+		if err := s.inner.DeleteFile(k.Object); err != nil {
+			return err
+		}
+		if err := s.store.DeleteKey(ctx, hash); err != nil {
+			return err
+		}
+	}
+	return s.inner.DeleteFile(hash)
+}
+
+func (s *EncryptedStorage) WriteStaging(username string, uploadID string, offset int64, src io.Reader) (int64, error) {
+	return s.inner.WriteStaging(username, uploadID, offset, src)
+}
+
+// CommitStaging commits the upload in inner and replaces the plain text with
+// an encrypted copy.
+func (s *EncryptedStorage) CommitStaging(username string, uploadID string) (string, int64, error) {
+	hash, size, err := s.inner.CommitStaging(username, uploadID)
+	if err != nil {
+		return "", 0, err
+	}
+	k, err := s.store.GetKey(context.Background(), hash)
+	if err != nil {
+		return "", 0, err
+	}
+	if k != nil {
+		return hash, size, s.inner.DeleteFile(hash)
+	}
+	rc, err := s.inner.OpenFile(hash)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+	return s.SaveFile(rc)
+}
+
+func (s *EncryptedStorage) DeleteStaging(username string, uploadID string) error {
+	return s.inner.DeleteStaging(username, uploadID)
+}
+
+// Rewrap wraps every data key that is not wrapped with the current master key
+// again with it and returns how many it rewrapped. The contents themselves
+// are not touched. Once it succeeded, older master keys can be dropped.
+func (s *EncryptedStorage) Rewrap(ctx context.Context) (int, error) {
+	keys, err := s.store.GetKeysNotWrappedBy(ctx, s.keys.CurrentKeyID())
+	if err != nil {
+		return 0, err
+	}
+	for i, k := range keys {
+		dek, err := s.keys.unwrap(k)
+		if err != nil {
+			return i, err
+		}
+		if k.KeyID, k.WrappedKey, err = s.keys.wrap(k.Hash, dek); err != nil {
+			return i, err
+		}
+		if err := s.store.UpdateKey(ctx, k); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is unique per chunk of a content. A data key is never used for
+// more than one content, so the nonce does not have to be random.
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptReader seals src chunk by chunk. The content ends with a chunk
+// marked as last, which is empty for empty contents.
+type encryptReader struct {
+	src   *bufio.Reader
+	aead  cipher.AEAD
+	index int64
+	size  int64
+	buf   []byte
+	done  bool
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		chunk := make([]byte, chunkSize, frameSize)
+		n, err := io.ReadFull(r.src, chunk)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		if err == nil {
+			// A full chunk is only the last one if nothing follows.
+			_, err = r.src.Peek(1)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return 0, err
+			}
+		}
+		r.done = err != nil
+		r.buf = r.aead.Seal(chunk[:0], chunkNonce(r.index, r.done), chunk[:n], nil)
+		r.index++
+		r.size += int64(n)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// decryptReader opens the chunks of src as they are read.
+type decryptReader struct {
+	src    io.ReadSeekCloser
+	aead   cipher.AEAD
+	frames int64
+	size   int64
+	pos    int64
+	// frame is the index of the chunk in buf, next the one src is positioned at.
+	frame int64
+	next  int64
+	buf   []byte
+}
+
+func newDecryptReader(src io.ReadSeekCloser, aead cipher.AEAD) (*decryptReader, error) {
+	end, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	frames := (end + frameSize - 1) / frameSize
+	if frames == 0 {
+		// Every content ends with a chunk marked as last.
+		return nil, ErrCorruptBlob
+	}
+	return &decryptReader{
+		src:    src,
+		aead:   aead,
+		frames: frames,
+		size:   end - frames*(frameSize-chunkSize),
+		frame:  -1,
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if index := r.pos / chunkSize; index != r.frame {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[r.pos-r.frame*chunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *decryptReader) load(index int64) error {
+	if index != r.next {
+		if _, err := r.src.Seek(index*frameSize, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	frame := make([]byte, frameSize)
+	n, err := io.ReadFull(r.src, frame)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	r.buf, err = r.aead.Open(frame[:0], chunkNonce(index, index == r.frames-1), frame[:n], nil)
+	if err != nil {
+		return ErrCorruptBlob
+	}
+	r.frame = index
+	r.next = index + 1
+	return nil
+}
+
+func (r *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to a negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
@@ -0,0 +1,338 @@
+package storage_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/repository"
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/testutil"
+)
+
+type encryptedTest struct {
+	inner *storage.IOStorage
+	dir   string
+	repo  *repository.BlobRepository
+}
+
+func setupEncryptedTest(t *testing.T) *encryptedTest {
+	t.Helper()
+	dir := testutil.SetupTestStorage(t)
+	return &encryptedTest{storage.NewIOStorage(dir), dir, repository.NewBlobRepository(testutil.SetupTestDB(t))}
+}
+
+// open returns the encrypted storage with the given master keys, the first
+// one being the current key.
+func (et *encryptedTest) open(t *testing.T, keys ...string) *storage.EncryptedStorage {
+	t.Helper()
+	kr, err := storage.ParseKeyring(strings.Join(keys, ","))
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	return storage.NewEncryptedStorage(et.inner, kr, et.repo)
+}
+
+// objectPath returns the path of the encrypted content of hash on disk.
+func (et *encryptedTest) objectPath(t *testing.T, hash string) string {
+	t.Helper()
+	k, err := et.repo.GetKey(testutil.TestContext(t), hash)
+	if err != nil || k == nil {
+		t.Fatalf("expected a key for %s, got %v, %v", hash, k, err)
+	}
+	return filepath.Join(et.dir, ".blobs", k.Object[:2], k.Object)
+}
+
+func (et *encryptedTest) countObjects(t *testing.T) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(filepath.Join(et.dir, ".blobs"), func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to list blobs: %v", err)
+	}
+	return n
+}
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// fixedContent returns n bytes that look random but are the same on every
+// run, the SHA-256 of a counter, so failures can be reproduced.
+func fixedContent(n int) []byte {
+	b := make([]byte, 0, n+sha256.Size)
+	for i := uint64(0); len(b) < n; i++ {
+		sum := sha256.Sum256(binary.BigEndian.AppendUint64(nil, i))
+		b = append(b, sum[:]...)
+	}
+	return b[:n]
+}
+
+func TestEncryptedStorage_RoundTrip(t *testing.T) {
+	et := setupEncryptedTest(t)
+	st := et.open(t, testKey(1))
+
+	// The contents are long enough that their ciphertext never matches them
+	// by chance, as a single byte would one time in 256.
+	for _, n := range []int{0, 17, 64 << 10, 64<<10 + 1, 3*64<<10 + 5} {
+		content := fixedContent(n)
+		hash, size, err := st.SaveFile(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("SaveFile of %d bytes failed: %v", n, err)
+		}
+		if want := fmt.Sprintf("%x", sha256.Sum256(content)); hash != want || size != int64(n) {
+			t.Errorf("expected %s with %d bytes, got %s with %d", want, n, hash, size)
+		}
+		if got := readBlob(t, st, hash); !bytes.Equal(got, content) {
+			t.Errorf("content of %d bytes differs after decrypting", n)
+		}
+		if _, err := et.inner.OpenFile(hash); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected no plain text copy under %s, got %v", hash, err)
+		}
+		stored, err := os.ReadFile(et.objectPath(t, hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every chunk of up to 64 KiB, and an empty content, is sealed with a
+		// 16 byte tag.
+		frames := max(1, (n+64<<10-1)/(64<<10))
+		if len(stored) != n+16*frames || (n > 0 && bytes.Contains(stored, content)) {
+			t.Errorf("content of %d bytes is not sealed, stored %d bytes", n, len(stored))
+		}
+	}
+}
+
+func TestEncryptedStorage_IdenticalContentIsStoredOnce(t *testing.T) {
+	et := setupEncryptedTest(t)
+	st := et.open(t, testKey(1))
+
+	first, _, err := st.SaveFile(strings.NewReader("same"))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	second, _, err := st.SaveFile(strings.NewReader("same"))
+	if err != nil || second != first {
+		t.Fatalf("expected %s again, got %s, %v", first, second, err)
+	}
+	if n := et.countObjects(t); n != 1 {
+		t.Errorf("expected 1 stored object, got %d", n)
+	}
+
+	if err := st.DeleteFile(first); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if n := et.countObjects(t); n != 0 {
+		t.Errorf("expected no stored object after delete, got %d", n)
+	}
+	if k, err := et.repo.GetKey(testutil.TestContext(t), first); err != nil || k != nil {
+		t.Errorf("expected the key to be deleted, got %v, %v", k, err)
+	}
+	if _, err := st.OpenFile(first); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+}
+
+func TestEncryptedStorage_Seek(t *testing.T) {
+	et := setupEncryptedTest(t)
+	st := et.open(t, testKey(1))
+
+	content := fixedContent(200 << 10)
+	hash, _, err := st.SaveFile(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	rc, err := st.OpenFile(hash)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer rc.Close()
+	rs, ok := rc.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("expected the reader to support seeking")
+	}
+
+	if end, err := rs.Seek(0, io.SeekEnd); err != nil || end != int64(len(content)) {
+		t.Fatalf("expected the size %d from seeking to the end, got %d, %v", len(content), end, err)
+	}
+	// Ranges within a chunk, across chunk boundaries and at the end.
+	for _, r := range []struct{ off, n int }{{10, 100}, {64<<10 - 3, 10}, {130 << 10, 64 << 10}, {200<<10 - 7, 7}, {0, 5}} {
+		if _, err := rs.Seek(int64(r.off), io.SeekStart); err != nil {
+			t.Fatalf("Seek to %d failed: %v", r.off, err)
+		}
+		buf := make([]byte, r.n)
+		if _, err := io.ReadFull(rs, buf); err != nil {
+			t.Fatalf("failed to read %d bytes at %d: %v", r.n, r.off, err)
+		}
+		if !bytes.Equal(buf, content[r.off:r.off+r.n]) {
+			t.Errorf("read the wrong bytes at %d", r.off)
+		}
+	}
+	if _, err := rs.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("Seek to the end failed: %v", err)
+	}
+	if n, err := rs.Read(make([]byte, 1)); n != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF at the end, got %d, %v", n, err)
+	}
+}
+
+func TestEncryptedStorage_DetectsTampering(t *testing.T) {
+	content := fixedContent(3 * 64 << 10)
+	for _, tc := range []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{"flipped bit", func(b []byte) []byte { b[len(b)/2] ^= 1; return b }},
+		{"truncated at a chunk boundary", func(b []byte) []byte { return b[:2*(64<<10+16)] }},
+		{"swapped chunks", func(b []byte) []byte {
+			f := 64<<10 + 16
+			swapped := append([]byte{}, b[f:2*f]...)
+			swapped = append(swapped, b[:f]...)
+			return append(swapped, b[2*f:]...)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			et := setupEncryptedTest(t)
+			st := et.open(t, testKey(1))
+			hash, _, err := st.SaveFile(bytes.NewReader(content))
+			if err != nil {
+				t.Fatalf("SaveFile failed: %v", err)
+			}
+			p := et.objectPath(t, hash)
+			stored, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, tc.modify(stored), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			rc, err := st.OpenFile(hash)
+			if err != nil {
+				t.Fatalf("OpenFile failed: %v", err)
+			}
+			defer rc.Close()
+			if _, err := io.ReadAll(rc); !errors.Is(err, storage.ErrCorruptBlob) {
+				t.Errorf("expected ErrCorruptBlob, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedStorage_Rewrap(t *testing.T) {
+	et := setupEncryptedTest(t)
+	ctx := testutil.TestContext(t)
+	oldKey, newKey := testKey(1), testKey(2)
+
+	hash, _, err := et.open(t, oldKey).SaveFile(strings.NewReader("secret"))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+
+	rotated := et.open(t, newKey, oldKey)
+	if got := readBlob(t, rotated, hash); string(got) != "secret" {
+		t.Errorf("expected the old key to still be readable, got %q", got)
+	}
+	n, err := rotated.Rewrap(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 rewrapped key, got %d, %v", n, err)
+	}
+	if n, err := rotated.Rewrap(ctx); err != nil || n != 0 {
+		t.Errorf("expected nothing left to rewrap, got %d, %v", n, err)
+	}
+
+	if got := readBlob(t, et.open(t, newKey), hash); string(got) != "secret" {
+		t.Errorf("expected %q with only the new key, got %q", "secret", got)
+	}
+	if _, err := et.open(t, oldKey).OpenFile(hash); err == nil {
+		t.Error("expected the old key to no longer open the content")
+	}
+}
+
+func TestEncryptedStorage_PlainTextContent(t *testing.T) {
+	et := setupEncryptedTest(t)
+	st := et.open(t, testKey(1))
+
+	// Stored before encryption was enabled.
+	hash, _, err := et.inner.SaveFile(strings.NewReader("plain"))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if got := readBlob(t, st, hash); string(got) != "plain" {
+		t.Errorf("expected plain text content to be readable, got %q", got)
+	}
+
+	if _, _, err := st.SaveFile(strings.NewReader("plain")); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if _, err := et.inner.OpenFile(hash); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the plain text copy to be replaced, got %v", err)
+	}
+	if got := readBlob(t, st, hash); string(got) != "plain" {
+		t.Errorf("expected %q, got %q", "plain", got)
+	}
+}
+
+func TestEncryptedStorage_Staging(t *testing.T) {
+	et := setupEncryptedTest(t)
+	st := et.open(t, testKey(1))
+
+	if _, err := st.WriteStaging("alice", "upload", 0, strings.NewReader("hello ")); err != nil {
+		t.Fatalf("WriteStaging failed: %v", err)
+	}
+	if _, err := st.WriteStaging("alice", "upload", 6, strings.NewReader("world")); err != nil {
+		t.Fatalf("WriteStaging failed: %v", err)
+	}
+	hash, size, err := st.CommitStaging("alice", "upload")
+	if err != nil {
+		t.Fatalf("CommitStaging failed: %v", err)
+	}
+	if got := readBlob(t, st, hash); string(got) != "hello world" || size != 11 {
+		t.Errorf("expected %q with 11 bytes, got %q with %d", "hello world", got, size)
+	}
+	if _, err := et.inner.OpenFile(hash); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no plain text copy after commit, got %v", err)
+	}
+	if n := et.countObjects(t); n != 1 {
+		t.Errorf("expected 1 stored object, got %d", n)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"single key", testKey(1), false},
+		{"comma separated", testKey(1) + ", " + testKey(2), false},
+		{"key file", "# current\n" + testKey(1) + "\n\n# previous\n" + testKey(2) + "\n", false},
+		{"empty", " \n# nothing\n", true},
+		{"not base64", "not a key", true},
+		{"too short", base64.StdEncoding.EncodeToString([]byte("short")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kr, err := storage.ParseKeyring(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && kr.CurrentKeyID() == "" {
+				t.Error("expected a current key ID")
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LegacyLayout reads the directory tree files were stored in before the blob
+// store: DATA_ROOT/<username>/<path> for files, DATA_ROOT/.trash/<username>/<id>
+// for trashed items and DATA_ROOT/.versions/<username>/<id> for older versions.
+type LegacyLayout struct {
+	basePath string
+	st       FileManager
+}
+
+func NewLegacyLayout(basePath string, st FileManager) *LegacyLayout {
+	return &LegacyLayout{basePath, st}
+}
+
+// Exists reports whether anything of the legacy layout is left.
+func (l *LegacyLayout) Exists() bool {
+	entries, err := os.ReadDir(l.basePath)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if isLegacyDir(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLegacyDir reports whether e is a user directory, the trash or the version
+// area of the legacy layout. Other hidden directories belong to the store.
+func isLegacyDir(e fs.DirEntry) bool {
+	if !e.IsDir() {
+		return false
+	}
+	return !strings.HasPrefix(e.Name(), ".") || e.Name() == ".trash" || e.Name() == ".versions"
+}
+
+// FilePath returns where a file with the given location used to be stored.
+func (l *LegacyLayout) FilePath(username, location string) string {
+	return path.Join(l.basePath, username, location)
+}
+
+// TrashPath returns where a trashed file used to be stored, entryPath is
+// relative to the trashed item and empty for the item itself.
+func (l *LegacyLayout) TrashPath(username string, trashID int64, entryPath string) string {
+	return path.Join(l.basePath, ".trash", username, strconv.FormatInt(trashID, 10), entryPath)
+}
+
+// VersionPath returns where an older version used to be stored.
+func (l *LegacyLayout) VersionPath(username string, versionID int64) string {
+	return path.Join(l.basePath, ".versions", username, strconv.FormatInt(versionID, 10))
+}
+
+// Import moves the file at p into the store and returns its hash and size.
+// It returns fs.ErrNotExist if there is no such file, e.g. because it was
+// imported before.
+func (l *LegacyLayout) Import(p string) (string, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", 0, err
+	}
+	hash, size, err := l.st.SaveFile(f)
+	_ = f.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	return hash, size, os.Remove(p)
+}
+
+// Cleanup removes the directories of the legacy layout that are empty after
+// the import and returns the number of files left behind, which no database
+// row referred to.
+func (l *LegacyLayout) Cleanup() (int, error) {
+	entries, err := os.ReadDir(l.basePath)
+	if err != nil {
+		return 0, err
+	}
+	left := 0
+	for _, e := range entries {
+		if !isLegacyDir(e) {
+			continue
+		}
+		n, err := removeEmptyDirs(path.Join(l.basePath, e.Name()))
+		if err != nil {
+			return left, err
+		}
+		left += n
+	}
+	return left, nil
+}
+
+// removeEmptyDirs removes dir and every directory below it that holds no
+// files, bottom up, and returns the number of files it found.
+func removeEmptyDirs(dir string) (int, error) {
+	files := 0
+	var dirs []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, p)
+		} else {
+			files++
+		}
+		return nil
+	})
+	if err != nil {
+		return files, err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		// Fails for directories that still hold files, which are kept.
+		_ = os.Remove(dirs[i])
+	}
+	return files, nil
+}
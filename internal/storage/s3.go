@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// minPartSize is the smallest part S3 accepts in a multipart upload,
+	// except for the last one.
+	minPartSize = 5 << 20
+	// maxCopySize is the largest object S3 copies in a single request.
+	maxCopySize = 5 << 30
+)
+
+// S3Config selects a bucket of an S3 compatible object store.
+type S3Config struct {
+	// Endpoint is the URL of the service, e.g. https://s3.eu-central-1.amazonaws.com
+	// or http://minio:9000. The scheme decides whether TLS is used.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to every object key, so one bucket can be shared.
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// which most self-hosted stores need.
+	PathStyle bool
+	// PartSize is the size of the parts large contents are uploaded in. An
+	// upload buffers up to two parts in memory.
+	PartSize uint64
+}
+
+// S3Storage keeps contents in an S3 compatible bucket. Nothing is kept on the
+// local disk, so several instances can share one bucket:
+//
+//	<prefix>blobs/<hash>                    stored contents
+//	<prefix>uploads/<random>                contents being saved, before their hash is known
+//	<prefix>staging/<user>/<upload>/<offset> the chunks of unfinished tus uploads
+type S3Storage struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+}
+
+// NewS3Storage connects to the bucket of cfg and fails if it does not exist.
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q, expected a URL like https://host:port", cfg.Endpoint)
+	}
+	if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("S3 part size must be at least %d bytes", minPartSize)
+	}
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure:       u.Scheme == "https",
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := client.BucketExists(context.Background(), cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach S3 bucket %q: %w", cfg.Bucket, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("S3 bucket %q does not exist", cfg.Bucket)
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3Storage{client, cfg.Bucket, prefix, cfg.PartSize}, nil
+}
+
+// SaveFile uploads src under a random key while hashing it and copies it to
+// the key of its hash inside the store afterwards, so readers never see a
+// partially written blob. If the content is already stored the upload is
+// dropped. Uploads of a crashed instance stay behind below uploads/; a
+// lifecycle rule on that prefix removes them.
+func (s *S3Storage) SaveFile(src io.Reader) (string, int64, error) {
+	ctx := context.Background()
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", 0, err
+	}
+	tmpKey := s.prefix + "uploads/" + hex.EncodeToString(id)
+
+	hasher := sha256.New()
+	info, err := s.put(ctx, tmpKey, io.TeeReader(src, hasher))
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		_ = s.client.RemoveObject(ctx, s.bucket, tmpKey, minio.RemoveObjectOptions{})
+	}()
+
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+	if _, err := s.client.StatObject(ctx, s.bucket, s.blobKey(hash), minio.StatObjectOptions{}); err == nil {
+		return hash, info.Size, nil
+	} else if !isNotFound(err) {
+		return "", 0, err
+	}
+	copyDst := minio.CopyDestOptions{Bucket: s.bucket, Object: s.blobKey(hash)}
+	copySrc := minio.CopySrcOptions{Bucket: s.bucket, Object: tmpKey}
+	if info.Size <= maxCopySize {
+		_, err = s.client.CopyObject(ctx, copyDst, copySrc)
+	} else {
+		// Larger copies have to be split into parts.
+		_, err = s.client.ComposeObject(ctx, copyDst, copySrc)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to store blob %s: %w", hash, err)
+	}
+	return hash, info.Size, nil
+}
+
+// put streams src to key. Contents that fit into one part are uploaded in a
+// single request, larger ones in parts, so the size does not have to be known
+// up front.
+func (s *S3Storage) put(ctx context.Context, key string, src io.Reader) (minio.UploadInfo, error) {
+	opts := minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    s.partSize,
+		// Parts are checked with Content-MD5 instead of signing every chunk
+		// of the payload, which not every S3 compatible store supports.
+		SendContentMd5:       true,
+		DisableContentSha256: true,
+	}
+	head := make([]byte, s.partSize)
+	n, err := io.ReadFull(src, head)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(head[:n]), int64(n), opts)
+	}
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	return s.client.PutObject(ctx, s.bucket, key, io.MultiReader(bytes.NewReader(head), src), -1, opts)
+}
+
+// OpenFile returns a reader that fetches the content on demand. It also
+// implements io.Seeker and io.ReaderAt, so downloads can serve ranges.
+func (s *S3Storage) OpenFile(hash string) (io.ReadSeekCloser, error) {
+	key := s.blobKey(hash)
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.pathError("open", key, err)
+	}
+	// GetObject is lazy, a missing object is only noticed on the first request.
+	if _, err := obj.Stat(); err != nil {
+		_ = obj.Close()
+		return nil, s.pathError("open", key, err)
+	}
+	return obj, nil
+}
+
+// DeleteFile removes the content of hash. Removing a missing object succeeds.
+func (s *S3Storage) DeleteFile(hash string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, s.blobKey(hash), minio.RemoveObjectOptions{})
+}
+
+// WriteStaging stores src as its own chunk object, since objects cannot be
+// written to in place. A chunk is stored completely or not at all, so on an
+// error nothing was written and the client resumes at offset.
+func (s *S3Storage) WriteStaging(username string, uploadID string, offset int64, src io.Reader) (int64, error) {
+	info, err := s.put(context.Background(), s.chunkKey(username, uploadID, offset), src)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// CommitStaging streams the chunks of an upload in order into the store and
+// deletes them afterwards.
+func (s *S3Storage) CommitStaging(username string, uploadID string) (string, int64, error) {
+	chunks, err := s.listStaging(username, uploadID)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(chunks) == 0 {
+		return "", 0, &fs.PathError{Op: "open", Path: s.stagingPrefix(username, uploadID), Err: fs.ErrNotExist}
+	}
+
+	r := &chunkReader{s: s, chunks: chunks}
+	defer r.Close()
+	hash, size, err := s.SaveFile(r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hash, size, s.DeleteStaging(username, uploadID)
+}
+
+func (s *S3Storage) DeleteStaging(username string, uploadID string) error {
+	chunks, err := s.listStaging(username, uploadID)
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if err := s.client.RemoveObject(context.Background(), s.bucket, c.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listStaging returns the chunks of an upload ordered by offset.
+func (s *S3Storage) listStaging(username string, uploadID string) ([]minio.ObjectInfo, error) {
+	var chunks []minio.ObjectInfo
+	// Keys are listed in lexical order, which the zero padded offsets keep.
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{
+		Prefix: s.stagingPrefix(username, uploadID),
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		chunks = append(chunks, obj)
+	}
+	return chunks, nil
+}
+
+func (s *S3Storage) blobKey(hash string) string {
+	return s.prefix + "blobs/" + path.Base(hash)
+}
+
+func (s *S3Storage) stagingPrefix(username string, uploadID string) string {
+	return s.prefix + "staging/" + path.Base(username) + "/" + path.Base(uploadID) + "/"
+}
+
+func (s *S3Storage) chunkKey(username string, uploadID string, offset int64) string {
+	return s.stagingPrefix(username, uploadID) + fmt.Sprintf("%020d", offset)
+}
+
+// pathError reports a missing object as fs.ErrNotExist, like the local disk.
+func (s *S3Storage) pathError(op, key string, err error) error {
+	if isNotFound(err) {
+		return &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == minio.NoSuchKey || code == "NotFound"
+}
+
+// chunkReader reads the chunks of a staged upload one after another and
+// checks that they follow each other without gaps.
+type chunkReader struct {
+	s      *S3Storage
+	chunks []minio.ObjectInfo
+	cur    io.ReadCloser
+	offset int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			c := r.chunks[0]
+			r.chunks = r.chunks[1:]
+			start, err := strconv.ParseInt(path.Base(c.Key), 10, 64)
+			if err != nil || start != r.offset {
+				return 0, errors.New("staged upload has missing or overlapping chunks at " + c.Key)
+			}
+			obj, err := r.s.client.GetObject(context.Background(), r.s.bucket, c.Key, minio.GetObjectOptions{})
+			if err != nil {
+				return 0, err
+			}
+			r.cur = obj
+		}
+		n, err := r.cur.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF {
+			_ = r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	return r.cur.Close()
+}
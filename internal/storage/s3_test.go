@@ -0,0 +1,166 @@
+package storage_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/NiClassic/go-cloud/internal/storage/storagetest"
+)
+
+func setupS3Test(t *testing.T) *storage.S3Storage {
+	t.Helper()
+	st, err := storage.NewS3Storage(storagetest.SetupS3(t))
+	if err != nil {
+		t.Fatalf("failed to connect to test bucket: %v", err)
+	}
+	return st
+}
+
+func readBlob(t *testing.T, st storage.FileManager, hash string) []byte {
+	t.Helper()
+	rc, err := st.OpenFile(hash)
+	if err != nil {
+		t.Fatalf("failed to open blob %s: %v", hash, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read blob %s: %v", hash, err)
+	}
+	return data
+}
+
+func TestS3Storage_SaveFile(t *testing.T) {
+	st := setupS3Test(t)
+
+	hash, size, err := st.SaveFile(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("hello"))); hash != want || size != 5 {
+		t.Errorf("expected %s with 5 bytes, got %s with %d", want, hash, size)
+	}
+	again, _, err := st.SaveFile(strings.NewReader("hello"))
+	if err != nil || again != hash {
+		t.Fatalf("expected saving the same content again to succeed with %s, got %s, %v", hash, again, err)
+	}
+	if got := readBlob(t, st, hash); string(got) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+
+	if err := st.DeleteFile(hash); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if _, err := st.OpenFile(hash); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+	if err := st.DeleteFile(hash); err != nil {
+		t.Errorf("expected deleting a missing blob to succeed, got %v", err)
+	}
+}
+
+func TestS3Storage_MultipartUpload(t *testing.T) {
+	st := setupS3Test(t)
+
+	// More than two parts of 5 MiB.
+	content := make([]byte, 11<<20+123)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	hash, size, err := st.SaveFile(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(content)); hash != want || size != int64(len(content)) {
+		t.Errorf("expected %s with %d bytes, got %s with %d", want, len(content), hash, size)
+	}
+	if got := readBlob(t, st, hash); !bytes.Equal(got, content) {
+		t.Error("downloaded content differs from the upload")
+	}
+
+	rc, err := st.OpenFile(hash)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer rc.Close()
+	rs, ok := rc.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("expected the reader to support seeking")
+	}
+	if _, err := rs.Seek(6<<20, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rs, buf); err != nil {
+		t.Fatalf("failed to read after seeking: %v", err)
+	}
+	if !bytes.Equal(buf, content[6<<20:6<<20+16]) {
+		t.Error("read the wrong bytes after seeking")
+	}
+}
+
+func TestS3Storage_Staging(t *testing.T) {
+	st := setupS3Test(t)
+
+	for _, chunk := range []struct {
+		offset int64
+		data   string
+	}{{0, "hello "}, {6, "chunked "}, {14, "world"}} {
+		n, err := st.WriteStaging("alice", "upload1", chunk.offset, strings.NewReader(chunk.data))
+		if err != nil || n != int64(len(chunk.data)) {
+			t.Fatalf("WriteStaging at %d: got %d, %v", chunk.offset, n, err)
+		}
+	}
+	// Staging of another upload is kept apart.
+	if _, err := st.WriteStaging("alice", "upload2", 0, strings.NewReader("other")); err != nil {
+		t.Fatalf("WriteStaging failed: %v", err)
+	}
+
+	hash, size, err := st.CommitStaging("alice", "upload1")
+	if err != nil {
+		t.Fatalf("CommitStaging failed: %v", err)
+	}
+	if got := readBlob(t, st, hash); string(got) != "hello chunked world" || size != int64(len(got)) {
+		t.Errorf("expected the chunks in order, got %q with size %d", got, size)
+	}
+	if _, _, err := st.CommitStaging("alice", "upload1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the staging to be removed, got %v", err)
+	}
+
+	if err := st.DeleteStaging("alice", "upload2"); err != nil {
+		t.Fatalf("DeleteStaging failed: %v", err)
+	}
+	if _, _, err := st.CommitStaging("alice", "upload2"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the staging to be removed, got %v", err)
+	}
+}
+
+func TestS3Storage_StagingWithGap(t *testing.T) {
+	st := setupS3Test(t)
+
+	if _, err := st.WriteStaging("alice", "upload", 0, strings.NewReader("abc")); err != nil {
+		t.Fatalf("WriteStaging failed: %v", err)
+	}
+	if _, err := st.WriteStaging("alice", "upload", 5, strings.NewReader("def")); err != nil {
+		t.Fatalf("WriteStaging failed: %v", err)
+	}
+	if _, _, err := st.CommitStaging("alice", "upload"); err == nil {
+		t.Error("expected committing chunks with a gap to fail")
+	}
+}
+
+func TestNewS3Storage_MissingBucket(t *testing.T) {
+	cfg := storagetest.SetupS3(t)
+	cfg.Bucket = "missing"
+	if _, err := storage.NewS3Storage(cfg); err == nil {
+		t.Error("expected an error for a missing bucket")
+	}
+}
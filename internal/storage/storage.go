@@ -8,23 +8,29 @@ import (
 	"path"
 )
 
+// FileManager stores file contents by their SHA-256 hash, so identical
+// content is kept once no matter how many files, versions or trash items use
+// it. Where a file lives in the folder tree is only recorded in the database,
+// as is how many rows still use a hash, see the blobs table.
 type FileManager interface {
-	// GetBaseDir returns the absolute base directory for the user.
-	GetBaseDir(username string) string
-	// EnsureDir ensures that a folder exists (creates if missing), returns absolute path.
-	EnsureDir(username string, folderPath string) (absPath string, err error)
-	// SaveFile saves a file to a folder, returning absolute path, SHA256 hash, and size.
-	SaveFile(username string, folderPath, filename string, src io.Reader) (absPath, hash string, size int64, err error)
-	// OpenFile opens a file for reading (download).
-	OpenFile(username string, folderPath, filename string) (io.ReadCloser, error)
-	// DeleteFile deletes a file.
-	DeleteFile(username string, folderPath, filename string) error
-	// MoveFile moves or renames a file.
-	MoveFile(username string, oldFolderPath, oldFilename, newFolderPath, newFilename string) (newAbsPath string, err error)
-	// DeleteFolder deletes an empty folder (or recursively).
-	DeleteFolder(username string, folderPath string) error
+	// SaveFile streams src into the store, returning its SHA256 hash and size. Content that is already stored is not kept twice.
+	SaveFile(src io.Reader) (hash string, size int64, err error)
+	// OpenFile opens the content stored under hash for reading (download). The reader can seek, so
+	// downloads serve ranges without reading the content before them.
+	OpenFile(hash string) (io.ReadSeekCloser, error)
+	// DeleteFile deletes the content stored under hash once nothing uses it anymore.
+	DeleteFile(hash string) error
+	// WriteStaging writes src into the staging file of an unfinished upload starting at offset
+	// and returns the number of bytes written, even if the copy fails halfway.
+	WriteStaging(username string, uploadID string, offset int64, src io.Reader) (int64, error)
+	// CommitStaging moves a finished staging file into the store, returning SHA256 hash and size.
+	CommitStaging(username string, uploadID string) (hash string, size int64, err error)
+	// DeleteStaging deletes the staging file of an upload.
+	DeleteStaging(username string, uploadID string) error
 }
 
+// IOStorage keeps contents on the local disk below basePath in .blobs, named
+// by hash and fanned out by its first two characters.
 type IOStorage struct {
 	basePath string
 }
@@ -33,82 +39,133 @@ func NewIOStorage(basePath string) *IOStorage {
 	return &IOStorage{basePath}
 }
 
-func (s *IOStorage) GetBaseDir(username string) string {
-	return s.getUserDir(username)
+// SaveFile streams src into a temporary file in the blob directory and
+// renames it into place once it is complete and hashed, so readers never see
+// a partially written blob. If the content is already stored the temporary
+// file is dropped.
+func (s *IOStorage) SaveFile(src io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(s.blobDir(), 0o755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(s.blobDir(), ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		// No-op once the rename succeeded.
+		_ = os.Remove(tmpPath)
+	}()
+
+	hasher := sha256.New()
+	size, err := io.Copy(tmp, io.TeeReader(src, hasher))
+	if err != nil {
+		_ = tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+	return hash, size, s.store(tmpPath, hash)
+}
+
+// store moves the file at src to the blob of hash unless that already exists.
+func (s *IOStorage) store(src, hash string) error {
+	dst := s.blobPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return os.Remove(src)
+	}
+	if err := os.MkdirAll(path.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Chmod(src, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+func (s *IOStorage) OpenFile(hash string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.blobPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-func (s *IOStorage) EnsureDir(username string, folderPath string) (string, error) {
-	absPath := s.absFolderPath(username, folderPath)
-	if err := os.MkdirAll(absPath, 0o755); err != nil {
-		return "", err
+func (s *IOStorage) DeleteFile(hash string) error {
+	err := os.Remove(s.blobPath(hash))
+	if os.IsNotExist(err) {
+		return nil
 	}
-	return absPath, nil
+	return err
 }
 
-func (s *IOStorage) SaveFile(username string, folderPath, filename string, src io.Reader) (string, string, int64, error) {
-	if _, err := s.EnsureDir(username, folderPath); err != nil {
-		return "", "", 0, err
+func (s *IOStorage) WriteStaging(username string, uploadID string, offset int64, src io.Reader) (int64, error) {
+	if err := os.MkdirAll(s.stagingDir(username), 0o755); err != nil {
+		return 0, err
 	}
 
-	dstPath := s.absFilePath(username, folderPath, filename)
-	dst, err := os.Create(dstPath)
+	dst, err := os.OpenFile(s.stagingFilePath(username, uploadID), os.O_WRONLY|os.O_CREATE, 0o644)
 	if err != nil {
-		return "", "", 0, err
+		return 0, err
 	}
 	defer func(dst *os.File) {
-		err := dst.Close()
-		if err != nil {
-			return
-		}
+		_ = dst.Close()
 	}(dst)
 
-	hasher := sha256.New()
-	tee := io.TeeReader(src, hasher)
+	if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(dst, src)
+}
 
-	size, err := io.Copy(dst, tee)
+func (s *IOStorage) CommitStaging(username string, uploadID string) (string, int64, error) {
+	stagingPath := s.stagingFilePath(username, uploadID)
+	src, err := os.Open(stagingPath)
+	if err != nil {
+		return "", 0, err
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, src)
+	_ = src.Close()
 	if err != nil {
-		return "", "", 0, err
+		return "", 0, err
 	}
 
 	hash := fmt.Sprintf("%x", hasher.Sum(nil))
-	return dstPath, hash, size, nil
-}
-
-func (s *IOStorage) OpenFile(username string, folderPath, filename string) (io.ReadCloser, error) {
-	return os.Open(s.absFilePath(username, folderPath, filename))
-}
-
-func (s *IOStorage) DeleteFile(username string, folderPath, filename string) error {
-	return os.Remove(s.absFilePath(username, folderPath, filename))
+	return hash, size, s.store(stagingPath, hash)
 }
 
-func (s *IOStorage) MoveFile(username string, oldFolder, oldName, newFolder, newName string) (string, error) {
-	oldPath := s.absFilePath(username, oldFolder, oldName)
-	newFolderAbs := s.absFolderPath(username, newFolder)
-	if err := os.MkdirAll(newFolderAbs, 0o755); err != nil {
-		return "", err
-	}
-
-	newPath := s.absFilePath(username, newFolder, newName)
-	if err := os.Rename(oldPath, newPath); err != nil {
-		return "", err
+func (s *IOStorage) DeleteStaging(username string, uploadID string) error {
+	err := os.Remove(s.stagingFilePath(username, uploadID))
+	if os.IsNotExist(err) {
+		return nil
 	}
-	return newPath, nil
+	return err
 }
 
-func (s *IOStorage) DeleteFolder(username string, folderPath string) error {
-	absPath := s.absFolderPath(username, folderPath)
-	return os.RemoveAll(absPath)
+// stagingDir keeps unfinished uploads apart from the blobs, so only complete
+// contents ever get a hash.
+func (s *IOStorage) stagingDir(username string) string {
+	return path.Join(s.basePath, ".staging", username)
 }
 
-func (s *IOStorage) getUserDir(username string) string {
-	return path.Join(s.basePath, username)
+func (s *IOStorage) stagingFilePath(username string, uploadID string) string {
+	return path.Join(s.stagingDir(username), path.Base(uploadID))
 }
 
-func (s *IOStorage) absFolderPath(username string, folderPath string) string {
-	return path.Join(s.getUserDir(username), folderPath)
+// blobDir lives next to the staging area. Before the blob store files were
+// kept in one directory per user, see LegacyLayout.
+func (s *IOStorage) blobDir() string {
+	return path.Join(s.basePath, ".blobs")
 }
 
-func (s *IOStorage) absFilePath(username string, folderPath string, filename string) string {
-	return path.Join(s.getUserDir(username), folderPath, path.Base(filename))
+func (s *IOStorage) blobPath(hash string) string {
+	hash = path.Base(hash)
+	if len(hash) < 2 {
+		return path.Join(s.blobDir(), hash)
+	}
+	return path.Join(s.blobDir(), hash[:2], hash)
 }
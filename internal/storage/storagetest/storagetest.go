@@ -0,0 +1,36 @@
+// Package storagetest provides the storage fixtures shared by the tests of
+// several packages.
+package storagetest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NiClassic/go-cloud/internal/storage"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// SetupS3 starts an in-memory S3 compatible server with an empty bucket for
+// the duration of the test and returns the configuration to reach it.
+func SetupS3(t *testing.T) storage.S3Config {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("test"); err != nil {
+		t.Fatalf("failed to create test bucket: %v", err)
+	}
+	srv := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(srv.Close)
+
+	return storage.S3Config{
+		Endpoint:        srv.URL,
+		Region:          "us-east-1",
+		Bucket:          "test",
+		Prefix:          "go-cloud",
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		PathStyle:       true,
+		PartSize:        5 << 20,
+	}
+}
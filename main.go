@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"github.com/NiClassic/go-cloud/internal/path"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/NiClassic/go-cloud/config"
 	"github.com/NiClassic/go-cloud/internal/db"
 	"github.com/NiClassic/go-cloud/internal/handler"
 	"github.com/NiClassic/go-cloud/internal/logger"
+	"github.com/NiClassic/go-cloud/internal/repository"
 	"github.com/NiClassic/go-cloud/internal/service"
 	"github.com/NiClassic/go-cloud/internal/storage"
 	"github.com/NiClassic/go-cloud/internal/timezone"
+	"github.com/NiClassic/go-cloud/internal/token"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/joho/godotenv"
 	_ "modernc.org/sqlite"
@@ -45,10 +52,66 @@ func main() {
 		}
 	}(dbConn)
 
-	st := storage.NewIOStorage(os.Getenv("DATA_ROOT"))
+	st, err := openStorage(cfg)
+	if err != nil {
+		logger.Fatal("could not open storage: %v", err)
+	}
+	keys, err := openKeyring(cfg)
+	if err != nil {
+		logger.Fatal("could not read encryption keys: %v", err)
+	}
+	blobRepo := repository.NewBlobRepository(dbConn)
+	var encrypted *storage.EncryptedStorage
+	if keys != nil {
+		encrypted = storage.NewEncryptedStorage(st, keys, blobRepo)
+		st = encrypted
+	} else if ok, err := blobRepo.HasKeys(context.Background()); err != nil {
+		logger.Fatal("could not check for encrypted contents: %v", err)
+	} else if ok {
+		logger.Fatal("contents are stored encrypted, set ENCRYPTION_KEY or ENCRYPTION_KEY_FILE to read them")
+	}
+
+	if flag.Arg(0) == "rewrap" {
+		if encrypted == nil {
+			logger.Fatal("rewrap needs ENCRYPTION_KEY or ENCRYPTION_KEY_FILE")
+		}
+		n, err := encrypted.Rewrap(context.Background())
+		if err != nil {
+			logger.Fatal("could not rewrap data keys after %d: %v", n, err)
+		}
+		logger.Info("rewrapped %d data keys with master key %s", n, keys.CurrentKeyID())
+		return
+	}
 	converter := path.New(os.Getenv("DATA_ROOT"))
+	linkSecret, err := openLinkSecret(cfg)
+	if err != nil {
+		logger.Fatal("could not create link secret: %v", err)
+	}
+
+	services := service.InitServices(dbConn, st, converter, service.VersionPolicy{
+		Keep:   int(cfg.VersionKeep),
+		MaxAge: cfg.VersionRetention(),
+	}, cfg.DefaultQuota, service.ExtractLimits{
+		MaxEntries: int(cfg.ExtractMaxEntries),
+		MaxSize:    cfg.ExtractMaxSizeMB << 20,
+	}, linkSecret)
+
+	if flag.Arg(0) == "quota" {
+		if err := setQuota(services.Quota, flag.Arg(1), flag.Arg(2)); err != nil {
+			logger.Fatal("could not set quota: %v", err)
+		}
+		logger.Info("set the quota of %s to %s", flag.Arg(1), flag.Arg(2))
+		return
+	}
 
-	services := service.InitServices(dbConn, st, converter)
+	imported, err := services.Blob.MigrateLegacyLayout(context.Background(), storage.NewLegacyLayout(os.Getenv("DATA_ROOT"), st))
+	if err != nil {
+		logger.Fatal("could not import files from the legacy storage layout: %v", err)
+	}
+	if imported.Imported > 0 || imported.Missing > 0 || imported.Left > 0 {
+		logger.Info("imported %d files from the legacy storage layout, %d missing, %d unreferenced files left",
+			imported.Imported, imported.Missing, imported.Left)
+	}
 	renderer, err := handler.NewRenderer(cfg)
 	if err != nil {
 		logger.Fatal("could not initialize renderer: %v", err)
@@ -56,11 +119,168 @@ func main() {
 
 	mux := handler.New(cfg, renderer, services, st, converter)
 
+	go cleanup(services, cfg.TrashRetention(), time.Hour)
+	go thumbnails(services.Thumbnail, thumbnailInterval)
+	go searchIndex(services.Search, searchInterval)
+
 	logger.Info("DebugMode:          %v", cfg.DebugMode)
 	logger.Info("AllowRegistrations: %v", cfg.AllowRegistrations)
 	logger.Info("Timezone:           %v", cfg.TimezoneName)
+	logger.Info("TrashRetentionDays: %v", cfg.TrashRetentionDays)
+	logger.Info("VersionKeep:        %v", cfg.VersionKeep)
+	logger.Info("VersionKeepDays:    %v", cfg.VersionKeepDays)
+	logger.Info("DefaultQuota:       %v", cfg.DefaultQuota)
+	logger.Info("StorageBackend:     %v", cfg.StorageBackend)
+	logger.Info("Encryption:         %v", keys != nil)
 	logger.Info("listening on :8080")
 	if err = http.ListenAndServe(":8080", mux); err != nil {
 		logger.Fatal("could not run server: %v", err)
 	}
 }
+
+// openStorage returns the FileManager selected by cfg.StorageBackend.
+func openStorage(cfg *config.Config) (storage.FileManager, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return storage.NewIOStorage(os.Getenv("DATA_ROOT")), nil
+	case "s3":
+		return storage.NewS3Storage(storage.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			Prefix:          cfg.S3Prefix,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+			PartSize:        uint64(cfg.S3PartSizeMB) << 20,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, expected local or s3", cfg.StorageBackend)
+	}
+}
+
+// openKeyring returns the master keys of cfg, or nil if encryption is disabled.
+func openKeyring(cfg *config.Config) (*storage.Keyring, error) {
+	keys := cfg.EncryptionKey
+	if cfg.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = string(data)
+	}
+	if keys == "" {
+		return nil, nil
+	}
+	return storage.ParseKeyring(keys)
+}
+
+// openLinkSecret returns LINK_SECRET, or a random secret if it is not set.
+// Visitors then have to enter the passwords of links again after a restart.
+func openLinkSecret(cfg *config.Config) ([]byte, error) {
+	if cfg.LinkSecret != "" {
+		return []byte(cfg.LinkSecret), nil
+	}
+	logger.Info("LINK_SECRET is not set, link passwords have to be entered again after a restart")
+	return token.Bytes(32)
+}
+
+// setQuota handles `quota <username> <bytes|default>`, where a quota of 0 is
+// unlimited and default applies DEFAULT_QUOTA.
+func setQuota(quotas *service.QuotaService, username, value string) error {
+	if username == "" || value == "" {
+		return fmt.Errorf("usage: quota <username> <bytes|default>")
+	}
+	var quota sql.NullInt64
+	if value != "default" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid quota %q, expected a number of bytes or default", value)
+		}
+		quota = sql.NullInt64{Int64: n, Valid: true}
+	}
+	return quotas.SetQuota(context.Background(), username, quota)
+}
+
+// blobGracePeriod is how long stored contents nothing refers to are kept, so
+// uploads that are claimed but not yet recorded in the database are not
+// collected.
+const blobGracePeriod = time.Hour
+
+// cleanup deletes trash items older than trashRetention, file versions the
+// version policy no longer keeps and the contents nothing refers to any more
+// right away and then once every interval. A trashRetention of 0 keeps the
+// trash until it is emptied by hand.
+func cleanup(services *service.Services, trashRetention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if trashRetention > 0 {
+			n, err := services.Trash.PurgeExpired(context.Background(), time.Now().Add(-trashRetention))
+			if err != nil {
+				logger.Error("could not purge trash: %v", err)
+			}
+			if n > 0 {
+				logger.Info("purged %d expired trash items", n)
+			}
+		}
+		n, err := services.Version.Prune(context.Background(), time.Now())
+		if err != nil {
+			logger.Error("could not prune file versions: %v", err)
+		}
+		if n > 0 {
+			logger.Info("pruned %d file versions", n)
+		}
+		n, err = services.Blob.Collect(context.Background(), time.Now().Add(-blobGracePeriod))
+		if err != nil {
+			logger.Error("could not collect unused blobs: %v", err)
+		}
+		if n > 0 {
+			logger.Info("removed %d unused blobs from storage", n)
+		}
+		<-ticker.C
+	}
+}
+
+// thumbnailInterval is how often uploaded images are checked for missing
+// thumbnails. Thumbnails asked for before are made right away.
+const thumbnailInterval = 10 * time.Second
+
+// thumbnails makes the missing thumbnails of all images right away and then
+// once every interval.
+func thumbnails(thumbs *service.ThumbnailService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := thumbs.Generate(context.Background())
+		if err != nil {
+			logger.Error("could not generate thumbnails: %v", err)
+		}
+		if n > 0 {
+			logger.Debug("generated thumbnails of %d images", n)
+		}
+		<-ticker.C
+	}
+}
+
+// searchInterval is how often contents are checked for text that was not
+// extracted yet. Most uploads are indexed right away, resumable ones are picked
+// up here.
+const searchInterval = 10 * time.Second
+
+// searchIndex extracts the missing texts of all contents right away and then
+// once every interval.
+func searchIndex(search *service.SearchService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := search.Index(context.Background())
+		if err != nil {
+			logger.Error("could not index file contents: %v", err)
+		}
+		if n > 0 {
+			logger.Debug("indexed the text of %d contents", n)
+		}
+		<-ticker.C
+	}
+}